@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxBackoff caps how long the agent stays quiet after repeated 429s.
+const maxBackoff = 10 * time.Minute
+
+// busyError is returned by send when the server answered 429.
+type busyError struct {
+	retryAfter time.Duration // from the Retry-After header; 0 if absent
+}
+
+func (e *busyError) Error() string {
+	return fmt.Sprintf("server is busy (retry after %s)", e.retryAfter)
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as an HTTP date.
+func parseRetryAfter(h string) time.Duration {
+	if secs, err := strconv.Atoi(h); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// backoff keeps the agent from sending while the server is unreachable or
+// has asked it to wait. Each consecutive failure doubles the wait, and a
+// random extra of up to half the wait spreads a fleet of agents out so they
+// don't all come back on the same tick.
+type backoff struct {
+	until  time.Time
+	streak int
+}
+
+// failed records a failed send and returns how long to wait before the
+// next one. retryAfter is the server's Retry-After, or 0.
+func (b *backoff) failed(retryAfter, interval time.Duration) time.Duration {
+	b.streak++
+	wait := max(retryAfter, interval) << min(b.streak-1, 5)
+	wait = min(wait, maxBackoff)
+	wait += rand.N(wait/2 + 1)
+	b.until = time.Now().Add(wait)
+	return wait
+}
+
+// ok resets the backoff after a send the server accepted.
+func (b *backoff) ok() {
+	b.streak = 0
+	b.until = time.Time{}
+}
+
+// waiting reports whether a send now would ignore the server's request.
+func (b *backoff) waiting() bool {
+	return time.Now().Before(b.until)
+}
@@ -0,0 +1,252 @@
+//go:build cgo
+
+package main
+
+/*
+#include <stdlib.h>
+#include <sys/sysctl.h>
+#include <mach/mach.h>
+#include <mach/mach_host.h>
+
+// cpu_ticks reads the aggregate CPU tick counters for all cores.
+static kern_return_t cpu_ticks(natural_t ticks[CPU_STATE_MAX]) {
+	host_cpu_load_info_data_t info;
+	mach_msg_type_number_t count = HOST_CPU_LOAD_INFO_COUNT;
+	mach_port_t host = mach_host_self();
+	kern_return_t kr = host_statistics(host, HOST_CPU_LOAD_INFO, (host_info_t)&info, &count);
+	mach_port_deallocate(mach_task_self(), host);
+	if (kr != KERN_SUCCESS) {
+		return kr;
+	}
+	for (int i = 0; i < CPU_STATE_MAX; i++) {
+		ticks[i] = info.cpu_ticks[i];
+	}
+	return KERN_SUCCESS;
+}
+
+// core_ticks reads up to max cores' tick counters into ticks, CPU_STATE_MAX
+// per core, and returns the number of cores read or -1 on failure.
+static int core_ticks(natural_t *ticks, int max) {
+	natural_t n;
+	processor_info_array_t info;
+	mach_msg_type_number_t count;
+	mach_port_t host = mach_host_self();
+	kern_return_t kr = host_processor_info(host, PROCESSOR_CPU_LOAD_INFO, &n, &info, &count);
+	mach_port_deallocate(mach_task_self(), host);
+	if (kr != KERN_SUCCESS) {
+		return -1;
+	}
+	if ((int)n > max) {
+		n = max;
+	}
+	for (natural_t i = 0; i < n * CPU_STATE_MAX; i++) {
+		ticks[i] = info[i];
+	}
+	vm_deallocate(mach_task_self(), (vm_address_t)info, count * sizeof(integer_t));
+	return n;
+}
+
+// vm_available returns the bytes of free plus inactive (reclaimable) pages.
+static kern_return_t vm_available(uint64_t *bytes) {
+	vm_statistics64_data_t vm;
+	mach_msg_type_number_t count = HOST_VM_INFO64_COUNT;
+	mach_port_t host = mach_host_self();
+	vm_size_t page;
+	kern_return_t kr = host_page_size(host, &page);
+	if (kr == KERN_SUCCESS) {
+		kr = host_statistics64(host, HOST_VM_INFO64, (host_info64_t)&vm, &count);
+	}
+	mach_port_deallocate(mach_task_self(), host);
+	if (kr != KERN_SUCCESS) {
+		return kr;
+	}
+	*bytes = ((uint64_t)vm.free_count + vm.inactive_count) * page;
+	return KERN_SUCCESS;
+}
+
+// swap_usage reads vm.swapusage; macOS grows swap files on demand, so total
+// is the current size of the swap files rather than a fixed partition.
+static int swap_usage(uint64_t *used, uint64_t *total) {
+	struct xsw_usage xsu;
+	size_t len = sizeof(xsu);
+	if (sysctlbyname("vm.swapusage", &xsu, &len, NULL, 0) != 0) {
+		return -1;
+	}
+	*used = xsu.xsu_used;
+	*total = xsu.xsu_total;
+	return 0;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// readCPUSample reads the aggregate CPU ticks via host_statistics.
+func readCPUSample() (cpuSample, error) {
+	var ticks [C.CPU_STATE_MAX]C.natural_t
+	if kr := C.cpu_ticks(&ticks[0]); kr != C.KERN_SUCCESS {
+		return cpuSample{}, fmt.Errorf("host_statistics: kern_return %d", int(kr))
+	}
+	var total int64
+	for _, t := range ticks {
+		total += int64(t)
+	}
+	return cpuSample{total: total, idle: int64(ticks[C.CPU_STATE_IDLE])}, nil
+}
+
+// maxCores bounds the per-core buffer passed to host_processor_info.
+const maxCores = 512
+
+// readCoreSamples reads per-core CPU ticks via host_processor_info, keyed by
+// processor number.
+func readCoreSamples() (map[int]cpuSample, error) {
+	ticks := make([]C.natural_t, maxCores*C.CPU_STATE_MAX)
+	n := int(C.core_ticks(&ticks[0], maxCores))
+	if n < 0 {
+		return nil, fmt.Errorf("host_processor_info failed")
+	}
+	cores := make(map[int]cpuSample, n)
+	for i := 0; i < n; i++ {
+		var total int64
+		for _, t := range ticks[i*C.CPU_STATE_MAX : (i+1)*C.CPU_STATE_MAX] {
+			total += int64(t)
+		}
+		cores[i] = cpuSample{total: total, idle: int64(ticks[i*C.CPU_STATE_MAX+C.CPU_STATE_IDLE])}
+	}
+	return cores, nil
+}
+
+// readLoadAvg returns the 1, 5 and 15-minute load averages via getloadavg(3).
+func readLoadAvg() ([3]float64, error) {
+	var avg [3]C.double
+	if n := C.getloadavg(&avg[0], 3); n != 3 {
+		return [3]float64{}, fmt.Errorf("getloadavg returned %d", int(n))
+	}
+	return [3]float64{float64(avg[0]), float64(avg[1]), float64(avg[2])}, nil
+}
+
+// readMemInfo returns (used, total) bytes. total is hw.memsize; used is
+// total minus free and inactive pages, the closest match to Linux's
+// MemTotal - MemAvailable.
+func readMemInfo() (used, total int64, err error) {
+	memsize, err := unix.SysctlUint64("hw.memsize")
+	if err != nil {
+		return 0, 0, fmt.Errorf("sysctl hw.memsize: %w", err)
+	}
+	var avail C.uint64_t
+	if kr := C.vm_available(&avail); kr != C.KERN_SUCCESS {
+		return 0, 0, fmt.Errorf("host_statistics64: kern_return %d", int(kr))
+	}
+	total = int64(memsize)
+	return max(total-int64(avail), 0), total, nil
+}
+
+// readSwapInfo returns (used, total) swap bytes from sysctl vm.swapusage.
+func readSwapInfo() (used, total int64, err error) {
+	var u, t C.uint64_t
+	if C.swap_usage(&u, &t) != 0 {
+		return 0, 0, fmt.Errorf("sysctl vm.swapusage failed")
+	}
+	return int64(u), int64(t), nil
+}
+
+// virtualFSTypes is the set of filesystem types we skip when collecting disk stats.
+var virtualFSTypes = map[string]bool{
+	"devfs": true, "autofs": true, "nullfs": true, "lifs": true,
+}
+
+// readDiskStats returns used/total bytes for each real mounted filesystem.
+// APFS system volumes (/System/Volumes/VM, Preboot, ...) are flagged
+// MNT_DONTBROWSE and skipped, leaving / and the Data volume.
+func readDiskStats() ([]diskStat, error) {
+	n, err := unix.Getfsstat(nil, unix.MNT_NOWAIT)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]unix.Statfs_t, n)
+	n, err = unix.Getfsstat(buf, unix.MNT_NOWAIT)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var disks []diskStat
+	for _, st := range buf[:n] {
+		mount := unix.ByteSliceToString(st.Mntonname[:])
+		fstype := unix.ByteSliceToString(st.Fstypename[:])
+		if virtualFSTypes[fstype] || st.Flags&unix.MNT_DONTBROWSE != 0 {
+			continue
+		}
+		if seen[mount] || st.Blocks == 0 {
+			continue
+		}
+		seen[mount] = true
+
+		bsize := int64(st.Bsize)
+		total := int64(st.Blocks) * bsize
+		used := int64(st.Blocks-st.Bfree) * bsize
+		disks = append(disks, diskStat{Mount: mount, Used: used, Total: total,
+			InodesUsed: int64(st.Files - st.Ffree), InodesTotal: int64(st.Files)})
+	}
+	return disks, nil
+}
+
+// readNetCounters is not implemented on macOS yet; no interfaces are reported.
+func readNetCounters() (map[string]netCounters, error) {
+	return nil, nil
+}
+
+// readIOCounters is not implemented on macOS yet; no devices are reported.
+func readIOCounters() (map[string]ioCounters, error) {
+	return nil, nil
+}
+
+// readProcCounters is not implemented on macOS yet; no processes are reported.
+func readProcCounters() (map[int]procCounters, error) {
+	return nil, nil
+}
+
+// readTemperatures is not implemented on macOS (SMC sensors need a private
+// framework); no sensors are reported.
+func readTemperatures() ([]tempStat, error) {
+	return nil, nil
+}
+
+// readFailedUnits reports nil: macOS has launchd, not systemd.
+func readFailedUnits() ([]string, error) {
+	return nil, nil
+}
+
+// readSockStats is Linux-only for now; no counts are reported.
+func readSockStats() (*sockStat, error) {
+	return nil, nil
+}
+
+// defaultGateway is Linux-only for now; list the router's address instead.
+func defaultGateway() (string, error) {
+	return "", errors.New("not supported on macOS; use the router's address")
+}
+
+// fileUsage returns the disk space allocated to a file and its device.
+func fileUsage(fi fs.FileInfo) (bytes int64, dev uint64) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Blocks * 512, uint64(st.Dev)
+	}
+	return fi.Size(), 0
+}
+
+// readPlatform returns the macOS version and the Darwin kernel release.
+func readPlatform() (name, kernel string) {
+	if v, err := unix.Sysctl("kern.osproductversion"); err == nil {
+		name = "macOS " + v
+	}
+	kernel, _ = unix.Sysctl("kern.osrelease")
+	return name, kernel
+}
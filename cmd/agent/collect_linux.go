@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// readCPUSample reads the aggregate "cpu" line from /proc/stat.
+func readCPUSample() (cpuSample, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return cpuSample{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "cpu ") {
+			continue
+		}
+		// cpu  user nice system idle iowait irq softirq steal ...
+		fields := strings.Fields(line)
+		if len(fields) < 8 {
+			break
+		}
+		var v [8]int64
+		for i := range v {
+			v[i], _ = strconv.ParseInt(fields[i+1], 10, 64)
+		}
+		total := v[0] + v[1] + v[2] + v[3] + v[4] + v[5] + v[6] + v[7]
+		idle := v[3] + v[4] // idle + iowait
+		return cpuSample{total: total, idle: idle}, nil
+	}
+	return cpuSample{}, fmt.Errorf("cpu line not found in /proc/stat")
+}
+
+// readCoreSamples reads the per-core "cpuN" lines from /proc/stat, keyed by
+// N. Offline cores have no line.
+func readCoreSamples() (map[int]cpuSample, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cores := make(map[int]cpuSample)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "cpu") || strings.HasPrefix(line, "cpu ") {
+			continue
+		}
+		// Same layout as the aggregate line.
+		fields := strings.Fields(line)
+		if len(fields) < 9 {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(fields[0], "cpu"))
+		if err != nil {
+			continue
+		}
+		var v [8]int64
+		for i := range v {
+			v[i], _ = strconv.ParseInt(fields[i+1], 10, 64)
+		}
+		total := v[0] + v[1] + v[2] + v[3] + v[4] + v[5] + v[6] + v[7]
+		cores[id] = cpuSample{total: total, idle: v[3] + v[4]}
+	}
+	return cores, scanner.Err()
+}
+
+// readLoadAvg returns the 1, 5 and 15-minute load averages from /proc/loadavg.
+func readLoadAvg() ([3]float64, error) {
+	var load [3]float64
+	b, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return load, err
+	}
+	// 0.52 0.40 0.31 1/234 5678
+	fields := strings.Fields(string(b))
+	if len(fields) < 3 {
+		return load, fmt.Errorf("unexpected /proc/loadavg format")
+	}
+	for i := range load {
+		if load[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return load, fmt.Errorf("parse /proc/loadavg: %w", err)
+		}
+	}
+	return load, nil
+}
+
+// readMemInfo returns (used, total) bytes from /proc/meminfo.
+// used = MemTotal - MemAvailable.
+func readMemInfo() (used, total int64, err error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var memTotal, memAvailable int64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		// Values are in kB; convert to bytes.
+		val, _ := strconv.ParseInt(fields[1], 10, 64)
+		val *= 1024
+		switch fields[0] {
+		case "MemTotal:":
+			memTotal = val
+		case "MemAvailable:":
+			memAvailable = val
+		}
+	}
+	return memTotal - memAvailable, memTotal, nil
+}
+
+// readSwapInfo returns (used, total) swap bytes from /proc/meminfo.
+// used = SwapTotal - SwapFree; both are 0 without swap.
+func readSwapInfo() (used, total int64, err error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var swapTotal, swapFree int64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		val, _ := strconv.ParseInt(fields[1], 10, 64)
+		val *= 1024
+		switch fields[0] {
+		case "SwapTotal:":
+			swapTotal = val
+		case "SwapFree:":
+			swapFree = val
+		}
+	}
+	return max(swapTotal-swapFree, 0), swapTotal, nil
+}
+
+// virtualFSTypes is the set of filesystem types we skip when collecting disk stats.
+var virtualFSTypes = map[string]bool{
+	"tmpfs": true, "devtmpfs": true, "sysfs": true, "proc": true,
+	"cgroup": true, "cgroup2": true, "devpts": true, "hugetlbfs": true,
+	"mqueue": true, "pstore": true, "securityfs": true, "debugfs": true,
+	"tracefs": true, "bpf": true, "overlay": true, "fusectl": true,
+	"squashfs": true, "nsfs": true, "efivarfs": true,
+}
+
+// readDiskStats returns used/total bytes for each real mounted filesystem
+// by reading /proc/mounts and calling Statfs on each mount point.
+func readDiskStats() ([]diskStat, error) {
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seen := make(map[string]bool)
+	var disks []diskStat
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Format: device mountpoint fstype options dump pass
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		mount := fields[1]
+		fstype := fields[2]
+
+		if virtualFSTypes[fstype] {
+			continue
+		}
+		if seen[mount] {
+			continue
+		}
+		seen[mount] = true
+
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(mount, &stat); err != nil {
+			continue // inaccessible mount — skip silently
+		}
+		if stat.Blocks == 0 {
+			continue
+		}
+		total := int64(stat.Blocks) * stat.Bsize
+		used := int64(stat.Blocks-stat.Bfree) * stat.Bsize
+		disks = append(disks, diskStat{Mount: mount, Used: used, Total: total,
+			InodesUsed: int64(stat.Files - stat.Ffree), InodesTotal: int64(stat.Files)})
+	}
+	return disks, nil
+}
+
+// readNetCounters reads per-interface byte and packet counters from
+// /proc/net/dev, skipping the loopback interface.
+func readNetCounters() (map[string]netCounters, error) {
+	f, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	counters := make(map[string]netCounters)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Format: "  eth0: rx_bytes rx_packets errs drop fifo frame compressed multicast tx_bytes tx_packets ..."
+		// The first two lines are headers without a colon.
+		iface, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		iface = strings.TrimSpace(iface)
+		fields := strings.Fields(rest)
+		if iface == "lo" || len(fields) < 10 {
+			continue
+		}
+		var v [10]uint64
+		for i := range v {
+			v[i], _ = strconv.ParseUint(fields[i], 10, 64)
+		}
+		counters[iface] = netCounters{rxBytes: v[0], rxPackets: v[1], txBytes: v[8], txPackets: v[9]}
+	}
+	return counters, scanner.Err()
+}
+
+// readIOCounters reads per-device counters from /proc/diskstats for whole
+// disks (those listed in /sys/block), skipping partitions, loop and RAM
+// devices so I/O isn't counted twice.
+func readIOCounters() (map[string]ioCounters, error) {
+	f, err := os.Open("/proc/diskstats")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	counters := make(map[string]ioCounters)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Format: major minor name reads merged sectors_read ms_reading writes merged sectors_written ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		dev := fields[2]
+		if strings.HasPrefix(dev, "loop") || strings.HasPrefix(dev, "ram") || strings.HasPrefix(dev, "zram") {
+			continue
+		}
+		if _, err := os.Stat("/sys/block/" + dev); err != nil {
+			continue // a partition
+		}
+		var v [7]uint64
+		for i := range v {
+			v[i], _ = strconv.ParseUint(fields[i+3], 10, 64)
+		}
+		// Sectors are always 512 bytes in /proc/diskstats.
+		counters[dev] = ioCounters{reads: v[0], readBytes: v[2] * 512, writes: v[4], writeBytes: v[6] * 512}
+	}
+	return counters, scanner.Err()
+}
+
+// readTemperatures reads every hwmon temperature input (CPU package and
+// cores, NVMe, ...) plus thermal zones whose type isn't already covered by a
+// hwmon chip. Hosts without sensors (most VMs) report none.
+func readTemperatures() ([]tempStat, error) {
+	var temps []tempStat
+	chips := make(map[string]bool)
+	hwmons, _ := filepath.Glob("/sys/class/hwmon/hwmon*")
+	for _, dir := range hwmons {
+		chip := readSysString(filepath.Join(dir, "name"))
+		if chip == "" {
+			continue
+		}
+		chips[chip] = true
+		inputs, _ := filepath.Glob(filepath.Join(dir, "temp*_input"))
+		for _, input := range inputs {
+			milli, err := readSysInt(input)
+			if err != nil {
+				continue
+			}
+			label := readSysString(strings.TrimSuffix(input, "_input") + "_label")
+			if label == "" {
+				label = strings.TrimSuffix(filepath.Base(input), "_input")
+			}
+			temps = append(temps, tempStat{Sensor: chip + "/" + label, Celsius: float64(milli) / 1000})
+		}
+	}
+
+	zones, _ := filepath.Glob("/sys/class/thermal/thermal_zone*")
+	for _, dir := range zones {
+		typ := readSysString(filepath.Join(dir, "type"))
+		if typ == "" || chips[typ] {
+			continue
+		}
+		milli, err := readSysInt(filepath.Join(dir, "temp"))
+		if err != nil {
+			continue
+		}
+		temps = append(temps, tempStat{Sensor: typ + "/" + filepath.Base(dir), Celsius: float64(milli) / 1000})
+	}
+	sort.Slice(temps, func(i, j int) bool { return temps[i].Sensor < temps[j].Sensor })
+	return temps, nil
+}
+
+// readProcCounters reads utime+stime and RSS for every process from
+// /proc/[pid]/stat. Processes that exit mid-scan are skipped.
+func readProcCounters() (map[int]procCounters, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	pageSize := int64(os.Getpagesize())
+	procs := make(map[int]procCounters)
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		b, err := os.ReadFile("/proc/" + e.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		// pid (comm) state ppid ... — comm may contain spaces and parentheses,
+		// so split around the last ')'.
+		s := string(b)
+		open, end := strings.IndexByte(s, '('), strings.LastIndexByte(s, ')')
+		if open < 0 || end < open {
+			continue
+		}
+		fields := strings.Fields(s[end+1:])
+		// fields[0] is state (field 3); utime, stime are 14, 15 and rss is 24.
+		if len(fields) < 22 {
+			continue
+		}
+		utime, _ := strconv.ParseInt(fields[11], 10, 64)
+		stime, _ := strconv.ParseInt(fields[12], 10, 64)
+		rss, _ := strconv.ParseInt(fields[21], 10, 64)
+		procs[pid] = procCounters{name: s[open+1 : end], ticks: utime + stime, rss: rss * pageSize}
+	}
+	return procs, nil
+}
+
+// readSysString returns the trimmed contents of a sysfs attribute, or "".
+func readSysString(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func readSysInt(path string) (int64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// readFailedUnits lists systemd units in the failed state, the equivalent of
+// `systemctl --failed`. Hosts not booted with systemd report nil.
+func readFailedUnits() ([]string, error) {
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "systemctl", "list-units", "--state=failed",
+		"--no-legend", "--plain", "--no-pager").Output()
+	if err != nil {
+		return nil, fmt.Errorf("systemctl: %w", err)
+	}
+	units := []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			units = append(units, fields[0])
+		}
+	}
+	return units, nil
+}
+
+// readSockStats reads open file handles from /proc/sys/fs/file-nr and TCP
+// socket counts from /proc/net/sockstat (IPv4) and /proc/net/sockstat6
+// (IPv6). Established connections come from CurrEstab in /proc/net/snmp,
+// which covers both.
+func readSockStats() (*sockStat, error) {
+	b, err := os.ReadFile("/proc/sys/fs/file-nr")
+	if err != nil {
+		return nil, err
+	}
+	f := strings.Fields(string(b))
+	if len(f) < 3 {
+		return nil, fmt.Errorf("unexpected /proc/sys/fs/file-nr: %q", b)
+	}
+	allocated, _ := strconv.ParseInt(f[0], 10, 64)
+	free, _ := strconv.ParseInt(f[1], 10, 64)
+	st := &sockStat{FDOpen: allocated - free}
+	st.FDMax, _ = strconv.ParseInt(f[2], 10, 64)
+
+	for _, path := range []string{"/proc/net/sockstat", "/proc/net/sockstat6"} {
+		fields, err := sockstatLine(path)
+		if err != nil {
+			if path == "/proc/net/sockstat6" && os.IsNotExist(err) {
+				continue // IPv6 disabled
+			}
+			return nil, err
+		}
+		st.TCPInUse += fields["inuse"]
+		if path == "/proc/net/sockstat" {
+			st.TCPTimeWait = fields["tw"] // shared by IPv4 and IPv6
+		}
+	}
+
+	snmp, err := os.ReadFile("/proc/net/snmp")
+	if err != nil {
+		return nil, err
+	}
+	var header []string
+	for _, line := range strings.Split(string(snmp), "\n") {
+		if !strings.HasPrefix(line, "Tcp:") {
+			continue
+		}
+		if header == nil {
+			header = strings.Fields(line)
+			continue
+		}
+		for i, v := range strings.Fields(line) {
+			if i < len(header) && header[i] == "CurrEstab" {
+				st.TCPEstablished, _ = strconv.ParseInt(v, 10, 64)
+			}
+		}
+		break
+	}
+	return st, nil
+}
+
+// sockstatLine returns the name/value pairs on the TCP line of a sockstat
+// file, e.g. "TCP: inuse 12 orphan 0 tw 13 alloc 12 mem 1".
+func sockstatLine(path string) (map[string]int64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		name, rest, ok := strings.Cut(line, ":")
+		if !ok || (name != "TCP" && name != "TCP6") {
+			continue
+		}
+		fields := strings.Fields(rest)
+		vals := make(map[string]int64, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			vals[fields[i]], _ = strconv.ParseInt(fields[i+1], 10, 64)
+		}
+		return vals, nil
+	}
+	return nil, fmt.Errorf("no TCP line in %s", path)
+}
+
+// defaultGateway returns the IPv4 default route's gateway from
+// /proc/net/route, whose addresses are little-endian hex.
+func defaultGateway() (string, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		gw, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil || gw == 0 {
+			continue
+		}
+		return fmt.Sprintf("%d.%d.%d.%d", byte(gw), byte(gw>>8), byte(gw>>16), byte(gw>>24)), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("no default route")
+}
+
+// fileUsage returns the disk space allocated to a file and its device.
+func fileUsage(fi fs.FileInfo) (bytes int64, dev uint64) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Blocks * 512, uint64(st.Dev)
+	}
+	return fi.Size(), 0
+}
+
+// readPlatform returns the distribution's PRETTY_NAME from os-release and
+// the kernel release; either is "" if unknown.
+func readPlatform() (name, kernel string) {
+	kernel = readSysString("/proc/sys/kernel/osrelease")
+	for _, path := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if v, ok := strings.CutPrefix(line, "PRETTY_NAME="); ok {
+				if u, err := strconv.Unquote(v); err == nil {
+					v = u
+				}
+				return strings.Trim(v, "'"), kernel
+			}
+		}
+	}
+	return "", kernel
+}
@@ -0,0 +1,44 @@
+//go:build !linux && !(darwin && cgo)
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"runtime"
+)
+
+// errUnsupported is returned on platforms without a collector. The macOS
+// collector needs cgo for host_statistics, so a CGO_ENABLED=0 build lands here.
+var errUnsupported = errors.New("metrics collection is not supported on " + runtime.GOOS + " in this build")
+
+func readCPUSample() (cpuSample, error) { return cpuSample{}, errUnsupported }
+
+func readCoreSamples() (map[int]cpuSample, error) { return nil, errUnsupported }
+
+func readLoadAvg() ([3]float64, error) { return [3]float64{}, errUnsupported }
+
+func readMemInfo() (used, total int64, err error) { return 0, 0, errUnsupported }
+
+func readSwapInfo() (used, total int64, err error) { return 0, 0, errUnsupported }
+
+func readDiskStats() ([]diskStat, error) { return nil, errUnsupported }
+
+func readNetCounters() (map[string]netCounters, error) { return nil, errUnsupported }
+
+func readIOCounters() (map[string]ioCounters, error) { return nil, errUnsupported }
+
+func readProcCounters() (map[int]procCounters, error) { return nil, errUnsupported }
+
+func readTemperatures() ([]tempStat, error) { return nil, errUnsupported }
+
+func readFailedUnits() ([]string, error) { return nil, errUnsupported }
+
+func readSockStats() (*sockStat, error) { return nil, errUnsupported }
+
+func defaultGateway() (string, error) { return "", errUnsupported }
+
+// fileUsage falls back to the apparent size, and can't tell mounts apart.
+func fileUsage(fi fs.FileInfo) (bytes int64, dev uint64) { return fi.Size(), 0 }
+
+func readPlatform() (name, kernel string) { return "", "" }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"health-dashboard/internal/config"
+)
+
+// dirStat is the size of one of agent.dir_sizes at its last scan.
+type dirStat struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"` // allocated on disk, like du
+	Files int64  `json:"files"`
+	// Skipped counts entries that couldn't be read, e.g. for permissions;
+	// their contents are missing from Bytes.
+	Skipped   int64     `json:"skipped"`
+	ScannedAt time.Time `json:"scanned_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// dirScanner measures directory sizes in the background, every interval
+// and at most rate entries a second, so scanning a large tree doesn't tie up
+// the disk or delay the 30s samples. Payloads carry the last results.
+type dirScanner struct {
+	paths    []string
+	interval time.Duration
+	rate     int
+
+	mu      sync.Mutex
+	results []dirStat
+}
+
+// newDirScanner returns nil when cfg lists no directories.
+func newDirScanner(cfg config.AgentConfig) *dirScanner {
+	if len(cfg.DirSizes) == 0 {
+		return nil
+	}
+	s := &dirScanner{paths: cfg.DirSizes, interval: time.Duration(cfg.DirScanMinutes) * time.Minute, rate: cfg.DirScanRate}
+	if s.interval <= 0 {
+		s.interval = time.Hour
+	}
+	if s.rate <= 0 {
+		s.rate = 2000
+	}
+	return s
+}
+
+// run scans at once and then every interval until ctx ends.
+func (s *dirScanner) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.scanAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scanAll scans every path in turn and publishes the results.
+func (s *dirScanner) scanAll(ctx context.Context) {
+	results := make([]dirStat, 0, len(s.paths))
+	for _, path := range s.paths {
+		st := s.scan(ctx, path)
+		if ctx.Err() != nil {
+			return // cut short; keep the previous results
+		}
+		if st.Error != "" {
+			log.Printf("agent: dir size %s: %s", path, st.Error)
+		}
+		results = append(results, st)
+	}
+	s.mu.Lock()
+	s.results = results
+	s.mu.Unlock()
+}
+
+// latest returns the results of the last complete scan, nil before one.
+func (s *dirScanner) latest() []dirStat {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.results
+}
+
+// scan totals the disk usage under root without leaving its filesystem,
+// like du -sx, pausing as needed to stay under the rate.
+func (s *dirScanner) scan(ctx context.Context, root string) dirStat {
+	st := dirStat{Path: root}
+	start := time.Now()
+	// A symlinked path is measured at its target.
+	root, err := filepath.EvalSymlinks(root)
+	var rootInfo fs.FileInfo
+	if err == nil {
+		rootInfo, err = os.Stat(root)
+	}
+	if err != nil {
+		st.Error, st.ScannedAt = err.Error(), start.UTC().Truncate(time.Second)
+		return st
+	}
+	_, rootDev := fileUsage(rootInfo)
+	var seen int64
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			st.Skipped++
+			return nil // an unreadable directory is skipped, not fatal
+		}
+		seen++
+		if seen%100 == 0 {
+			if ahead := time.Duration(seen)*time.Second/time.Duration(s.rate) - time.Since(start); ahead > 0 {
+				time.Sleep(ahead)
+			}
+		}
+		info, err := d.Info()
+		if err != nil {
+			st.Skipped++
+			return nil
+		}
+		bytes, dev := fileUsage(info)
+		if d.IsDir() && path != root && dev != rootDev {
+			return filepath.SkipDir // another mount
+		}
+		st.Bytes += bytes
+		if !d.IsDir() {
+			st.Files++
+		}
+		return nil
+	})
+	st.ScannedAt = time.Now().UTC().Truncate(time.Second)
+	return st
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// containerStat is one running container's resource usage.
+type containerStat struct {
+	ID           string  `json:"id"` // short (12-character) container ID
+	Name         string  `json:"name"`
+	Image        string  `json:"image"`
+	State        string  `json:"state"`
+	CPUPercent   float64 `json:"cpu_percent"` // as in `docker stats`: 100% per core
+	MemUsed      int64   `json:"mem_used"`    // excluding page cache, as in `docker stats`
+	MemLimit     int64   `json:"mem_limit"`
+	RestartCount int     `json:"restart_count"`
+}
+
+// dockerClient talks to the Docker Engine API over its unix socket.
+type dockerClient struct {
+	http *http.Client
+}
+
+func newDockerClient(socket string) *dockerClient {
+	return &dockerClient{http: &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}}
+}
+
+// get decodes the JSON response to an Engine API GET. The host part of the
+// URL is ignored; every request goes to the socket.
+func (d *dockerClient) get(path string, v any) error {
+	resp, err := d.http.Get("http://docker" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: HTTP %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// containers returns stats for every running container. Stats are fetched
+// concurrently; each takes about a second because the engine samples CPU
+// twice. A container that stops mid-collection is skipped.
+func (d *dockerClient) containers() ([]containerStat, error) {
+	var list []struct {
+		ID    string   `json:"Id"`
+		Names []string `json:"Names"`
+		Image string   `json:"Image"`
+		State string   `json:"State"`
+	}
+	if err := d.get("/containers/json", &list); err != nil {
+		return nil, err
+	}
+
+	stats := make([]*containerStat, len(list))
+	var wg sync.WaitGroup
+	for i, c := range list {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st, err := d.stat(c.ID)
+			if err != nil {
+				return
+			}
+			st.ID = c.ID[:min(12, len(c.ID))]
+			if len(c.Names) > 0 {
+				st.Name = strings.TrimPrefix(c.Names[0], "/")
+			}
+			st.Image, st.State = c.Image, c.State
+			stats[i] = st
+		}()
+	}
+	wg.Wait()
+
+	var out []containerStat
+	for _, st := range stats {
+		if st != nil {
+			out = append(out, *st)
+		}
+	}
+	return out, nil
+}
+
+// stat reads one container's CPU and memory from /stats and its restart
+// count from /json.
+func (d *dockerClient) stat(id string) (*containerStat, error) {
+	var s struct {
+		CPUStats    dockerCPUStats `json:"cpu_stats"`
+		PreCPUStats dockerCPUStats `json:"precpu_stats"`
+		MemoryStats struct {
+			Usage int64            `json:"usage"`
+			Limit int64            `json:"limit"`
+			Stats map[string]int64 `json:"stats"`
+		} `json:"memory_stats"`
+	}
+	if err := d.get("/containers/"+id+"/stats?stream=false", &s); err != nil {
+		return nil, err
+	}
+	var inspect struct {
+		RestartCount int `json:"RestartCount"`
+	}
+	if err := d.get("/containers/"+id+"/json", &inspect); err != nil {
+		return nil, err
+	}
+
+	var pct float64
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	sysDelta := float64(s.CPUStats.SystemCPUUsage) - float64(s.PreCPUStats.SystemCPUUsage)
+	if cpuDelta > 0 && sysDelta > 0 {
+		cpus := float64(s.CPUStats.OnlineCPUs)
+		if cpus == 0 {
+			cpus = float64(len(s.CPUStats.CPUUsage.PercpuUsage))
+		}
+		pct = cpuDelta / sysDelta * cpus * 100
+	}
+
+	// Page cache is reclaimable: cgroup v2 reports it as inactive_file,
+	// v1 as total_inactive_file.
+	used := s.MemoryStats.Usage
+	if v, ok := s.MemoryStats.Stats["inactive_file"]; ok && v < used {
+		used -= v
+	} else if v, ok := s.MemoryStats.Stats["total_inactive_file"]; ok && v < used {
+		used -= v
+	}
+
+	return &containerStat{
+		CPUPercent:   pct,
+		MemUsed:      used,
+		MemLimit:     s.MemoryStats.Limit,
+		RestartCount: inspect.RestartCount,
+	}, nil
+}
+
+type dockerCPUStats struct {
+	CPUUsage struct {
+		TotalUsage  uint64   `json:"total_usage"`
+		PercpuUsage []uint64 `json:"percpu_usage"`
+	} `json:"cpu_usage"`
+	SystemCPUUsage uint64 `json:"system_cpu_usage"`
+	OnlineCPUs     int    `json:"online_cpus"`
+}
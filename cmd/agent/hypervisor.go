@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"health-dashboard/internal/config"
+)
+
+// vmStat is one guest of the hypervisor the agent runs on.
+type vmStat struct {
+	ID         string  `json:"id"` // "qemu/100" on Proxmox, the domain name on libvirt
+	Name       string  `json:"name"`
+	Node       string  `json:"node,omitempty"` // the Proxmox cluster node it runs on
+	Kind       string  `json:"kind"`           // "vm" or "container"
+	State      string  `json:"state"`
+	CPUs       int     `json:"cpus"`
+	CPUPercent float64 `json:"cpu_percent"` // of its own CPUs, so 100% is all of them busy
+	MemUsed    int64   `json:"mem_used"`
+	MemTotal   int64   `json:"mem_total"`
+}
+
+// hypervisor lists the guests of a virtualization host.
+type hypervisor interface {
+	vms() ([]vmStat, error)
+}
+
+// newHypervisor returns the client for agent.hypervisor, or nil when it
+// isn't set.
+func newHypervisor(cfg config.HypervisorConfig) (hypervisor, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "proxmox":
+		if cfg.TokenID == "" || cfg.TokenSecret == "" {
+			return nil, errors.New("agent.hypervisor: proxmox needs token_id and token_secret")
+		}
+		url := cfg.URL
+		if url == "" {
+			url = "https://127.0.0.1:8006"
+		}
+		var fingerprints []string
+		if cfg.Fingerprint != "" {
+			fingerprints = []string{cfg.Fingerprint}
+		}
+		tlsCfg, err := trustConfig("agent.hypervisor.ca", cfg.CA, fingerprints)
+		if err != nil {
+			return nil, err
+		}
+		client := &http.Client{Timeout: 10 * time.Second}
+		if tlsCfg != nil {
+			client.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsCfg, TLSHandshakeTimeout: 10 * time.Second}
+		}
+		return &proxmoxClient{http: client, url: strings.TrimSuffix(url, "/"),
+			auth: "PVEAPIToken=" + cfg.TokenID + "=" + cfg.TokenSecret}, nil
+	case "libvirt":
+		uri := cfg.URL
+		if uri == "" {
+			uri = "qemu:///system"
+		}
+		return &libvirtClient{uri: uri}, nil
+	}
+	return nil, fmt.Errorf("agent.hypervisor: type must be proxmox or libvirt, not %q", cfg.Type)
+}
+
+// proxmoxClient reads a Proxmox VE cluster's guests from its API.
+type proxmoxClient struct {
+	http *http.Client
+	url  string
+	auth string
+}
+
+// vms lists every VM and container in the cluster, templates aside, with
+// the usage Proxmox last sampled.
+func (p *proxmoxClient) vms() ([]vmStat, error) {
+	req, err := http.NewRequest(http.MethodGet, p.url+"/api2/json/cluster/resources?type=vm", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", p.auth)
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxmox: HTTP %d", resp.StatusCode)
+	}
+	var body struct {
+		Data []struct {
+			ID       string  `json:"id"`
+			Name     string  `json:"name"`
+			Node     string  `json:"node"`
+			Type     string  `json:"type"`
+			Status   string  `json:"status"`
+			Template int     `json:"template"`
+			CPU      float64 `json:"cpu"` // share of maxcpu
+			MaxCPU   int     `json:"maxcpu"`
+			Mem      int64   `json:"mem"`
+			MaxMem   int64   `json:"maxmem"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("proxmox: %w", err)
+	}
+	var out []vmStat
+	for _, g := range body.Data {
+		if g.Template == 1 {
+			continue
+		}
+		kind := "vm"
+		if g.Type == "lxc" {
+			kind = "container"
+		}
+		out = append(out, vmStat{
+			ID: g.ID, Name: g.Name, Node: g.Node, Kind: kind, State: g.Status, CPUs: g.MaxCPU,
+			CPUPercent: min(max(g.CPU*100, 0), 100), MemUsed: g.Mem, MemTotal: g.MaxMem,
+		})
+	}
+	return out, nil
+}
+
+// libvirtClient reads a libvirt host's domains with virsh.
+type libvirtClient struct {
+	uri string
+}
+
+// libvirtStates names the values of a domain's state.state.
+var libvirtStates = map[string]string{
+	"0": "unknown", "1": "running", "2": "running", "3": "paused", "4": "stopping",
+	"5": "stopped", "6": "crashed", "7": "suspended",
+}
+
+// vms lists every domain, running or not. CPU usage is the growth of each
+// domain's CPU time over a second, so this takes a second.
+func (l *libvirtClient) vms() ([]vmStat, error) {
+	first, err := l.domstats()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	time.Sleep(time.Second)
+	second, err := l.domstats()
+	if err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(start).Seconds()
+
+	kind := "vm"
+	if strings.HasPrefix(l.uri, "lxc:") {
+		kind = "container"
+	}
+	var out []vmStat
+	for _, d := range second {
+		st := vmStat{ID: d.name, Name: d.name, Kind: kind, State: libvirtStates[d.stats["state.state"]]}
+		if st.State == "" {
+			st.State = "unknown"
+		}
+		st.CPUs, _ = strconv.Atoi(d.stats["vcpu.current"])
+		if prev := findDomain(first, d.name); prev != nil && st.CPUs > 0 {
+			a, _ := strconv.ParseFloat(prev.stats["cpu.time"], 64)
+			b, _ := strconv.ParseFloat(d.stats["cpu.time"], 64)
+			if b > a {
+				st.CPUPercent = min((b-a)/1e9/elapsed/float64(st.CPUs)*100, 100)
+			}
+		}
+		// Balloon figures are KiB. The guest's own view of used memory needs
+		// its balloon driver; without it, the host's resident size stands in.
+		kib := func(key string) (int64, bool) {
+			v, err := strconv.ParseInt(d.stats[key], 10, 64)
+			return v * 1024, err == nil
+		}
+		available, okA := kib("balloon.available")
+		unused, okU := kib("balloon.unused")
+		if okA && okU && unused <= available {
+			st.MemUsed = available - unused
+		} else {
+			st.MemUsed, _ = kib("balloon.rss")
+		}
+		st.MemTotal, _ = kib("balloon.maximum")
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// domainStats is one domain's block of `virsh domstats --raw`.
+type domainStats struct {
+	name  string
+	stats map[string]string
+}
+
+func findDomain(list []domainStats, name string) *domainStats {
+	for i := range list {
+		if list[i].name == name {
+			return &list[i]
+		}
+	}
+	return nil
+}
+
+// domstats runs virsh domstats and parses its blocks: a "Domain: 'name'"
+// line, then indented key=value lines.
+func (l *libvirtClient) domstats() ([]domainStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "virsh", "-c", l.uri, "domstats", "--raw",
+		"--state", "--cpu-total", "--balloon", "--vcpu").Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && len(exit.Stderr) > 0 {
+			return nil, fmt.Errorf("virsh: %s", strings.TrimSpace(string(exit.Stderr)))
+		}
+		return nil, fmt.Errorf("virsh: %w", err)
+	}
+	var list []domainStats
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if name, ok := strings.CutPrefix(line, "Domain: "); ok {
+			list = append(list, domainStats{name: strings.Trim(name, "'"), stats: map[string]string{}})
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok && len(list) > 0 {
+			list[len(list)-1].stats[k] = v
+		}
+	}
+	return list, sc.Err()
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"health-dashboard/internal/config"
+)
+
+// Where `agent install` puts the agent. The paths match the README's
+// systemd example, so a host set up by hand and one installed this way
+// look the same.
+const (
+	installBinary  = "/usr/local/bin/health-agent"
+	installConfig  = "/etc/health-dashboard/config.yaml"
+	systemdUnit    = "/etc/systemd/system/health-agent.service"
+	launchdLabel   = "com.health-dashboard.agent"
+	launchdPlist   = "/Library/LaunchDaemons/" + launchdLabel + ".plist"
+	launchdLogFile = "/var/log/health-agent.log"
+)
+
+// install implements `agent install`: it copies the running binary to
+// installBinary, writes installConfig from --server-url and --token, and
+// registers and (re)starts the agent as a systemd service on Linux or a
+// launchd daemon on macOS. Run again, it upgrades the binary and keeps the
+// existing config unless new settings are given.
+func install(args []string) error {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	serverURL := fs.String("server-url", "", "dashboard URL to report to (agent.server_url)")
+	token := fs.String("token", "", "agent token (agent.token)")
+	listen := fs.String("listen", "", "also serve /metrics and /healthz on this address, e.g. 127.0.0.1:9101")
+	force := fs.Bool("force", false, "replace an existing "+installConfig)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		return fmt.Errorf("not supported on %s; only systemd (Linux) and launchd (macOS) services can be installed", runtime.GOOS)
+	}
+	if os.Geteuid() != 0 {
+		return errors.New("must run as root (try sudo)")
+	}
+	if *listen != "" {
+		if _, _, err := net.SplitHostPort(*listen); err != nil {
+			return fmt.Errorf("--listen: %v", err)
+		}
+	}
+
+	_, statErr := os.Stat(installConfig)
+	exists := statErr == nil
+	switch {
+	case *serverURL == "" && *token == "":
+		if !exists {
+			return fmt.Errorf("--server-url and --token are required (no %s yet)", installConfig)
+		}
+		log.Printf("agent: keeping %s", installConfig)
+	case *serverURL == "" || *token == "":
+		return errors.New("--server-url and --token must be given together")
+	case exists && !*force:
+		return fmt.Errorf("%s exists; pass --force to replace it, or omit --server-url and --token to keep it", installConfig)
+	default:
+		if err := writeInstallConfig(*serverURL, *token); err != nil {
+			return err
+		}
+		log.Printf("agent: wrote %s", installConfig)
+	}
+	// Catch a broken config now rather than in a restart loop.
+	if _, err := config.Load(installConfig); err != nil {
+		return err
+	}
+
+	if err := copyBinary(installBinary); err != nil {
+		return err
+	}
+	log.Printf("agent: installed %s", installBinary)
+
+	command := []string{installBinary, "--config", installConfig}
+	if *listen != "" {
+		command = append(command, "--listen", *listen)
+	}
+	if runtime.GOOS == "darwin" {
+		return installLaunchd(command)
+	}
+	return installSystemd(command)
+}
+
+// installQueue is the queue_file of an installed agent. A service has no
+// home directory for the default one.
+func installQueue() string {
+	if runtime.GOOS == "darwin" {
+		return "/usr/local/var/health-agent/queue.jsonl"
+	}
+	return "/var/lib/health-agent/queue.jsonl" // systemd's StateDirectory
+}
+
+// writeInstallConfig writes a minimal config for the agent. It holds the
+// token, so only root can read it.
+func writeInstallConfig(serverURL, token string) error {
+	u, err := url.Parse(serverURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("--server-url must be an http or https URL, not %q", serverURL)
+	}
+	var doc struct {
+		Agent struct {
+			ServerURL string `yaml:"server_url"`
+			Token     string `yaml:"token"`
+			QueueFile string `yaml:"queue_file"`
+		} `yaml:"agent"`
+	}
+	doc.Agent.ServerURL = strings.TrimSuffix(serverURL, "/")
+	doc.Agent.Token = token
+	doc.Agent.QueueFile = installQueue()
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	out = append([]byte("# Written by `health-agent install`. See config.yaml in the repository\n# for every agent setting.\n"), out...)
+	if err := os.MkdirAll(filepath.Dir(installConfig), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(installConfig, out, 0o600)
+}
+
+// copyBinary copies the running executable to dst, unless it is already
+// running from there. The copy is renamed into place, so a running agent
+// being upgraded keeps its old file.
+func copyBinary(dst string) error {
+	src, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if src, err = filepath.EvalSymlinks(src); err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(dst); err == nil && resolved == src {
+		return nil
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, data, 0o755)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// installSystemd writes the health-agent unit, a Type=notify service with
+// the watchdog on, and enables and restarts it.
+func installSystemd(command []string) error {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return errors.New("systemctl not found; this host doesn't run systemd")
+	}
+	unit := fmt.Sprintf(`# Written by health-agent install.
+[Unit]
+Description=Health Dashboard agent
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+ExecStart=%s
+WatchdogSec=60
+Restart=on-failure
+RestartSec=5
+StateDirectory=health-agent
+
+[Install]
+WantedBy=multi-user.target
+`, strings.Join(command, " "))
+	if err := writeFileAtomic(systemdUnit, []byte(unit), 0o644); err != nil {
+		return err
+	}
+	log.Printf("agent: wrote %s", systemdUnit)
+	for _, args := range [][]string{{"daemon-reload"}, {"enable", "health-agent"}, {"restart", "health-agent"}} {
+		if err := runCommand("systemctl", args...); err != nil {
+			return err
+		}
+	}
+	log.Printf("agent: health-agent is running; see journalctl -u health-agent")
+	return nil
+}
+
+// installLaunchd writes the launchd daemon's plist and loads it, replacing
+// a loaded copy.
+func installLaunchd(command []string) error {
+	var args bytes.Buffer
+	for _, a := range command {
+		args.WriteString("\t\t<string>")
+		xml.EscapeText(&args, []byte(a))
+		args.WriteString("</string>\n")
+	}
+	plist := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+%s	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`, launchdLabel, args.String(), launchdLogFile)
+	if err := writeFileAtomic(launchdPlist, []byte(plist), 0o644); err != nil {
+		return err
+	}
+	log.Printf("agent: wrote %s", launchdPlist)
+	// bootout fails when the daemon isn't loaded yet, which is fine.
+	exec.Command("launchctl", "bootout", "system/"+launchdLabel).Run()
+	if err := runCommand("launchctl", "bootstrap", "system", launchdPlist); err != nil {
+		return err
+	}
+	log.Printf("agent: %s is running; it logs to %s", launchdLabel, launchdLogFile)
+	return nil
+}
+
+// runCommand runs name with args, returning its output in the error if it
+// fails.
+func runCommand(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// staleAfter is how long the agent may go without delivering a payload
+// before /healthz reports it unhealthy: three missed cycles.
+const staleAfter = 3 * reportInterval
+
+// localServer is the agent's optional HTTP listener (--listen). It serves
+// the latest payload at /metrics for Prometheus and the agent's delivery
+// status at /healthz. A nil localServer ignores every update.
+type localServer struct {
+	started time.Time
+
+	mu        sync.Mutex
+	latest    *metricsPayload
+	lastSend  time.Time
+	lastError string
+	errorAt   time.Time
+	queued    int
+}
+
+func newLocalServer() *localServer {
+	return &localServer{started: time.Now()}
+}
+
+// collected records p as the payload to serve.
+func (s *localServer) collected(p metricsPayload) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.latest = &p
+	s.mu.Unlock()
+}
+
+// sent records a delivered payload; queued is what is still waiting.
+func (s *localServer) sent(queued int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.lastSend, s.queued = time.Now(), queued
+	s.mu.Unlock()
+}
+
+// failed records err, from collecting or sending a payload.
+func (s *localServer) failed(err error, queued int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.lastError, s.errorAt, s.queued = err.Error(), time.Now(), queued
+	s.mu.Unlock()
+}
+
+// serve listens on addr until the listener fails.
+func (s *localServer) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	log.Fatalf("agent: listen: %v", srv.ListenAndServe())
+}
+
+// handleHealthz reports whether the agent is delivering data: 200 while a
+// payload got through in the last staleAfter (or the agent started that
+// recently), 503 otherwise. Both carry the details as JSON.
+func (s *localServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	type status struct {
+		Status      string     `json:"status"` // "ok" or "stale"
+		StartedAt   time.Time  `json:"started_at"`
+		LastCollect *time.Time `json:"last_collect"`
+		LastSend    *time.Time `json:"last_send"`
+		LastError   string     `json:"last_error,omitempty"`
+		LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+		Queued      int        `json:"queued"`
+	}
+	opt := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		t = t.UTC().Truncate(time.Second)
+		return &t
+	}
+	s.mu.Lock()
+	st := status{
+		Status:      "ok",
+		StartedAt:   s.started.UTC().Truncate(time.Second),
+		LastSend:    opt(s.lastSend),
+		LastError:   s.lastError,
+		LastErrorAt: opt(s.errorAt),
+		Queued:      s.queued,
+	}
+	if s.latest != nil {
+		st.LastCollect = opt(s.latest.CollectedAt)
+	}
+	since := s.lastSend
+	if since.IsZero() {
+		since = s.started
+	}
+	s.mu.Unlock()
+
+	code := http.StatusOK
+	if time.Since(since) > staleAfter {
+		st.Status, code = "stale", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(st)
+}
@@ -2,77 +2,165 @@
 // It collects system metrics (CPU, memory, disk) every 30s and POSTs them
 // to the server's POST /api/metrics endpoint using a shared token.
 //
-// Supported platforms: Linux (reads /proc/stat, /proc/meminfo, /proc/mounts).
+// Supported platforms: Linux (reads /proc/stat, /proc/loadavg, /proc/meminfo, /proc/mounts)
+// and macOS (host_statistics, sysctl, getfsstat; built with cgo).
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"strconv"
-	"strings"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
 	"syscall"
 	"time"
 
 	"health-dashboard/internal/config"
 )
 
-// diskStat holds space usage for a single mount point.
+// diskStat holds space and inode usage for a single mount point. Inode
+// counts are 0 on filesystems that allocate inodes dynamically (btrfs, ZFS).
 type diskStat struct {
-	Mount string `json:"mount"`
-	Used  int64  `json:"used"`
-	Total int64  `json:"total"`
+	Mount       string `json:"mount"`
+	Used        int64  `json:"used"`
+	Total       int64  `json:"total"`
+	InodesUsed  int64  `json:"inodes_used"`
+	InodesTotal int64  `json:"inodes_total"`
 }
 
+// netStat holds throughput for a single network interface over the sample.
+type netStat struct {
+	Iface           string  `json:"iface"`
+	RxBytesPerSec   float64 `json:"rx_bytes_per_sec"`
+	TxBytesPerSec   float64 `json:"tx_bytes_per_sec"`
+	RxPacketsPerSec float64 `json:"rx_packets_per_sec"`
+	TxPacketsPerSec float64 `json:"tx_packets_per_sec"`
+}
+
+// diskIOStat holds I/O rates for a single block device over the sample.
+type diskIOStat struct {
+	Device           string  `json:"device"`
+	ReadsPerSec      float64 `json:"reads_per_sec"`
+	WritesPerSec     float64 `json:"writes_per_sec"`
+	ReadBytesPerSec  float64 `json:"read_bytes_per_sec"`
+	WriteBytesPerSec float64 `json:"write_bytes_per_sec"`
+}
+
+// tempStat is one temperature sensor reading.
+type tempStat struct {
+	Sensor  string  `json:"sensor"` // chip/label, e.g. "coretemp/Package id 0", "nvme/Composite"
+	Celsius float64 `json:"celsius"`
+}
+
+// procStat is one process in the top-N lists.
+type procStat struct {
+	PID        int     `json:"pid"`
+	Name       string  `json:"name"`
+	CPUPercent float64 `json:"cpu_percent"` // share of total CPU capacity, like cpu_percent
+	RSS        int64   `json:"rss"`         // resident memory in bytes
+}
+
+// version is the agent's release, set at build time with
+// -ldflags "-X main.version=v1.4.0".
+var version = "dev"
+
+// agentInfo describes the agent and the machine it runs on.
+type agentInfo struct {
+	Version  string `json:"version"`
+	OS       string `json:"os"`                 // GOOS: "linux", "darwin", ...
+	Platform string `json:"platform,omitempty"` // e.g. "Ubuntu 24.04 LTS", "macOS 14.5"
+	Kernel   string `json:"kernel,omitempty"`
+	Arch     string `json:"arch"` // GOARCH: "amd64", "arm64", ...
+}
+
+// thisAgent is read once: the kernel and distribution only change across
+// a reboot, which restarts the agent.
+var thisAgent = sync.OnceValue(func() agentInfo {
+	name, kernel := readPlatform()
+	return agentInfo{Version: version, OS: runtime.GOOS, Platform: name, Kernel: kernel, Arch: runtime.GOARCH}
+})
+
+// topN is how many processes are reported by CPU and by memory.
+const topN = 5
+
+// reportInterval is how often the agent collects and sends a payload.
+const reportInterval = 30 * time.Second
+
 // metricsPayload is the JSON body sent to POST /api/metrics.
 type metricsPayload struct {
-	CPUPercent float64    `json:"cpu_percent"`
-	MemUsed    int64      `json:"mem_used"`
-	MemTotal   int64      `json:"mem_total"`
-	Disks      []diskStat `json:"disks"`
+	CPUPercent float64      `json:"cpu_percent"`
+	CPUCores   []float64    `json:"cpu_cores,omitempty"`
+	Load1      float64      `json:"load_1"`
+	Load5      float64      `json:"load_5"`
+	Load15     float64      `json:"load_15"`
+	MemUsed    int64        `json:"mem_used"`
+	MemTotal   int64        `json:"mem_total"`
+	SwapUsed   int64        `json:"swap_used"`
+	SwapTotal  int64        `json:"swap_total"`
+	Disks      []diskStat   `json:"disks"`
+	Net        []netStat    `json:"net"`
+	DiskIO     []diskIOStat `json:"disk_io"`
+	Temps      []tempStat   `json:"temps"`
+	TopCPU     []procStat   `json:"top_cpu"`
+	TopMem     []procStat   `json:"top_mem"`
+
+	Containers  []containerStat `json:"containers,omitempty"`
+	VMs         []vmStat        `json:"vms,omitempty"`          // from agent.hypervisor
+	FailedUnits []string        `json:"failed_units,omitempty"` // nil on hosts without systemd
+	Sockets     *sockStat       `json:"sockets,omitempty"`      // Linux only
+
+	// CustomMetrics come from agent.plugins, keyed "<plugin>.<name>".
+	CustomMetrics map[string]float64 `json:"custom_metrics,omitempty"`
+
+	// DirSizes are agent.dir_sizes as of their last scan.
+	DirSizes []dirStat `json:"dir_sizes,omitempty"`
+
+	// Pings are the latencies to agent.pings.
+	Pings []pingStat `json:"pings,omitempty"`
+
+	// PeerAddress is agent.peer_address, where other agents check this
+	// host; PeerChecks are its checks of them.
+	PeerAddress string     `json:"peer_address,omitempty"`
+	PeerChecks  []peerStat `json:"peer_checks,omitempty"`
+
+	// NTPOffsetMs is the clock's offset from agent.ntp_server, positive when
+	// it runs ahead; omitted when unset or the last check failed.
+	NTPOffsetMs *float64 `json:"ntp_offset_ms,omitempty"`
+
+	// Agent identifies the agent's version and platform.
+	Agent agentInfo `json:"agent"`
+
+	// CollectedAt lets the server keep a replayed payload's original time.
+	CollectedAt time.Time `json:"collected_at"`
 }
 
-// cpuSample holds raw jiffies from a single /proc/stat reading.
+// sockStat holds system-wide open file handle and TCP socket counts.
+type sockStat struct {
+	FDOpen         int64 `json:"fd_open"`
+	FDMax          int64 `json:"fd_max"` // fs.file-max
+	TCPEstablished int64 `json:"tcp_established"`
+	TCPInUse       int64 `json:"tcp_inuse"` // open TCP sockets in any state but TIME_WAIT, listeners included
+	TCPTimeWait    int64 `json:"tcp_time_wait"`
+}
+
+// cpuSample holds cumulative CPU time counters from one reading; only the
+// difference between two samples is meaningful.
 type cpuSample struct {
 	total int64
 	idle  int64
 }
 
-// readCPUSample reads the aggregate "cpu" line from /proc/stat.
-func readCPUSample() (cpuSample, error) {
-	f, err := os.Open("/proc/stat")
-	if err != nil {
-		return cpuSample{}, err
-	}
-	defer f.Close()
-
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "cpu ") {
-			continue
-		}
-		// cpu  user nice system idle iowait irq softirq steal ...
-		fields := strings.Fields(line)
-		if len(fields) < 8 {
-			break
-		}
-		var v [8]int64
-		for i := range v {
-			v[i], _ = strconv.ParseInt(fields[i+1], 10, 64)
-		}
-		total := v[0] + v[1] + v[2] + v[3] + v[4] + v[5] + v[6] + v[7]
-		idle := v[3] + v[4] // idle + iowait
-		return cpuSample{total: total, idle: idle}, nil
-	}
-	return cpuSample{}, fmt.Errorf("cpu line not found in /proc/stat")
-}
-
 // cpuPercentBetween calculates the CPU usage percentage between two samples.
 func cpuPercentBetween(a, b cpuSample) float64 {
 	totalDelta := b.total - a.total
@@ -87,136 +175,259 @@ func cpuPercentBetween(a, b cpuSample) float64 {
 	return pct
 }
 
-// readMemInfo returns (used, total) bytes from /proc/meminfo.
-// used = MemTotal - MemAvailable.
-func readMemInfo() (used, total int64, err error) {
-	f, err := os.Open("/proc/meminfo")
-	if err != nil {
-		return 0, 0, err
+// corePercentsBetween is cpuPercentBetween for each core, matched by core
+// number and sorted by it. A core missing from either reading (e.g. taken
+// offline between them) is skipped rather than paired with its neighbour.
+func corePercentsBetween(a, b map[int]cpuSample) []float64 {
+	ids := make([]int, 0, len(b))
+	for id := range b {
+		if _, ok := a[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	pcts := make([]float64, len(ids))
+	for i, id := range ids {
+		pcts[i] = cpuPercentBetween(a[id], b[id])
 	}
-	defer f.Close()
+	return pcts
+}
+
+// netCounters holds cumulative counters for one interface.
+type netCounters struct {
+	rxBytes, txBytes, rxPackets, txPackets uint64
+}
 
-	var memTotal, memAvailable int64
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		fields := strings.Fields(scanner.Text())
-		if len(fields) < 2 {
+// netRatesBetween converts two counter readings taken secs apart into
+// per-second rates, sorted by interface. Interfaces missing from either
+// reading, or whose counters went backwards (reset), are skipped.
+func netRatesBetween(a, b map[string]netCounters, secs float64) []netStat {
+	if secs <= 0 {
+		return nil
+	}
+	var stats []netStat
+	for iface, nb := range b {
+		na, ok := a[iface]
+		if !ok || nb.rxBytes < na.rxBytes || nb.txBytes < na.txBytes ||
+			nb.rxPackets < na.rxPackets || nb.txPackets < na.txPackets {
 			continue
 		}
-		// Values are in kB; convert to bytes.
-		val, _ := strconv.ParseInt(fields[1], 10, 64)
-		val *= 1024
-		switch fields[0] {
-		case "MemTotal:":
-			memTotal = val
-		case "MemAvailable:":
-			memAvailable = val
-		}
+		stats = append(stats, netStat{
+			Iface:           iface,
+			RxBytesPerSec:   float64(nb.rxBytes-na.rxBytes) / secs,
+			TxBytesPerSec:   float64(nb.txBytes-na.txBytes) / secs,
+			RxPacketsPerSec: float64(nb.rxPackets-na.rxPackets) / secs,
+			TxPacketsPerSec: float64(nb.txPackets-na.txPackets) / secs,
+		})
 	}
-	return memTotal - memAvailable, memTotal, nil
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Iface < stats[j].Iface })
+	return stats
 }
 
-// virtualFSTypes is the set of filesystem types we skip when collecting disk stats.
-var virtualFSTypes = map[string]bool{
-	"tmpfs": true, "devtmpfs": true, "sysfs": true, "proc": true,
-	"cgroup": true, "cgroup2": true, "devpts": true, "hugetlbfs": true,
-	"mqueue": true, "pstore": true, "securityfs": true, "debugfs": true,
-	"tracefs": true, "bpf": true, "overlay": true, "fusectl": true,
-	"squashfs": true, "nsfs": true, "efivarfs": true,
+// ioCounters holds cumulative completed-operation and byte counters for one
+// block device.
+type ioCounters struct {
+	reads, writes, readBytes, writeBytes uint64
 }
 
-// readDiskStats returns used/total bytes for each real mounted filesystem
-// by reading /proc/mounts and calling Statfs on each mount point.
-func readDiskStats() ([]diskStat, error) {
-	f, err := os.Open("/proc/mounts")
-	if err != nil {
-		return nil, err
+// diskIORatesBetween is netRatesBetween for block devices.
+func diskIORatesBetween(a, b map[string]ioCounters, secs float64) []diskIOStat {
+	if secs <= 0 {
+		return nil
 	}
-	defer f.Close()
-
-	seen := make(map[string]bool)
-	var disks []diskStat
-
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "#") {
+	var stats []diskIOStat
+	for dev, cb := range b {
+		ca, ok := a[dev]
+		if !ok || cb.reads < ca.reads || cb.writes < ca.writes ||
+			cb.readBytes < ca.readBytes || cb.writeBytes < ca.writeBytes {
 			continue
 		}
-		// Format: device mountpoint fstype options dump pass
-		fields := strings.Fields(line)
-		if len(fields) < 3 {
-			continue
-		}
-		mount := fields[1]
-		fstype := fields[2]
+		stats = append(stats, diskIOStat{
+			Device:           dev,
+			ReadsPerSec:      float64(cb.reads-ca.reads) / secs,
+			WritesPerSec:     float64(cb.writes-ca.writes) / secs,
+			ReadBytesPerSec:  float64(cb.readBytes-ca.readBytes) / secs,
+			WriteBytesPerSec: float64(cb.writeBytes-ca.writeBytes) / secs,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Device < stats[j].Device })
+	return stats
+}
 
-		if virtualFSTypes[fstype] {
-			continue
-		}
-		if seen[mount] {
-			continue
+// procCounters holds one process's name, cumulative CPU ticks and current
+// resident memory.
+type procCounters struct {
+	name  string
+	ticks int64
+	rss   int64
+}
+
+// topProcesses ranks the processes alive in both readings by CPU used
+// between them (as a share of cpuTicks, the machine-wide tick delta) and by
+// resident memory in the second reading, returning the top n of each.
+func topProcesses(a, b map[int]procCounters, cpuTicks int64, n int) (byCPU, byMem []procStat) {
+	procs := make([]procStat, 0, len(b))
+	for pid, pb := range b {
+		var pct float64
+		if pa, ok := a[pid]; ok && cpuTicks > 0 && pb.ticks >= pa.ticks {
+			pct = min(100*float64(pb.ticks-pa.ticks)/float64(cpuTicks), 100)
 		}
-		seen[mount] = true
+		procs = append(procs, procStat{PID: pid, Name: pb.name, CPUPercent: pct, RSS: pb.rss})
+	}
 
-		var stat syscall.Statfs_t
-		if err := syscall.Statfs(mount, &stat); err != nil {
-			continue // inaccessible mount — skip silently
+	sort.Slice(procs, func(i, j int) bool { return procs[i].CPUPercent > procs[j].CPUPercent })
+	for _, p := range procs[:min(n, len(procs))] {
+		if p.CPUPercent > 0 {
+			byCPU = append(byCPU, p)
 		}
-		if stat.Blocks == 0 {
-			continue
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].RSS > procs[j].RSS })
+	for _, p := range procs[:min(n, len(procs))] {
+		if p.RSS > 0 {
+			byMem = append(byMem, p)
 		}
-		total := int64(stat.Blocks) * stat.Bsize
-		used := int64(stat.Blocks-stat.Bfree) * stat.Bsize
-		disks = append(disks, diskStat{Mount: mount, Used: used, Total: total})
 	}
-	return disks, nil
+	return byCPU, byMem
 }
 
-// collect gathers a full metrics snapshot.
-// CPU sampling takes ~1s (two /proc/stat reads with a 1s sleep between them).
-func collect() (metricsPayload, error) {
+// collect gathers a full metrics snapshot, including per-core CPU
+// utilization when perCore is set.
+// CPU, network, disk I/O and process sampling takes ~1s (two counter reads with a 1s
+// sleep between them).
+func collect(perCore bool) (metricsPayload, error) {
 	s1, err := readCPUSample()
 	if err != nil {
 		return metricsPayload{}, fmt.Errorf("cpu sample 1: %w", err)
 	}
+	var c1, c2 map[int]cpuSample
+	if perCore {
+		if c1, err = readCoreSamples(); err != nil {
+			return metricsPayload{}, fmt.Errorf("per-core cpu sample 1: %w", err)
+		}
+	}
+	n1, err := readNetCounters()
+	if err != nil {
+		return metricsPayload{}, fmt.Errorf("net sample 1: %w", err)
+	}
+	io1, err := readIOCounters()
+	if err != nil {
+		return metricsPayload{}, fmt.Errorf("disk io sample 1: %w", err)
+	}
+	p1, err := readProcCounters()
+	if err != nil {
+		return metricsPayload{}, fmt.Errorf("process sample 1: %w", err)
+	}
+	t1 := time.Now()
 	time.Sleep(time.Second)
 	s2, err := readCPUSample()
 	if err != nil {
 		return metricsPayload{}, fmt.Errorf("cpu sample 2: %w", err)
 	}
+	if perCore {
+		if c2, err = readCoreSamples(); err != nil {
+			return metricsPayload{}, fmt.Errorf("per-core cpu sample 2: %w", err)
+		}
+	}
+	n2, err := readNetCounters()
+	if err != nil {
+		return metricsPayload{}, fmt.Errorf("net sample 2: %w", err)
+	}
+	io2, err := readIOCounters()
+	if err != nil {
+		return metricsPayload{}, fmt.Errorf("disk io sample 2: %w", err)
+	}
+	p2, err := readProcCounters()
+	if err != nil {
+		return metricsPayload{}, fmt.Errorf("process sample 2: %w", err)
+	}
+	elapsed := time.Since(t1).Seconds()
+
+	load, err := readLoadAvg()
+	if err != nil {
+		return metricsPayload{}, fmt.Errorf("loadavg: %w", err)
+	}
 
 	memUsed, memTotal, err := readMemInfo()
 	if err != nil {
 		return metricsPayload{}, fmt.Errorf("meminfo: %w", err)
 	}
+	swapUsed, swapTotal, err := readSwapInfo()
+	if err != nil {
+		return metricsPayload{}, fmt.Errorf("swap: %w", err)
+	}
 
 	disks, err := readDiskStats()
 	if err != nil {
 		return metricsPayload{}, fmt.Errorf("diskstats: %w", err)
 	}
 
+	temps, err := readTemperatures()
+	if err != nil {
+		return metricsPayload{}, fmt.Errorf("temperatures: %w", err)
+	}
+
+	topCPU, topMem := topProcesses(p1, p2, s2.total-s1.total, topN)
+
 	return metricsPayload{
 		CPUPercent: cpuPercentBetween(s1, s2),
+		CPUCores:   corePercentsBetween(c1, c2),
+		Load1:      load[0],
+		Load5:      load[1],
+		Load15:     load[2],
 		MemUsed:    memUsed,
 		MemTotal:   memTotal,
+		SwapUsed:   swapUsed,
+		SwapTotal:  swapTotal,
 		Disks:      disks,
+		Net:        netRatesBetween(n1, n2, elapsed),
+		DiskIO:     diskIORatesBetween(io1, io2, elapsed),
+		Temps:      temps,
+		TopCPU:     topCPU,
+		TopMem:     topMem,
 	}, nil
 }
 
-// send POSTs the metrics payload to the server.
-func send(client *http.Client, serverURL, token string, payload metricsPayload) error {
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return err
+// rejectedError is returned by send when the server refused the payload
+// itself (a 4xx other than 429), so sending it again won't help.
+type rejectedError struct {
+	status int
+	size   int // the payload's bytes before compression
+}
+
+func (e *rejectedError) Error() string {
+	if e.status == http.StatusRequestEntityTooLarge {
+		return fmt.Sprintf("server rejected payload as too large (HTTP 413, %d bytes before compression)", e.size)
 	}
+	return fmt.Sprintf("server rejected payload with HTTP %d", e.status)
+}
 
+// send POSTs one JSON-encoded metrics payload to the server, gzipped if
+// compress is set. host names the agent in the server's ingestion stats.
+func send(client *http.Client, serverURL, token, host string, body []byte, compress bool) error {
+	size := len(body)
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
 	req, err := http.NewRequest(http.MethodPost, serverURL+"/api/metrics", bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 	req.Header.Set("X-Agent-Token", token)
+	req.Header.Set("X-Agent-Host", host)
+	req.Header.Set("User-Agent", "health-agent/"+version)
+	req.Header.Set("X-Agent-Time", time.Now().UTC().Format(time.RFC3339Nano))
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -224,34 +435,233 @@ func send(client *http.Client, serverURL, token string, payload metricsPayload)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &busyError{retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	// A 401 or a proxy's 407 is a credentials problem, not the payload's.
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusProxyAuthRequired {
+		return &rejectedError{status: resp.StatusCode, size: size}
+	}
 	if resp.StatusCode != http.StatusNoContent {
 		return fmt.Errorf("server returned HTTP %d", resp.StatusCode)
 	}
 	return nil
 }
 
-func run(client *http.Client, serverURL, token string) {
-	payload, err := collect()
+// sources are the optional collectors agent config turns on; each is nil
+// when off.
+type sources struct {
+	docker     *dockerClient
+	hypervisor hypervisor
+	dirs       *dirScanner // agent.dir_sizes
+}
+
+// gather collects a full payload: the host metrics from collect, then the
+// optional extras, checking peers if given any. Only a collect error is
+// returned; the extras' errors are logged and their fields left empty.
+func gather(cfg config.AgentConfig, src sources, peers []peer) (metricsPayload, error) {
+	// Pings and the hypervisor wait on the network or sample over a second,
+	// so they run during the CPU sample.
+	pings := make(chan []pingStat, 1)
+	go func() { pings <- runPings(cfg.Pings) }()
+	checks := make(chan []peerStat, 1)
+	go func() { checks <- runPeerChecks(peers) }()
+	vms := make(chan []vmStat, 1)
+	go func() {
+		if src.hypervisor == nil {
+			vms <- nil
+			return
+		}
+		list, err := src.hypervisor.vms()
+		if err != nil {
+			log.Printf("agent: hypervisor: %v", err)
+		}
+		vms <- list
+	}()
+	payload, err := collect(cfg.PerCoreCPU)
+	payload.Pings, payload.PeerChecks, payload.VMs = <-pings, <-checks, <-vms
+	if err != nil {
+		return payload, err
+	}
+	payload.PeerAddress = cfg.PeerAddress
+	payload.Agent = thisAgent()
+	payload.CollectedAt = time.Now().UTC().Truncate(time.Second)
+	if payload.FailedUnits, err = readFailedUnits(); err != nil {
+		log.Printf("agent: failed units: %v", err)
+	}
+	if payload.Sockets, err = readSockStats(); err != nil {
+		log.Printf("agent: socket stats: %v", err)
+	}
+	if src.docker != nil {
+		if payload.Containers, err = src.docker.containers(); err != nil {
+			log.Printf("agent: docker: %v", err)
+		}
+	}
+	payload.DirSizes = src.dirs.latest()
+	if cfg.NTPServer != "" {
+		if payload.NTPOffsetMs, err = clock.offset(cfg.NTPServer); err != nil {
+			log.Printf("agent: clock check: %v", err)
+		}
+	}
+	var pluginErrs []error
+	payload.CustomMetrics, pluginErrs = runPlugins(cfg.Plugins, time.Duration(cfg.PluginTimeout)*time.Second)
+	for _, err := range pluginErrs {
+		log.Printf("agent: plugin %v", err)
+	}
+	return payload, nil
+}
+
+// run collects one payload and sends it, after any payloads queued while
+// the server was unreachable, so history is replayed in order. An error from
+// one of src (Docker, say) is logged and the host metrics still go out.
+// With agent.peer_checks, the peers are fetched from the server first. A
+// payload that can't be sent now is queued.
+// Threshold events are posted first, unless the server asked to back off.
+// local, if not nil, serves the payload whether or not it is sent, and the
+// outcome for /healthz. run returns what kept the payload from reaching the
+// server, or nil if it was sent (or held back while backing off).
+func run(client *http.Client, cfg config.AgentConfig, src sources, q *queue, bo *backoff, th *thresholds, local *localServer) error {
+	var peers []peer
+	if cfg.PeerChecks && !bo.waiting() {
+		var err error
+		if peers, err = mesh.get(client, cfg.ServerURL, cfg.Token, cfg.Hostname); err != nil {
+			log.Printf("agent: peer list: %v", err)
+		}
+	}
+	payload, err := gather(cfg, src, peers)
 	if err != nil {
 		log.Printf("agent: collect error: %v", err)
-		return
+		err = fmt.Errorf("collect: %w", err)
+		local.failed(err, q.len())
+		return err
 	}
-	if err := send(client, serverURL, token, payload); err != nil {
-		log.Printf("agent: send error: %v", err)
-		return
+	local.collected(payload)
+	if !bo.waiting() {
+		th.check(client, cfg.ServerURL, cfg.APIKey, &payload, time.Now())
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("agent: encode error: %v", err)
+		return fmt.Errorf("encode: %w", err)
+	}
+
+	if !bo.waiting() {
+		post := func(b []byte) error { return send(client, cfg.ServerURL, cfg.Token, cfg.Hostname, b, cfg.Gzip) }
+		if queued := q.len(); queued > 0 {
+			if err = q.drain(post); err == nil {
+				log.Printf("agent: replayed %d queued payloads", queued)
+			}
+		}
+		if err == nil {
+			if err = post(body); err == nil {
+				bo.ok()
+				local.sent(q.len())
+				log.Printf("agent: sent cpu=%.1f%% load=%.2f mem=%d/%d disks=%d",
+					payload.CPUPercent, payload.Load1, payload.MemUsed, payload.MemTotal, len(payload.Disks))
+				return nil
+			}
+		}
+		var rejected *rejectedError
+		if errors.As(err, &rejected) {
+			log.Printf("agent: send error: %v", err)
+			local.failed(err, q.len())
+			return err
+		}
+		var busy *busyError
+		if errors.As(err, &busy) {
+			log.Printf("agent: %v; backing off for %s", err, bo.failed(busy.retryAfter, reportInterval).Round(time.Second))
+		} else {
+			log.Printf("agent: send error: %v; retrying in %s", err, bo.failed(0, reportInterval).Round(time.Second))
+		}
 	}
-	log.Printf("agent: sent cpu=%.1f%% mem=%d/%d disks=%d",
-		payload.CPUPercent, payload.MemUsed, payload.MemTotal, len(payload.Disks))
+	if qerr := q.push(body); qerr != nil {
+		log.Printf("agent: queue: %v", qerr)
+		err = errors.Join(err, fmt.Errorf("queue: %w", qerr))
+	}
+	if err != nil {
+		local.failed(err, q.len())
+	}
+	return err
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "install" {
+		if err := install(os.Args[2:]); err != nil {
+			log.Fatalf("agent: install: %v", err)
+		}
+		return
+	}
+
 	configPath := flag.String("config", "config.yaml", "path to config file")
+	listen := flag.String("listen", "", "serve /metrics (Prometheus format) and /healthz on this address, e.g. :9101")
+	once := flag.Bool("once", false, "collect and send one sample, then exit; non-zero if it wasn't sent (for cron)")
+	printOnly := flag.Bool("print", false, "collect one sample and write it to stdout as JSON instead of sending it")
+	showVersion := flag.Bool("version", false, "print the agent's version and platform, then exit")
 	flag.Parse()
+	if *showVersion {
+		a := thisAgent()
+		fmt.Printf("health-agent %s %s/%s %s %s\n", a.Version, a.OS, a.Arch, a.Kernel, a.Platform)
+		return
+	}
+	if *once && *listen != "" {
+		log.Fatal("agent: --once and --listen can't be combined")
+	}
+	if *printOnly && (*once || *listen != "") {
+		log.Fatal("agent: --print can't be combined with --once or --listen")
+	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
 		log.Fatalf("agent: config: %v", err)
 	}
+
+	var src sources
+	if cfg.Agent.Docker {
+		socket := cfg.Agent.DockerSocket
+		if socket == "" {
+			socket = "/var/run/docker.sock"
+		}
+		src.docker = newDockerClient(socket)
+		log.Printf("agent: collecting container stats from %s", socket)
+	}
+	if cfg.Agent.PluginTimeout <= 0 {
+		cfg.Agent.PluginTimeout = 10
+	}
+	if src.hypervisor, err = newHypervisor(cfg.Agent.Hypervisor); err != nil {
+		log.Fatalf("agent: %v", err)
+	}
+	if src.hypervisor != nil {
+		log.Printf("agent: reporting %s guests", cfg.Agent.Hypervisor.Type)
+	}
+	// A single run can't wait for a background scan, so --print and --once
+	// scan before collecting.
+	src.dirs = newDirScanner(cfg.Agent)
+	if src.dirs != nil && (*printOnly || *once) {
+		src.dirs.scanAll(context.Background())
+	}
+
+	// --print needs no server: it shows exactly the payload that would be
+	// sent, and nothing leaves the machine.
+	if *printOnly {
+		payload, err := gather(cfg.Agent, src, nil)
+		if err != nil {
+			log.Fatalf("agent: collect error: %v", err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(payload); err != nil {
+			log.Fatalf("agent: %v", err)
+		}
+		return
+	}
+
+	if cfg.Agent.Hostname == "" {
+		cfg.Agent.Hostname, _ = os.Hostname()
+	}
+	if cfg.Agent.Jitter < 0 || time.Duration(cfg.Agent.Jitter)*time.Second >= reportInterval {
+		log.Fatalf("agent: agent.jitter must be between 0 and %d seconds", int(reportInterval.Seconds())-1)
+	}
 	if cfg.Agent.Token == "" {
 		log.Fatal("agent: agent.token must be set in config.yaml")
 	}
@@ -259,18 +669,113 @@ func main() {
 		log.Fatal("agent: agent.server_url must be set in config.yaml")
 	}
 
-	log.Printf("agent: reporting to %s every 30s", cfg.Agent.ServerURL)
+	var offset time.Duration
+	if cfg.Agent.Splay {
+		offset = splay(cfg.Agent.Hostname)
+	}
+	if !*once {
+		log.Printf("agent: reporting to %s every 30s (splay %s, jitter up to %ds)",
+			cfg.Agent.ServerURL, offset.Round(time.Millisecond), cfg.Agent.Jitter)
+	}
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	client, err := newHTTPClient(cfg.Agent)
+	if err != nil {
+		log.Fatalf("agent: %v", err)
+	}
+	if u, err := url.Parse(cfg.Agent.ProxyURL); err == nil && u.Host != "" {
+		log.Printf("agent: sending through proxy %s", u.Redacted())
+	}
 
-	// Send once immediately on startup, then tick every 30s.
-	// collect() takes ~1s for the CPU sample, so the effective interval
-	// between reports is ~30s.
-	run(client, cfg.Agent.ServerURL, cfg.Agent.Token)
+	queuePath := cfg.Agent.QueueFile
+	if queuePath == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			log.Fatalf("agent: agent.queue_file must be set: %v", err)
+		}
+		queuePath = filepath.Join(dir, "health-dashboard", "agent-queue.jsonl")
+	}
+	queueMax := cfg.Agent.QueueMax
+	if queueMax <= 0 {
+		queueMax = 2880 // a day of samples
+	}
+	q, err := openQueue(queuePath, queueMax)
+	if err != nil {
+		log.Fatalf("agent: queue: %v", err)
+	}
+	if n := q.len(); n > 0 {
+		log.Printf("agent: %d payloads queued in %s", n, queuePath)
+	}
+
+	if len(cfg.Agent.Plugins) > 0 {
+		log.Printf("agent: running %d plugins each cycle", len(cfg.Agent.Plugins))
+	}
+
+	if err := validateThresholds(cfg.Agent.Thresholds); err != nil {
+		log.Fatalf("agent: %v", err)
+	}
+	if cfg.Agent.APIKey == "" {
+		cfg.Agent.APIKey = cfg.Events.APIKey
+	}
+	if len(cfg.Agent.Thresholds) > 0 && cfg.Agent.APIKey == "" {
+		log.Fatal("agent: agent.api_key (or events.api_key) must be set to post threshold events")
+	}
+	th := &thresholds{rules: cfg.Agent.Thresholds}
 
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-	for range ticker.C {
-		run(client, cfg.Agent.ServerURL, cfg.Agent.Token)
+	var local *localServer
+	if *listen != "" {
+		local = newLocalServer()
+		go local.serve(*listen)
+		log.Printf("agent: serving /metrics and /healthz on %s", *listen)
 	}
+
+	// Send once on startup, after the splay, then tick every 30s.
+	// collect() takes ~1s for the CPU sample, so the effective interval
+	// between reports is ~30s. Jitter delays each send within its tick.
+	var bo backoff
+	if *once {
+		// Hosts run from the same cron schedule are spread out too.
+		time.Sleep(offset + jitter(cfg.Agent.Jitter))
+		// A sample that can't be sent stays queued for the next run.
+		if err := run(client, cfg.Agent, src, q, &bo, th, local); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if src.dirs != nil {
+		go src.dirs.run(ctx)
+	}
+	// Under systemd with Type=notify, the agent is ready once configured;
+	// the first send comes after the splay.
+	wd := newWatchdog()
+	if wd != nil {
+		go wd.run()
+		log.Printf("agent: feeding the systemd watchdog (%s) while sends succeed", wd.interval)
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("agent: sd_notify: %v", err)
+	}
+	if sleepCtx(ctx, offset) {
+		ticker := time.NewTicker(reportInterval)
+		defer ticker.Stop()
+		for sleepCtx(ctx, jitter(cfg.Agent.Jitter)) {
+			if run(client, cfg.Agent, src, q, &bo, th, local) == nil {
+				wd.ok()
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	// On shutdown, send a last sample (and anything queued) so a host going
+	// away doesn't lose its last interval. This tries even while backing
+	// off; what can't be sent stays queued. A second signal exits at once.
+	stop()
+	sdNotify("STOPPING=1")
+	log.Printf("agent: shutting down; sending a final sample")
+	var final backoff
+	run(client, cfg.Agent, src, q, &final, th, local)
 }
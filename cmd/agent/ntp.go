@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ntpInterval is how often the clock is checked against agent.ntp_server,
+// so a fleet doesn't query a public pool every 30s. Payloads in between
+// carry the last offset.
+const ntpInterval = 5 * time.Minute
+
+// ntpEpoch is the NTP era-0 epoch, 1900-01-01, as a unix time.
+const ntpEpoch = -2208988800
+
+// clockCheck caches the last clock offset measured against one source.
+type clockCheck struct {
+	source   string
+	checked  time.Time
+	offsetMs *float64
+}
+
+var clock clockCheck
+
+// offset returns the local clock's offset from source in milliseconds
+// (positive when it runs ahead), measuring it again once ntpInterval has
+// passed. source is an NTP server ("host" or "host:port"), or "chronyc" to
+// ask the local chronyd instead.
+func (c *clockCheck) offset(source string) (*float64, error) {
+	if source == c.source && time.Since(c.checked) < ntpInterval {
+		return c.offsetMs, nil
+	}
+	var ms float64
+	var err error
+	if source == "chronyc" {
+		ms, err = chronyOffset()
+	} else {
+		ms, err = sntpOffset(source)
+	}
+	c.source, c.checked = source, time.Now()
+	if err != nil {
+		c.offsetMs = nil
+		return nil, err
+	}
+	c.offsetMs = &ms
+	return c.offsetMs, nil
+}
+
+// sntpOffset sends one SNTP request (RFC 4330) to server and computes the
+// offset from the four timestamps, which cancels out symmetric network delay.
+func sntpOffset(server string) (float64, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", server, 5*time.Second)
+	if err != nil {
+		return 0, fmt.Errorf("ntp: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := make([]byte, 48)
+	req[0] = 0x23 // no leap warning, version 4, client mode
+	t1 := time.Now()
+	binary.BigEndian.PutUint64(req[40:], ntpTime(t1)) // echoed back as the origin timestamp
+	if _, err := conn.Write(req); err != nil {
+		return 0, fmt.Errorf("ntp: %w", err)
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	t4 := time.Now()
+	if err != nil {
+		return 0, fmt.Errorf("ntp: %w", err)
+	}
+	if n < 48 || resp[0]&0x07 != 4 {
+		return 0, errors.New("ntp: not a server reply")
+	}
+	if binary.BigEndian.Uint64(resp[24:]) != ntpTime(t1) {
+		return 0, errors.New("ntp: reply doesn't match the request")
+	}
+	if stratum := resp[1]; stratum == 0 || stratum > 15 {
+		return 0, fmt.Errorf("ntp: server is unsynchronized (stratum %d)", stratum)
+	}
+	t2 := fromNTPTime(binary.BigEndian.Uint64(resp[32:]))
+	t3 := fromNTPTime(binary.BigEndian.Uint64(resp[40:]))
+	// Server time minus local time, averaged over the two legs; negated so
+	// a fast local clock is positive.
+	serverAhead := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	return -float64(serverAhead.Microseconds()) / 1000, nil
+}
+
+// ntpTime encodes t as a 64-bit NTP timestamp: seconds since 1900 and a
+// binary fraction.
+func ntpTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() - ntpEpoch)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+func fromNTPTime(v uint64) time.Time {
+	secs := int64(v>>32) + ntpEpoch
+	nsec := int64((v & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(secs, nsec)
+}
+
+// chronyOffset reads the system clock's offset from chronyd's tracking
+// report. Its "System time" field is how far the clock is behind NTP time,
+// in seconds.
+func chronyOffset() (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "chronyc", "-c", "tracking").Output()
+	if err != nil {
+		return 0, fmt.Errorf("chronyc: %w", err)
+	}
+	fields := strings.Split(strings.TrimSpace(string(out)), ",")
+	if len(fields) < 5 {
+		return 0, errors.New("chronyc: unexpected tracking output")
+	}
+	slow, err := strconv.ParseFloat(fields[4], 64)
+	if err != nil {
+		return 0, fmt.Errorf("chronyc: system time: %w", err)
+	}
+	return -slow * 1000, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// peerRefresh is how often the peer list is fetched again, so hosts that
+// join or leave are picked up without asking the server every cycle.
+const peerRefresh = 5 * time.Minute
+
+// peer is another agent to check, from GET /api/agent/peers.
+type peer struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// peerStat is one peer's reachability from this host.
+type peerStat struct {
+	Peer string `json:"peer"`
+	pingStat
+}
+
+// peerList caches the peers the server last returned.
+type peerList struct {
+	fetched time.Time
+	peers   []peer
+}
+
+var mesh peerList
+
+// get returns the other agents to check, fetching the list again once
+// peerRefresh has passed. If that fails, the previous list is returned with
+// the error and the fetch is retried on the next call.
+func (l *peerList) get(client *http.Client, serverURL, token, host string) ([]peer, error) {
+	if !l.fetched.IsZero() && time.Since(l.fetched) < peerRefresh {
+		return l.peers, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, serverURL+"/api/agent/peers", nil)
+	if err != nil {
+		return l.peers, err
+	}
+	req.Header.Set("X-Agent-Token", token)
+	req.Header.Set("X-Agent-Host", host)
+	req.Header.Set("User-Agent", "health-agent/"+version)
+	resp, err := client.Do(req)
+	if err != nil {
+		return l.peers, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return l.peers, fmt.Errorf("server returned HTTP %d", resp.StatusCode)
+	}
+	var peers []peer
+	if err := json.NewDecoder(resp.Body).Decode(&peers); err != nil {
+		return l.peers, err
+	}
+	l.fetched, l.peers = time.Now(), peers
+	return peers, nil
+}
+
+// runPeerChecks checks every peer at once, the same way as agent.pings.
+func runPeerChecks(peers []peer) []peerStat {
+	if len(peers) == 0 {
+		return nil
+	}
+	targets := make([]string, len(peers))
+	for i, p := range peers {
+		targets[i] = p.Address
+	}
+	stats := make([]peerStat, len(peers))
+	for i, st := range runPings(targets) {
+		stats[i] = peerStat{Peer: peers[i].Name, pingStat: st}
+	}
+	return stats
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Each ping target gets pingCount probes pingSpacing apart, and each probe
+// waits up to pingTimeout for its reply.
+const (
+	pingCount   = 3
+	pingSpacing = 200 * time.Millisecond
+	pingTimeout = 2 * time.Second
+)
+
+// pingStat is one target's latency over a cycle's probes.
+type pingStat struct {
+	Target string `json:"target"`
+	Method string `json:"method"` // "icmp" or "tcp"
+	// RTTMs is the average round trip of the probes answered; nil if none were.
+	RTTMs       *float64 `json:"rtt_ms"`
+	LossPercent float64  `json:"loss_percent"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// runPings probes every target at once. A target is a host pinged with
+// ICMP echo, "host:port" timed with TCP connects, or "gateway" for the
+// default IPv4 gateway.
+func runPings(targets []string) []pingStat {
+	if len(targets) == 0 {
+		return nil
+	}
+	stats := make([]pingStat, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stats[i] = ping(target)
+		}()
+	}
+	wg.Wait()
+	return stats
+}
+
+func ping(target string) pingStat {
+	st := pingStat{Target: target, Method: "icmp", LossPercent: 100}
+	var rtts []time.Duration
+	var err error
+	if host, port, splitErr := net.SplitHostPort(target); splitErr == nil {
+		st.Method = "tcp"
+		rtts, err = tcpPing(net.JoinHostPort(host, port))
+	} else {
+		host := target
+		if target == "gateway" {
+			host, err = defaultGateway()
+			if err != nil {
+				err = fmt.Errorf("gateway: %w", err)
+			}
+		}
+		if err == nil {
+			rtts, err = icmpPing(host)
+		}
+	}
+	if err != nil {
+		st.Error = err.Error()
+	}
+	if len(rtts) > 0 {
+		var sum time.Duration
+		for _, d := range rtts {
+			sum += d
+		}
+		ms := float64((sum / time.Duration(len(rtts))).Microseconds()) / 1000
+		st.RTTMs = &ms
+		st.LossPercent = 100 * float64(pingCount-len(rtts)) / pingCount
+	}
+	return st
+}
+
+// tcpPing times pingCount connections to addr, each closed at once.
+func tcpPing(addr string) ([]time.Duration, error) {
+	var rtts []time.Duration
+	var lastErr error
+	for i := range pingCount {
+		if i > 0 {
+			time.Sleep(pingSpacing)
+		}
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", addr, pingTimeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		rtts = append(rtts, time.Since(start))
+		conn.Close()
+	}
+	if len(rtts) == 0 {
+		return nil, lastErr
+	}
+	return rtts, nil
+}
+
+// pingSeq numbers echo requests across concurrent pings, so a raw socket,
+// which sees every reply, can tell its own apart.
+var pingSeq atomic.Uint32
+
+// icmpPing sends pingCount echo requests to host. It uses an unprivileged
+// ICMP socket where the kernel allows one (Linux with net.ipv4.ping_group_range
+// covering the agent's group, or macOS), and a raw socket otherwise, which
+// needs root or CAP_NET_RAW.
+func icmpPing(host string) ([]time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	ip := addrs[0].IP
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			ip = a.IP
+			break
+		}
+	}
+
+	network, raw, listen, proto := "udp4", "ip4:icmp", "0.0.0.0", 1
+	var echo, reply icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		network, raw, listen, proto = "udp6", "ip6:ipv6-icmp", "::", 58
+		echo, reply = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+	var dst net.Addr = &net.UDPAddr{IP: ip}
+	conn, err := icmp.ListenPacket(network, listen)
+	if err != nil {
+		if conn, err = icmp.ListenPacket(raw, listen); err != nil {
+			return nil, fmt.Errorf("icmp needs root, CAP_NET_RAW or net.ipv4.ping_group_range: %w", err)
+		}
+		dst = &net.IPAddr{IP: ip}
+	}
+	defer conn.Close()
+
+	// The kernel replaces the ID on unprivileged sockets, so replies are
+	// matched on their sequence number and source.
+	id := os.Getpid() & 0xffff
+	var rtts []time.Duration
+	buf := make([]byte, 1500)
+	for i := range pingCount {
+		if i > 0 {
+			time.Sleep(pingSpacing)
+		}
+		seq := int(pingSeq.Add(1) & 0xffff)
+		msg, err := (&icmp.Message{Type: echo, Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("health-agent")}}).Marshal(nil)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		if _, err := conn.WriteTo(msg, dst); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(start.Add(pingTimeout))
+		for {
+			n, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break // lost
+				}
+				return nil, err
+			}
+			m, err := icmp.ParseMessage(proto, buf[:n])
+			if err != nil || m.Type != reply {
+				continue
+			}
+			if e, ok := m.Body.(*icmp.Echo); ok && e.Seq == seq && sameHost(peer, ip) {
+				rtts = append(rtts, time.Since(start))
+				break
+			}
+		}
+	}
+	if len(rtts) == 0 {
+		return nil, errors.New("no reply")
+	}
+	return rtts, nil
+}
+
+func sameHost(a net.Addr, ip net.IP) bool {
+	switch a := a.(type) {
+	case *net.UDPAddr:
+		return a.IP.Equal(ip)
+	case *net.IPAddr:
+		return a.IP.Equal(ip)
+	}
+	return false
+}
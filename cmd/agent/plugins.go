@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPluginOutput caps how much of a plugin's output is read.
+const maxPluginOutput = 64 << 10
+
+// maxMetricKey is the longest custom metric name the server accepts,
+// including the plugin prefix.
+const maxMetricKey = 128
+
+// runPlugins runs each plugin executable at once, waiting at most timeout
+// for each, and merges their output into one custom metrics map. A plugin
+// prints a JSON object of numbers (or booleans, sent as 1 and 0) on stdout;
+// each key is prefixed with the plugin's file name minus its extension, so
+// "ups.sh" printing {"battery_percent": 97} reports "ups.battery_percent".
+// A failing plugin's metrics are left out and its error returned.
+func runPlugins(paths []string, timeout time.Duration) (map[string]float64, []error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	type result struct {
+		values map[string]float64
+		err    error
+	}
+	results := make([]result, len(paths))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := runPlugin(path, timeout)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", path, err)
+			}
+			results[i] = result{v, err}
+		}()
+	}
+	wg.Wait()
+
+	metrics := make(map[string]float64)
+	var errs []error
+	for _, r := range results {
+		if r.err != nil {
+			errs = append(errs, r.err)
+		}
+		for k, v := range r.values {
+			metrics[k] = v
+		}
+	}
+	return metrics, errs
+}
+
+// runPlugin runs one plugin and parses its output.
+func runPlugin(path string, timeout time.Duration) (map[string]float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path)
+	var stdout, stderr cappedBuffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	// Don't wait on a background process the plugin left holding its output.
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	switch {
+	case ctx.Err() != nil:
+		return nil, fmt.Errorf("timed out after %s", timeout)
+	case err != nil && !errors.Is(err, exec.ErrWaitDelay):
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %.200s", err, msg)
+		}
+		return nil, err
+	case stdout.over:
+		return nil, fmt.Errorf("output exceeds %d bytes", maxPluginOutput)
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(stdout.Bytes(), &raw); err != nil {
+		return nil, fmt.Errorf("output is not a JSON object: %w", err)
+	}
+	prefix := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	values := make(map[string]float64, len(raw))
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := prefix + "." + k
+		if !validMetricKey(name) {
+			return nil, fmt.Errorf("%q is not a valid metric name", name)
+		}
+		switch v := raw[k].(type) {
+		case float64:
+			values[name] = v
+		case bool:
+			values[name] = 0
+			if v {
+				values[name] = 1
+			}
+		default:
+			return nil, fmt.Errorf("%q must be a number or boolean", k)
+		}
+	}
+	return values, nil
+}
+
+// cappedBuffer keeps the first maxPluginOutput bytes written to it and
+// notes whether there were more.
+type cappedBuffer struct {
+	bytes.Buffer
+	over bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := maxPluginOutput - b.Len(); len(p) > room {
+		b.Buffer.Write(p[:max(room, 0)])
+		b.over = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// validMetricKey reports whether name may be sent as a custom metric:
+// letters, digits, '_', '-' and '.', at most maxMetricKey long. The server
+// applies the same rule.
+func validMetricKey(name string) bool {
+	return name != "" && len(name) <= maxMetricKey && strings.IndexFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-.", r))
+	}) < 0
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// handleMetrics serves the latest collected payload in the Prometheus text
+// exposition format.
+func (s *localServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	p := s.latest
+	s.mu.Unlock()
+	if p == nil {
+		http.Error(w, "no metrics collected yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(exposition(p))
+}
+
+// sample is one line of a metric family: label name/value pairs and a value.
+type sample struct {
+	labels []string
+	value  float64
+}
+
+// exposition renders p. Names follow the payload's fields under a
+// health_agent_ prefix; rates keep the payload's per-second units.
+func exposition(p *metricsPayload) []byte {
+	var b bytes.Buffer
+	gauge := func(name, help string, samples ...sample) {
+		if len(samples) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "# HELP health_agent_%s %s\n# TYPE health_agent_%s gauge\n", name, help, name)
+		for _, s := range samples {
+			b.WriteString("health_agent_" + name)
+			if len(s.labels) > 0 {
+				b.WriteByte('{')
+				for i := 0; i < len(s.labels); i += 2 {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, `%s="%s"`, s.labels[i], escapeLabel(s.labels[i+1]))
+				}
+				b.WriteByte('}')
+			}
+			b.WriteString(" " + formatValue(s.value) + "\n")
+		}
+	}
+	one := func(v float64) sample { return sample{value: v} }
+	each := func(n int, f func(i int) sample) []sample {
+		out := make([]sample, n)
+		for i := range out {
+			out[i] = f(i)
+		}
+		return out
+	}
+
+	gauge("collected_timestamp_seconds", "When the latest sample was collected.", one(float64(p.CollectedAt.Unix())))
+	gauge("cpu_percent", "CPU utilization across all cores.", one(p.CPUPercent))
+	gauge("cpu_core_percent", "CPU utilization of one core.", each(len(p.CPUCores), func(i int) sample {
+		return sample{[]string{"core", strconv.Itoa(i)}, p.CPUCores[i]}
+	})...)
+	gauge("load1", "1-minute load average.", one(p.Load1))
+	gauge("load5", "5-minute load average.", one(p.Load5))
+	gauge("load15", "15-minute load average.", one(p.Load15))
+	gauge("memory_used_bytes", "Memory in use.", one(float64(p.MemUsed)))
+	gauge("memory_total_bytes", "Total memory.", one(float64(p.MemTotal)))
+	gauge("swap_used_bytes", "Swap in use.", one(float64(p.SwapUsed)))
+	gauge("swap_total_bytes", "Total swap.", one(float64(p.SwapTotal)))
+
+	disk := func(f func(d diskStat) int64) []sample {
+		return each(len(p.Disks), func(i int) sample {
+			return sample{[]string{"mount", p.Disks[i].Mount}, float64(f(p.Disks[i]))}
+		})
+	}
+	gauge("disk_used_bytes", "Space used on a mount.", disk(func(d diskStat) int64 { return d.Used })...)
+	gauge("disk_total_bytes", "Size of a mount.", disk(func(d diskStat) int64 { return d.Total })...)
+	gauge("disk_inodes_used", "Inodes used on a mount (0 where allocated dynamically).", disk(func(d diskStat) int64 { return d.InodesUsed })...)
+	gauge("disk_inodes_total", "Inodes on a mount (0 where allocated dynamically).", disk(func(d diskStat) int64 { return d.InodesTotal })...)
+
+	nic := func(f func(n netStat) float64) []sample {
+		return each(len(p.Net), func(i int) sample { return sample{[]string{"iface", p.Net[i].Iface}, f(p.Net[i])} })
+	}
+	gauge("network_receive_bytes_per_second", "Bytes received per second.", nic(func(n netStat) float64 { return n.RxBytesPerSec })...)
+	gauge("network_transmit_bytes_per_second", "Bytes sent per second.", nic(func(n netStat) float64 { return n.TxBytesPerSec })...)
+	gauge("network_receive_packets_per_second", "Packets received per second.", nic(func(n netStat) float64 { return n.RxPacketsPerSec })...)
+	gauge("network_transmit_packets_per_second", "Packets sent per second.", nic(func(n netStat) float64 { return n.TxPacketsPerSec })...)
+
+	dev := func(f func(d diskIOStat) float64) []sample {
+		return each(len(p.DiskIO), func(i int) sample { return sample{[]string{"device", p.DiskIO[i].Device}, f(p.DiskIO[i])} })
+	}
+	gauge("disk_reads_per_second", "Completed reads per second.", dev(func(d diskIOStat) float64 { return d.ReadsPerSec })...)
+	gauge("disk_writes_per_second", "Completed writes per second.", dev(func(d diskIOStat) float64 { return d.WritesPerSec })...)
+	gauge("disk_read_bytes_per_second", "Bytes read per second.", dev(func(d diskIOStat) float64 { return d.ReadBytesPerSec })...)
+	gauge("disk_written_bytes_per_second", "Bytes written per second.", dev(func(d diskIOStat) float64 { return d.WriteBytesPerSec })...)
+
+	gauge("temperature_celsius", "Temperature sensor reading.", each(len(p.Temps), func(i int) sample {
+		return sample{[]string{"sensor", p.Temps[i].Sensor}, p.Temps[i].Celsius}
+	})...)
+
+	if s := p.Sockets; s != nil {
+		gauge("file_handles_open", "Open file handles.", one(float64(s.FDOpen)))
+		gauge("file_handles_max", "File handle limit (fs.file-max).", one(float64(s.FDMax)))
+		gauge("tcp_established", "Established TCP connections.", one(float64(s.TCPEstablished)))
+		gauge("tcp_inuse", "TCP sockets in any state but TIME_WAIT.", one(float64(s.TCPInUse)))
+		gauge("tcp_time_wait", "TCP sockets in TIME_WAIT.", one(float64(s.TCPTimeWait)))
+	}
+	var answered []pingStat
+	for _, s := range p.Pings {
+		if s.RTTMs != nil {
+			answered = append(answered, s)
+		}
+	}
+	gauge("ping_rtt_seconds", "Average round trip to an agent.pings target.", each(len(answered), func(i int) sample {
+		return sample{[]string{"target", answered[i].Target, "method", answered[i].Method}, *answered[i].RTTMs / 1000}
+	})...)
+	gauge("ping_loss_ratio", "Share of probes to an agent.pings target that got no reply.", each(len(p.Pings), func(i int) sample {
+		return sample{[]string{"target", p.Pings[i].Target, "method", p.Pings[i].Method}, p.Pings[i].LossPercent / 100}
+	})...)
+	var reached []peerStat
+	for _, s := range p.PeerChecks {
+		if s.RTTMs != nil {
+			reached = append(reached, s)
+		}
+	}
+	gauge("peer_rtt_seconds", "Average round trip to another agent, from agent.peer_checks.", each(len(reached), func(i int) sample {
+		return sample{[]string{"peer", reached[i].Peer, "method", reached[i].Method}, *reached[i].RTTMs / 1000}
+	})...)
+	gauge("peer_loss_ratio", "Share of probes to another agent that got no reply.", each(len(p.PeerChecks), func(i int) sample {
+		return sample{[]string{"peer", p.PeerChecks[i].Peer, "method", p.PeerChecks[i].Method}, p.PeerChecks[i].LossPercent / 100}
+	})...)
+	if p.NTPOffsetMs != nil {
+		gauge("clock_offset_seconds", "Clock offset from agent.ntp_server; positive when ahead.", one(*p.NTPOffsetMs/1000))
+	}
+
+	ctr := func(f func(c containerStat) float64) []sample {
+		return each(len(p.Containers), func(i int) sample {
+			c := p.Containers[i]
+			return sample{[]string{"id", c.ID, "name", c.Name, "image", c.Image}, f(c)}
+		})
+	}
+	gauge("container_running", "1 if the container is running.", ctr(func(c containerStat) float64 {
+		if c.State == "running" {
+			return 1
+		}
+		return 0
+	})...)
+	gauge("container_cpu_percent", "Container CPU utilization (100 per core).", ctr(func(c containerStat) float64 { return c.CPUPercent })...)
+	gauge("container_memory_used_bytes", "Container memory in use, excluding page cache.", ctr(func(c containerStat) float64 { return float64(c.MemUsed) })...)
+	gauge("container_memory_limit_bytes", "Container memory limit.", ctr(func(c containerStat) float64 { return float64(c.MemLimit) })...)
+	gauge("container_restarts", "Container restart count.", ctr(func(c containerStat) float64 { return float64(c.RestartCount) })...)
+
+	vm := func(f func(v vmStat) float64) []sample {
+		return each(len(p.VMs), func(i int) sample {
+			v := p.VMs[i]
+			return sample{[]string{"id", v.ID, "name", v.Name, "node", v.Node, "kind", v.Kind}, f(v)}
+		})
+	}
+	gauge("vm_running", "1 if the hypervisor guest is running.", vm(func(v vmStat) float64 {
+		if v.State == "running" {
+			return 1
+		}
+		return 0
+	})...)
+	gauge("vm_cpu_percent", "Guest CPU utilization (100 when all its CPUs are busy).", vm(func(v vmStat) float64 { return v.CPUPercent })...)
+	gauge("vm_memory_used_bytes", "Guest memory in use.", vm(func(v vmStat) float64 { return float64(v.MemUsed) })...)
+	gauge("vm_memory_total_bytes", "Guest memory size.", vm(func(v vmStat) float64 { return float64(v.MemTotal) })...)
+
+	gauge("systemd_unit_failed", "1 for each failed systemd unit.", each(len(p.FailedUnits), func(i int) sample {
+		return sample{[]string{"unit", p.FailedUnits[i]}, 1}
+	})...)
+
+	names := make([]string, 0, len(p.CustomMetrics))
+	for k := range p.CustomMetrics {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	gauge("custom", "Custom metric reported by a plugin.", each(len(names), func(i int) sample {
+		return sample{[]string{"name", names[i]}, p.CustomMetrics[names[i]]}
+	})...)
+	return b.Bytes()
+}
+
+// escapeLabel escapes a label value for the text format.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case v == math.Trunc(v) && math.Abs(v) < 1e15:
+		return strconv.FormatFloat(v, 'f', -1, 64) // byte counts and timestamps without an exponent
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
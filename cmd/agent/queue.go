@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// saveEvery is how many payloads queue.drain sends between rewrites of the
+// file, bounding both the I/O of a long replay and the duplicates resent if
+// the agent dies mid-replay.
+const saveEvery = 50
+
+// queue holds payloads the server did not accept, oldest first, in a file
+// with one JSON payload per line. It keeps at most max payloads and drops
+// the oldest beyond that, so a long outage costs a fixed amount of disk.
+type queue struct {
+	path  string
+	max   int
+	items [][]byte
+}
+
+// openQueue loads the queue at path, creating its directory if needed.
+func openQueue(path string, max int) (*queue, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	q := &queue{path: path, max: max}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 {
+			q.items = append(q.items, bytes.Clone(line))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	q.trim()
+	return q, nil
+}
+
+func (q *queue) len() int { return len(q.items) }
+
+// trim drops the oldest payloads beyond max.
+func (q *queue) trim() {
+	if over := len(q.items) - q.max; over > 0 {
+		log.Printf("agent: queue full, dropping %d oldest payloads", over)
+		q.items = q.items[over:]
+	}
+}
+
+// push appends body and writes the queue out.
+func (q *queue) push(body []byte) error {
+	q.items = append(q.items, body)
+	q.trim()
+	return q.save()
+}
+
+// drain sends queued payloads oldest first until the queue is empty or send
+// fails with an error worth retrying. A payload the server rejected outright
+// is dropped, so it can't block the ones behind it.
+func (q *queue) drain(send func([]byte) error) error {
+	if len(q.items) == 0 {
+		return nil
+	}
+	var err error
+	for n := 1; len(q.items) > 0; n++ {
+		if err = send(q.items[0]); err != nil {
+			var rejected *rejectedError
+			if !errors.As(err, &rejected) {
+				break
+			}
+			log.Printf("agent: dropping queued payload: %v", err)
+			err = nil
+		}
+		q.items = q.items[1:]
+		if n%saveEvery == 0 {
+			if err := q.save(); err != nil {
+				return err
+			}
+		}
+	}
+	return errors.Join(err, q.save())
+}
+
+// save rewrites the file atomically, or removes it when the queue is empty.
+func (q *queue) save() error {
+	if len(q.items) == 0 {
+		if err := os.Remove(q.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, append(bytes.Join(q.items, []byte("\n")), '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// sdNotify sends state to systemd's notification socket, as sd_notify(3)
+// does. Without $NOTIFY_SOCKET (not started by systemd, or not
+// Type=notify) it does nothing.
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	// A leading '@' is an abstract socket, which net handles as is.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdog keeps systemd's watchdog (WatchdogSec=) fed while the agent is
+// delivering: it sends WATCHDOG=1 every half interval as long as a cycle
+// succeeded within staleAfter, the same test as /healthz. An agent whose
+// loop is stuck, say on a hung send, stops feeding it and is restarted.
+type watchdog struct {
+	interval time.Duration
+	lastOK   atomic.Int64 // unix nanoseconds
+}
+
+// newWatchdog returns nil unless systemd set a watchdog for this process.
+func newWatchdog() *watchdog {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	w := &watchdog{interval: time.Duration(usec) * time.Microsecond}
+	w.lastOK.Store(time.Now().UnixNano()) // the first send is up to a splay away
+	return w
+}
+
+// ok records a successful cycle.
+func (w *watchdog) ok() {
+	if w != nil {
+		w.lastOK.Store(time.Now().UnixNano())
+	}
+}
+
+// run feeds the watchdog until the process exits, so the final send on
+// shutdown is covered too.
+func (w *watchdog) run() {
+	ticker := time.NewTicker(w.interval / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		if time.Since(time.Unix(0, w.lastOK.Load())) <= staleAfter {
+			sdNotify("WATCHDOG=1")
+		}
+	}
+}
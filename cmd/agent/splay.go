@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand/v2"
+	"time"
+)
+
+// splay returns where in each reportInterval the host sends: a fixed offset
+// from a hash of its name, so a fleet started at the same moment spreads
+// its sends over the interval and each host keeps the same slot across
+// restarts.
+func splay(hostname string) time.Duration {
+	h := fnv.New64a()
+	h.Write([]byte(hostname))
+	return time.Duration(h.Sum64() % uint64(reportInterval))
+}
+
+// jitter returns a random delay of up to maxSeconds for one send.
+func jitter(maxSeconds int) time.Duration {
+	if maxSeconds <= 0 {
+		return 0
+	}
+	return rand.N(time.Duration(maxSeconds) * time.Second)
+}
+
+// sleepCtx waits for d, and reports false if ctx was canceled first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"health-dashboard/internal/config"
+	"health-dashboard/internal/rules"
+)
+
+// thresholds watches the agent.thresholds rules across cycles. Each rule
+// posts its event once it starts firing and, if it has one, its clear event
+// once it stops; a post that fails is retried on the next cycle.
+type thresholds struct {
+	rules   []config.AgentThreshold
+	watches []rules.Watch
+	posted  []bool // the state last posted for each rule
+}
+
+// rule is t as an alert rule, so it fires and clears the way rules do.
+func rule(t config.AgentThreshold) rules.Rule {
+	return rules.Rule{Kind: rules.KindMetric, Metric: t.Metric, Op: t.Op, Threshold: t.Value,
+		ClearThreshold: t.ClearValue, ForSeconds: t.ForSeconds}
+}
+
+// validateThresholds reports the first invalid rule in ts, or nil.
+func validateThresholds(ts []config.AgentThreshold) error {
+	for i, t := range ts {
+		switch {
+		case !slices.Contains(rules.Metrics, t.Metric) && !strings.Contains(t.Metric, "."):
+			return fmt.Errorf("agent.thresholds[%d]: metric must be one of %v or a plugin metric", i, rules.Metrics)
+		case t.Mount != "" && t.Metric != "disk_percent":
+			return fmt.Errorf("agent.thresholds[%d]: mount only applies to disk_percent", i)
+		case !slices.Contains([]string{">", ">=", "<", "<="}, t.Op):
+			return fmt.Errorf("agent.thresholds[%d]: op must be >, >=, < or <=", i)
+		case strings.TrimSpace(t.Event) == "":
+			return fmt.Errorf("agent.thresholds[%d]: event is required", i)
+		case t.ForSeconds < 0 || t.ForSeconds > 24*3600:
+			return fmt.Errorf("agent.thresholds[%d]: for_seconds must be between 0 and 86400", i)
+		case t.ClearValue != nil && strings.HasPrefix(t.Op, ">") && *t.ClearValue > t.Value:
+			return fmt.Errorf("agent.thresholds[%d]: clear_value must not be above value for op %s", i, t.Op)
+		case t.ClearValue != nil && strings.HasPrefix(t.Op, "<") && *t.ClearValue < t.Value:
+			return fmt.Errorf("agent.thresholds[%d]: clear_value must not be below value for op %s", i, t.Op)
+		}
+	}
+	return nil
+}
+
+// check evaluates the rules against p, collected at, and posts the events
+// for those that started or stopped firing. Rules whose metric p doesn't
+// carry (no swap, no sensors, a failed plugin) are left as they were.
+func (t *thresholds) check(client *http.Client, serverURL, apiKey string, p *metricsPayload, at time.Time) {
+	if t.watches == nil {
+		t.watches = make([]rules.Watch, len(t.rules))
+		for i, r := range t.rules {
+			t.watches[i].Rule = rule(r)
+		}
+		t.posted = make([]bool, len(t.rules))
+	}
+	for i, r := range t.rules {
+		v, ok := metricValue(p, r.Metric, r.Mount)
+		if !ok {
+			continue
+		}
+		firing, _ := t.watches[i].Observe(rules.Point{At: at, Value: v})
+		if firing == t.posted[i] {
+			continue
+		}
+		event := r.Event
+		if !firing {
+			event = r.ClearEvent
+		}
+		if event != "" {
+			if err := postEvent(client, serverURL, apiKey, event); err != nil {
+				log.Printf("agent: threshold %s %s %g: event %s: %v", r.Metric, r.Op, r.Value, event, err)
+				continue
+			}
+			log.Printf("agent: threshold %s %s %g: sent %s (value %g)", r.Metric, r.Op, r.Value, event, v)
+		}
+		t.posted[i] = firing
+	}
+}
+
+// metricValue returns the value of a threshold's metric in p, computed as
+// the server computes it for alert rules, and whether p has one.
+func metricValue(p *metricsPayload, metric, mount string) (float64, bool) {
+	switch metric {
+	case "cpu_percent":
+		return p.CPUPercent, true
+	case "mem_percent":
+		return percent(p.MemUsed, p.MemTotal)
+	case "swap_percent":
+		return percent(p.SwapUsed, p.SwapTotal)
+	case "load_1":
+		return p.Load1, true
+	case "load_5":
+		return p.Load5, true
+	case "load_15":
+		return p.Load15, true
+	case "disk_percent":
+		var worst float64
+		var ok bool
+		for _, d := range p.Disks {
+			if mount != "" && d.Mount != mount {
+				continue
+			}
+			if pct, has := percent(d.Used, d.Total); has && (!ok || pct > worst) {
+				worst, ok = pct, true
+			}
+		}
+		return worst, ok
+	case "temp_max":
+		var hottest float64
+		for i, t := range p.Temps {
+			if i == 0 || t.Celsius > hottest {
+				hottest = t.Celsius
+			}
+		}
+		return hottest, len(p.Temps) > 0
+	case "fd_open":
+		if p.Sockets == nil {
+			return 0, false
+		}
+		return float64(p.Sockets.FDOpen), true
+	case "tcp_established":
+		if p.Sockets == nil {
+			return 0, false
+		}
+		return float64(p.Sockets.TCPEstablished), true
+	}
+	v, ok := p.CustomMetrics[metric]
+	return v, ok
+}
+
+func percent(used, total int64) (float64, bool) {
+	if total <= 0 {
+		return 0, false
+	}
+	return 100 * float64(used) / float64(total), true
+}
+
+// postEvent sends one occurrence of an event to POST /api/events, so event
+// totals count crossings.
+func postEvent(client *http.Client, serverURL, apiKey, name string) error {
+	body, err := json.Marshal(map[string]any{"event_name": name, "value": 1})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, serverURL+"/api/events", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", apiKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("server returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"health-dashboard/internal/config"
+)
+
+// newHTTPClient returns the client used to reach the server, through
+// agent.proxy_url if set. With agent.server_ca set, only that CA is
+// trusted, not the system store. With a pinned fingerprint, the server's
+// certificate must also match one of the pins; pinning alone skips chain
+// verification, so it works with a self-signed certificate.
+func newHTTPClient(cfg config.AgentConfig) (*http.Client, error) {
+	proxy, err := proxyFunc(cfg.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	tlsCfg, err := trustConfig("server_ca", cfg.ServerCA, cfg.PinnedFingerprints())
+	if err != nil {
+		return nil, err
+	}
+	if tlsCfg == nil {
+		if cfg.ProxyURL != "" {
+			t := http.DefaultTransport.(*http.Transport).Clone()
+			t.Proxy = proxy
+			client.Transport = t
+		}
+		return client, nil
+	}
+	if !strings.HasPrefix(cfg.ServerURL, "https://") {
+		return nil, errors.New("agent.server_ca and agent.server_fingerprint need an https:// server_url")
+	}
+	client.Transport = &http.Transport{
+		Proxy:               proxy,
+		TLSClientConfig:     tlsCfg,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	return client, nil
+}
+
+// trustConfig returns the TLS config that trusts only the CAs in caFile,
+// named caKey in errors, and/or certificates matching one of fingerprints,
+// or nil when neither is set. With pins alone, chain verification is
+// skipped, so they work with a self-signed certificate.
+func trustConfig(caKey, caFile string, fingerprints []string) (*tls.Config, error) {
+	pins, err := parsePins(fingerprints)
+	if err != nil {
+		return nil, err
+	}
+	if caFile == "" && len(pins) == 0 {
+		return nil, nil
+	}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", caKey, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates in %s", caKey, caFile)
+		}
+		tlsCfg.RootCAs = pool
+	} else {
+		// The pin is the trust decision; VerifyConnection below enforces it.
+		tlsCfg.InsecureSkipVerify = true
+	}
+	if len(pins) > 0 {
+		tlsCfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("server sent no certificate")
+			}
+			sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			for _, pin := range pins {
+				if subtle.ConstantTimeCompare(sum[:], pin) == 1 {
+					return nil
+				}
+			}
+			return fmt.Errorf("server certificate %s matches no pinned fingerprint", hex.EncodeToString(sum[:]))
+		}
+	}
+	return tlsCfg, nil
+}
+
+// proxyFunc returns the proxy for raw, an agent.proxy_url, or the
+// environment's when it is empty. Through an http:// or https:// proxy,
+// https requests are tunneled with CONNECT, so pinning still applies to the
+// server's certificate.
+func proxyFunc(raw string) (func(*http.Request) (*url.URL, error), error) {
+	if raw == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("proxy_url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy_url %q: scheme must be http, https, socks5 or socks5h", u.Redacted())
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy_url %q: no proxy host", u.Redacted())
+	}
+	return http.ProxyURL(u), nil
+}
+
+// parsePins decodes SHA-256 certificate fingerprints written as hex, with
+// or without colons. The output of `openssl x509 -noout -fingerprint -sha256`
+// ("sha256 Fingerprint=AB:CD:...") is accepted as is.
+func parsePins(list []string) ([][]byte, error) {
+	var pins [][]byte
+	for _, s := range list {
+		if i := strings.LastIndexByte(s, '='); i >= 0 {
+			s = s[i+1:]
+		}
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		b, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("fingerprint %q is not a SHA-256 fingerprint", s)
+		}
+		pins = append(pins, b)
+	}
+	return pins, nil
+}
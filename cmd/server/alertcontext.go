@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"health-dashboard/internal/monitor"
+	"health-dashboard/internal/rules"
+)
+
+// Down alerts carry the events and metric anomalies of the contextWindow
+// before them, at most contextEvents events. A metric is anomalous when its
+// most extreme value in the window is more than anomalySigmas standard
+// deviations from its mean over the baselineWindow before that.
+const (
+	contextWindow  = 15 * time.Minute
+	baselineWindow = time.Hour
+	contextEvents  = 5
+	anomalySigmas  = 3
+	// minBaseline is how many baseline samples a metric needs to be judged.
+	minBaseline = 10
+)
+
+// alertContext returns a function building the context of a down alert from
+// the monitor's workspace: its recent events and unusual host metrics.
+// Errors are logged and leave that part empty.
+func alertContext(db *sql.DB) func(m *monitor.Monitor) *monitor.AlertContext {
+	return func(m *monitor.Monitor) *monitor.AlertContext {
+		now := time.Now().UTC()
+		ac := &monitor.AlertContext{Events: []monitor.ContextEvent{}, Anomalies: []monitor.MetricAnomaly{}}
+		events, err := recentEvents(db, m.WorkspaceID, now.Add(-contextWindow))
+		if err != nil {
+			log.Printf("monitor %d: alert context events: %v", m.ID, err)
+		} else {
+			ac.Events = events
+		}
+		anomalies, err := metricAnomalies(db, m.WorkspaceID, now)
+		if err != nil {
+			log.Printf("monitor %d: alert context metrics: %v", m.ID, err)
+		} else {
+			ac.Anomalies = anomalies
+		}
+		return ac
+	}
+}
+
+func recentEvents(db *sql.DB, workspaceID int64, since time.Time) ([]monitor.ContextEvent, error) {
+	rows, err := db.Query(`
+		SELECT event_name, value, created_at FROM events
+		WHERE workspace_id = ? AND created_at >= ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?`, workspaceID, since.Format("2006-01-02 15:04:05"), contextEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []monitor.ContextEvent{}
+	for rows.Next() {
+		var e monitor.ContextEvent
+		if err := rows.Scan(&e.Name, &e.Value, &e.At); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// metricAnomalies compares each host metric's samples in the contextWindow
+// before now with its baseline over the baselineWindow before that.
+func metricAnomalies(db *sql.DB, workspaceID int64, now time.Time) ([]monitor.MetricAnomaly, error) {
+	exprs := make([]string, len(rules.Metrics))
+	for i, name := range rules.Metrics {
+		exprs[i] = metricExprs[name]
+	}
+	windowStart := now.Add(-contextWindow)
+	rows, err := db.Query(`
+		SELECT recorded_at, `+strings.Join(exprs, ", ")+` FROM metrics
+		WHERE workspace_id = ? AND recorded_at >= ?
+		ORDER BY recorded_at`, workspaceID, windowStart.Add(-baselineWindow).Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type series struct {
+		n, sum, sumSq float64 // over the baseline
+		recent        []rules.Point
+	}
+	all := make([]series, len(rules.Metrics))
+	vals := make([]sql.NullFloat64, len(rules.Metrics))
+	dest := make([]any, len(vals)+1)
+	var at time.Time
+	dest[0] = &at
+	for i := range vals {
+		dest[i+1] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i, v := range vals {
+			if !v.Valid {
+				continue
+			}
+			s := &all[i]
+			if at.Before(windowStart) {
+				s.n++
+				s.sum += v.Float64
+				s.sumSq += v.Float64 * v.Float64
+			} else {
+				s.recent = append(s.recent, rules.Point{At: at, Value: v.Float64})
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	anomalies := []monitor.MetricAnomaly{}
+	for i, s := range all {
+		if s.n < minBaseline || len(s.recent) == 0 {
+			continue
+		}
+		mean := s.sum / s.n
+		sd := math.Sqrt(max(s.sumSq/s.n-mean*mean, 0))
+		var worst rules.Point
+		var worstDev float64
+		for _, p := range s.recent {
+			if d := math.Abs(p.Value - mean); d > worstDev {
+				worst, worstDev = p, d
+			}
+		}
+		// A flat baseline has no spread; also require a change of at least
+		// a fifth of the usual level (and 1 unit) so noise doesn't qualify.
+		if worstDev > anomalySigmas*sd && worstDev >= max(0.2*math.Abs(mean), 1) {
+			anomalies = append(anomalies, monitor.MetricAnomaly{
+				Metric:   rules.Metrics[i],
+				Value:    math.Round(worst.Value*100) / 100,
+				Baseline: math.Round(mean*100) / 100,
+				At:       worst.At,
+			})
+		}
+	}
+	return anomalies, nil
+}
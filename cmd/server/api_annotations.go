@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// annotationWindow is how far back GET /api/dashboard/annotations looks.
+	annotationWindow = 7 * 24 * time.Hour
+	// annotationRetention matches the annotations table's prune trigger.
+	annotationRetention = 365 * 24 * time.Hour
+	// annotationLead is how long before an incident's start its timeline
+	// shows annotations from, so the deploy or change that caused it is on it.
+	annotationLead     = time.Hour
+	maxAnnotationTitle = 200
+)
+
+// annotation is a note pinned to a point in time on the dashboard's
+// timeline: a deploy or release, or a note added by hand.
+type annotation struct {
+	ID     int64     `json:"id"`
+	At     time.Time `json:"at"`
+	Kind   string    `json:"kind"`   // "deploy", "deploy_failed", "release" or "note"
+	Source string    `json:"source"` // "github", "gitlab" or "manual"
+	Title  string    `json:"title"`
+	URL    string    `json:"url,omitempty"`
+	Author string    `json:"author,omitempty"`
+}
+
+// addAnnotation stores a in workspaceID, and records the business event
+// event with it so deploys count in the events summary and show in down
+// alerts' recent_events. A delivery ID already stored for the same source,
+// one the sender retried, is skipped; added reports whether a was new.
+func (s *server) addAnnotation(ctx context.Context, workspaceID int64, a annotation, deliveryID, event string) (added bool, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+		INSERT OR IGNORE INTO annotations (workspace_id, at, kind, source, title, url, author, delivery_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		workspaceID, a.At.UTC().Format("2006-01-02 15:04:05"), a.Kind, a.Source, a.Title, a.URL, a.Author, deliveryID)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if event != "" {
+		if _, err := tx.Exec(`INSERT INTO events (workspace_id, event_name, value) VALUES (?, ?, 1)`, workspaceID, event); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// annotationsBetween returns the workspace's annotations from from to to,
+// newest first.
+func (s *server) annotationsBetween(ctx context.Context, workspaceID int64, from, to time.Time) ([]annotation, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, at, kind, source, title, url, author FROM annotations
+		WHERE workspace_id = ? AND at >= ? AND at <= ?
+		ORDER BY at DESC, id DESC LIMIT 200`,
+		workspaceID, from.UTC().Format("2006-01-02 15:04:05"), to.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []annotation{}
+	for rows.Next() {
+		var a annotation
+		if err := rows.Scan(&a.ID, &a.At, &a.Kind, &a.Source, &a.Title, &a.URL, &a.Author); err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// handleDashboardAnnotations returns the workspace's annotations from the
+// last annotationWindow, newest first.
+func (s *server) handleDashboardAnnotations(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	list, err := s.annotationsBetween(r.Context(), workspaceID(r.Context()), now.Add(-annotationWindow), now)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	s.writeListJSON(w, r, list)
+}
+
+// noteRequest is the body of POST /api/annotations.
+type noteRequest struct {
+	Title  string     `json:"title"`
+	At     *time.Time `json:"at"` // default now
+	URL    string     `json:"url"`
+	Author string     `json:"author"`
+}
+
+// handleAnnotationCreate handles POST /api/annotations: a note an operator
+// pins to the timeline, such as a change made by hand.
+// Body: {"title": "Swapped router firmware", "at": "2026-10-14T09:30:00Z"}.
+func (s *server) handleAnnotationCreate(w http.ResponseWriter, r *http.Request) {
+	var req noteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
+		return
+	}
+	now := time.Now()
+	a := annotation{
+		At: now, Kind: "note", Source: "manual",
+		Title:  strings.TrimSpace(req.Title),
+		URL:    strings.TrimSpace(req.URL),
+		Author: strings.TrimSpace(req.Author),
+	}
+	if req.At != nil {
+		a.At = *req.At
+	}
+	var msg string
+	switch {
+	case a.Title == "":
+		msg = "title is required"
+	case len(a.Title) > maxAnnotationTitle:
+		msg = fmt.Sprintf("title must be at most %d bytes", maxAnnotationTitle)
+	case len(a.URL) > 2048:
+		msg = "url must be at most 2048 bytes"
+	case a.URL != "" && !strings.HasPrefix(a.URL, "http://") && !strings.HasPrefix(a.URL, "https://"):
+		msg = "url must be an http or https URL"
+	case len(a.Author) > 100:
+		msg = "author must be at most 100 bytes"
+	case a.At.After(now.Add(time.Minute)):
+		msg = "at must not be in the future"
+	case a.At.Before(now.Add(-annotationRetention)):
+		msg = "at must be within the last 365 days"
+	}
+	if msg != "" {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, msg)
+		return
+	}
+	a.At = a.At.UTC().Truncate(time.Second)
+	id, err := s.insertNote(r.Context(), workspaceID(r.Context()), a)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	a.ID = id
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+// insertNote stores a manual note and returns its ID.
+func (s *server) insertNote(ctx context.Context, workspaceID int64, a annotation) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO annotations (workspace_id, at, kind, source, title, url, author)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		workspaceID, a.At.Format("2006-01-02 15:04:05"), a.Kind, a.Source, a.Title, a.URL, a.Author)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// handleAnnotationDelete handles DELETE /api/annotations/{id}. Deploys can
+// be removed as well as notes; the business event a deploy recorded stays.
+func (s *server) handleAnnotationDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseID(w, r)
+	if !ok {
+		return
+	}
+	res, err := s.db.ExecContext(r.Context(), `DELETE FROM annotations WHERE id = ? AND workspace_id = ?`,
+		id, workspaceID(r.Context()))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
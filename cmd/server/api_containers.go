@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+)
+
+// containerInfo is one container's stats, as sent by the agent and returned
+// by GET /api/dashboard/containers.
+type containerInfo struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	Image        string  `json:"image"`
+	State        string  `json:"state"`
+	CPUPercent   float64 `json:"cpu_percent"`
+	MemUsed      int64   `json:"mem_used"`
+	MemLimit     int64   `json:"mem_limit"`
+	RestartCount int     `json:"restart_count"`
+	Restarts24h  int     `json:"restarts_24h"` // restart_count growth over the last 24 h
+}
+
+// handleDashboardContainers returns the containers from the workspace's most
+// recent metrics payload, with how often each restarted in the last 24 hours.
+func (s *server) handleDashboardContainers(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT c.container_id, c.name, c.image, c.state, c.cpu_percent, c.mem_used, c.mem_limit, c.restart_count,
+			c.restart_count - (
+				SELECT MIN(c2.restart_count) FROM container_stats c2
+				JOIN metrics m2 ON m2.id = c2.metric_id
+				WHERE c2.container_id = c.container_id AND m2.workspace_id = m.workspace_id
+				  AND m2.recorded_at >= datetime('now', '-24 hours'))
+		FROM container_stats c
+		JOIN metrics m ON m.id = c.metric_id
+		WHERE c.metric_id = (
+			SELECT id FROM metrics
+			WHERE workspace_id = ? AND recorded_at >= datetime('now', '-24 hours')
+			ORDER BY recorded_at DESC, id DESC LIMIT 1)
+		ORDER BY c.name
+	`, workspaceID(r.Context()))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []containerInfo{}
+	for rows.Next() {
+		var c containerInfo
+		if err := rows.Scan(&c.ID, &c.Name, &c.Image, &c.State, &c.CPUPercent, &c.MemUsed, &c.MemLimit,
+			&c.RestartCount, &c.Restarts24h); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		list = append(list, c)
+	}
+	if err := rows.Err(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	s.writeListJSON(w, r, list)
+}
@@ -3,134 +3,390 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"slices"
+	"time"
+
+	"health-dashboard/internal/monitor"
 )
 
 // dashboardMonitor is the per-monitor payload returned by GET /api/dashboard/monitors.
 type dashboardMonitor struct {
-	ID             int64    `json:"id"`
-	Name           string   `json:"name"`
-	URL            string   `json:"url"`
-	State          string   `json:"state"`
-	LastResponseMs *int64   `json:"last_response_ms"`
-	Uptime24h      *float64 `json:"uptime_24h"`
+	ID              int64      `json:"id"`
+	Name            string     `json:"name"`
+	Type            string     `json:"type"`
+	URL             string     `json:"url"`
+	State           string     `json:"state"`
+	LastResponseMs  *int64     `json:"last_response_ms"`
+	LastBytes       *int64     `json:"last_response_bytes"`
+	SecurityScore   *int64     `json:"security_score"`
+	Uptime24h       *float64   `json:"uptime_24h"`
+	NoData24h       int64      `json:"no_data_seconds_24h"`
+	Owner           string     `json:"owner"`
+	RunbookURL      string     `json:"runbook_url"`
+	Description     string     `json:"description"`
+	KnownIssue      string     `json:"known_issue,omitempty"`
+	KnownIssueUntil *time.Time `json:"known_issue_until,omitempty"`
 }
 
-// handleDashboardMonitors returns all monitors enriched with last response time
-// and 24-hour uptime percentage.
+// handleDashboardMonitors returns the workspace's monitors enriched with last response time,
+// 24-hour uptime percentage, and seconds without data in the last 24 hours.
+// The list is cached (server.cache_seconds).
 func (s *server) handleDashboardMonitors(w http.ResponseWriter, r *http.Request) {
-	rows, err := s.db.QueryContext(r.Context(), `
+	wsID := workspaceID(r.Context())
+	cached, err := s.cache.get(cacheMonitors, wsID, func() (any, error) {
+		return s.dashboardMonitors(wsID)
+	})
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	result := cached.([]dashboardMonitor)
+	if !isAdmin(r.Context()) {
+		result = slices.Clone(result)
+		for i := range result {
+			result[i].URL = monitor.RedactURL(result[i].URL)
+		}
+	}
+
+	s.writeListJSON(w, r, result)
+}
+
+// dashboardMonitors queries the list for handleDashboardMonitors, with
+// URLs unredacted. It runs detached from any one request, whose result
+// other requests share.
+func (s *server) dashboardMonitors(wsID int64) ([]dashboardMonitor, error) {
+	rows, err := s.db.Query(`
 		SELECT
-			m.id, m.name, m.url, m.state,
+			m.id, m.name, m.type, m.url, m.state, m.tags, m.owner, m.runbook_url, m.description,
+			m.known_issue, m.known_issue_until,
 			(SELECT response_time_ms FROM checks
 			 WHERE monitor_id = m.id AND is_up = 1
 			 ORDER BY checked_at DESC LIMIT 1),
-			(SELECT CAST(SUM(is_up) AS REAL) / COUNT(*) * 100
+			(SELECT response_bytes FROM checks
+			 WHERE monitor_id = m.id AND is_up = 1
+			 ORDER BY checked_at DESC LIMIT 1),
+			(SELECT security_score FROM checks
+			 WHERE monitor_id = m.id AND security_score IS NOT NULL
+			 ORDER BY checked_at DESC LIMIT 1),
+			(SELECT CAST(SUM(is_up * sample_weight) AS REAL) / SUM(sample_weight) * 100
 			 FROM checks
 			 WHERE monitor_id = m.id
-			   AND checked_at >= datetime('now', '-24 hours'))
+			   AND checked_at >= datetime('now', '-24 hours')),
+			(SELECT COALESCE(SUM(unixepoch(ended_at) - unixepoch(MAX(started_at, datetime('now', '-24 hours')))), 0)
+			 FROM data_gaps
+			 WHERE monitor_id = m.id
+			   AND ended_at >= datetime('now', '-24 hours'))
 		FROM monitors m
+		WHERE m.workspace_id = ?
 		ORDER BY m.id
-	`)
+	`, wsID)
 	if err != nil {
-		jsonErr(w, "database error", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
 	result := make([]dashboardMonitor, 0)
 	for rows.Next() {
 		var m dashboardMonitor
-		if err := rows.Scan(&m.ID, &m.Name, &m.URL, &m.State, &m.LastResponseMs, &m.Uptime24h); err != nil {
-			jsonErr(w, "scan error", http.StatusInternalServerError)
-			return
+		var tags string
+		if err := rows.Scan(&m.ID, &m.Name, &m.Type, &m.URL, &m.State, &tags, &m.Owner, &m.RunbookURL, &m.Description,
+			&m.KnownIssue, &m.KnownIssueUntil, &m.LastResponseMs, &m.LastBytes, &m.SecurityScore, &m.Uptime24h, &m.NoData24h); err != nil {
+			return nil, err
 		}
+		if m.KnownIssueUntil == nil || !m.KnownIssueUntil.After(time.Now()) {
+			m.KnownIssue, m.KnownIssueUntil = "", nil
+		}
+		m.RunbookURL = monitor.RunbookURL(&monitor.Monitor{ID: m.ID, Name: m.Name, Tags: tags, RunbookURL: m.RunbookURL}, s.runbooks, m.State)
 		result = append(result, m)
 	}
-	if err := rows.Err(); err != nil {
-		jsonErr(w, "database error", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	return result, rows.Err()
 }
 
-// metricPoint is a single timeseries entry for the metrics chart.
+// metricPoint is a single timeseries entry for the metrics charts.
+// Network and disk I/O rates are summed over all interfaces and devices.
+// CPUMaxCore is the busiest core's utilization, null without per-core data.
 type metricPoint struct {
-	Ts         int64   `json:"ts"`
-	CPUPercent float64 `json:"cpu_percent"`
-	MemUsed    int64   `json:"mem_used"`
-	MemTotal   int64   `json:"mem_total"`
+	Ts               int64    `json:"ts"`
+	CPUPercent       float64  `json:"cpu_percent"`
+	CPUMaxCore       *float64 `json:"cpu_max_core"`
+	Load1            float64  `json:"load_1"`
+	Load5            float64  `json:"load_5"`
+	Load15           float64  `json:"load_15"`
+	MemUsed          int64    `json:"mem_used"`
+	MemTotal         int64    `json:"mem_total"`
+	SwapUsed         int64    `json:"swap_used"`
+	SwapTotal        int64    `json:"swap_total"`
+	RxBytesPerSec    float64  `json:"rx_bytes_per_sec"`
+	TxBytesPerSec    float64  `json:"tx_bytes_per_sec"`
+	IOPS             float64  `json:"iops"`
+	ReadBytesPerSec  float64  `json:"read_bytes_per_sec"`
+	WriteBytesPerSec float64  `json:"write_bytes_per_sec"`
+	TempMax          *float64 `json:"temp_max"`    // hottest sensor; null without sensors
+	PingMaxMs        *float64 `json:"ping_max_ms"` // slowest ping target; null without answered pings
+	FDOpen           *int64   `json:"fd_open"`     // null when the agent doesn't report sockets
+	TCPEstablished   *int64   `json:"tcp_established"`
+	TCPTimeWait      *int64   `json:"tcp_time_wait"`
 }
 
 // diskInfo is a parsed disk entry from the metrics disk_json column.
 type diskInfo struct {
-	Mount string `json:"mount"`
-	Used  int64  `json:"used"`
-	Total int64  `json:"total"`
+	Mount       string `json:"mount"`
+	Used        int64  `json:"used"`
+	Total       int64  `json:"total"`
+	InodesUsed  int64  `json:"inodes_used"`
+	InodesTotal int64  `json:"inodes_total"` // 0 when the filesystem doesn't report inodes
+}
+
+// netInfo is a per-interface entry from the metrics net_json column.
+type netInfo struct {
+	Iface           string  `json:"iface"`
+	RxBytesPerSec   float64 `json:"rx_bytes_per_sec"`
+	TxBytesPerSec   float64 `json:"tx_bytes_per_sec"`
+	RxPacketsPerSec float64 `json:"rx_packets_per_sec"`
+	TxPacketsPerSec float64 `json:"tx_packets_per_sec"`
+}
+
+// diskIOInfo is a per-device entry from the metrics disk_io_json column.
+type diskIOInfo struct {
+	Device           string  `json:"device"`
+	ReadsPerSec      float64 `json:"reads_per_sec"`
+	WritesPerSec     float64 `json:"writes_per_sec"`
+	ReadBytesPerSec  float64 `json:"read_bytes_per_sec"`
+	WriteBytesPerSec float64 `json:"write_bytes_per_sec"`
+}
+
+// tempInfo is a sensor entry from the metrics temps_json column.
+type tempInfo struct {
+	Sensor  string  `json:"sensor"`
+	Celsius float64 `json:"celsius"`
+}
+
+// pingInfo is a ping target's entry from the metrics pings_json column.
+type pingInfo struct {
+	Target      string   `json:"target"`
+	Method      string   `json:"method"` // "icmp" or "tcp"
+	RTTMs       *float64 `json:"rtt_ms"` // null when no probe was answered
+	LossPercent float64  `json:"loss_percent"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// dirInfo is a directory's entry from the metrics dir_sizes_json column.
+type dirInfo struct {
+	Path      string    `json:"path"`
+	Bytes     int64     `json:"bytes"`
+	Files     int64     `json:"files"`
+	Skipped   int64     `json:"skipped"` // unreadable entries left out
+	ScannedAt time.Time `json:"scanned_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// sockInfo holds open file handle and TCP socket counts from the metrics
+// fd_* and tcp_* columns.
+type sockInfo struct {
+	FDOpen         int64 `json:"fd_open"`
+	FDMax          int64 `json:"fd_max"`
+	TCPEstablished int64 `json:"tcp_established"`
+	TCPInUse       int64 `json:"tcp_inuse"`
+	TCPTimeWait    int64 `json:"tcp_time_wait"`
+}
+
+// procInfo is a process entry from the metrics top_cpu_json and
+// top_mem_json columns.
+type procInfo struct {
+	PID        int     `json:"pid"`
+	Name       string  `json:"name"`
+	CPUPercent float64 `json:"cpu_percent"`
+	RSS        int64   `json:"rss"`
 }
 
 // latestMetrics is the most recent snapshot used for the gauges.
 type latestMetrics struct {
-	CPUPercent float64    `json:"cpu_percent"`
-	MemUsed    int64      `json:"mem_used"`
-	MemTotal   int64      `json:"mem_total"`
-	Disks      []diskInfo `json:"disks"`
+	CPUPercent float64      `json:"cpu_percent"`
+	CPUCores   []float64    `json:"cpu_cores"`
+	Load1      float64      `json:"load_1"`
+	Load5      float64      `json:"load_5"`
+	Load15     float64      `json:"load_15"`
+	MemUsed    int64        `json:"mem_used"`
+	MemTotal   int64        `json:"mem_total"`
+	SwapUsed   int64        `json:"swap_used"`
+	SwapTotal  int64        `json:"swap_total"`
+	Disks      []diskInfo   `json:"disks"`
+	Net        []netInfo    `json:"net"`
+	DiskIO     []diskIOInfo `json:"disk_io"`
+	Temps      []tempInfo   `json:"temps"`
+	Pings      []pingInfo   `json:"pings"`
+	DirSizes   []dirInfo    `json:"dir_sizes"`
+	TopCPU     []procInfo   `json:"top_cpu"`
+	TopMem     []procInfo   `json:"top_mem"`
+	// FailedUnits are systemd units in the failed state.
+	FailedUnits []string `json:"failed_units"`
+	// Sockets is null when the agent doesn't report them (e.g. macOS).
+	Sockets *sockInfo `json:"sockets"`
+	// CustomMetrics are the values agent plugins reported.
+	CustomMetrics map[string]float64 `json:"custom_metrics"`
+	// NTPOffsetMs is the host clock's offset from NTP, positive when ahead;
+	// null when the agent doesn't check it.
+	NTPOffsetMs *float64 `json:"ntp_offset_ms"`
 }
 
 // metricsResponse is returned by GET /api/dashboard/metrics.
 type metricsResponse struct {
 	Latest *latestMetrics `json:"latest"`
 	Series []metricPoint  `json:"series"`
+	// Gaps are the stretches of Series with samples missing, oldest first.
+	Gaps []metricGap `json:"gaps"`
+	// TempThreshold is alerts.temperature_threshold, so the dashboard can
+	// highlight hot sensors; omitted when disabled.
+	TempThreshold float64 `json:"temp_threshold,omitempty"`
+	// ClockDriftThresholdMs is alerts.clock_drift_threshold_ms, beyond which
+	// the dashboard flags the host's clock; omitted when disabled.
+	ClockDriftThresholdMs float64 `json:"clock_drift_threshold_ms,omitempty"`
+}
+
+// metricGap marks a stretch with no samples between Start and End (unix
+// seconds), about Missed expected ones. A gap still open at the time of the
+// request ends now and is Ongoing.
+type metricGap struct {
+	Start   int64 `json:"start"`
+	End     int64 `json:"end"`
+	Missed  int64 `json:"missed"`
+	Ongoing bool  `json:"ongoing,omitempty"`
+}
+
+// A silence is a gap once it lasts gapFactor times the host's usual spacing
+// between samples, and at least minGap, so a late or retried send isn't one.
+const (
+	gapFactor = 3
+	minGap    = 2 * time.Minute
+)
+
+// metricGaps finds the gaps in a series sampled at ts, in ascending order.
+// The expected spacing is the median between consecutive samples, so an
+// agent run from cron every 5 minutes isn't seen as missing 9 in 10.
+func metricGaps(ts []int64, now int64) []metricGap {
+	gaps := []metricGap{}
+	if len(ts) < 2 {
+		return gaps
+	}
+	deltas := make([]int64, len(ts)-1)
+	for i := range deltas {
+		deltas[i] = ts[i+1] - ts[i]
+	}
+	slices.Sort(deltas)
+	expected := max(deltas[len(deltas)/2], 1)
+	limit := max(gapFactor*expected, int64(minGap/time.Second))
+	for i := 1; i < len(ts); i++ {
+		if d := ts[i] - ts[i-1]; d > limit {
+			gaps = append(gaps, metricGap{Start: ts[i-1], End: ts[i], Missed: d/expected - 1})
+		}
+	}
+	if d := now - ts[len(ts)-1]; d > limit {
+		gaps = append(gaps, metricGap{Start: ts[len(ts)-1], End: now, Missed: d / expected, Ongoing: true})
+	}
+	return gaps
 }
 
-// handleDashboardMetrics returns the last 24 h of system metrics and the
+// handleDashboardMetrics returns the workspace's last 24 h of system metrics and the
 // most-recent snapshot for gauges.
 func (s *server) handleDashboardMetrics(w http.ResponseWriter, r *http.Request) {
 	rows, err := s.db.QueryContext(r.Context(), `
-		SELECT strftime('%s', recorded_at), cpu_percent, mem_used, mem_total, disk_json
+		SELECT strftime('%s', recorded_at), cpu_percent, cpu_cores_json,
+			(SELECT MAX(value) FROM json_each(cpu_cores_json)),
+			load_1, load_5, load_15, mem_used, mem_total, swap_used, swap_total, disk_json, net_json,
+			(SELECT COALESCE(SUM(json_extract(value, '$.rx_bytes_per_sec')), 0) FROM json_each(net_json)),
+			(SELECT COALESCE(SUM(json_extract(value, '$.tx_bytes_per_sec')), 0) FROM json_each(net_json)),
+			disk_io_json,
+			(SELECT COALESCE(SUM(json_extract(value, '$.reads_per_sec') + json_extract(value, '$.writes_per_sec')), 0) FROM json_each(disk_io_json)),
+			(SELECT COALESCE(SUM(json_extract(value, '$.read_bytes_per_sec')), 0) FROM json_each(disk_io_json)),
+			(SELECT COALESCE(SUM(json_extract(value, '$.write_bytes_per_sec')), 0) FROM json_each(disk_io_json)),
+			temps_json,
+			(SELECT MAX(json_extract(value, '$.celsius')) FROM json_each(temps_json)),
+			(SELECT MAX(json_extract(value, '$.rtt_ms')) FROM json_each(pings_json)),
+			fd_open, fd_max, tcp_established, tcp_inuse, tcp_time_wait
 		FROM metrics
-		WHERE recorded_at >= datetime('now', '-24 hours')
+		WHERE workspace_id = ? AND recorded_at >= datetime('now', '-24 hours')
 		ORDER BY recorded_at ASC
-	`)
+	`, workspaceID(r.Context()))
 	if err != nil {
-		jsonErr(w, "database error", http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
 	defer rows.Close()
 
 	var series []metricPoint
-	var lastDiskJSON string
+	var stamps []int64
+	var lastCoresJSON, lastDiskJSON, lastNetJSON, lastIOJSON, lastTempsJSON string
 	var latest *latestMetrics
 
 	for rows.Next() {
 		var ts int64
-		var cpu float64
-		var memUsed, memTotal int64
-		var diskJSON string
-		if err := rows.Scan(&ts, &cpu, &memUsed, &memTotal, &diskJSON); err != nil {
-			jsonErr(w, "scan error", http.StatusInternalServerError)
+		var cpu, load1, load5, load15, rx, tx, iops, readBps, writeBps float64
+		var memUsed, memTotal, swapUsed, swapTotal int64
+		var maxCore, maxTemp, maxPing *float64
+		var fdOpen, fdMax, tcpEst, tcpInUse, tcpTW *int64
+		var coresJSON, diskJSON, netJSON, ioJSON, tempsJSON string
+		if err := rows.Scan(&ts, &cpu, &coresJSON, &maxCore, &load1, &load5, &load15, &memUsed, &memTotal, &swapUsed, &swapTotal, &diskJSON, &netJSON, &rx, &tx,
+			&ioJSON, &iops, &readBps, &writeBps, &tempsJSON, &maxTemp, &maxPing, &fdOpen, &fdMax, &tcpEst, &tcpInUse, &tcpTW); err != nil {
+			internalError(w, r, err)
 			return
 		}
+		stamps = append(stamps, ts)
 		series = append(series, metricPoint{
-			Ts:         ts,
-			CPUPercent: cpu,
-			MemUsed:    memUsed,
-			MemTotal:   memTotal,
+			Ts:               ts,
+			CPUPercent:       cpu,
+			CPUMaxCore:       maxCore,
+			Load1:            load1,
+			Load5:            load5,
+			Load15:           load15,
+			MemUsed:          memUsed,
+			MemTotal:         memTotal,
+			SwapUsed:         swapUsed,
+			SwapTotal:        swapTotal,
+			RxBytesPerSec:    rx,
+			TxBytesPerSec:    tx,
+			IOPS:             iops,
+			ReadBytesPerSec:  readBps,
+			WriteBytesPerSec: writeBps,
+			TempMax:          maxTemp,
+			PingMaxMs:        maxPing,
+			FDOpen:           fdOpen,
+			TCPEstablished:   tcpEst,
+			TCPTimeWait:      tcpTW,
 		})
-		lastDiskJSON = diskJSON
+		lastCoresJSON, lastDiskJSON, lastNetJSON, lastIOJSON, lastTempsJSON = coresJSON, diskJSON, netJSON, ioJSON, tempsJSON
 		latest = &latestMetrics{
 			CPUPercent: cpu,
+			Load1:      load1,
+			Load5:      load5,
+			Load15:     load15,
 			MemUsed:    memUsed,
 			MemTotal:   memTotal,
+			SwapUsed:   swapUsed,
+			SwapTotal:  swapTotal,
+		}
+		if fdOpen != nil && fdMax != nil && tcpEst != nil && tcpInUse != nil && tcpTW != nil {
+			latest.Sockets = &sockInfo{
+				FDOpen:         *fdOpen,
+				FDMax:          *fdMax,
+				TCPEstablished: *tcpEst,
+				TCPInUse:       *tcpInUse,
+				TCPTimeWait:    *tcpTW,
+			}
 		}
 	}
 	if err := rows.Err(); err != nil {
-		jsonErr(w, "database error", http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
 
 	if latest != nil {
+		var cores []float64
+		if err := json.Unmarshal([]byte(lastCoresJSON), &cores); err == nil {
+			latest.CPUCores = cores
+		}
+		if latest.CPUCores == nil {
+			latest.CPUCores = []float64{}
+		}
 		var disks []diskInfo
 		if err := json.Unmarshal([]byte(lastDiskJSON), &disks); err == nil {
 			latest.Disks = disks
@@ -138,11 +394,58 @@ func (s *server) handleDashboardMetrics(w http.ResponseWriter, r *http.Request)
 		if latest.Disks == nil {
 			latest.Disks = []diskInfo{}
 		}
+		var nets []netInfo
+		if err := json.Unmarshal([]byte(lastNetJSON), &nets); err == nil {
+			latest.Net = nets
+		}
+		if latest.Net == nil {
+			latest.Net = []netInfo{}
+		}
+		var ios []diskIOInfo
+		if err := json.Unmarshal([]byte(lastIOJSON), &ios); err == nil {
+			latest.DiskIO = ios
+		}
+		if latest.DiskIO == nil {
+			latest.DiskIO = []diskIOInfo{}
+		}
+		var temps []tempInfo
+		if err := json.Unmarshal([]byte(lastTempsJSON), &temps); err == nil {
+			latest.Temps = temps
+		}
+		if latest.Temps == nil {
+			latest.Temps = []tempInfo{}
+		}
+
+		// Process lists, failed units, custom metrics and the clock offset
+		// are only needed for the newest row, so they aren't pulled through
+		// the series query.
+		var topCPUJSON, topMemJSON, failedJSON, customJSON, pingsJSON, dirsJSON string
+		err := s.db.QueryRowContext(r.Context(), `
+			SELECT top_cpu_json, top_mem_json, failed_units_json, custom_json, ntp_offset_ms, pings_json, dir_sizes_json FROM metrics
+			WHERE workspace_id = ? ORDER BY recorded_at DESC, id DESC LIMIT 1
+		`, workspaceID(r.Context())).Scan(&topCPUJSON, &topMemJSON, &failedJSON, &customJSON, &latest.NTPOffsetMs, &pingsJSON, &dirsJSON)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		latest.TopCPU, latest.TopMem, latest.FailedUnits = []procInfo{}, []procInfo{}, []string{}
+		latest.CustomMetrics = map[string]float64{}
+		latest.Pings, latest.DirSizes = []pingInfo{}, []dirInfo{}
+		json.Unmarshal([]byte(topCPUJSON), &latest.TopCPU)
+		json.Unmarshal([]byte(topMemJSON), &latest.TopMem)
+		json.Unmarshal([]byte(failedJSON), &latest.FailedUnits)
+		json.Unmarshal([]byte(customJSON), &latest.CustomMetrics)
+		json.Unmarshal([]byte(pingsJSON), &latest.Pings)
+		json.Unmarshal([]byte(dirsJSON), &latest.DirSizes)
 	}
 
 	resp := metricsResponse{
-		Latest: latest,
-		Series: series,
+		Latest:        latest,
+		Series:        series,
+		Gaps:          metricGaps(stamps, time.Now().Unix()),
+		TempThreshold: s.cfg.Alerts.TemperatureThreshold,
+
+		ClockDriftThresholdMs: s.cfg.Alerts.ClockDriftThresholdMs,
 	}
 	if resp.Series == nil {
 		resp.Series = []metricPoint{}
@@ -155,42 +458,5 @@ func (s *server) handleDashboardMetrics(w http.ResponseWriter, r *http.Request)
 // handleDashboardEvents returns the same event summary as the API-key-gated
 // endpoint but accepts a session cookie — used by the dashboard frontend.
 func (s *server) handleDashboardEvents(w http.ResponseWriter, r *http.Request) {
-	rows, err := s.db.QueryContext(r.Context(), `
-		SELECT
-			event_name,
-			SUM(CASE WHEN created_at >= datetime('now', 'start of day') THEN value ELSE 0 END) AS today,
-			SUM(value) AS last_7_days
-		FROM events
-		WHERE created_at >= datetime('now', '-7 days')
-		GROUP BY event_name
-		ORDER BY event_name
-	`)
-	if err != nil {
-		jsonErr(w, "database error", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	summaries := make([]EventSummary, 0)
-	for rows.Next() {
-		var es EventSummary
-		if err := rows.Scan(&es.EventName, &es.Today, &es.Last7Days); err != nil {
-			jsonErr(w, "scan error", http.StatusInternalServerError)
-			return
-		}
-		summaries = append(summaries, es)
-	}
-	if err := rows.Err(); err != nil {
-		jsonErr(w, "database error", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(summaries)
-}
-
-// jsonErr writes a JSON error response.
-func jsonErr(w http.ResponseWriter, msg string, code int) {
-	w.Header().Set("Content-Type", "application/json")
-	http.Error(w, `{"error":"`+msg+`"}`, code)
+	s.handleEventSummary(w, r)
 }
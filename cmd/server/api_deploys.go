@@ -0,0 +1,362 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"health-dashboard/internal/auth"
+	"health-dashboard/internal/workspace"
+)
+
+// handleGitHubWebhook handles POST /api/webhooks/github. The webhook's
+// secret is a workspace's github_secret (or, for the default workspace, a
+// plaintext events API key), which picks the workspace: GitHub signs the
+// body with it in X-Hub-Signature-256 rather than sending it. A successful
+// or failed deployment_status and a published release become annotations;
+// every other event is acknowledged and ignored.
+func (s *server) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	body, ok := readWebhookBody(w, r)
+	if !ok {
+		return
+	}
+	wsID, err := s.signedWorkspace(r.Header.Get("X-Hub-Signature-256"), body)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if wsID == 0 {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "missing or invalid X-Hub-Signature-256")
+		return
+	}
+
+	var a annotation
+	var event string
+	switch r.Header.Get("X-GitHub-Event") {
+	case "deployment_status":
+		var p struct {
+			DeploymentStatus struct {
+				State          string    `json:"state"`
+				TargetURL      string    `json:"target_url"`
+				LogURL         string    `json:"log_url"`
+				EnvironmentURL string    `json:"environment_url"`
+				UpdatedAt      time.Time `json:"updated_at"`
+			} `json:"deployment_status"`
+			Deployment struct {
+				Ref         string `json:"ref"`
+				SHA         string `json:"sha"`
+				Environment string `json:"environment"`
+				Creator     struct {
+					Login string `json:"login"`
+				} `json:"creator"`
+			} `json:"deployment"`
+			Repository struct {
+				FullName string `json:"full_name"`
+			} `json:"repository"`
+			Sender struct {
+				Login string `json:"login"`
+			} `json:"sender"`
+		}
+		if !decodeWebhook(w, r, body, &p) {
+			return
+		}
+		st, d := p.DeploymentStatus, p.Deployment
+		switch st.State {
+		case "success":
+			event = "deploy"
+		case "failure", "error":
+			event = "deploy_failed"
+		default: // queued, pending, in_progress: wait for the outcome
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		a = annotation{
+			At: st.UpdatedAt, Kind: event,
+			Title:  deployTitle(p.Repository.FullName, d.Ref, d.SHA, d.Environment, event == "deploy_failed"),
+			URL:    firstNonEmpty(st.TargetURL, st.LogURL, st.EnvironmentURL),
+			Author: firstNonEmpty(d.Creator.Login, p.Sender.Login),
+		}
+	case "release":
+		var p struct {
+			Action  string `json:"action"`
+			Release struct {
+				TagName     string    `json:"tag_name"`
+				Name        string    `json:"name"`
+				HTMLURL     string    `json:"html_url"`
+				PublishedAt time.Time `json:"published_at"`
+				Author      struct {
+					Login string `json:"login"`
+				} `json:"author"`
+			} `json:"release"`
+			Repository struct {
+				FullName string `json:"full_name"`
+			} `json:"repository"`
+		}
+		if !decodeWebhook(w, r, body, &p) {
+			return
+		}
+		if p.Action != "published" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		rel := p.Release
+		event = "release"
+		a = annotation{
+			At: rel.PublishedAt, Kind: event,
+			Title:  releaseTitle(p.Repository.FullName, rel.TagName, rel.Name),
+			URL:    rel.HTMLURL,
+			Author: rel.Author.Login,
+		}
+	default: // ping, and events the webhook was subscribed to by mistake
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	a.Source = "github"
+	s.recordDeploy(w, r, wsID, a, r.Header.Get("X-GitHub-Delivery"))
+}
+
+// handleGitLabWebhook handles POST /api/webhooks/gitlab. The webhook's
+// secret token is an events API key, which picks the workspace. A
+// successful or failed Deployment Hook and a created Release Hook become
+// annotations; every other event is acknowledged and ignored.
+func (s *server) handleGitLabWebhook(w http.ResponseWriter, r *http.Request) {
+	wsID, err := s.apiKeyWorkspace(r.Header.Get("X-Gitlab-Token"))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if wsID == 0 {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "missing or invalid X-Gitlab-Token")
+		return
+	}
+	body, ok := readWebhookBody(w, r)
+	if !ok {
+		return
+	}
+
+	type project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	}
+	var a annotation
+	var event string
+	switch r.Header.Get("X-Gitlab-Event") {
+	case "Deployment Hook":
+		var p struct {
+			Status          string  `json:"status"`
+			StatusChangedAt string  `json:"status_changed_at"`
+			DeployableURL   string  `json:"deployable_url"`
+			Environment     string  `json:"environment"`
+			EnvironmentURL  string  `json:"environment_external_url"`
+			Ref             string  `json:"ref"`
+			ShortSHA        string  `json:"short_sha"`
+			Project         project `json:"project"`
+			User            struct {
+				Username string `json:"username"`
+			} `json:"user"`
+		}
+		if !decodeWebhook(w, r, body, &p) {
+			return
+		}
+		switch p.Status {
+		case "success":
+			event = "deploy"
+		case "failed":
+			event = "deploy_failed"
+		default: // running, canceled
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		a = annotation{
+			At: gitlabTime(p.StatusChangedAt), Kind: event,
+			Title:  deployTitle(p.Project.PathWithNamespace, p.Ref, p.ShortSHA, p.Environment, event == "deploy_failed"),
+			URL:    firstNonEmpty(p.DeployableURL, p.EnvironmentURL),
+			Author: p.User.Username,
+		}
+	case "Release Hook":
+		var p struct {
+			Action     string  `json:"action"`
+			Tag        string  `json:"tag"`
+			Name       string  `json:"name"`
+			URL        string  `json:"url"`
+			ReleasedAt string  `json:"released_at"`
+			Project    project `json:"project"`
+		}
+		if !decodeWebhook(w, r, body, &p) {
+			return
+		}
+		if p.Action != "create" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		event = "release"
+		a = annotation{
+			At: gitlabTime(p.ReleasedAt), Kind: event,
+			Title: releaseTitle(p.Project.PathWithNamespace, p.Tag, p.Name),
+			URL:   p.URL,
+		}
+	default:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	a.Source = "gitlab"
+	delivery := firstNonEmpty(r.Header.Get("Idempotency-Key"), r.Header.Get("X-Gitlab-Event-UUID"))
+	s.recordDeploy(w, r, wsID, a, delivery)
+}
+
+// recordDeploy stores a webhook's annotation, with a business event named
+// after its kind, and answers the webhook. An event time that is missing or ahead of the server's clock is taken as
+// now.
+func (s *server) recordDeploy(w http.ResponseWriter, r *http.Request, wsID int64, a annotation, delivery string) {
+	if now := time.Now(); a.At.IsZero() || a.At.After(now) {
+		a.At = now
+	}
+	a.Title = truncate(a.Title, maxAnnotationTitle)
+	a.URL = truncate(a.URL, 2048)
+	a.Author = truncate(a.Author, 100)
+	added, err := s.addAnnotation(r.Context(), wsID, a, truncate(delivery, 100), a.Kind)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if added {
+		s.cache.invalidate(wsID, cacheEventSummary)
+		log.Printf("workspace %d: %s: %s", wsID, a.Source, a.Title)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readWebhookBody reads a webhook's raw body, which GitHub's signature
+// covers, rejecting anything but JSON.
+func readWebhookBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		writeError(w, r, http.StatusUnsupportedMediaType, codeBadRequest, "set the webhook's content type to application/json")
+		return nil, false
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, codeTooLarge,
+				fmt.Sprintf("request body exceeds %d bytes", maxWebhookBody))
+		} else {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "reading request body failed")
+		}
+		return nil, false
+	}
+	return body, true
+}
+
+// decodeWebhook decodes body into v. Unlike decodeStrict it ignores unknown
+// fields: payloads carry far more than the annotation needs.
+func decodeWebhook(w http.ResponseWriter, r *http.Request, body []byte, v any) bool {
+	if err := json.Unmarshal(body, v); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
+		return false
+	}
+	return true
+}
+
+// signedWorkspace returns the workspace whose GitHub secret, or for the
+// default workspace a plaintext events API key from config.yaml, signed
+// body, given GitHub's "sha256=<hex>" signature, or 0.
+func (s *server) signedWorkspace(signature string, body []byte) (int64, error) {
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !strings.HasPrefix(signature, "sha256=") || len(sig) != sha256.Size {
+		return 0, nil
+	}
+	signedBy := func(key string) bool {
+		if key == "" {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(body)
+		return hmac.Equal(mac.Sum(nil), sig)
+	}
+	for _, key := range s.cfg.Events.AcceptedKeys() {
+		if !auth.IsHashedToken(key) && signedBy(key) {
+			return workspace.DefaultID, nil
+		}
+	}
+	list, err := s.spaces.List()
+	if err != nil {
+		return 0, err
+	}
+	for _, ws := range list {
+		if signedBy(ws.GitHubSecret) {
+			return ws.ID, nil
+		}
+	}
+	return 0, nil
+}
+
+// gitlabTime parses a GitLab webhook timestamp, which comes as
+// "2006-01-02 15:04:05 -0700", "... UTC" or RFC 3339 depending on the hook
+// and version. It returns the zero time for anything else.
+func gitlabTime(s string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05 -0700", "2006-01-02 15:04:05 MST", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// deployTitle describes a deploy: "Deployed org/app main@1a2b3c4 to
+// production", or "Deploy of ... failed".
+func deployTitle(repo, ref, sha, env string, failed bool) string {
+	what := repo
+	if ref != "" || sha != "" {
+		rev := ref
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		if sha != "" && sha != ref {
+			rev = strings.TrimPrefix(rev+"@"+sha, "@")
+		}
+		what += " " + rev
+	}
+	if env != "" {
+		what += " to " + env
+	}
+	what = strings.TrimSpace(what)
+	if failed {
+		return "Deploy of " + what + " failed"
+	}
+	return "Deployed " + what
+}
+
+// releaseTitle describes a release: "Released org/app v1.2.0", followed by
+// its name when that says more than the tag.
+func releaseTitle(repo, tag, name string) string {
+	title := strings.TrimSpace("Released " + repo + " " + tag)
+	if name != "" && name != tag {
+		title += ": " + name
+	}
+	return title
+}
+
+// truncate shortens s to at most n runes.
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
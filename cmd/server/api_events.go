@@ -1,22 +1,39 @@
 package main
 
 import (
-	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
+	"strings"
+
+	"health-dashboard/internal/auth"
+	"health-dashboard/internal/workspace"
 )
 
 // requireAPIKey is a middleware that checks the X-API-Key header against the
-// configured events API key.
+// configured events API keys, which scope the request to the default
+// workspace, and each workspace's own key.
 func (s *server) requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		key := r.Header.Get("X-API-Key")
-		if key == "" || key != s.cfg.Events.APIKey {
-			w.Header().Set("Content-Type", "application/json")
-			http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		wsID, err := s.apiKeyWorkspace(r.Header.Get("X-API-Key"))
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		if wsID == 0 {
+			writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
 			return
 		}
-		next(w, r)
+		next(w, r.WithContext(withWorkspace(r.Context(), wsID)))
+	}
+}
+
+// apiKeyWorkspace returns the workspace key is the events API key of, or 0.
+func (s *server) apiKeyWorkspace(key string) (int64, error) {
+	if auth.TokenMatches(key, s.cfg.Events.AcceptedKeys()) {
+		return workspace.DefaultID, nil
 	}
+	return s.spaces.ForAPIKey(key)
 }
 
 // handleEventPost handles POST /api/events.
@@ -27,14 +44,11 @@ func (s *server) handleEventPost(w http.ResponseWriter, r *http.Request) {
 		EventName string   `json:"event_name"`
 		Value     *float64 `json:"value"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, `{"error":"invalid JSON"}`, http.StatusBadRequest)
+	if !decodeStrict(w, r, &payload, maxEventBody) {
 		return
 	}
-	if payload.EventName == "" {
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, `{"error":"event_name is required"}`, http.StatusBadRequest)
+	if msg := validateEvent(payload.EventName, payload.Value); msg != "" {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, msg)
 		return
 	}
 
@@ -44,18 +58,45 @@ func (s *server) handleEventPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	_, err := s.db.ExecContext(r.Context(),
-		`INSERT INTO events (event_name, value) VALUES (?, ?)`,
-		payload.EventName, value,
+		`INSERT INTO events (workspace_id, event_name, value) VALUES (?, ?, ?)`,
+		workspaceID(r.Context()), payload.EventName, value,
 	)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, `{"error":"database error"}`, http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// maxEventNameLen bounds event names so one client can't bloat the summary.
+const maxEventNameLen = 128
+
+// validateEvent returns an error message for an invalid event, or "".
+func validateEvent(name string, value *float64) string {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return "event_name is required"
+	case len(name) > maxEventNameLen:
+		return fmt.Sprintf("event_name must be at most %d bytes", maxEventNameLen)
+	case value != nil && (math.IsNaN(*value) || math.IsInf(*value, 0)):
+		return "value must be a finite number"
+	}
+	return ""
+}
+
+// eventSummaryQuery totals one workspace's events for today and the last 7 days.
+const eventSummaryQuery = `
+	SELECT
+		event_name,
+		SUM(CASE WHEN created_at >= datetime('now', 'start of day') THEN value ELSE 0 END) AS today,
+		SUM(value) AS last_7_days
+	FROM events
+	WHERE workspace_id = ? AND created_at >= datetime('now', '-7 days')
+	GROUP BY event_name
+	ORDER BY event_name
+`
+
 // EventSummary is the per-event summary returned by GET /api/events/summary.
 type EventSummary struct {
 	EventName string  `json:"event_name"`
@@ -64,41 +105,35 @@ type EventSummary struct {
 }
 
 // handleEventSummary handles GET /api/events/summary.
-// Returns per-event totals for today and the trailing 7 days.
+// Returns per-event totals for today and the trailing 7 days, cached
+// (server.cache_seconds) until the next event is posted.
 func (s *server) handleEventSummary(w http.ResponseWriter, r *http.Request) {
-	rows, err := s.db.QueryContext(r.Context(), `
-		SELECT
-			event_name,
-			SUM(CASE WHEN created_at >= datetime('now', 'start of day') THEN value ELSE 0 END) AS today,
-			SUM(value) AS last_7_days
-		FROM events
-		WHERE created_at >= datetime('now', '-7 days')
-		GROUP BY event_name
-		ORDER BY event_name
-	`)
+	wsID := workspaceID(r.Context())
+	summaries, err := s.cache.get(cacheEventSummary, wsID, func() (any, error) {
+		return s.eventSummary(wsID)
+	})
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, `{"error":"database error"}`, http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
+
+	s.writeListJSON(w, r, summaries)
+}
+
+func (s *server) eventSummary(wsID int64) ([]EventSummary, error) {
+	rows, err := s.db.Query(eventSummaryQuery, wsID)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	summaries := make([]EventSummary, 0)
 	for rows.Next() {
 		var es EventSummary
 		if err := rows.Scan(&es.EventName, &es.Today, &es.Last7Days); err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			http.Error(w, `{"error":"scan error"}`, http.StatusInternalServerError)
-			return
+			return nil, err
 		}
 		summaries = append(summaries, es)
 	}
-	if err := rows.Err(); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, `{"error":"database error"}`, http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(summaries)
+	return summaries, rows.Err()
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"health-dashboard/internal/auth"
+	"health-dashboard/internal/monitor"
+)
+
+// actor describes the session making r, for the monitor history.
+func (s *server) actor(r *http.Request) monitor.Actor {
+	info, ok := s.sessions.Info(auth.GetSessionToken(r))
+	if !ok {
+		return monitor.Actor{IP: auth.ClientIP(r)}
+	}
+	return monitor.Actor{Role: string(info.Role), SessionID: info.ID, IP: auth.ClientIP(r)}
+}
+
+// recordVersion adds m's configuration to its history. The change itself
+// has already been saved, so a failure here is logged rather than returned.
+func (s *server) recordVersion(r *http.Request, before, m *monitor.Monitor, note string) {
+	if err := s.monitors.RecordVersion(before, m, s.actor(r), note); err != nil {
+		log.Printf("request %s: monitor %d history: %v", requestID(r.Context()), m.ID, err)
+	}
+}
+
+// handleMonitorHistory handles GET /api/monitors/{id}/history: every saved
+// configuration, newest first, with the fields each one changed.
+func (s *server) handleMonitorHistory(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.loadMonitor(w, r)
+	if !ok {
+		return
+	}
+	list, err := s.monitors.History(m.ID)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if list == nil {
+		list = []*monitor.Version{}
+	}
+	if !isAdmin(r.Context()) {
+		for i, v := range list {
+			list[i] = v.Redacted()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// handleMonitorRevert handles POST /api/monitors/{id}/history/{version}/revert:
+// restores that version's configuration, recorded as a new version.
+func (s *server) handleMonitorRevert(w http.ResponseWriter, r *http.Request) {
+	existing, ok := s.loadMonitor(w, r)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil || n <= 0 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid version")
+		return
+	}
+	v, err := s.monitors.GetVersion(existing.ID, n)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if v == nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "version not found")
+		return
+	}
+	m, err := monitor.ApplyConfig(existing, v.Config)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	// The target may no longer pass checks that were added or tightened
+	// since the version was saved.
+	if msg := validateMonitor(m); msg != "" {
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, msg)
+		return
+	}
+	if !s.validateChildren(w, r, m) {
+		return
+	}
+	if err := s.policy.ValidateTarget(r.Context(), m); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, err.Error())
+		return
+	}
+	if err := s.monitors.Update(m); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	s.recordVersion(r, existing, m, fmt.Sprintf("revert to version %d", n))
+	s.checker.Restart(m)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"health-dashboard/internal/statuspage"
+)
+
+// Length limits for incident fields.
+const (
+	maxIncidentTitle   = 200
+	maxIncidentMessage = 5000
+	maxPostmortem      = 50000
+)
+
+// incidentRequest is the body of POST /api/status-page/incidents.
+type incidentRequest struct {
+	Title   string `json:"title"`
+	Impact  string `json:"impact"`
+	Status  string `json:"status"`  // default investigating
+	Message string `json:"message"` // the first update
+}
+
+// incidentEditRequest is the body of PUT /api/status-page/incidents/{id}.
+type incidentEditRequest struct {
+	Title      string `json:"title"`
+	Impact     string `json:"impact"`
+	Postmortem string `json:"postmortem"`
+}
+
+// updateRequest is the body of POST /api/status-page/incidents/{id}/updates.
+type updateRequest struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// incidentTimeline is an incident in GET /api/status-page/incidents, with
+// the annotations from annotationLead before it started until it was
+// resolved. They are for the operators only; the status page doesn't show
+// them.
+type incidentTimeline struct {
+	*statuspage.Incident
+	Annotations []annotation `json:"annotations"`
+}
+
+// handleIncidentList handles GET /api/status-page/incidents: every incident
+// in the workspace, newest first.
+func (s *server) handleIncidentList(w http.ResponseWriter, r *http.Request) {
+	wsID := workspaceID(r.Context())
+	incidents, err := s.pages.Incidents(wsID, time.Time{})
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	list := make([]incidentTimeline, 0, len(incidents))
+	for _, inc := range incidents {
+		end := time.Now()
+		if inc.ResolvedAt != nil {
+			end = *inc.ResolvedAt
+		}
+		notes, err := s.annotationsBetween(r.Context(), wsID, inc.CreatedAt.Add(-annotationLead), end)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		list = append(list, incidentTimeline{Incident: inc, Annotations: notes})
+	}
+	s.writeListJSON(w, r, list)
+}
+
+// handleIncidentCreate handles POST /api/status-page/incidents.
+// Body: {"title": "Delayed emails", "impact": "minor", "message": "We are looking into it."}.
+func (s *server) handleIncidentCreate(w http.ResponseWriter, r *http.Request) {
+	var req incidentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
+		return
+	}
+	if req.Status == "" {
+		req.Status = statuspage.IncidentInvestigating
+	}
+	inc := &statuspage.Incident{WorkspaceID: workspaceID(r.Context()), Status: req.Status}
+	if !applyIncident(w, r, inc, incidentEditRequest{Title: req.Title, Impact: req.Impact}) {
+		return
+	}
+	msg, ok := validUpdate(w, r, updateRequest{Status: req.Status, Message: req.Message})
+	if !ok {
+		return
+	}
+	if err := s.pages.CreateIncident(inc, msg); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(inc)
+}
+
+// handleIncidentUpdate handles PUT /api/status-page/incidents/{id}: edits the
+// title, impact and postmortem without posting a status update.
+func (s *server) handleIncidentUpdate(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.loadIncident(w, r)
+	if !ok {
+		return
+	}
+	var req incidentEditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
+		return
+	}
+	if !applyIncident(w, r, inc, req) {
+		return
+	}
+	if err := s.pages.UpdateIncident(inc); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, codeNotFound, "not found")
+			return
+		}
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inc)
+}
+
+// handleIncidentAddUpdate handles POST /api/status-page/incidents/{id}/updates.
+// Body: {"status": "resolved", "message": "Emails are flowing again."}.
+func (s *server) handleIncidentAddUpdate(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.loadIncident(w, r)
+	if !ok {
+		return
+	}
+	var req updateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
+		return
+	}
+	msg, ok := validUpdate(w, r, req)
+	if !ok {
+		return
+	}
+	if err := s.pages.AddUpdate(inc, req.Status, msg); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(inc)
+}
+
+// handleIncidentDelete handles DELETE /api/status-page/incidents/{id}.
+func (s *server) handleIncidentDelete(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.loadIncident(w, r)
+	if !ok {
+		return
+	}
+	if err := s.pages.DeleteIncident(inc.ID); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadIncident fetches the incident named by the {id} path value, writing a
+// 404 if it doesn't exist or belongs to another workspace.
+func (s *server) loadIncident(w http.ResponseWriter, r *http.Request) (*statuspage.Incident, bool) {
+	id, ok := parseID(w, r)
+	if !ok {
+		return nil, false
+	}
+	inc, err := s.pages.Incident(id)
+	if err != nil {
+		internalError(w, r, err)
+		return nil, false
+	}
+	if inc == nil || inc.WorkspaceID != workspaceID(r.Context()) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "not found")
+		return nil, false
+	}
+	return inc, true
+}
+
+// applyIncident validates req and copies it into inc.
+func applyIncident(w http.ResponseWriter, r *http.Request, inc *statuspage.Incident, req incidentEditRequest) bool {
+	title := strings.TrimSpace(req.Title)
+	switch {
+	case title == "":
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, "title is required")
+	case len(title) > maxIncidentTitle:
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, "title is too long")
+	case !statuspage.ValidImpact(req.Impact):
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, "impact must be none, minor, major or critical")
+	case len(req.Postmortem) > maxPostmortem:
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, "postmortem is too long")
+	default:
+		inc.Title, inc.Impact, inc.Postmortem = title, req.Impact, strings.TrimSpace(req.Postmortem)
+		return true
+	}
+	return false
+}
+
+// validUpdate checks a status update and returns its trimmed message.
+func validUpdate(w http.ResponseWriter, r *http.Request, req updateRequest) (string, bool) {
+	msg := strings.TrimSpace(req.Message)
+	switch {
+	case !statuspage.ValidIncidentStatus(req.Status):
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, "status must be investigating, identified, monitoring or resolved")
+	case msg == "":
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, "message is required")
+	case len(msg) > maxIncidentMessage:
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, "message is too long")
+	default:
+		return msg, true
+	}
+	return "", false
+}
@@ -2,51 +2,480 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"time"
+
+	"health-dashboard/internal/auth"
+	"health-dashboard/internal/workspace"
 )
 
-// handleMetricsPost handles POST /api/metrics.
-// Authenticated via the X-Agent-Token header (shared secret from config.yaml).
-func (s *server) handleMetricsPost(w http.ResponseWriter, r *http.Request) {
+// maxDisks, maxInterfaces, maxIODevices, maxCPUCores, maxSensors,
+// maxTopProcesses, maxContainers, maxVMs, maxFailedUnits, maxCustomMetrics,
+// maxPings, maxPeers and maxDirSizes bound the entries accepted in one
+// metrics payload.
+const (
+	maxDisks      = 64
+	maxInterfaces = 64
+	maxIODevices  = 64
+	maxCPUCores   = 1024
+	maxSensors    = 128
+
+	maxTopProcesses = 20
+	maxContainers   = 256
+	maxVMs          = 1000 // a whole Proxmox cluster
+	maxFailedUnits  = 1000
+
+	maxCustomMetrics = 256
+	maxMetricKey     = 128
+
+	maxPings      = 64
+	maxPingTarget = 300 // a host name, port and brackets
+	maxPeers      = 64  // also the most GET /api/agent/peers returns
+	maxDirSizes   = 64
+	maxDirPath    = 4096
+)
+
+// A payload's collected_at may run ahead of the server clock by maxClockSkew
+// (it is then recorded at receipt) and lag it by up to maxMetricAge, the
+// metrics retention; agents replaying a queue send old ones. Payloads older
+// than freshMetric are stored but not fed to the temperature alerts.
+const (
+	maxClockSkew = 5 * time.Minute
+	maxMetricAge = 7 * 24 * time.Hour
+	freshMetric  = 2 * time.Minute
+)
+
+// agentWorkspace authenticates an agent request by its X-Agent-Token
+// header: the shared secret from config.yaml for the default workspace, or
+// a workspace's own agent token. It writes the error response and returns
+// false if the token is neither.
+func (s *server) agentWorkspace(w http.ResponseWriter, r *http.Request) (int64, bool) {
 	token := r.Header.Get("X-Agent-Token")
-	if token == "" || token != s.cfg.Agent.Token {
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+	if auth.TokenMatches(token, s.cfg.Agent.AcceptedTokens()) {
+		return workspace.DefaultID, true
+	}
+	id, err := s.spaces.ForAgentToken(token)
+	if err != nil {
+		internalError(w, r, err)
+		return 0, false
+	}
+	if id == 0 {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+		return 0, false
+	}
+	return id, true
+}
+
+// handleMetricsPost handles POST /api/metrics, authenticated by
+// agentWorkspace.
+func (s *server) handleMetricsPost(w http.ResponseWriter, r *http.Request) {
+	wsID, ok := s.agentWorkspace(w, r)
+	if !ok {
 		return
 	}
+	w, done := s.hosts.track(w, r, wsID)
+	defer done()
 
 	var payload struct {
-		CPUPercent float64 `json:"cpu_percent"`
-		MemUsed    int64   `json:"mem_used"`
-		MemTotal   int64   `json:"mem_total"`
-		Disks      []struct {
-			Mount string `json:"mount"`
-			Used  int64  `json:"used"`
-			Total int64  `json:"total"`
-		} `json:"disks"`
+		CPUPercent float64      `json:"cpu_percent"`
+		CPUCores   []float64    `json:"cpu_cores"`
+		Load1      float64      `json:"load_1"`
+		Load5      float64      `json:"load_5"`
+		Load15     float64      `json:"load_15"`
+		MemUsed    int64        `json:"mem_used"`
+		MemTotal   int64        `json:"mem_total"`
+		SwapUsed   int64        `json:"swap_used"`
+		SwapTotal  int64        `json:"swap_total"`
+		Disks      []diskInfo   `json:"disks"`
+		Net        []netInfo    `json:"net"`
+		DiskIO     []diskIOInfo `json:"disk_io"`
+		Temps      []tempInfo   `json:"temps"`
+		Pings      []pingInfo   `json:"pings"`
+		PeerChecks []peerInfo   `json:"peer_checks"`
+		DirSizes   []dirInfo    `json:"dir_sizes"`
+		TopCPU     []procInfo   `json:"top_cpu"`
+		TopMem     []procInfo   `json:"top_mem"`
+
+		Containers  []containerInfo `json:"containers"`
+		VMs         []vmInfo        `json:"vms"`
+		Agent       *agentMeta      `json:"agent"`
+		FailedUnits []string        `json:"failed_units"`
+		Sockets     *sockInfo       `json:"sockets"`
+		CollectedAt *time.Time      `json:"collected_at"`
+		NTPOffsetMs *float64        `json:"ntp_offset_ms"`
+		PeerAddress string          `json:"peer_address"`
+
+		CustomMetrics map[string]float64 `json:"custom_metrics"`
+	}
+	if !decodeStrict(w, r, &payload, maxMetricsBody) {
+		return
+	}
+	if payload.CPUPercent < 0 || payload.CPUPercent > 100 {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "cpu_percent must be between 0 and 100")
+		return
+	}
+	if len(payload.CPUCores) > maxCPUCores {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, fmt.Sprintf("at most %d cpu_cores are accepted", maxCPUCores))
+		return
+	}
+	for _, pct := range payload.CPUCores {
+		if pct < 0 || pct > 100 {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "each of cpu_cores must be between 0 and 100")
+			return
+		}
+	}
+	if payload.Load1 < 0 || payload.Load5 < 0 || payload.Load15 < 0 {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "load averages must not be negative")
+		return
+	}
+	if payload.MemUsed < 0 || payload.MemTotal < 0 || payload.MemUsed > payload.MemTotal {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "mem_used must be between 0 and mem_total")
+		return
+	}
+	if payload.SwapUsed < 0 || payload.SwapTotal < 0 || payload.SwapUsed > payload.SwapTotal {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "swap_used must be between 0 and swap_total")
+		return
+	}
+	if len(payload.Disks) > maxDisks {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, fmt.Sprintf("at most %d disks are accepted", maxDisks))
+		return
+	}
+	for _, d := range payload.Disks {
+		if d.Mount == "" || d.Used < 0 || d.Total < 0 {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "each disk needs a mount and non-negative used/total")
+			return
+		}
+		if d.InodesUsed < 0 || d.InodesTotal < 0 || d.InodesUsed > d.InodesTotal {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "each disk's inodes_used must be between 0 and inodes_total")
+			return
+		}
+	}
+
+	if len(payload.Net) > maxInterfaces {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, fmt.Sprintf("at most %d interfaces are accepted", maxInterfaces))
+		return
+	}
+	for _, n := range payload.Net {
+		if n.Iface == "" || n.RxBytesPerSec < 0 || n.TxBytesPerSec < 0 || n.RxPacketsPerSec < 0 || n.TxPacketsPerSec < 0 {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "each interface needs an iface and non-negative rates")
+			return
+		}
+	}
+
+	if len(payload.DiskIO) > maxIODevices {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, fmt.Sprintf("at most %d I/O devices are accepted", maxIODevices))
+		return
+	}
+	for _, d := range payload.DiskIO {
+		if d.Device == "" || d.ReadsPerSec < 0 || d.WritesPerSec < 0 || d.ReadBytesPerSec < 0 || d.WriteBytesPerSec < 0 {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "each I/O device needs a device and non-negative rates")
+			return
+		}
+	}
+
+	if payload.CPUCores == nil {
+		payload.CPUCores = []float64{}
+	}
+	coresJSON, err := json.Marshal(payload.CPUCores)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if len(payload.Temps) > maxSensors {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, fmt.Sprintf("at most %d temperature sensors are accepted", maxSensors))
+		return
+	}
+	for _, t := range payload.Temps {
+		if t.Sensor == "" || t.Celsius < -273.15 || t.Celsius > 1000 {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "each temperature needs a sensor and a plausible celsius value")
+			return
+		}
+	}
+
+	if len(payload.Pings) > maxPings {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, fmt.Sprintf("at most %d pings are accepted", maxPings))
+		return
+	}
+	for _, p := range payload.Pings {
+		if p.Target == "" || len(p.Target) > maxPingTarget || (p.Method != "icmp" && p.Method != "tcp") ||
+			p.LossPercent < 0 || p.LossPercent > 100 || (p.RTTMs != nil && *p.RTTMs < 0) || len(p.Error) > 1024 {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "each ping needs a target, a method of icmp or tcp, a loss_percent between 0 and 100 and a non-negative rtt_ms")
+			return
+		}
 	}
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, `{"error":"invalid JSON"}`, http.StatusBadRequest)
+
+	if len(payload.PeerAddress) > maxPingTarget {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, fmt.Sprintf("peer_address must be at most %d characters", maxPingTarget))
+		return
+	}
+	if len(payload.PeerChecks) > maxPeers {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, fmt.Sprintf("at most %d peer_checks are accepted", maxPeers))
+		return
+	}
+	for _, p := range payload.PeerChecks {
+		if p.Peer == "" || len(p.Peer) > maxHostName || len(p.Target) > maxPingTarget || (p.Method != "icmp" && p.Method != "tcp") ||
+			p.LossPercent < 0 || p.LossPercent > 100 || (p.RTTMs != nil && *p.RTTMs < 0) || len(p.Error) > 1024 {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "each of peer_checks needs a peer, a method of icmp or tcp, a loss_percent between 0 and 100 and a non-negative rtt_ms")
+			return
+		}
+	}
+
+	if len(payload.DirSizes) > maxDirSizes {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, fmt.Sprintf("at most %d dir_sizes are accepted", maxDirSizes))
+		return
+	}
+	for _, d := range payload.DirSizes {
+		if d.Path == "" || len(d.Path) > maxDirPath || d.Bytes < 0 || d.Files < 0 || d.Skipped < 0 || len(d.Error) > 1024 {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "each of dir_sizes needs a path and non-negative bytes, files and skipped")
+			return
+		}
+	}
+
+	for _, list := range [][]procInfo{payload.TopCPU, payload.TopMem} {
+		if len(list) > maxTopProcesses {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, fmt.Sprintf("at most %d processes per top list are accepted", maxTopProcesses))
+			return
+		}
+		for _, p := range list {
+			if p.PID <= 0 || p.CPUPercent < 0 || p.CPUPercent > 100 || p.RSS < 0 {
+				writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "each process needs a pid, cpu_percent between 0 and 100 and non-negative rss")
+				return
+			}
+		}
+	}
+
+	if len(payload.Containers) > maxContainers {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, fmt.Sprintf("at most %d containers are accepted", maxContainers))
 		return
 	}
+	for _, c := range payload.Containers {
+		if c.ID == "" || c.CPUPercent < 0 || c.MemUsed < 0 || c.MemLimit < 0 || c.RestartCount < 0 {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "each container needs an id and non-negative stats")
+			return
+		}
+	}
+
+	if len(payload.VMs) > maxVMs {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, fmt.Sprintf("at most %d vms are accepted", maxVMs))
+		return
+	}
+	for _, v := range payload.VMs {
+		if v.ID == "" || len(v.ID) > 256 || len(v.Name) > 256 || len(v.Node) > 256 || (v.Kind != "vm" && v.Kind != "container") ||
+			v.State == "" || len(v.State) > 32 || v.CPUs < 0 || v.CPUPercent < 0 || v.CPUPercent > 100 || v.MemUsed < 0 || v.MemTotal < 0 {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "each vm needs an id, a kind of vm or container, a state, cpu_percent between 0 and 100 and non-negative stats")
+			return
+		}
+	}
+
+	if a := payload.Agent; a != nil && (a.Version == "" || len(a.Version) > 64 || len(a.OS) > 32 ||
+		len(a.Platform) > 128 || len(a.Kernel) > 128 || len(a.Arch) > 32) {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField,
+			"agent needs a version of at most 64 bytes; os and arch may be 32 bytes, platform and kernel 128")
+		return
+	}
+
+	if len(payload.FailedUnits) > maxFailedUnits {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, fmt.Sprintf("at most %d failed_units are accepted", maxFailedUnits))
+		return
+	}
+	for _, u := range payload.FailedUnits {
+		if u == "" || len(u) > 256 {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "each of failed_units must be a unit name of at most 256 characters")
+			return
+		}
+	}
+
+	if len(payload.CustomMetrics) > maxCustomMetrics {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, fmt.Sprintf("at most %d custom_metrics are accepted", maxCustomMetrics))
+		return
+	}
+	for k := range payload.CustomMetrics {
+		if !validMetricKey(k) {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField,
+				fmt.Sprintf("custom_metrics key %q must be at most %d letters, digits, '_', '-' or '.'", k, maxMetricKey))
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	recordedAt := now
+	if at := payload.CollectedAt; at != nil && !at.IsZero() {
+		switch {
+		case at.After(now.Add(maxClockSkew)):
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "collected_at is in the future")
+			return
+		case at.Before(now.Add(-maxMetricAge)):
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "collected_at is older than metrics retention")
+			return
+		case at.Before(now):
+			recordedAt = at.UTC()
+		}
+	}
+
+	if k := payload.Sockets; k != nil {
+		if k.FDOpen < 0 || k.FDMax < 0 || k.TCPEstablished < 0 || k.TCPInUse < 0 || k.TCPTimeWait < 0 {
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "socket counts must not be negative")
+			return
+		}
+	}
 
 	diskJSON, err := json.Marshal(payload.Disks)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+		internalError(w, r, err)
+		return
+	}
+	if payload.Net == nil {
+		payload.Net = []netInfo{}
+	}
+	netJSON, err := json.Marshal(payload.Net)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if payload.DiskIO == nil {
+		payload.DiskIO = []diskIOInfo{}
+	}
+	ioJSON, err := json.Marshal(payload.DiskIO)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	if payload.Temps == nil {
+		payload.Temps = []tempInfo{}
+	}
+	tempsJSON, err := json.Marshal(payload.Temps)
+	if err != nil {
+		internalError(w, r, err)
 		return
 	}
 
-	_, err = s.db.ExecContext(r.Context(),
-		`INSERT INTO metrics (cpu_percent, mem_used, mem_total, disk_json) VALUES (?, ?, ?, ?)`,
-		payload.CPUPercent, payload.MemUsed, payload.MemTotal, string(diskJSON),
-	)
+	if payload.Pings == nil {
+		payload.Pings = []pingInfo{}
+	}
+	pingsJSON, err := json.Marshal(payload.Pings)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, `{"error":"database error"}`, http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
 
+	if payload.DirSizes == nil {
+		payload.DirSizes = []dirInfo{}
+	}
+	dirsJSON, err := json.Marshal(payload.DirSizes)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	if payload.VMs == nil {
+		payload.VMs = []vmInfo{}
+	}
+	vmsJSON, err := json.Marshal(payload.VMs)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	if payload.TopCPU == nil {
+		payload.TopCPU = []procInfo{}
+	}
+	topCPUJSON, err := json.Marshal(payload.TopCPU)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if payload.TopMem == nil {
+		payload.TopMem = []procInfo{}
+	}
+	topMemJSON, err := json.Marshal(payload.TopMem)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	if payload.FailedUnits == nil {
+		payload.FailedUnits = []string{}
+	}
+	failedJSON, err := json.Marshal(payload.FailedUnits)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	if payload.CustomMetrics == nil {
+		payload.CustomMetrics = map[string]float64{}
+	}
+	customJSON, err := json.Marshal(payload.CustomMetrics)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	row := &metricRow{
+		WorkspaceID: wsID,
+		RecordedAt:  recordedAt.Format("2006-01-02 15:04:05"),
+		CPUPercent:  payload.CPUPercent,
+		CoresJSON:   string(coresJSON),
+		Load1:       payload.Load1,
+		Load5:       payload.Load5,
+		Load15:      payload.Load15,
+		MemUsed:     payload.MemUsed,
+		MemTotal:    payload.MemTotal,
+		SwapUsed:    payload.SwapUsed,
+		SwapTotal:   payload.SwapTotal,
+		DiskJSON:    string(diskJSON),
+		NetJSON:     string(netJSON),
+		IOJSON:      string(ioJSON),
+		TempsJSON:   string(tempsJSON),
+		PingsJSON:   string(pingsJSON),
+		DirsJSON:    string(dirsJSON),
+		VMsJSON:     string(vmsJSON),
+		TopCPUJSON:  string(topCPUJSON),
+		TopMemJSON:  string(topMemJSON),
+		FailedJSON:  string(failedJSON),
+		CustomJSON:  string(customJSON),
+		Containers:  payload.Containers,
+		Sockets:     payload.Sockets,
+		NTPOffsetMs: payload.NTPOffsetMs,
+	}
+	if s.metricBuf != nil {
+		err = s.metricBuf.add(row)
+	} else {
+		err = writeMetrics(r.Context(), s.db, []*metricRow{row})
+	}
+	if errors.Is(err, errBufferFull) {
+		s.tooBusy(w, r)
+		return
+	}
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if now.Sub(recordedAt) < freshMetric {
+		s.temps.observe(wsID, agentHost(r), payload.Temps, recordedAt)
+	}
+	// The metrics are stored, so a failure here is logged rather than
+	// refusing a payload the agent would only send again.
+	if err := s.hosts.recordPeers(wsID, agentHost(r), payload.PeerAddress, recordedAt, payload.PeerChecks); err != nil {
+		log.Printf("request %s: peer checks: %v", requestID(r.Context()), err)
+	}
+	if payload.Agent != nil {
+		if err := s.hosts.recordAgent(wsID, agentHost(r), *payload.Agent); err != nil {
+			log.Printf("request %s: agent info: %v", requestID(r.Context()), err)
+		}
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// validMetricKey reports whether k is an acceptable custom metric name.
+func validMetricKey(k string) bool {
+	return k != "" && len(k) <= maxMetricKey && strings.IndexFunc(k, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-.", r))
+	}) < 0
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"health-dashboard/internal/monitor"
 )
@@ -12,17 +15,61 @@ import (
 // handleMonitorCreate handles POST /api/monitors.
 func (s *server) handleMonitorCreate(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Name            string `json:"name"`
-		URL             string `json:"url"`
-		IntervalSeconds int    `json:"interval_seconds"`
-		TimeoutSeconds  int    `json:"timeout_seconds"`
+		Name              string `json:"name"`
+		Type              string `json:"type"`
+		URL               string `json:"url"`
+		IntervalSeconds   int    `json:"interval_seconds"`
+		TimeoutSeconds    int    `json:"timeout_seconds"`
+		Schedule          string `json:"schedule"`
+		RetentionDays     int    `json:"retention_days"`
+		FailureThreshold  int    `json:"failure_threshold"`
+		Retries           int    `json:"retries"`
+		RetryDelaySeconds int    `json:"retry_delay_seconds"`
+		ActiveDays        string `json:"active_days"`
+		ActiveHours       string `json:"active_hours"`
+		Timezone          string `json:"timezone"`
+
+		DetectContentChange bool              `json:"detect_content_change"`
+		MaintenanceStart    *time.Time        `json:"maintenance_start"`
+		MaintenanceEnd      *time.Time        `json:"maintenance_end"`
+		BudgetBytes         int64             `json:"budget_bytes"`
+		BudgetMs            int               `json:"budget_ms"`
+		SecurityAudit       bool              `json:"security_audit"`
+		AssertCanonical     bool              `json:"assert_canonical"`
+		AssertHTTP2         bool              `json:"assert_http2"`
+		DNSServer           string            `json:"dns_server"`
+		ExpectedIPs         string            `json:"expected_ips"`
+		DNSRecordType       string            `json:"dns_record_type"`
+		DNSExpected         string            `json:"dns_expected"`
+		JSONAssert          string            `json:"json_assert"`
+		HTTPMethod          string            `json:"http_method"`
+		HTTPHeaders         map[string]string `json:"http_headers"`
+		HTTPBody            string            `json:"http_body"`
+		AcceptedStatusCodes string            `json:"accepted_status_codes"`
+		AuthType            string            `json:"auth_type"`
+		AuthUsername        string            `json:"auth_username"`
+		AuthSecret          string            `json:"auth_secret"`
+		DNSBLZones          string            `json:"dnsbl_zones"`
+		Tags                string            `json:"tags"`
+		Children            string            `json:"children"`
+		CompositeMode       string            `json:"composite_mode"`
+		CompositeThreshold  int               `json:"composite_threshold"`
+		DebugTrace          bool              `json:"debug_trace"`
+		Owner               string            `json:"owner"`
+		RunbookURL          string            `json:"runbook_url"`
+		Description         string            `json:"description"`
+		MaxRunSeconds       int               `json:"max_run_seconds"`
+		RunMedianFactor     float64           `json:"run_median_factor"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
 		return
 	}
-	if strings.TrimSpace(req.Name) == "" || strings.TrimSpace(req.URL) == "" {
-		http.Error(w, `{"error":"name and url are required"}`, http.StatusBadRequest)
+	// Composite monitors derive their state from children, and push monitors
+	// from their jobs' pings; neither has a url.
+	needsURL := req.Type != monitor.TypeComposite && req.Type != monitor.TypePush
+	if strings.TrimSpace(req.Name) == "" || (strings.TrimSpace(req.URL) == "" && needsURL) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, "name and url are required")
 		return
 	}
 	if req.IntervalSeconds <= 0 {
@@ -31,52 +78,114 @@ func (s *server) handleMonitorCreate(w http.ResponseWriter, r *http.Request) {
 	if req.TimeoutSeconds <= 0 {
 		req.TimeoutSeconds = 10
 	}
+	if req.Type == "" {
+		req.Type = monitor.TypeHTTP
+	}
+	if req.FailureThreshold == 0 {
+		req.FailureThreshold = monitor.DefaultFailureThreshold
+	}
+	if req.RetryDelaySeconds == 0 {
+		req.RetryDelaySeconds = monitor.DefaultRetryDelaySeconds
+	}
 
 	m := &monitor.Monitor{
-		Name:            strings.TrimSpace(req.Name),
-		URL:             strings.TrimSpace(req.URL),
-		IntervalSeconds: req.IntervalSeconds,
-		TimeoutSeconds:  req.TimeoutSeconds,
+		Name:              strings.TrimSpace(req.Name),
+		Type:              req.Type,
+		URL:               strings.TrimSpace(req.URL),
+		IntervalSeconds:   req.IntervalSeconds,
+		TimeoutSeconds:    req.TimeoutSeconds,
+		Schedule:          strings.TrimSpace(req.Schedule),
+		RetentionDays:     req.RetentionDays,
+		FailureThreshold:  req.FailureThreshold,
+		Retries:           req.Retries,
+		RetryDelaySeconds: req.RetryDelaySeconds,
+		ActiveDays:        strings.TrimSpace(req.ActiveDays),
+		ActiveHours:       strings.TrimSpace(req.ActiveHours),
+		Timezone:          strings.TrimSpace(req.Timezone),
+
+		DetectContentChange: req.DetectContentChange,
+		MaintenanceStart:    req.MaintenanceStart,
+		MaintenanceEnd:      req.MaintenanceEnd,
+		BudgetBytes:         max(req.BudgetBytes, 0),
+		BudgetMs:            max(req.BudgetMs, 0),
+		SecurityAudit:       req.SecurityAudit,
+		AssertCanonical:     req.AssertCanonical,
+		AssertHTTP2:         req.AssertHTTP2,
+		DNSServer:           strings.TrimSpace(req.DNSServer),
+		ExpectedIPs:         req.ExpectedIPs,
+		DNSRecordType:       req.DNSRecordType,
+		DNSExpected:         req.DNSExpected,
+		JSONAssert:          req.JSONAssert,
+		HTTPMethod:          req.HTTPMethod,
+		HTTPHeaders:         req.HTTPHeaders,
+		HTTPBody:            req.HTTPBody,
+		AcceptedStatusCodes: req.AcceptedStatusCodes,
+		AuthType:            req.AuthType,
+		AuthUsername:        req.AuthUsername,
+		AuthSecret:          req.AuthSecret,
+		DNSBLZones:          strings.TrimSpace(req.DNSBLZones),
+		Tags:                req.Tags,
+		Children:            req.Children,
+		CompositeMode:       strings.TrimSpace(req.CompositeMode),
+		CompositeThreshold:  req.CompositeThreshold,
+		DebugTrace:          req.DebugTrace,
+		Owner:               strings.TrimSpace(req.Owner),
+		RunbookURL:          strings.TrimSpace(req.RunbookURL),
+		Description:         strings.TrimSpace(req.Description),
+		MaxRunSeconds:       req.MaxRunSeconds,
+		RunMedianFactor:     req.RunMedianFactor,
+	}
+	m.WorkspaceID = workspaceID(r.Context())
+	if msg := validateMonitor(m); msg != "" {
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, msg)
+		return
+	}
+	if !s.validateChildren(w, r, m) {
+		return
+	}
+	if err := s.policy.ValidateTarget(r.Context(), m); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, err.Error())
+		return
 	}
 	if err := s.monitors.Create(m); err != nil {
-		http.Error(w, `{"error":"database error"}`, http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
-	s.checker.Add(r.Context(), m)
+	s.recordVersion(r, nil, m, "")
+	s.checker.Add(m)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(m)
 }
 
-// handleMonitorList handles GET /api/monitors.
+// handleMonitorList handles GET /api/monitors. Viewers get credentials in
+// URLs masked; the same applies to GET /api/monitors/{id}.
 func (s *server) handleMonitorList(w http.ResponseWriter, r *http.Request) {
-	monitors, err := s.monitors.List()
+	monitors, err := s.monitors.ListWorkspace(workspaceID(r.Context()))
 	if err != nil {
-		http.Error(w, `{"error":"database error"}`, http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
 	if monitors == nil {
 		monitors = []*monitor.Monitor{}
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(monitors)
+	if !isAdmin(r.Context()) {
+		for i, m := range monitors {
+			monitors[i] = m.Redacted()
+		}
+	}
+	s.writeListJSON(w, r, monitors)
 }
 
 // handleMonitorGet handles GET /api/monitors/{id}.
 func (s *server) handleMonitorGet(w http.ResponseWriter, r *http.Request) {
-	id, ok := parseMonitorID(w, r)
+	m, ok := s.loadMonitor(w, r)
 	if !ok {
 		return
 	}
-	m, err := s.monitors.Get(id)
-	if err != nil {
-		http.Error(w, `{"error":"database error"}`, http.StatusInternalServerError)
-		return
-	}
-	if m == nil {
-		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
-		return
+	if !isAdmin(r.Context()) {
+		m = m.Redacted()
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(m)
@@ -84,37 +193,78 @@ func (s *server) handleMonitorGet(w http.ResponseWriter, r *http.Request) {
 
 // handleMonitorUpdate handles PUT /api/monitors/{id}.
 func (s *server) handleMonitorUpdate(w http.ResponseWriter, r *http.Request) {
-	id, ok := parseMonitorID(w, r)
+	existing, ok := s.loadMonitor(w, r)
 	if !ok {
 		return
 	}
-	existing, err := s.monitors.Get(id)
-	if err != nil {
-		http.Error(w, `{"error":"database error"}`, http.StatusInternalServerError)
-		return
-	}
-	if existing == nil {
-		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
-		return
-	}
 
 	var req struct {
-		Name            string `json:"name"`
-		URL             string `json:"url"`
-		IntervalSeconds int    `json:"interval_seconds"`
-		TimeoutSeconds  int    `json:"timeout_seconds"`
+		Name              string  `json:"name"`
+		Type              string  `json:"type"`
+		URL               string  `json:"url"`
+		IntervalSeconds   int     `json:"interval_seconds"`
+		TimeoutSeconds    int     `json:"timeout_seconds"`
+		Schedule          *string `json:"schedule"`
+		RetentionDays     *int    `json:"retention_days"`
+		FailureThreshold  *int    `json:"failure_threshold"`
+		Retries           *int    `json:"retries"`
+		RetryDelaySeconds *int    `json:"retry_delay_seconds"`
+		ActiveDays        *string `json:"active_days"`
+		ActiveHours       *string `json:"active_hours"`
+		Timezone          *string `json:"timezone"`
+
+		DetectContentChange *bool              `json:"detect_content_change"`
+		MaintenanceStart    nullTime           `json:"maintenance_start"`
+		MaintenanceEnd      nullTime           `json:"maintenance_end"`
+		BudgetBytes         *int64             `json:"budget_bytes"`
+		BudgetMs            *int               `json:"budget_ms"`
+		SecurityAudit       *bool              `json:"security_audit"`
+		AssertCanonical     *bool              `json:"assert_canonical"`
+		AssertHTTP2         *bool              `json:"assert_http2"`
+		DNSServer           *string            `json:"dns_server"`
+		ExpectedIPs         *string            `json:"expected_ips"`
+		DNSRecordType       *string            `json:"dns_record_type"`
+		DNSExpected         *string            `json:"dns_expected"`
+		JSONAssert          *string            `json:"json_assert"`
+		HTTPMethod          *string            `json:"http_method"`
+		HTTPHeaders         *map[string]string `json:"http_headers"`
+		HTTPBody            *string            `json:"http_body"`
+		AcceptedStatusCodes *string            `json:"accepted_status_codes"`
+		AuthType            *string            `json:"auth_type"`
+		AuthUsername        *string            `json:"auth_username"`
+		AuthSecret          *string            `json:"auth_secret"`
+		DNSBLZones          *string            `json:"dnsbl_zones"`
+		Tags                *string            `json:"tags"`
+		Children            *string            `json:"children"`
+		CompositeMode       *string            `json:"composite_mode"`
+		CompositeThreshold  *int               `json:"composite_threshold"`
+		DebugTrace          *bool              `json:"debug_trace"`
+		Owner               *string            `json:"owner"`
+		RunbookURL          *string            `json:"runbook_url"`
+		Description         *string            `json:"description"`
+		MaxRunSeconds       *int               `json:"max_run_seconds"`
+		RunMedianFactor     *float64           `json:"run_median_factor"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
 		return
 	}
 
+	before := *existing
+	prevType, prevURL, prevDNS := existing.Type, existing.URL, existing.DNSServer
+
 	// Apply only provided fields.
 	if n := strings.TrimSpace(req.Name); n != "" {
 		existing.Name = n
 	}
+	if req.Type != "" {
+		existing.Type = req.Type
+	}
 	if u := strings.TrimSpace(req.URL); u != "" {
 		existing.URL = u
+	} else if existing.Type == monitor.TypePush {
+		// A monitor turned into a push monitor drops its old target.
+		existing.URL = ""
 	}
 	if req.IntervalSeconds > 0 {
 		existing.IntervalSeconds = req.IntervalSeconds
@@ -122,50 +272,272 @@ func (s *server) handleMonitorUpdate(w http.ResponseWriter, r *http.Request) {
 	if req.TimeoutSeconds > 0 {
 		existing.TimeoutSeconds = req.TimeoutSeconds
 	}
+	// retention_days may be reset to 0 (global default), so nil means "not provided".
+	if req.RetentionDays != nil {
+		existing.RetentionDays = *req.RetentionDays
+	}
+	if req.FailureThreshold != nil {
+		existing.FailureThreshold = *req.FailureThreshold
+	}
+	if req.Retries != nil {
+		existing.Retries = *req.Retries
+	}
+	if req.RetryDelaySeconds != nil {
+		existing.RetryDelaySeconds = *req.RetryDelaySeconds
+	}
+	// Schedule fields may be cleared with "", so nil means "not provided".
+	if req.Schedule != nil {
+		existing.Schedule = strings.TrimSpace(*req.Schedule)
+	}
+	if req.ActiveDays != nil {
+		existing.ActiveDays = strings.TrimSpace(*req.ActiveDays)
+	}
+	if req.ActiveHours != nil {
+		existing.ActiveHours = strings.TrimSpace(*req.ActiveHours)
+	}
+	if req.Timezone != nil {
+		existing.Timezone = strings.TrimSpace(*req.Timezone)
+	}
+	if req.DetectContentChange != nil {
+		existing.DetectContentChange = *req.DetectContentChange
+	}
+	// Maintenance bounds may be cleared with null.
+	if req.MaintenanceStart.Set {
+		existing.MaintenanceStart = req.MaintenanceStart.Time
+	}
+	if req.MaintenanceEnd.Set {
+		existing.MaintenanceEnd = req.MaintenanceEnd.Time
+	}
+	// Budgets may be disabled with 0.
+	if req.BudgetBytes != nil {
+		existing.BudgetBytes = max(*req.BudgetBytes, 0)
+	}
+	if req.BudgetMs != nil {
+		existing.BudgetMs = max(*req.BudgetMs, 0)
+	}
+	if req.SecurityAudit != nil {
+		existing.SecurityAudit = *req.SecurityAudit
+	}
+	if req.AssertCanonical != nil {
+		existing.AssertCanonical = *req.AssertCanonical
+	}
+	if req.AssertHTTP2 != nil {
+		existing.AssertHTTP2 = *req.AssertHTTP2
+	}
+	if req.DNSServer != nil {
+		existing.DNSServer = strings.TrimSpace(*req.DNSServer)
+	}
+	if req.ExpectedIPs != nil {
+		existing.ExpectedIPs = *req.ExpectedIPs
+	}
+	if req.DNSRecordType != nil {
+		existing.DNSRecordType = *req.DNSRecordType
+	}
+	if req.DNSExpected != nil {
+		existing.DNSExpected = *req.DNSExpected
+	}
+	if req.JSONAssert != nil {
+		existing.JSONAssert = *req.JSONAssert
+	}
+	if req.HTTPMethod != nil {
+		existing.HTTPMethod = *req.HTTPMethod
+	}
+	if req.HTTPHeaders != nil {
+		existing.HTTPHeaders = *req.HTTPHeaders
+	}
+	if req.HTTPBody != nil {
+		existing.HTTPBody = *req.HTTPBody
+	}
+	if req.AcceptedStatusCodes != nil {
+		existing.AcceptedStatusCodes = *req.AcceptedStatusCodes
+	}
+	// The secret is never sent back, so a client that leaves auth_secret
+	// out keeps it; auth_type "" removes the credentials altogether.
+	if req.AuthType != nil {
+		existing.AuthType = *req.AuthType
+		if strings.TrimSpace(existing.AuthType) == "" {
+			existing.AuthUsername, existing.AuthSecret = "", ""
+		}
+	}
+	if req.AuthUsername != nil {
+		existing.AuthUsername = *req.AuthUsername
+	}
+	if req.AuthSecret != nil {
+		existing.AuthSecret = *req.AuthSecret
+	}
+	if req.DNSBLZones != nil {
+		existing.DNSBLZones = strings.TrimSpace(*req.DNSBLZones)
+	}
+	if req.Tags != nil {
+		existing.Tags = *req.Tags
+	}
+	if req.Children != nil {
+		existing.Children = *req.Children
+	}
+	if req.CompositeMode != nil {
+		existing.CompositeMode = strings.TrimSpace(*req.CompositeMode)
+	}
+	if req.CompositeThreshold != nil {
+		existing.CompositeThreshold = *req.CompositeThreshold
+	}
+	if req.DebugTrace != nil {
+		existing.DebugTrace = *req.DebugTrace
+	}
+	if req.Owner != nil {
+		existing.Owner = strings.TrimSpace(*req.Owner)
+	}
+	if req.RunbookURL != nil {
+		existing.RunbookURL = strings.TrimSpace(*req.RunbookURL)
+	}
+	if req.Description != nil {
+		existing.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.MaxRunSeconds != nil {
+		existing.MaxRunSeconds = *req.MaxRunSeconds
+	}
+	if req.RunMedianFactor != nil {
+		existing.RunMedianFactor = *req.RunMedianFactor
+	}
+	if msg := validateMonitor(existing); msg != "" {
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, msg)
+		return
+	}
+	if !s.validateChildren(w, r, existing) {
+		return
+	}
+	if existing.Type != prevType || existing.URL != prevURL || existing.DNSServer != prevDNS {
+		if err := s.policy.ValidateTarget(r.Context(), existing); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidField, err.Error())
+			return
+		}
+	}
 
 	if err := s.monitors.Update(existing); err != nil {
-		http.Error(w, `{"error":"database error"}`, http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
-	s.checker.Restart(r.Context(), existing)
+	s.recordVersion(r, &before, existing, "")
+	s.checker.Restart(existing)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(existing)
 }
 
-// handleMonitorDelete handles DELETE /api/monitors/{id}.
+// handleMonitorDelete handles DELETE /api/monitors/{id}. A monitor that
+// composite monitors are derived from can't be deleted until they no longer
+// list it (409).
 func (s *server) handleMonitorDelete(w http.ResponseWriter, r *http.Request) {
-	id, ok := parseMonitorID(w, r)
+	m, ok := s.loadMonitor(w, r)
 	if !ok {
 		return
 	}
-	s.checker.Remove(id)
-	if err := s.monitors.Delete(id); err != nil {
-		http.Error(w, `{"error":"database error"}`, http.StatusInternalServerError)
+	parents, err := s.monitors.Parents(m.ID)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if len(parents) > 0 {
+		writeError(w, r, http.StatusConflict, codeConflict,
+			fmt.Sprintf("monitor is a child of composite monitor %q", parents[0].Name))
+		return
+	}
+	s.checker.Remove(m.ID)
+	if err := s.monitors.Delete(m.ID); err != nil {
+		internalError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// handleMonitorChecks handles GET /api/monitors/{id}/checks.
-func (s *server) handleMonitorChecks(w http.ResponseWriter, r *http.Request) {
-	id, ok := parseMonitorID(w, r)
+// handleMonitorKnownIssue handles PUT /api/monitors/{id}/known-issue: a
+// banner explaining an ongoing problem, shown with the monitor on the
+// dashboard and the public status page until expires_at.
+func (s *server) handleMonitorKnownIssue(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.loadMonitor(w, r)
 	if !ok {
 		return
 	}
-	m, err := s.monitors.Get(id)
-	if err != nil {
-		http.Error(w, `{"error":"database error"}`, http.StatusInternalServerError)
+	var req struct {
+		Text      string     `json:"text"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
 		return
 	}
-	if m == nil {
-		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+	req.Text = strings.TrimSpace(req.Text)
+	switch {
+	case req.Text == "":
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, "text is required")
+		return
+	case len(req.Text) > maxKnownIssueLen:
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, fmt.Sprintf("text must be at most %d characters", maxKnownIssueLen))
+		return
+	case req.ExpiresAt == nil:
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, "expires_at is required")
+		return
+	case !req.ExpiresAt.After(time.Now()):
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, "expires_at must be in the future")
+		return
+	case time.Until(*req.ExpiresAt) > maxKnownIssueFor:
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, "expires_at must be within 90 days")
+		return
+	}
+	until := req.ExpiresAt.UTC()
+	if err := s.monitors.SetKnownIssue(m.ID, req.Text, &until); err != nil {
+		internalError(w, r, err)
 		return
 	}
+	m.KnownIssue, m.KnownIssueUntil = req.Text, &until
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
 
-	checks, err := s.monitors.RecentChecks(id, 100)
+// handleMonitorKnownIssueClear handles DELETE /api/monitors/{id}/known-issue.
+func (s *server) handleMonitorKnownIssueClear(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.loadMonitor(w, r)
+	if !ok {
+		return
+	}
+	if err := s.monitors.SetKnownIssue(m.ID, "", nil); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMonitorCheckAll handles POST /api/monitors/check-all for the
+// request's workspace.
+// Probes are queued asynchronously; the response reports how many were queued.
+func (s *server) handleMonitorCheckAll(w http.ResponseWriter, r *http.Request) {
+	queued := s.checker.CheckWorkspace(workspaceID(r.Context()))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int{"queued": queued})
+}
+
+// handleDNSCacheStats handles GET /api/checker/dns-cache: the probe DNS
+// cache's hit and miss counters, shared by every workspace. enabled is false
+// without checker.dns_cache.
+func (s *server) handleDNSCacheStats(w http.ResponseWriter, r *http.Request) {
+	stats, ok := s.checker.DNSStats()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Enabled bool `json:"enabled"`
+		monitor.DNSStats
+	}{ok, stats})
+}
+
+// handleMonitorChecks handles GET /api/monitors/{id}/checks.
+func (s *server) handleMonitorChecks(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.loadMonitor(w, r)
+	if !ok {
+		return
+	}
+
+	checks, err := s.monitors.RecentChecks(m.ID, 100)
 	if err != nil {
-		http.Error(w, `{"error":"database error"}`, http.StatusInternalServerError)
+		internalError(w, r, err)
 		return
 	}
 	if checks == nil {
@@ -175,12 +547,318 @@ func (s *server) handleMonitorChecks(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(checks)
 }
 
-// parseMonitorID extracts and validates the {id} path value from r.
-func parseMonitorID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+// handleMonitorGaps handles GET /api/monitors/{id}/gaps: no-data periods
+// (e.g. server downtime) from the last 7 days.
+func (s *server) handleMonitorGaps(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.loadMonitor(w, r)
+	if !ok {
+		return
+	}
+
+	gaps, err := s.monitors.Gaps(m.ID, time.Now().AddDate(0, 0, -7))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if gaps == nil {
+		gaps = []monitor.Gap{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(gaps)
+}
+
+// handleMonitorTraces handles GET /api/monitors/{id}/traces: diagnostic
+// traces of the monitor's failed and slow checks from the last day, newest
+// first. They are only recorded while its debug_trace is enabled.
+func (s *server) handleMonitorTraces(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.loadMonitor(w, r)
+	if !ok {
+		return
+	}
+
+	traces, err := s.monitors.Traces(m.ID)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if traces == nil {
+		traces = []*monitor.Trace{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(traces)
+}
+
+// defaultHeatmapWeeks and maxHeatmapWeeks bound GET /api/monitors/{id}/heatmap.
+const (
+	defaultHeatmapWeeks = 4
+	maxHeatmapWeeks     = 52
+)
+
+// handleMonitorHeatmap handles GET /api/monitors/{id}/heatmap: checks and
+// failures bucketed by weekday and hour over the last ?weeks= weeks, in the
+// ?tz= time zone (default the monitor's schedule timezone, then the server's).
+func (s *server) handleMonitorHeatmap(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.loadMonitor(w, r)
+	if !ok {
+		return
+	}
+	weeks := defaultHeatmapWeeks
+	if v := r.URL.Query().Get("weeks"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxHeatmapWeeks {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("weeks must be between 1 and %d", maxHeatmapWeeks))
+			return
+		}
+		weeks = n
+	}
+	loc := time.Local
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		tz = m.Timezone
+	}
+	if tz != "" {
+		l, err := time.LoadLocation(tz)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid tz")
+			return
+		}
+		loc = l
+	}
+
+	h, err := s.monitors.FailureHeatmap(m.ID, time.Now().AddDate(0, 0, -7*weeks), loc)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
+
+// Limits on a monitor's free-text contact fields and known issue.
+const (
+	maxOwnerLen       = 200
+	maxDescriptionLen = 10000
+	maxKnownIssueLen  = 1000
+	maxKnownIssueFor  = 90 * 24 * time.Hour
+)
+
+// validateMonitor checks fields shared by create and update and returns an
+// error message, or "" if m is valid.
+func validateMonitor(m *monitor.Monitor) string {
+	switch m.Type {
+	case monitor.TypeHTTP, monitor.TypeDNSBL:
+	case monitor.TypeDNS:
+		name, recordType, expected, err := monitor.ParseDNSRecord(m.URL, m.DNSRecordType, m.DNSExpected)
+		if err != nil {
+			return err.Error()
+		}
+		m.URL, m.DNSRecordType, m.DNSExpected = name, recordType, expected
+	case monitor.TypeComposite:
+		children, err := monitor.ParseChildren(m.Children)
+		if err != nil {
+			return err.Error()
+		}
+		if err := m.ValidateComposite(children); err != nil {
+			return err.Error()
+		}
+		m.Children = monitor.FormatChildren(children)
+	case monitor.TypePush:
+	default:
+		return "type must be http, dnsbl, dns, composite or push"
+	}
+	if err := m.ValidatePush(); err != nil {
+		return err.Error()
+	}
+	if m.RetentionDays < 0 {
+		return "retention_days must not be negative"
+	}
+	if m.FailureThreshold < 1 || m.FailureThreshold > monitor.MaxFailureThreshold {
+		return fmt.Sprintf("failure_threshold must be between 1 and %d", monitor.MaxFailureThreshold)
+	}
+	if m.Retries < 0 || m.Retries > monitor.MaxRetries {
+		return fmt.Sprintf("retries must be between 0 and %d", monitor.MaxRetries)
+	}
+	if m.RetryDelaySeconds < 1 || m.RetryDelaySeconds > monitor.MaxRetryDelaySeconds {
+		return fmt.Sprintf("retry_delay_seconds must be between 1 and %d", monitor.MaxRetryDelaySeconds)
+	}
+	if m.Retries > 0 && m.Type == monitor.TypeComposite {
+		return "retries don't apply to composite monitors"
+	}
+	// Retries wait out the rest of the check, so they must end before the
+	// next one is due.
+	if m.Retries > 0 && m.Schedule == "" && m.MaxProbeTime() >= time.Duration(m.IntervalSeconds)*time.Second {
+		return "retries: the attempts, at timeout_seconds each, and the delays between them must take less than interval_seconds"
+	}
+	if _, err := m.ActiveWindow(); err != nil {
+		return "invalid schedule"
+	}
+	cron, err := m.CronSchedule()
+	if err != nil {
+		return "invalid cron schedule"
+	}
+	// A valid expression can still name a day that never comes, e.g.
+	// "0 0 30 2 *"; the monitor would never be checked.
+	if cron != nil && cron.Next(time.Now()).IsZero() {
+		return "cron schedule never fires"
+	}
+	dns, err := monitor.ParseDNSServer(m.DNSServer)
+	if err != nil {
+		return err.Error()
+	}
+	m.DNSServer = dns // stored as ip:port
+	ips, err := monitor.ParseExpectedIPs(m.ExpectedIPs)
+	if err != nil {
+		return err.Error()
+	}
+	m.ExpectedIPs = ips
+	jsonAssert, err := monitor.ParseJSONAssert(m.JSONAssert)
+	if err != nil {
+		return err.Error()
+	}
+	if jsonAssert != "" && m.Type != monitor.TypeHTTP {
+		return "json_assert only applies to http monitors"
+	}
+	m.JSONAssert = jsonAssert
+	method, headers, err := monitor.ParseHTTPRequest(m.HTTPMethod, m.HTTPHeaders, m.HTTPBody)
+	if err != nil {
+		return err.Error()
+	}
+	if m.Type != monitor.TypeHTTP && (method != http.MethodGet || len(headers) > 0 || m.HTTPBody != "") {
+		return "http_method, http_headers and http_body only apply to http monitors"
+	}
+	m.HTTPMethod, m.HTTPHeaders = method, headers
+	codes, err := monitor.ParseStatusCodes(m.AcceptedStatusCodes)
+	if err != nil {
+		return err.Error()
+	}
+	if codes != "" && m.Type != monitor.TypeHTTP {
+		return "accepted_status_codes only applies to http monitors"
+	}
+	m.AcceptedStatusCodes = codes
+	authType, err := monitor.ParseAuth(m.AuthType, m.AuthUsername, m.AuthSecret)
+	if err != nil {
+		return err.Error()
+	}
+	if authType != "" {
+		if m.Type != monitor.TypeHTTP {
+			return "auth_type only applies to http monitors"
+		}
+		if _, ok := m.HTTPHeaders["Authorization"]; ok {
+			return "http_headers: Authorization can't be combined with auth_type"
+		}
+		if u, err := url.Parse(m.URL); err == nil && u.User != nil {
+			return "credentials in the url can't be combined with auth_type"
+		}
+	}
+	m.AuthType, m.AuthSecretSet = authType, m.AuthSecret != ""
+	tags, err := monitor.ParseTags(m.Tags)
+	if err != nil {
+		return err.Error()
+	}
+	m.Tags = tags
+	if len(m.Owner) > maxOwnerLen {
+		return fmt.Sprintf("owner must be at most %d characters", maxOwnerLen)
+	}
+	if m.RunbookURL != "" {
+		if err := monitor.ValidateRunbookURL(m.RunbookURL); err != nil {
+			return "runbook_url " + err.Error()
+		}
+	}
+	if len(m.Description) > maxDescriptionLen {
+		return fmt.Sprintf("description must be at most %d characters", maxDescriptionLen)
+	}
+
+	return ""
+}
+
+// validateChildren checks that a composite monitor's children are other,
+// non-composite monitors in its workspace, writing a 400 if not. Nesting
+// composites isn't supported, which also rules out cycles.
+func (s *server) validateChildren(w http.ResponseWriter, r *http.Request, m *monitor.Monitor) bool {
+	if m.Type != monitor.TypeComposite {
+		return true
+	}
+	if m.ID != 0 {
+		parents, err := s.monitors.Parents(m.ID)
+		if err != nil {
+			internalError(w, r, err)
+			return false
+		}
+		if len(parents) > 0 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidField,
+				fmt.Sprintf("monitor is a child of composite monitor %q, so can't be a composite", parents[0].Name))
+			return false
+		}
+	}
+	children, _ := monitor.ParseChildren(m.Children) // validated by validateMonitor
+	for _, ch := range children {
+		msg := ""
+		child, err := s.monitors.Get(ch.ID)
+		switch {
+		case err != nil:
+			internalError(w, r, err)
+			return false
+		case ch.ID == m.ID:
+			msg = "a composite monitor can't be its own child"
+		case child == nil || child.WorkspaceID != m.WorkspaceID:
+			msg = fmt.Sprintf("children: monitor %d not found", ch.ID)
+		case child.Type == monitor.TypeComposite:
+			msg = fmt.Sprintf("children: monitor %d is itself a composite", ch.ID)
+		}
+		if msg != "" {
+			writeError(w, r, http.StatusBadRequest, codeInvalidField, msg)
+			return false
+		}
+	}
+	return true
+}
+
+// nullTime distinguishes an explicit JSON null (clear the field) from an
+// absent field: Set is false when absent, and Time is nil for null.
+type nullTime struct {
+	Set  bool
+	Time *time.Time
+}
+
+func (n *nullTime) UnmarshalJSON(b []byte) error {
+	n.Set = true
+	if string(b) == "null" {
+		n.Time = nil
+		return nil
+	}
+	var t time.Time
+	if err := json.Unmarshal(b, &t); err != nil {
+		return err
+	}
+	n.Time = &t
+	return nil
+}
+
+// loadMonitor returns the monitor named by the {id} path value, writing a
+// 404 if it doesn't exist or belongs to another workspace.
+func (s *server) loadMonitor(w http.ResponseWriter, r *http.Request) (*monitor.Monitor, bool) {
+	id, ok := parseID(w, r)
+	if !ok {
+		return nil, false
+	}
+	m, err := s.monitors.Get(id)
+	if err != nil {
+		internalError(w, r, err)
+		return nil, false
+	}
+	if m == nil || m.WorkspaceID != workspaceID(r.Context()) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "not found")
+		return nil, false
+	}
+	return m, true
+}
+
+// parseID extracts and validates the {id} path value from r.
+func parseID(w http.ResponseWriter, r *http.Request) (int64, bool) {
 	idStr := r.PathValue("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil || id <= 0 {
-		http.Error(w, `{"error":"invalid id"}`, http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid id")
 		return 0, false
 	}
 	return id, true
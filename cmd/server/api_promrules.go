@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"health-dashboard/internal/monitor"
+)
+
+// promExprs maps each rules.Metrics name to the PromQL computing it from the
+// agent's /metrics (see cmd/agent/prometheus.go), per scraped instance.
+var promExprs = map[string]string{
+	"cpu_percent":     `health_agent_cpu_percent`,
+	"mem_percent":     `100 * health_agent_memory_used_bytes / health_agent_memory_total_bytes`,
+	"swap_percent":    `100 * health_agent_swap_used_bytes / (health_agent_swap_total_bytes > 0)`,
+	"load_1":          `health_agent_load1`,
+	"load_5":          `health_agent_load5`,
+	"load_15":         `health_agent_load15`,
+	"disk_percent":    `max without (mount) (100 * health_agent_disk_used_bytes / (health_agent_disk_total_bytes > 0))`,
+	"temp_max":        `max without (sensor) (health_agent_temperature_celsius)`,
+	"fd_open":         `health_agent_file_handles_open`,
+	"tcp_established": `health_agent_tcp_established`,
+}
+
+// promRuleGroup and promRule are the parts of a Prometheus rules file the
+// export uses.
+type promRuleGroup struct {
+	Name  string     `yaml:"name"`
+	Rules []promRule `yaml:"rules"`
+}
+
+type promRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// handlePromRules handles GET /api/alert-rules/prometheus: the configured
+// thresholds as a Prometheus rules file. Host rules (the temperature alert
+// and agent.thresholds) target the agent's --listen metrics; monitor rules
+// target a blackbox exporter probing each monitor's URL as its instance.
+func (s *server) handlePromRules(w http.ResponseWriter, r *http.Request) {
+	monitors, err := s.monitors.ListWorkspace(workspaceID(r.Context()))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	var hosts []promRule
+	if t := s.cfg.Alerts.TemperatureThreshold; t > 0 {
+		hosts = append(hosts, promRule{
+			Alert:       "TemperatureHigh",
+			Expr:        "health_agent_temperature_celsius >= " + promNumber(t),
+			For:         promDuration(time.Duration(s.cfg.Alerts.TemperatureForSeconds) * time.Second),
+			Annotations: map[string]string{"summary": "{{ $labels.sensor }} at {{ $value }} °C"},
+		})
+	}
+	for _, t := range s.cfg.Agent.Thresholds {
+		expr, ok := promExprs[t.Metric]
+		switch {
+		case ok && t.Mount != "" && t.Metric == "disk_percent":
+			sel := "{mount=" + strconv.Quote(t.Mount) + "}"
+			expr = "100 * health_agent_disk_used_bytes" + sel + " / health_agent_disk_total_bytes" + sel
+		case !ok && strings.Contains(t.Metric, "."):
+			expr = "health_agent_custom{name=" + strconv.Quote(t.Metric) + "}"
+		case !ok:
+			continue // the agent refuses to start with it
+		}
+		hosts = append(hosts, promRule{
+			Alert:       promAlertName(t.Event),
+			Expr:        expr + " " + t.Op + " " + promNumber(t.Value),
+			For:         promDuration(time.Duration(t.ForSeconds) * time.Second),
+			Labels:      map[string]string{"event": t.Event},
+			Annotations: map[string]string{"summary": fmt.Sprintf("%s %s %s (value {{ $value }})", t.Metric, t.Op, promNumber(t.Value))},
+		})
+	}
+
+	var checks []promRule
+	for _, m := range monitors {
+		if m.Type != monitor.TypeHTTP {
+			continue // composite and DNSBL checks have no blackbox equivalent
+		}
+		target := m.URL
+		if !isAdmin(r.Context()) {
+			target = monitor.RedactURL(target)
+		}
+		sel := "{instance=" + strconv.Quote(target) + "}"
+		// The dashboard alerts on the failure_threshold-th failed check in
+		// a row, failure_threshold-1 intervals after the first, and on the
+		// DefaultFailureThreshold-th check over budget.
+		interval := time.Duration(m.IntervalSeconds) * time.Second
+		sustained := promDuration(time.Duration(m.DownAfter()-1) * interval)
+		overBudget := promDuration((monitor.DefaultFailureThreshold - 1) * interval)
+		annotations := func(summary, status string) map[string]string {
+			a := map[string]string{"summary": summary}
+			if rb := monitor.RunbookURL(m, s.runbooks, status); rb != "" {
+				a["runbook_url"] = rb
+			}
+			return a
+		}
+		checks = append(checks, promRule{
+			Alert:       "MonitorDown",
+			Expr:        "probe_success" + sel + " == 0",
+			For:         sustained,
+			Labels:      map[string]string{"monitor": m.Name},
+			Annotations: annotations(m.Name+" is down", "down"),
+		})
+		if m.BudgetMs > 0 {
+			checks = append(checks, promRule{
+				Alert:       "MonitorOverBudget",
+				Expr:        "probe_duration_seconds" + sel + " > " + promNumber(float64(m.BudgetMs)/1000),
+				For:         overBudget,
+				Labels:      map[string]string{"monitor": m.Name},
+				Annotations: annotations(fmt.Sprintf("%s takes over %d ms", m.Name, m.BudgetMs), "over_budget"),
+			})
+		}
+	}
+
+	groups := []promRuleGroup{}
+	if len(hosts) > 0 {
+		groups = append(groups, promRuleGroup{Name: "health-dashboard-hosts", Rules: hosts})
+	}
+	if len(checks) > 0 {
+		groups = append(groups, promRuleGroup{Name: "health-dashboard-monitors", Rules: checks})
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	enc.Encode(map[string]any{"groups": groups})
+	enc.Close()
+}
+
+var promNameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// promAlertName turns an event name into a valid alert name.
+func promAlertName(event string) string {
+	name := promNameInvalid.ReplaceAllString(event, "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+func promNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// promDuration renders d in Prometheus's duration syntax, or "" for zero.
+func promDuration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return strconv.Itoa(int(d.Seconds())) + "s"
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"health-dashboard/internal/monitor"
+)
+
+// maxRunsListed bounds GET /api/monitors/{id}/runs.
+const maxRunsListed = 100
+
+// handlePushStart handles POST /api/push/{token}/start: the job a push
+// monitor watches has started a run. The token authenticates the request.
+func (s *server) handlePushStart(w http.ResponseWriter, r *http.Request) {
+	s.handlePush(w, r, s.monitors.StartRun)
+}
+
+// handlePushFinish handles POST /api/push/{token}/finish: the job's run
+// succeeded. It closes the run opened by a start ping, if any.
+func (s *server) handlePushFinish(w http.ResponseWriter, r *http.Request) {
+	s.handlePush(w, r, s.monitors.FinishRun)
+}
+
+func (s *server) handlePush(w http.ResponseWriter, r *http.Request, record func(id int64) error) {
+	m, err := s.monitors.GetByPushToken(r.PathValue("token"))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if m == nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "not found")
+		return
+	}
+	if err := record(m.ID); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	// Evaluate now rather than at the next check, so a finish after an
+	// overlong run alerts at once.
+	s.checker.CheckMonitor(m.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMonitorRuns handles GET /api/monitors/{id}/runs: a push monitor's
+// latest runs, newest first.
+func (s *server) handleMonitorRuns(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.loadMonitor(w, r)
+	if !ok {
+		return
+	}
+
+	runs, err := s.monitors.PushRuns(m.ID, maxRunsListed)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if runs == nil {
+		runs = []*monitor.PushRun{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
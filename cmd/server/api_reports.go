@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"health-dashboard/internal/monitor"
+)
+
+// defaultReportRange and maxReportRange bound ?range= on
+// GET /api/reports/compare.
+const (
+	defaultReportRange = "30d"
+	maxReportRange     = 365 * 24 * time.Hour
+)
+
+// compareReport is returned by GET /api/reports/compare.
+type compareReport struct {
+	Tag      string                 `json:"tag"`
+	Range    string                 `json:"range"`
+	Since    time.Time              `json:"since"`
+	Monitors []*monitor.Reliability `json:"monitors"` // least reliable first
+}
+
+// handleReportCompare handles GET /api/reports/compare?tag=prod&range=30d: the
+// workspace's monitors (only those tagged tag, when given) ranked from least
+// to most reliable, with uptime, outage count and MTTR over the range.
+func (s *server) handleReportCompare(w http.ResponseWriter, r *http.Request) {
+	rng := r.URL.Query().Get("range")
+	if rng == "" {
+		rng = defaultReportRange
+	}
+	d, ok := parseRange(rng)
+	if !ok || d <= 0 || d > maxReportRange {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "range must be a number of hours or days, like 24h or 30d, up to 365d")
+		return
+	}
+	tag := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tag")))
+	since := time.Now().Add(-d).UTC().Truncate(time.Second)
+
+	monitors, err := s.monitors.ListWorkspace(workspaceID(r.Context()))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	report := compareReport{Tag: tag, Range: rng, Since: since, Monitors: []*monitor.Reliability{}}
+	for _, m := range monitors {
+		if tag != "" && !m.HasTag(tag) {
+			continue
+		}
+		rel, err := s.monitors.Reliability(m, since)
+		if err != nil {
+			internalError(w, r, err)
+			return
+		}
+		report.Monitors = append(report.Monitors, rel)
+	}
+	sort.SliceStable(report.Monitors, func(i, j int) bool {
+		return lessReliable(report.Monitors[i], report.Monitors[j])
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// lessReliable orders monitors by uptime ascending, then by more outages,
+// then by longer MTTR. Monitors without checks go last.
+func lessReliable(a, b *monitor.Reliability) bool {
+	if (a.Uptime == nil) != (b.Uptime == nil) {
+		return b.Uptime == nil
+	}
+	if a.Uptime != nil && *a.Uptime != *b.Uptime {
+		return *a.Uptime < *b.Uptime
+	}
+	if a.Incidents != b.Incidents {
+		return a.Incidents > b.Incidents
+	}
+	return mttr(a) > mttr(b)
+}
+
+func mttr(r *monitor.Reliability) float64 {
+	if r.MTTRSeconds == nil {
+		return 0
+	}
+	return *r.MTTRSeconds
+}
+
+// parseRange parses a report range like "24h" or "30d".
+func parseRange(s string) (time.Duration, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 || n > 100000 { // large enough for any valid range, small enough not to overflow
+		return 0, false
+	}
+	switch s[len(s)-1] {
+	case 'h':
+		return time.Duration(n) * time.Hour, true
+	case 'd':
+		return time.Duration(n) * 24 * time.Hour, true
+	}
+	return 0, false
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"health-dashboard/internal/rules"
+)
+
+// Rule previews cover the last defaultPreviewDays unless asked otherwise,
+// and at most maxPreviewDays, the retention of metrics and events.
+const (
+	defaultPreviewDays = 7
+	maxPreviewDays     = 7
+)
+
+// eventPreviewStep is how often an event rule's trailing total is sampled.
+const eventPreviewStep = time.Minute
+
+// metricExprs maps each rules.Metrics name to the SQL computing it from a
+// metrics row. NULL means the row has no value for it.
+var metricExprs = map[string]string{
+	"cpu_percent":     `cpu_percent`,
+	"mem_percent":     `CASE WHEN mem_total > 0 THEN 100.0 * mem_used / mem_total END`,
+	"swap_percent":    `CASE WHEN swap_total > 0 THEN 100.0 * swap_used / swap_total END`,
+	"load_1":          `load_1`,
+	"load_5":          `load_5`,
+	"load_15":         `load_15`,
+	"disk_percent":    `(SELECT MAX(100.0 * json_extract(value, '$.used') / json_extract(value, '$.total')) FROM json_each(disk_json) WHERE json_extract(value, '$.total') > 0)`,
+	"temp_max":        `(SELECT MAX(json_extract(value, '$.celsius')) FROM json_each(temps_json))`,
+	"fd_open":         `fd_open`,
+	"tcp_established": `tcp_established`,
+}
+
+// previewResponse is returned by POST /api/alert-rules/preview.
+type previewResponse struct {
+	Since     time.Time      `json:"since"`
+	Samples   int            `json:"samples"`    // values the rule was evaluated on
+	Fires     int            `json:"fires"`      // times it would have started firing
+	FiringNow bool           `json:"firing_now"` // whether it would be firing at the last sample
+	Firings   []rules.Firing `json:"firings"`    // oldest first
+}
+
+// handleRulePreview handles POST /api/alert-rules/preview: replays a
+// proposed rule over the workspace's stored metrics or events and reports
+// when it would have fired, so thresholds can be tuned before use.
+// Body: {"kind": "metric", "metric": "cpu_percent", "op": ">", "threshold": 90,
+// "for_seconds": 300, "days": 7}.
+func (s *server) handleRulePreview(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		rules.Rule
+		Days int `json:"days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
+		return
+	}
+	if err := req.Rule.Validate(); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, err.Error())
+		return
+	}
+	if req.Days == 0 {
+		req.Days = defaultPreviewDays
+	}
+	if req.Days < 0 || req.Days > maxPreviewDays {
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, "days must be between 1 and 7")
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	since := now.AddDate(0, 0, -req.Days)
+	var points []rules.Point
+	var err error
+	if req.Kind == rules.KindMetric {
+		points, err = s.metricSeries(r, req.Metric, since)
+	} else {
+		points, err = s.eventSeries(r, req.Event, time.Duration(req.WindowSeconds)*time.Second, since, now)
+	}
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	resp := previewResponse{Since: since, Samples: len(points), Firings: rules.Evaluate(req.Rule, points)}
+	resp.Fires = len(resp.Firings)
+	if resp.Firings == nil {
+		resp.Firings = []rules.Firing{}
+	}
+	if n := len(resp.Firings); n > 0 && resp.Firings[n-1].End == nil {
+		resp.FiringNow = true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// metricSeries returns a host metric's values in the request's workspace
+// since the given time, oldest first, skipping rows without a value.
+func (s *server) metricSeries(r *http.Request, metric string, since time.Time) ([]rules.Point, error) {
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT recorded_at, `+metricExprs[metric]+` FROM metrics
+		WHERE workspace_id = ? AND recorded_at >= ?
+		ORDER BY recorded_at`, workspaceID(r.Context()), since.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var points []rules.Point
+	for rows.Next() {
+		var at time.Time
+		var v sql.NullFloat64
+		if err := rows.Scan(&at, &v); err != nil {
+			return nil, err
+		}
+		if v.Valid {
+			points = append(points, rules.Point{At: at, Value: v.Float64})
+		}
+	}
+	return points, rows.Err()
+}
+
+// eventSeries returns an event's trailing-window totals in the request's
+// workspace, sampled every eventPreviewStep from since to until. Events
+// from the window before since are loaded so the first totals are whole.
+func (s *server) eventSeries(r *http.Request, event string, window time.Duration, since, until time.Time) ([]rules.Point, error) {
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT created_at, value FROM events
+		WHERE workspace_id = ? AND event_name = ? AND created_at >= ?
+		ORDER BY created_at`, workspaceID(r.Context()), event, since.Add(-window).Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var occurrences []rules.Point
+	for rows.Next() {
+		var p rules.Point
+		if err := rows.Scan(&p.At, &p.Value); err != nil {
+			return nil, err
+		}
+		occurrences = append(occurrences, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rules.WindowTotals(occurrences, window, eventPreviewStep, since, until), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"health-dashboard/internal/auth"
+)
+
+// handleSessionList handles GET /api/auth/sessions: live sessions with
+// their creation and last-use times, client IP and user agent. Sessions
+// span workspaces, so only an admin of every workspace manages them.
+func (s *server) handleSessionList(w http.ResponseWriter, r *http.Request) {
+	if !isInstanceAdmin(r.Context()) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, instanceAdminOnly)
+		return
+	}
+	list, err := s.sessions.List(auth.GetSessionToken(r))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// handleSessionRevoke handles DELETE /api/auth/sessions/{id}.
+func (s *server) handleSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	if !isInstanceAdmin(r.Context()) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, instanceAdminOnly)
+		return
+	}
+	ok, err := s.sessions.Revoke(r.PathValue("id"))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSessionRevokeOthers handles DELETE /api/auth/sessions: every session
+// but the caller's is revoked, e.g. after a cookie may have leaked.
+func (s *server) handleSessionRevokeOthers(w http.ResponseWriter, r *http.Request) {
+	if !isInstanceAdmin(r.Context()) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, instanceAdminOnly)
+		return
+	}
+	n, err := s.sessions.RevokeOthers(auth.GetSessionToken(r))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"revoked": n})
+}
+
+// handleLoginList handles GET /api/auth/logins: recent login attempts,
+// successful or not, newest first. Admin of every workspace only.
+func (s *server) handleLoginList(w http.ResponseWriter, r *http.Request) {
+	if !isInstanceAdmin(r.Context()) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, instanceAdminOnly)
+		return
+	}
+	limit := loginHistoryLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxLoginHistory {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+	logins, err := s.logins.Recent(limit)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logins)
+}
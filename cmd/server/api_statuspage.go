@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"health-dashboard/internal/statuspage"
+)
+
+// maxComponentMonitors bounds the monitors listed in one component.
+const maxComponentMonitors = 100
+
+// componentRequest is the body of POST and PUT /api/status-page/components.
+type componentRequest struct {
+	Name     string             `json:"name"`
+	Position int                `json:"position"`
+	Monitors []statuspage.Entry `json:"monitors"`
+}
+
+// handleComponentList handles GET /api/status-page/components: the
+// workspace's status page components in display order.
+func (s *server) handleComponentList(w http.ResponseWriter, r *http.Request) {
+	list, err := s.pages.List(workspaceID(r.Context()))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if list == nil {
+		list = []*statuspage.Component{}
+	}
+	s.writeListJSON(w, r, list)
+}
+
+// handleComponentCreate handles POST /api/status-page/components.
+// Body: {"name": "API", "position": 1, "monitors": [{"monitor_id": 3, "display_name": "REST API"}]}.
+func (s *server) handleComponentCreate(w http.ResponseWriter, r *http.Request) {
+	var req componentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
+		return
+	}
+	c := &statuspage.Component{WorkspaceID: workspaceID(r.Context())}
+	if !s.applyComponent(w, r, c, req) {
+		return
+	}
+	if err := s.pages.Create(c); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// handleComponentUpdate handles PUT /api/status-page/components/{id}.
+// The body is the same as for create and replaces the component entirely.
+func (s *server) handleComponentUpdate(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.loadComponent(w, r)
+	if !ok {
+		return
+	}
+	var req componentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
+		return
+	}
+	if !s.applyComponent(w, r, c, req) {
+		return
+	}
+	if err := s.pages.Update(c); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, codeNotFound, "not found")
+			return
+		}
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// handleComponentDelete handles DELETE /api/status-page/components/{id}.
+func (s *server) handleComponentDelete(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.loadComponent(w, r)
+	if !ok {
+		return
+	}
+	if err := s.pages.Delete(c.ID); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadComponent fetches the component named by the {id} path value, writing
+// a 404 if it doesn't exist or belongs to another workspace.
+func (s *server) loadComponent(w http.ResponseWriter, r *http.Request) (*statuspage.Component, bool) {
+	id, ok := parseID(w, r)
+	if !ok {
+		return nil, false
+	}
+	c, err := s.pages.Get(id)
+	if err != nil {
+		internalError(w, r, err)
+		return nil, false
+	}
+	if c == nil || c.WorkspaceID != workspaceID(r.Context()) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "not found")
+		return nil, false
+	}
+	return c, true
+}
+
+// applyComponent validates req and copies it into c. Every monitor must
+// exist in c's workspace, appear at most once, and have a display name so
+// internal names never reach the public page.
+func (s *server) applyComponent(w http.ResponseWriter, r *http.Request, c *statuspage.Component, req componentRequest) bool {
+	fail := func(msg string) bool {
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, msg)
+		return false
+	}
+	name := strings.TrimSpace(req.Name)
+	switch {
+	case name == "":
+		return fail("name is required")
+	case len(name) > 100:
+		return fail("name must be at most 100 characters")
+	case len(req.Monitors) > maxComponentMonitors:
+		return fail(fmt.Sprintf("at most %d monitors per component", maxComponentMonitors))
+	}
+	entries := make([]statuspage.Entry, 0, len(req.Monitors))
+	seen := make(map[int64]bool)
+	for _, e := range req.Monitors {
+		e.DisplayName = strings.TrimSpace(e.DisplayName)
+		if e.DisplayName == "" || len(e.DisplayName) > 100 {
+			return fail("each monitor needs a display_name of at most 100 characters")
+		}
+		if seen[e.MonitorID] {
+			return fail(fmt.Sprintf("monitor %d is listed twice", e.MonitorID))
+		}
+		seen[e.MonitorID] = true
+		m, err := s.monitors.Get(e.MonitorID)
+		if err != nil {
+			internalError(w, r, err)
+			return false
+		}
+		if m == nil || m.WorkspaceID != c.WorkspaceID {
+			return fail(fmt.Sprintf("monitor %d not found", e.MonitorID))
+		}
+		entries = append(entries, e)
+	}
+	c.Name, c.Position, c.Monitors = name, req.Position, entries
+	return true
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"health-dashboard/internal/monitor"
+)
+
+// topology is returned by GET /api/topology: the workspace's monitors and
+// tags as nodes of a graph, ready to lay out as a map.
+type topology struct {
+	Nodes []topologyNode `json:"nodes"`
+	Edges []topologyEdge `json:"edges"`
+}
+
+// topologyNode is a monitor ("monitor:3") or a tag ("tag:prod"). A tag's
+// state summarizes its monitors: down if any is down, else up if any is up,
+// else unknown.
+type topologyNode struct {
+	ID        string   `json:"id"`
+	Kind      string   `json:"kind"` // "monitor" or "tag"
+	Name      string   `json:"name"`
+	State     string   `json:"state"`
+	MonitorID int64    `json:"monitor_id,omitempty"`
+	Type      string   `json:"type,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// topologyEdge points from a node to one it relies on or belongs to:
+// "depends_on" from a composite monitor to each child, "tagged" from a
+// monitor to each of its tags.
+type topologyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// handleTopology handles GET /api/topology.
+func (s *server) handleTopology(w http.ResponseWriter, r *http.Request) {
+	monitors, err := s.monitors.ListWorkspace(workspaceID(r.Context()))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	monitorNode := func(id int64) string { return "monitor:" + strconv.FormatInt(id, 10) }
+
+	t := topology{Nodes: []topologyNode{}, Edges: []topologyEdge{}}
+	inWorkspace := make(map[int64]bool, len(monitors))
+	for _, m := range monitors {
+		inWorkspace[m.ID] = true
+	}
+	tagState := map[string]string{}
+	var tagOrder []string
+	for _, m := range monitors {
+		n := topologyNode{ID: monitorNode(m.ID), Kind: "monitor", Name: m.Name, State: m.State, MonitorID: m.ID, Type: m.Type}
+		if m.Tags != "" {
+			n.Tags = strings.Split(m.Tags, ",")
+		}
+		t.Nodes = append(t.Nodes, n)
+
+		children, _ := monitor.ParseChildren(m.Children) // validated on save
+		for _, c := range children {
+			if inWorkspace[c.ID] {
+				t.Edges = append(t.Edges, topologyEdge{From: n.ID, To: monitorNode(c.ID), Kind: "depends_on"})
+			}
+		}
+		for _, tag := range n.Tags {
+			prev, seen := tagState[tag]
+			if !seen {
+				tagOrder = append(tagOrder, tag)
+			}
+			switch {
+			case m.State == "down" || prev == "down":
+				tagState[tag] = "down"
+			case m.State == "up" || prev == "up":
+				tagState[tag] = "up"
+			default:
+				tagState[tag] = "unknown"
+			}
+			t.Edges = append(t.Edges, topologyEdge{From: n.ID, To: "tag:" + tag, Kind: "tagged"})
+		}
+	}
+	slices.Sort(tagOrder)
+	for _, tag := range tagOrder {
+		t.Nodes = append(t.Nodes, topologyNode{ID: "tag:" + tag, Kind: "tag", Name: tag, State: tagState[tag]})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// vmFresh is how recent the last payload listing VMs must be for
+// GET /api/dashboard/vms to return them: the hypervisor's agent posts every
+// 30s, and other agents in the workspace post none.
+const vmFresh = 5 * time.Minute
+
+// vmInfo is one hypervisor guest, as sent by an agent with
+// agent.hypervisor set.
+type vmInfo struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Node       string  `json:"node,omitempty"`
+	Kind       string  `json:"kind"` // "vm" or "container"
+	State      string  `json:"state"`
+	CPUs       int     `json:"cpus"`
+	CPUPercent float64 `json:"cpu_percent"`
+	MemUsed    int64   `json:"mem_used"`
+	MemTotal   int64   `json:"mem_total"`
+}
+
+// vmMonitor is a monitor matched to a guest.
+type vmMonitor struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// dashboardVM is one entry of GET /api/dashboard/vms: a guest, and the host
+// and monitors that are the same machine by name.
+type dashboardVM struct {
+	vmInfo
+	HostID   *int64      `json:"host_id"` // the agent running inside it, if any
+	Host     string      `json:"host,omitempty"`
+	Monitors []vmMonitor `json:"monitors"`
+}
+
+// handleDashboardVMs returns the guests from the workspace's latest payload
+// that lists any, in the last vmFresh. Each is matched by its short name (the
+// first label, ignoring case) to a host posting metrics under that name, and
+// to the monitors whose target host or one of whose tags has that name.
+func (s *server) handleDashboardVMs(w http.ResponseWriter, r *http.Request) {
+	wsID := workspaceID(r.Context())
+	var raw string
+	err := s.db.QueryRowContext(r.Context(), `
+		SELECT vms_json FROM metrics
+		WHERE workspace_id = ? AND recorded_at >= ? AND vms_json != '[]'
+		ORDER BY recorded_at DESC, id DESC LIMIT 1`,
+		wsID, time.Now().UTC().Add(-vmFresh).Format("2006-01-02 15:04:05")).Scan(&raw)
+	list := []dashboardVM{}
+	if err == sql.ErrNoRows {
+		s.writeListJSON(w, r, list)
+		return
+	}
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	var vms []vmInfo
+	if err := json.Unmarshal([]byte(raw), &vms); err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	type host struct {
+		id   int64
+		name string
+	}
+	hosts := map[string]host{}
+	rows, err := s.db.QueryContext(r.Context(), `SELECT id, name FROM hosts WHERE workspace_id = ? ORDER BY name`, wsID)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var h host
+		if err := rows.Scan(&h.id, &h.name); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		if _, ok := hosts[shortName(h.name)]; !ok {
+			hosts[shortName(h.name)] = h
+		}
+	}
+	if err := rows.Err(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	monitors, err := s.monitors.ListWorkspace(wsID)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	byName := map[string][]vmMonitor{}
+	for _, m := range monitors {
+		mm := vmMonitor{ID: m.ID, Name: m.Name, State: m.State}
+		keys := []string{targetHost(m.URL)}
+		if m.Tags != "" {
+			keys = append(keys, strings.Split(m.Tags, ",")...)
+		}
+		seen := map[string]bool{}
+		for _, k := range keys {
+			if k = shortName(k); k != "" && !seen[k] {
+				seen[k] = true
+				byName[k] = append(byName[k], mm)
+			}
+		}
+	}
+
+	for _, v := range vms {
+		d := dashboardVM{vmInfo: v, Monitors: []vmMonitor{}}
+		key := shortName(v.Name)
+		if h, ok := hosts[key]; ok {
+			d.HostID, d.Host = &h.id, h.name
+		}
+		if ms := byName[key]; ms != nil {
+			d.Monitors = ms
+		}
+		list = append(list, d)
+	}
+	s.writeListJSON(w, r, list)
+}
+
+// targetHost returns the host a monitor checks: from a URL, "host:port" or
+// a bare host name.
+func targetHost(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	if h, _, err := net.SplitHostPort(raw); err == nil {
+		return h
+	}
+	return raw
+}
+
+// shortName lowercases name and keeps its first DNS label, so "Web1",
+// "web1.lan" and "web1.example.com" match. An IP address is kept whole.
+func shortName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if net.ParseIP(name) != nil {
+		return name
+	}
+	name, _, _ = strings.Cut(name, ".")
+	return name
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"health-dashboard/internal/workspace"
+)
+
+// handleWorkspaceList handles GET /api/workspaces: those the session may
+// use. Viewers get the list without keys or webhook URLs. Creating, changing
+// and deleting workspaces is for admins of every workspace.
+func (s *server) handleWorkspaceList(w http.ResponseWriter, r *http.Request) {
+	list, err := s.spaces.List()
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	g := grant(r.Context())
+	list = slices.DeleteFunc(list, func(ws *workspace.Workspace) bool { return !g.Allows(ws.ID) })
+	if list == nil {
+		list = []*workspace.Workspace{}
+	}
+	if !isAdmin(r.Context()) {
+		for i, ws := range list {
+			list[i] = ws.Redacted()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// handleWorkspaceCreate handles POST /api/workspaces.
+// Body: {"name": "Acme", "webhook_url": "https://..."}. The response carries
+// the generated api_key and agent_token for the workspace's clients; only
+// their digests are kept, so this is the one time they are shown.
+func (s *server) handleWorkspaceCreate(w http.ResponseWriter, r *http.Request) {
+	if !isInstanceAdmin(r.Context()) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, instanceAdminOnly)
+		return
+	}
+	var req struct {
+		Name       string `json:"name"`
+		WebhookURL string `json:"webhook_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
+		return
+	}
+	ws := &workspace.Workspace{
+		Name:       strings.TrimSpace(req.Name),
+		WebhookURL: strings.TrimSpace(req.WebhookURL),
+	}
+	if msg := validateWorkspace(ws); msg != "" {
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, msg)
+		return
+	}
+	if err := s.spaces.Create(ws); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ws)
+}
+
+// handleWorkspaceUpdate handles PUT /api/workspaces/{id}.
+// Only provided fields are changed; "webhook_url": "" clears the override.
+func (s *server) handleWorkspaceUpdate(w http.ResponseWriter, r *http.Request) {
+	if !isInstanceAdmin(r.Context()) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, instanceAdminOnly)
+		return
+	}
+	id, ok := parseID(w, r)
+	if !ok {
+		return
+	}
+	ws, err := s.spaces.Get(id)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if ws == nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "not found")
+		return
+	}
+
+	var req struct {
+		Name       string  `json:"name"`
+		WebhookURL *string `json:"webhook_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
+		return
+	}
+	if n := strings.TrimSpace(req.Name); n != "" {
+		ws.Name = n
+	}
+	if req.WebhookURL != nil {
+		ws.WebhookURL = strings.TrimSpace(*req.WebhookURL)
+	}
+	if msg := validateWorkspace(ws); msg != "" {
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, msg)
+		return
+	}
+	if err := s.spaces.Update(ws); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, codeNotFound, "not found")
+			return
+		}
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ws)
+}
+
+// handleWorkspaceRotateKeys handles POST /api/workspaces/{id}/rotate-keys.
+// Only digests of the keys are stored, so this is how to get a workspace's
+// keys again if they are lost: the response carries new ones, and the old
+// ones, GitHub secret included, stop working.
+func (s *server) handleWorkspaceRotateKeys(w http.ResponseWriter, r *http.Request) {
+	if !isInstanceAdmin(r.Context()) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, instanceAdminOnly)
+		return
+	}
+	id, ok := parseID(w, r)
+	if !ok {
+		return
+	}
+	ws, err := s.spaces.RotateKeys(id)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if ws == nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ws)
+}
+
+// handleWorkspaceDelete handles DELETE /api/workspaces/{id}. Monitors must be
+// deleted first; the workspace's metrics and events go with it.
+func (s *server) handleWorkspaceDelete(w http.ResponseWriter, r *http.Request) {
+	if !isInstanceAdmin(r.Context()) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, instanceAdminOnly)
+		return
+	}
+	id, ok := parseID(w, r)
+	if !ok {
+		return
+	}
+	switch err := s.spaces.Delete(id); {
+	case errors.Is(err, workspace.ErrDefault):
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
+	case errors.Is(err, workspace.ErrNotEmpty):
+		writeError(w, r, http.StatusConflict, codeConflict, err.Error())
+	case err != nil:
+		internalError(w, r, err)
+	default:
+		s.cache.invalidate(id, cacheMonitors, cacheEventSummary, cacheStatusPage)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// validateWorkspace returns an error message for an invalid workspace, or "".
+func validateWorkspace(ws *workspace.Workspace) string {
+	if ws.Name == "" {
+		return "name is required"
+	}
+	if ws.WebhookURL != "" {
+		u, err := url.Parse(ws.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "webhook_url must be an http(s) URL"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// asset is one embedded static file, held in memory with its ETag.
+type asset struct {
+	name      string // served name, used for the Content-Type
+	body      []byte
+	etag      string
+	immutable bool // fingerprinted name: its content can never change
+}
+
+// assets serves the embedded frontend. Each file is reachable under its own
+// name and under a fingerprinted one (app.js → app.1f2e3d4c5b.js); index.html
+// refers to the fingerprinted names, so browsers may cache those forever and
+// a deploy only needs index.html revalidated.
+type assets struct {
+	files map[string]*asset // keyed by path without the leading slash
+	index *asset
+}
+
+// newAssets loads fsys (the static directory) and rewrites index.html to
+// reference fingerprinted asset names.
+func newAssets(fsys fs.FS) (*assets, error) {
+	a := &assets{files: make(map[string]*asset)}
+	var rewrites []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || name == "index.html" {
+			return err
+		}
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(body)
+		hash := hex.EncodeToString(sum[:])
+		ext := path.Ext(name)
+		hashed := strings.TrimSuffix(name, ext) + "." + hash[:10] + ext
+		etag := `"` + hash[:16] + `"`
+		a.files[name] = &asset{name: name, body: body, etag: etag}
+		a.files[hashed] = &asset{name: name, body: body, etag: etag, immutable: true}
+		rewrites = append(rewrites, `"/`+name+`"`, `"/`+hashed+`"`)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := fs.ReadFile(fsys, "index.html")
+	if err != nil {
+		return nil, err
+	}
+	index = []byte(strings.NewReplacer(rewrites...).Replace(string(index)))
+	sum := sha256.Sum256(index)
+	a.index = &asset{name: "index.html", body: index, etag: `"` + hex.EncodeToString(sum[:8]) + `"`}
+	return a, nil
+}
+
+// ServeHTTP serves a static file, or index.html for extensionless paths so
+// deep links into the single-page app load it.
+func (a *assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	f, ok := a.files[name]
+	switch {
+	case ok:
+	case path.Ext(name) == "" || name == "index.html":
+		f = a.index
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if f.immutable {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("ETag", f.etag)
+	http.ServeContent(w, r, f.name, time.Time{}, bytes.NewReader(f.body))
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"health-dashboard/internal/monitor"
+)
+
+// Backup reports are accepted up to maxBackupAge after the run finished, the
+// runs' retention. A repository's latest successful backup may be
+// defaultBackupMaxAge hours old before it is stale, unless its reports set
+// max_age_hours: a daily job, with two hours' slack. Freshness is checked
+// every backupWatchEvery.
+const (
+	maxBackupAge        = 90 * 24 * time.Hour
+	defaultBackupMaxAge = 26.0
+	backupWatchEvery    = time.Minute
+)
+
+// backupReport is the body of POST /api/backups: one run of a backup job.
+type backupReport struct {
+	Repository      string     `json:"repository"`
+	Tool            string     `json:"tool"` // "restic", "borg", ...
+	Host            string     `json:"host"`
+	SnapshotID      string     `json:"snapshot_id"`
+	Success         *bool      `json:"success"`
+	SizeBytes       *int64     `json:"size_bytes"`  // of the data backed up
+	AddedBytes      *int64     `json:"added_bytes"` // new to the repository, after deduplication
+	DurationSeconds *float64   `json:"duration_seconds"`
+	Error           string     `json:"error"`
+	FinishedAt      *time.Time `json:"finished_at"`
+	MaxAgeHours     *float64   `json:"max_age_hours"`
+}
+
+// validateBackup returns an error message for an invalid report, or "".
+func validateBackup(b *backupReport) string {
+	switch {
+	case strings.TrimSpace(b.Repository) == "" || len(b.Repository) > 256:
+		return "repository is required and must be at most 256 bytes"
+	case len(b.Tool) > 32 || len(b.Host) > 256 || len(b.SnapshotID) > 128:
+		return "tool, host and snapshot_id must be at most 32, 256 and 128 bytes"
+	case b.Success == nil:
+		return "success is required"
+	case len(b.Error) > 1024:
+		return "error must be at most 1024 bytes"
+	case b.SizeBytes != nil && *b.SizeBytes < 0, b.AddedBytes != nil && *b.AddedBytes < 0,
+		b.DurationSeconds != nil && *b.DurationSeconds < 0:
+		return "size_bytes, added_bytes and duration_seconds must not be negative"
+	case b.MaxAgeHours != nil && (*b.MaxAgeHours <= 0 || *b.MaxAgeHours > maxBackupAge.Hours()):
+		return fmt.Sprintf("max_age_hours must be more than 0 and at most %.0f", maxBackupAge.Hours())
+	}
+	return ""
+}
+
+// backupRepo is a backup_repos row.
+type backupRepo struct {
+	maxAge      float64
+	lastRun     time.Time
+	lastSuccess sql.NullTime
+	failing     bool
+	stale       bool
+}
+
+// handleBackupPost handles POST /api/backups, authenticated like
+// POST /api/events. A failed run that is the repository's latest alerts
+// "backup_failed", and the first success after a failure or a stale alert
+// alerts "backup_ok". A report replayed out of order is stored and counts
+// towards freshness, but doesn't change whether the repository is failing.
+func (s *server) handleBackupPost(w http.ResponseWriter, r *http.Request) {
+	var b backupReport
+	if !decodeStrict(w, r, &b, maxEventBody) {
+		return
+	}
+	if msg := validateBackup(&b); msg != "" {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, msg)
+		return
+	}
+	now := time.Now().UTC()
+	finished := now
+	if at := b.FinishedAt; at != nil && !at.IsZero() {
+		switch {
+		case at.After(now.Add(maxClockSkew)):
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "finished_at is in the future")
+			return
+		case at.Before(now.Add(-maxBackupAge)):
+			writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField, "finished_at is older than backup retention")
+			return
+		case at.Before(now):
+			finished = at.UTC()
+		}
+	}
+	finished = finished.Truncate(time.Second)
+	wsID := workspaceID(r.Context())
+	ok := *b.Success
+	b.Error = strings.TrimSpace(b.Error)
+
+	tx, err := s.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`
+		INSERT INTO backup_runs (workspace_id, repository, tool, host, snapshot_id, success, size_bytes, added_bytes,
+			duration_seconds, error, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		wsID, b.Repository, b.Tool, b.Host, b.SnapshotID, ok, b.SizeBytes, b.AddedBytes,
+		b.DurationSeconds, b.Error, finished.Format("2006-01-02 15:04:05")); err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	prev := backupRepo{maxAge: defaultBackupMaxAge}
+	err = tx.QueryRow(`
+		SELECT max_age_hours, last_run_at, last_success_at, failing, stale FROM backup_repos
+		WHERE workspace_id = ? AND repository = ?`, wsID, b.Repository).
+		Scan(&prev.maxAge, &prev.lastRun, &prev.lastSuccess, &prev.failing, &prev.stale)
+	if err != nil && err != sql.ErrNoRows {
+		internalError(w, r, err)
+		return
+	}
+	latest := !finished.Before(prev.lastRun)
+	next := prev
+	if b.MaxAgeHours != nil {
+		next.maxAge = *b.MaxAgeHours
+	}
+	if latest {
+		next.lastRun, next.failing = finished, !ok
+	}
+	if ok && (!prev.lastSuccess.Valid || finished.After(prev.lastSuccess.Time)) {
+		next.lastSuccess = sql.NullTime{Time: finished, Valid: true}
+	}
+	if next.stale && next.lastSuccess.Valid && now.Sub(next.lastSuccess.Time).Hours() <= next.maxAge {
+		next.stale = false
+	}
+	var lastSuccess any
+	if next.lastSuccess.Valid {
+		lastSuccess = next.lastSuccess.Time.Format("2006-01-02 15:04:05")
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO backup_repos (workspace_id, repository, max_age_hours, first_seen_at, last_run_at, last_success_at, failing, stale)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (workspace_id, repository) DO UPDATE SET
+			max_age_hours = excluded.max_age_hours, last_run_at = excluded.last_run_at,
+			last_success_at = excluded.last_success_at, failing = excluded.failing, stale = excluded.stale`,
+		wsID, b.Repository, next.maxAge, now.Format("2006-01-02 15:04:05"), next.lastRun.Format("2006-01-02 15:04:05"),
+		lastSuccess, next.failing, next.stale); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	name := "backup: " + b.Repository
+	switch {
+	case latest && !ok:
+		detail := "backup failed"
+		if b.Host != "" {
+			detail += " on " + b.Host
+		}
+		if b.Error != "" {
+			detail += ": " + b.Error
+		}
+		go s.alerter.NotifyMetric(wsID, name, "backup_failed", detail)
+	case (prev.failing || prev.stale) && !next.failing && !next.stale:
+		go s.alerter.NotifyMetric(wsID, name, "backup_ok", "backup succeeded at "+next.lastSuccess.Time.Format(time.RFC3339))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runBackupWatch alerts "backup_stale" for each repository whose last
+// successful backup (or, with none, its first report) is older than its
+// max_age_hours, checking every backupWatchEvery until ctx ends. The alert
+// is recorded in the repository's row before it is sent, so every instance
+// of a cluster may run this and each repository alerts once.
+func runBackupWatch(ctx context.Context, db *sql.DB, alerter *monitor.Alerter) {
+	ticker := time.NewTicker(backupWatchEvery)
+	defer ticker.Stop()
+	for {
+		if err := checkBackups(ctx, db, alerter, time.Now().UTC()); err != nil {
+			log.Printf("backup watch: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkBackups sends the stale alerts due at now.
+func checkBackups(ctx context.Context, db *sql.DB, alerter *monitor.Alerter, now time.Time) error {
+	type due struct {
+		workspace  int64
+		repository string
+		maxAge     float64
+		since      time.Time
+		succeeded  bool
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT workspace_id, repository, max_age_hours, first_seen_at, last_success_at
+		FROM backup_repos WHERE stale = 0`)
+	if err != nil {
+		return err
+	}
+	var list []due
+	for rows.Next() {
+		var d due
+		var lastSuccess sql.NullTime
+		if err := rows.Scan(&d.workspace, &d.repository, &d.maxAge, &d.since, &lastSuccess); err != nil {
+			rows.Close()
+			return err
+		}
+		if lastSuccess.Valid {
+			d.since, d.succeeded = lastSuccess.Time, true
+		}
+		if now.Sub(d.since).Hours() > d.maxAge {
+			list = append(list, d)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, d := range list {
+		res, err := db.ExecContext(ctx, `UPDATE backup_repos SET stale = 1 WHERE workspace_id = ? AND repository = ? AND stale = 0`,
+			d.workspace, d.repository)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue // another instance got there first
+		}
+		detail := fmt.Sprintf("no successful backup in %s (max %gh)", now.Sub(d.since).Round(time.Minute), d.maxAge)
+		if d.succeeded {
+			detail = fmt.Sprintf("last successful backup %s ago at %s (max %gh)",
+				now.Sub(d.since).Round(time.Minute), d.since.Format(time.RFC3339), d.maxAge)
+		}
+		go alerter.NotifyMetric(d.workspace, "backup: "+d.repository, "backup_stale", detail)
+	}
+	return nil
+}
+
+// dashboardBackup is one repository in GET /api/dashboard/backups.
+type dashboardBackup struct {
+	Repository    string     `json:"repository"`
+	Status        string     `json:"status"` // "ok", "failed" or "stale"
+	MaxAgeHours   float64    `json:"max_age_hours"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+	LastRun       backupRun  `json:"last_run"`
+}
+
+// backupRun is a stored report.
+type backupRun struct {
+	Tool            string    `json:"tool"`
+	Host            string    `json:"host"`
+	SnapshotID      string    `json:"snapshot_id"`
+	Success         bool      `json:"success"`
+	SizeBytes       *int64    `json:"size_bytes"`
+	AddedBytes      *int64    `json:"added_bytes"`
+	DurationSeconds *float64  `json:"duration_seconds"`
+	Error           string    `json:"error,omitempty"`
+	FinishedAt      time.Time `json:"finished_at"`
+}
+
+// handleDashboardBackups returns the workspace's backup repositories with
+// their latest run, stale and failing ones first.
+func (s *server) handleDashboardBackups(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT p.repository, p.max_age_hours, p.last_success_at, p.failing, p.stale,
+			b.tool, b.host, b.snapshot_id, b.success, b.size_bytes, b.added_bytes, b.duration_seconds, b.error, b.finished_at
+		FROM backup_repos p
+		JOIN backup_runs b ON b.id = (
+			SELECT id FROM backup_runs
+			WHERE workspace_id = p.workspace_id AND repository = p.repository
+			ORDER BY finished_at DESC, id DESC LIMIT 1)
+		WHERE p.workspace_id = ?
+		ORDER BY p.stale DESC, p.failing DESC, p.repository`, workspaceID(r.Context()))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+	list := []dashboardBackup{}
+	for rows.Next() {
+		var d dashboardBackup
+		var lastSuccess sql.NullTime
+		var failing, stale bool
+		run := &d.LastRun
+		if err := rows.Scan(&d.Repository, &d.MaxAgeHours, &lastSuccess, &failing, &stale,
+			&run.Tool, &run.Host, &run.SnapshotID, &run.Success, &run.SizeBytes, &run.AddedBytes,
+			&run.DurationSeconds, &run.Error, &run.FinishedAt); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		if lastSuccess.Valid {
+			d.LastSuccessAt = &lastSuccess.Time
+		}
+		switch {
+		case stale:
+			d.Status = "stale"
+		case failing:
+			d.Status = "failed"
+		default:
+			d.Status = "ok"
+		}
+		list = append(list, d)
+	}
+	if err := rows.Err(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	s.writeListJSON(w, r, list)
+}
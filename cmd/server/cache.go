@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Kinds of readCache entries.
+const (
+	cacheMonitors     = "monitors"      // GET /api/dashboard/monitors
+	cacheEventSummary = "event_summary" // GET /api/events/summary
+	cacheStatusPage   = "status_page"   // GET /status and /api/status
+)
+
+// readCache keeps the results of the dashboard's heaviest read queries for
+// a few seconds, so a status page with many viewers, or many dashboards
+// polling, costs one aggregation per workspace per TTL rather than one per
+// request. Writes that change a result drop the workspace's entries, so
+// admins see their own edits at once; the TTL bounds how stale a result
+// can be otherwise, e.g. after a check. Values must not be modified once
+// cached.
+type readCache struct {
+	ttl     time.Duration // 0 disables the cache
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+}
+
+type cacheKey struct {
+	kind        string
+	workspaceID int64
+}
+
+type cacheEntry struct {
+	ready   chan struct{} // closed once val and err are set
+	val     any
+	err     error
+	expires time.Time
+}
+
+func newReadCache(ttl time.Duration) *readCache {
+	return &readCache{ttl: ttl, entries: map[cacheKey]*cacheEntry{}}
+}
+
+// get returns the cached value of kind for workspaceID, calling load when
+// there is none or it has expired. Concurrent callers for the same key
+// share one load. Errors aren't cached.
+func (c *readCache) get(kind string, workspaceID int64, load func() (any, error)) (any, error) {
+	if c.ttl <= 0 {
+		return load()
+	}
+	key := cacheKey{kind, workspaceID}
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.mu.Unlock()
+		<-e.ready
+		if e.err == nil && time.Now().Before(e.expires) {
+			return e.val, nil
+		}
+		c.mu.Lock()
+		if c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		if e2, ok := c.entries[key]; ok {
+			// Another caller is already reloading it.
+			c.mu.Unlock()
+			<-e2.ready
+			return e2.val, e2.err
+		}
+	}
+	e := &cacheEntry{ready: make(chan struct{})}
+	c.entries[key] = e
+	c.mu.Unlock()
+
+	e.val, e.err = load()
+	e.expires = time.Now().Add(c.ttl)
+	close(e.ready)
+	if e.err != nil {
+		c.mu.Lock()
+		if c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+	}
+	return e.val, e.err
+}
+
+// invalidate drops workspaceID's entries of the given kinds, after a write
+// that may change them.
+func (c *readCache) invalidate(workspaceID int64, kinds ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, kind := range kinds {
+		delete(c.entries, cacheKey{kind, workspaceID})
+	}
+}
+
+// invalidates wraps a write handler so that, once it succeeds, the request
+// workspace's cached results of the given kinds are dropped.
+func (s *server) invalidates(next http.HandlerFunc, kinds ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		next(sw, r)
+		if sw.status < 400 {
+			s.cache.invalidate(workspaceID(r.Context()), kinds...)
+		}
+	}
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
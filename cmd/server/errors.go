@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// Machine-readable error codes returned in API error responses.
+const (
+	codeBadRequest   = "bad_request"
+	codeInvalidJSON  = "invalid_json"
+	codeInvalidField = "invalid_field"
+	codeUnauthorized = "unauthorized"
+	codeForbidden    = "forbidden"
+	codeNotFound     = "not_found"
+	codeConflict     = "conflict"
+	codeTooLarge     = "payload_too_large"
+	codeReadOnly     = "read_only"
+	codeBusy         = "busy"
+	codeInternal     = "internal_error"
+)
+
+// errorBody is the envelope for every API error:
+// {"error": {"code": "...", "message": "...", "request_id": "..."}}.
+type errorBody struct {
+	Error struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"request_id,omitempty"`
+	} `json:"error"`
+}
+
+// writeError writes a JSON error envelope with the given status.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	var body errorBody
+	body.Error.Code = code
+	body.Error.Message = msg
+	body.Error.RequestID = requestID(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// internalError logs err against the request ID and writes a generic 500,
+// so driver messages never reach clients.
+func internalError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("request %s %s %s: %v", requestID(r.Context()), r.Method, r.URL.Path, err)
+	writeError(w, r, http.StatusInternalServerError, codeInternal, "internal error")
+}
+
+type requestIDKey struct{}
+
+// validRequestID bounds what a client may pass in X-Request-ID.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestID tags each request with an ID, taken from an incoming
+// X-Request-ID header (e.g. set by a reverse proxy) or generated, and echoes
+// it in the response header.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
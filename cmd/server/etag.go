@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxVersions caps the number of responses versionLog remembers; past it
+// the log starts over, which only costs a few full responses.
+const maxVersions = 4096
+
+// versionLog remembers, for each list response (path, query, workspace and
+// role), the ETag it last had and when that version first appeared: its
+// Last-Modified, since the tables behind most lists have no single
+// modification time of their own.
+type versionLog struct {
+	mu   sync.Mutex
+	seen map[string]listVersion
+}
+
+type listVersion struct {
+	etag  string
+	since time.Time
+}
+
+func newVersionLog() *versionLog {
+	return &versionLog{seen: map[string]listVersion{}}
+}
+
+// observe records etag as key's current version and returns when it
+// appeared.
+func (l *versionLog) observe(key, etag string) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	prev, ok := l.seen[key]
+	if ok && prev.etag == etag {
+		return prev.since
+	}
+	if len(l.seen) >= maxVersions {
+		clear(l.seen)
+	}
+	// HTTP dates have whole seconds, so a second change within one gets
+	// the next, or If-Modified-Since would miss it.
+	v := listVersion{etag: etag, since: time.Now().UTC().Truncate(time.Second)}
+	if ok && !v.since.After(prev.since) {
+		v.since = prev.since.Add(time.Second)
+	}
+	l.seen[key] = v
+	return v.since
+}
+
+// writeListJSON writes v as JSON with a weak ETag, a hash of the body, and
+// a Last-Modified, answering 304 with no body when the request's
+// If-None-Match (or, without one, If-Modified-Since) shows the client
+// already has this version. Frontends polling every few seconds then get
+// an empty response while nothing has changed.
+func (s *server) writeListJSON(w http.ResponseWriter, r *http.Request, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	body = append(body, '\n')
+	sum := sha256.Sum256(body)
+	etag := `W/"` + base64.RawURLEncoding.EncodeToString(sum[:12]) + `"`
+	role := "viewer"
+	if isAdmin(r.Context()) {
+		role = "admin"
+	}
+	key := r.URL.Path + "?" + r.URL.RawQuery + "#" + role + "@" + strconv.FormatInt(workspaceID(r.Context()), 10)
+	modified := s.versions.observe(key, etag)
+
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", modified.Format(http.TimeFormat))
+	// Session-specific, and worth revalidating every time.
+	h.Set("Cache-Control", "private, no-cache")
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// notModified reports whether a conditional GET already has the version
+// etag, last modified at modified. If-None-Match takes precedence over
+// If-Modified-Since, as RFC 9110 says.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			// Weak comparison: W/ prefixes don't matter.
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.After(ims)
+}
@@ -1,28 +1,50 @@
 package main
 
 import (
+	"encoding/json"
 	"html/template"
-	"io/fs"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"health-dashboard/internal/auth"
 )
 
 // handleHealth returns a simple JSON status — used by load balancers / Docker HEALTHCHECK.
+// In cluster or read-only mode it also reports this node's role. With
+// ?deep=1 it checks the instance's dependencies instead; see deepHealth.
 func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"status":"ok"}`))
+	deep, _ := strconv.ParseBool(r.URL.Query().Get("deep"))
+	switch {
+	case s.draining.Load():
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"draining"}`))
+	case deep:
+		h := s.deepHealth(r.Context())
+		if h.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(h)
+	case s.cfg.Replication.ReadOnly:
+		w.Write([]byte(`{"status":"ok","role":"replica"}`))
+	case s.elector != nil:
+		role := "standby"
+		if s.elector.IsLeader() {
+			role = "leader"
+		}
+		json.NewEncoder(w).Encode(map[string]any{"status": "ok", "node_id": s.cfg.Cluster.NodeID, "role": role})
+	case s.members != nil:
+		json.NewEncoder(w).Encode(map[string]any{"status": "ok", "node_id": s.cfg.Cluster.NodeID, "role": "shard", "members": s.members.Members()})
+	default:
+		w.Write([]byte(`{"status":"ok"}`))
+	}
 }
 
-// handleDashboard serves the embedded index.html (placeholder for Task 5 frontend).
+// handleDashboard serves the embedded frontend; see assets.
 func (s *server) handleDashboard(w http.ResponseWriter, r *http.Request) {
-	sub, err := fs.Sub(staticFS, "static")
-	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
-		return
-	}
-	http.FileServer(http.FS(sub)).ServeHTTP(w, r)
+	s.assets.ServeHTTP(w, r)
 }
 
 var loginTmpl = template.Must(template.New("login").Parse(`<!DOCTYPE html>
@@ -52,7 +74,7 @@ var loginTmpl = template.Must(template.New("login").Parse(`<!DOCTYPE html>
     }
     h1 { font-size: 1.25rem; margin-bottom: 1.5rem; color: #f8fafc; }
     label { display: block; font-size: 0.85rem; color: #94a3b8; margin-bottom: 0.35rem; }
-    input[type=password] {
+    input[type=text], input[type=password] {
       width: 100%;
       padding: 0.6rem 0.75rem;
       background: #0f1117;
@@ -62,7 +84,7 @@ var loginTmpl = template.Must(template.New("login").Parse(`<!DOCTYPE html>
       font-size: 1rem;
       margin-bottom: 1rem;
     }
-    input[type=password]:focus { outline: none; border-color: #6366f1; }
+    input[type=text]:focus, input[type=password]:focus { outline: none; border-color: #6366f1; }
     button {
       width: 100%;
       padding: 0.65rem;
@@ -82,8 +104,10 @@ var loginTmpl = template.Must(template.New("login").Parse(`<!DOCTYPE html>
     <h1>Health Dashboard</h1>
     {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
     <form method="POST" action="/login">
+      {{if .Users}}<label for="username">Username (leave empty for the shared password)</label>
+      <input type="text" id="username" name="username" autofocus autocomplete="username">{{end}}
       <label for="password">Password</label>
-      <input type="password" id="password" name="password" autofocus autocomplete="current-password">
+      <input type="password" id="password" name="password" {{if not .Users}}autofocus {{end}}autocomplete="current-password">
       <button type="submit">Sign in</button>
     </form>
   </div>
@@ -96,7 +120,7 @@ func (s *server) handleLoginPage(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
-	loginTmpl.Execute(w, nil)
+	loginTmpl.Execute(w, map[string]any{"Users": len(s.cfg.Auth.Users) > 0})
 }
 
 func (s *server) handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -104,27 +128,66 @@ func (s *server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	password := r.FormValue("password")
-
-	if !auth.CheckPassword(s.cfg.Auth.Password, password) {
+	grant, ok := s.authenticate(strings.TrimSpace(r.FormValue("username")), r.FormValue("password"))
+	if !ok {
+		s.recordLogin(r, false, "")
 		w.WriteHeader(http.StatusUnauthorized)
-		loginTmpl.Execute(w, map[string]string{"Error": "Invalid password."})
+		msg := "Invalid password."
+		if len(s.cfg.Auth.Users) > 0 {
+			msg = "Invalid username or password."
+		}
+		loginTmpl.Execute(w, map[string]any{"Error": msg, "Users": len(s.cfg.Auth.Users) > 0})
 		return
 	}
 
-	token, err := s.sessions.Create()
+	token, err := s.sessions.Create(grant, r)
 	if err != nil {
 		log.Printf("session create: %v", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	s.recordLogin(r, true, grant.Role)
 	auth.SetSessionCookie(w, token)
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
+// authenticate checks a login. Without a username, password is matched
+// against auth.password, for an admin of every workspace, and then
+// auth.viewer_password; with one, against that entry of auth.users.
+func (s *server) authenticate(username, password string) (auth.Grant, bool) {
+	if username == "" {
+		switch {
+		case auth.CheckPassword(s.cfg.Auth.Password, password):
+			return auth.Grant{Role: auth.RoleAdmin}, true
+		case s.cfg.Auth.ViewerPassword != "" && auth.CheckPassword(s.cfg.Auth.ViewerPassword, password):
+			return auth.Grant{Role: auth.RoleViewer, Workspaces: s.cfg.Auth.ViewerWorkspaces}, true
+		}
+		return auth.Grant{}, false
+	}
+	for _, u := range s.cfg.Auth.Users {
+		if u.Name == username {
+			if !auth.CheckPassword(u.Password, password) {
+				return auth.Grant{}, false
+			}
+			return auth.Grant{User: u.Name, Role: auth.Role(u.Role), Workspaces: u.Workspaces}, true
+		}
+	}
+	return auth.Grant{}, false
+}
+
+// recordLogin adds a login attempt to the audit log. Failures to record are
+// logged but never block the login itself (e.g. on a read-only standby).
+func (s *server) recordLogin(r *http.Request, success bool, role auth.Role) {
+	if err := s.logins.Record(success, string(role), auth.ClientIP(r), r.UserAgent()); err != nil {
+		log.Printf("login audit: %v", err)
+	}
+}
+
 func (s *server) handleLogout(w http.ResponseWriter, r *http.Request) {
 	token := auth.GetSessionToken(r)
-	s.sessions.Delete(token)
+	if err := s.sessions.Delete(token); err != nil {
+		log.Printf("session delete: %v", err)
+	}
 	auth.ClearSessionCookie(w)
 	http.Redirect(w, r, "/login", http.StatusFound)
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"health-dashboard/internal/monitor"
+)
+
+// healthReport is the body of GET /health?deep=1. Status is "degraded",
+// and the response 503, when any of Problems holds.
+type healthReport struct {
+	Status   string   `json:"status"`
+	Problems []string `json:"problems"`
+
+	Database struct {
+		Writable  bool   `json:"writable"`
+		ReadOnly  bool   `json:"read_only,omitempty"` // a replica, which isn't meant to take writes
+		LatencyMs int64  `json:"latency_ms"`
+		Error     string `json:"error,omitempty"`
+	} `json:"database"`
+
+	// Checker is this instance's; Expected is false on a replica or a
+	// cluster standby, whose checker is stopped by design.
+	Checker struct {
+		Expected bool `json:"expected"`
+		monitor.CheckerHealth
+	} `json:"checker"`
+
+	// Queues besides the checker's probes_waiting.
+	Queues struct {
+		IngestInFlight    int  `json:"ingest_in_flight"`
+		IngestMax         int  `json:"ingest_max"`
+		MetricsPending    *int `json:"metrics_pending"` // nil without ingest.flush_seconds
+		MetricsMaxPending *int `json:"metrics_max_pending"`
+	} `json:"queues"`
+}
+
+// deepHealth checks what a wedged instance would get wrong: that the
+// database takes writes, that the checker is running and its probes and
+// pruning are making progress, and that the metrics buffer isn't full.
+func (s *server) deepHealth(ctx context.Context) *healthReport {
+	h := &healthReport{Status: "ok", Problems: []string{}}
+
+	start := time.Now()
+	if s.cfg.Replication.ReadOnly {
+		h.Database.ReadOnly = true
+		if err := s.db.PingContext(ctx); err != nil {
+			h.Database.Error = err.Error()
+			h.Problems = append(h.Problems, "database: "+err.Error())
+		}
+	} else if err := s.writeHealthRow(ctx); err != nil {
+		h.Database.Error = err.Error()
+		h.Problems = append(h.Problems, "database not writable: "+err.Error())
+	} else {
+		h.Database.Writable = true
+	}
+	h.Database.LatencyMs = time.Since(start).Milliseconds()
+
+	h.Checker.Expected = !s.cfg.Replication.ReadOnly && (s.elector == nil || s.elector.IsLeader())
+	h.Checker.CheckerHealth = s.checker.Health()
+	if h.Checker.Expected && !h.Checker.Running {
+		h.Problems = append(h.Problems, "checker is not running")
+	}
+	if why := h.Checker.Stalled(time.Now()); why != "" {
+		h.Problems = append(h.Problems, "checker stalled: "+why)
+	}
+
+	h.Queues.IngestInFlight, h.Queues.IngestMax = len(s.ingest), cap(s.ingest)
+	if s.metricBuf != nil {
+		pending, maxPending := s.metricBuf.depth()
+		h.Queues.MetricsPending, h.Queues.MetricsMaxPending = &pending, &maxPending
+		if pending >= maxPending {
+			h.Problems = append(h.Problems, fmt.Sprintf("metrics buffer full (%d payloads); flushes are failing", pending))
+		}
+	}
+
+	if len(h.Problems) > 0 {
+		h.Status = "degraded"
+	}
+	return h
+}
+
+// writeHealthRow rewrites this instance's health_writes row. A database
+// locked for longer than its busy timeout (5s) counts as not writable.
+func (s *server) writeHealthRow(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO health_writes (node_id, written_at) VALUES (?, ?)
+		ON CONFLICT(node_id) DO UPDATE SET written_at = excluded.written_at`,
+		s.cfg.Cluster.NodeID, time.Now().UTC())
+	return err
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"health-dashboard/internal/auth"
+)
+
+// maxHostName bounds the X-Agent-Host header; longer names are cut.
+const maxHostName = 253
+
+// hostTracker keeps per-agent ingestion statistics for
+// GET /api/hosts/{id}/ingestion, so an agent whose payloads are being
+// refused can be diagnosed from the server. Agents are told apart by their
+// X-Agent-Host header, or their address if they don't send one, and get a
+// row in the hosts table the first time they post. The counters are kept in
+// memory and start over when the server restarts.
+type hostTracker struct {
+	db      *sql.DB
+	started time.Time
+
+	mu     sync.Mutex
+	ids    map[hostKey]int64
+	stats  map[int64]*hostStats
+	peers  map[int64]peerSeen
+	agents map[int64]agentMeta // as last written
+}
+
+type hostKey struct {
+	workspace int64
+	name      string
+}
+
+// hostStats is one agent's ingestion statistics since Since, the server's
+// start.
+type hostStats struct {
+	HostID           int64      `json:"host_id"`
+	Name             string     `json:"name"`
+	Since            time.Time  `json:"since"`
+	LastSeen         *time.Time `json:"last_seen"`
+	Samples          int64      `json:"samples"` // payloads accepted
+	Errors           int64      `json:"errors"`  // payloads refused, or that failed to store
+	LastStatus       int        `json:"last_status,omitempty"`
+	LastError        string     `json:"last_error,omitempty"`
+	LastErrorAt      *time.Time `json:"last_error_at,omitempty"`
+	LastPayloadBytes int64      `json:"last_payload_bytes"` // as sent, so compressed if gzipped
+	// ClockOffsetMs is the agent's clock minus the server's when the last
+	// payload arrived, from its X-Agent-Time header; nil without one.
+	ClockOffsetMs *int64 `json:"clock_offset_ms"`
+}
+
+func newHostTracker(db *sql.DB) *hostTracker {
+	return &hostTracker{db: db, started: time.Now().UTC().Truncate(time.Second), ids: map[hostKey]int64{}, stats: map[int64]*hostStats{},
+		peers: map[int64]peerSeen{}, agents: map[int64]agentMeta{}}
+}
+
+// ingestWriter captures the status of a POST /api/metrics response, and
+// the start of its body when it is an error.
+type ingestWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *ingestWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *ingestWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 && w.body.Len() < 1024 {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// agentHost returns the name an agent's request comes from: its
+// X-Agent-Host header, or its address without one.
+func agentHost(r *http.Request) string {
+	name := strings.ToValidUTF8(strings.TrimSpace(r.Header.Get("X-Agent-Host")), "")
+	if len(name) > maxHostName {
+		name = name[:maxHostName]
+	}
+	if name == "" {
+		name = auth.ClientIP(r)
+	}
+	return name
+}
+
+// track starts counting an authenticated POST /api/metrics for
+// workspaceID. It returns the writer the handler should respond through,
+// and a function to call once it has, which records the outcome.
+func (t *hostTracker) track(w http.ResponseWriter, r *http.Request, workspaceID int64) (http.ResponseWriter, func()) {
+	received := time.Now().UTC()
+	name := agentHost(r)
+	var offset *int64
+	if at, err := time.Parse(time.RFC3339Nano, r.Header.Get("X-Agent-Time")); err == nil {
+		ms := at.Sub(received).Milliseconds()
+		offset = &ms
+	}
+	body := &countingBody{ReadCloser: r.Body}
+	r.Body = body
+	iw := &ingestWriter{ResponseWriter: w}
+	return iw, func() {
+		var msg string
+		if iw.status >= 400 {
+			var e errorBody
+			if json.Unmarshal(iw.body.Bytes(), &e) == nil {
+				msg = e.Error.Message
+			}
+		}
+		if err := t.record(workspaceID, name, received.Truncate(time.Second), iw.status, msg, body.n, offset); err != nil {
+			log.Printf("request %s: host stats: %v", requestID(r.Context()), err)
+		}
+	}
+}
+
+// record counts one payload from the named agent.
+func (t *hostTracker) record(workspaceID int64, name string, at time.Time, status int, msg string, size int64, offset *int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id, err := t.hostID(workspaceID, name)
+	if err != nil {
+		return err
+	}
+	st := t.stats[id]
+	if st == nil {
+		st = &hostStats{HostID: id, Name: name, Since: t.started}
+		t.stats[id] = st
+	}
+	st.LastSeen = &at
+	st.LastStatus = status
+	st.LastPayloadBytes = size
+	if offset != nil {
+		st.ClockOffsetMs = offset
+	}
+	if status == http.StatusNoContent {
+		st.Samples++
+	} else {
+		st.Errors++
+		st.LastError = "HTTP " + strconv.Itoa(status)
+		if msg != "" {
+			st.LastError += ": " + msg
+		}
+		st.LastErrorAt = &at
+	}
+	return nil
+}
+
+// hostID returns the named agent's row in the hosts table, adding it the
+// first time. t.mu must be held.
+func (t *hostTracker) hostID(workspaceID int64, name string) (int64, error) {
+	key := hostKey{workspaceID, name}
+	if id, ok := t.ids[key]; ok {
+		return id, nil
+	}
+	if _, err := t.db.Exec(`INSERT OR IGNORE INTO hosts (workspace_id, name) VALUES (?, ?)`, workspaceID, name); err != nil {
+		return 0, err
+	}
+	var id int64
+	if err := t.db.QueryRow(`SELECT id FROM hosts WHERE workspace_id = ? AND name = ?`, workspaceID, name).Scan(&id); err != nil {
+		return 0, err
+	}
+	t.ids[key] = id
+	return id, nil
+}
+
+// agentMeta is the agent field of a metrics payload: the agent's release
+// and the platform it runs on.
+type agentMeta struct {
+	Version  string `json:"version"`
+	OS       string `json:"os"`
+	Platform string `json:"platform"`
+	Kernel   string `json:"kernel"`
+	Arch     string `json:"arch"`
+}
+
+// recordAgent stores the named agent's release and platform when they
+// differ from what was last stored, so an upgrade shows on the next payload.
+func (t *hostTracker) recordAgent(workspaceID int64, name string, a agentMeta) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id, err := t.hostID(workspaceID, name)
+	if err != nil {
+		return err
+	}
+	if prev, ok := t.agents[id]; ok && prev == a {
+		return nil
+	}
+	if _, err := t.db.Exec(`
+		UPDATE hosts SET agent_version = ?, agent_os = ?, agent_platform = ?, agent_kernel = ?, agent_arch = ?
+		WHERE id = ?`, a.Version, a.OS, a.Platform, a.Kernel, a.Arch, id); err != nil {
+		return err
+	}
+	t.agents[id] = a
+	return nil
+}
+
+// olderVersion reports whether release a ("v1.2.3" or "1.2.3", with any
+// suffix after a '-' or '+' ignored) comes before b. Versions that don't
+// parse, such as "dev" builds, are never older.
+func olderVersion(a, b string) bool {
+	parse := func(v string) ([3]int, bool) {
+		var n [3]int
+		v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+		v, _, _ = strings.Cut(v, "+")
+		parts := strings.Split(v, ".")
+		if len(parts) > 3 {
+			return n, false
+		}
+		for i, p := range parts {
+			x, err := strconv.Atoi(p)
+			if err != nil || x < 0 {
+				return n, false
+			}
+			n[i] = x
+		}
+		return n, true
+	}
+	va, okA := parse(a)
+	vb, okB := parse(b)
+	if !okA || !okB {
+		return false
+	}
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] < vb[i]
+		}
+	}
+	return false
+}
+
+// get returns host id's statistics, or ones with no payloads counted if it
+// hasn't posted since the server started.
+func (t *hostTracker) get(id int64, name string) hostStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if st := t.stats[id]; st != nil {
+		return *st
+	}
+	return hostStats{HostID: id, Name: name, Since: t.started}
+}
+
+// hostInfo is one entry of GET /api/hosts.
+type hostInfo struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"created_at"` // first payload ever
+	LastSeen  *time.Time `json:"last_seen"`  // since the server started
+	Agent     *agentMeta `json:"agent"`      // nil until an agent reporting it posts
+	// Outdated is set when the agent's release is older than the server's.
+	Outdated bool `json:"outdated"`
+}
+
+// handleHostList handles GET /api/hosts: the agents that have posted
+// metrics to the workspace.
+func (s *server) handleHostList(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT id, name, created_at, agent_version, agent_os, agent_platform, agent_kernel, agent_arch
+		FROM hosts WHERE workspace_id = ? ORDER BY name`, workspaceID(r.Context()))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+	hosts := []hostInfo{}
+	for rows.Next() {
+		var h hostInfo
+		var a agentMeta
+		if err := rows.Scan(&h.ID, &h.Name, &h.CreatedAt, &a.Version, &a.OS, &a.Platform, &a.Kernel, &a.Arch); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		if a.Version != "" {
+			h.Agent, h.Outdated = &a, olderVersion(a.Version, version)
+		}
+		h.LastSeen = s.hosts.get(h.ID, h.Name).LastSeen
+		hosts = append(hosts, h)
+	}
+	if err := rows.Err(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	s.writeListJSON(w, r, hosts)
+}
+
+// handleHostIngestion handles GET /api/hosts/{id}/ingestion.
+func (s *server) handleHostIngestion(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseID(w, r)
+	if !ok {
+		return
+	}
+	var name string
+	err := s.db.QueryRowContext(r.Context(), `SELECT name FROM hosts WHERE id = ? AND workspace_id = ?`,
+		id, workspaceID(r.Context())).Scan(&name)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "host not found")
+		return
+	}
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.hosts.get(id, name))
+}
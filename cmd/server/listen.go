@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listen returns the listener to serve on. Under systemd socket
+// activation (LISTEN_PID and LISTEN_FDS) that is the socket systemd
+// passed, which it keeps open, queuing connections, while the server
+// restarts. Otherwise it is a new socket on addr; with reusePort it sets
+// SO_REUSEPORT, so a new server can bind the same port while the old one
+// drains. The second result describes where the socket came from, for the
+// log.
+func listen(addr string, reusePort bool) (net.Listener, string, error) {
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == os.Getpid() {
+		n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		// Later processes, such as restore or checkpoint commands, must not
+		// think the sockets are theirs.
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		if n < 1 {
+			return nil, "", fmt.Errorf("socket activation passed no sockets")
+		}
+		// The first passed descriptor is always 3; extra ones are ignored.
+		f := os.NewFile(3, "systemd socket")
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, "", fmt.Errorf("systemd socket: %w", err)
+		}
+		return ln, " (systemd socket)", nil
+	}
+	var lc net.ListenConfig
+	if reusePort {
+		lc.Control = reusePortControl
+	}
+	ln, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, "", err
+	}
+	if reusePort {
+		return ln, " (SO_REUSEPORT)", nil
+	}
+	return ln, "", nil
+}
@@ -8,21 +8,39 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
 
+	"health-dashboard/internal/audit"
 	"health-dashboard/internal/auth"
+	"health-dashboard/internal/cluster"
 	"health-dashboard/internal/config"
 	"health-dashboard/internal/db"
 	"health-dashboard/internal/monitor"
+	"health-dashboard/internal/secrets"
+	"health-dashboard/internal/statuspage"
+	"health-dashboard/internal/workspace"
 )
 
+// version is the server's release, set at build time with
+// -ldflags "-X main.version=v1.4.0". Agents of an older release are shown
+// as outdated.
+var version = "dev"
+
 func main() {
 	configPath := flag.String("config", "config.yaml", "path to config file")
 	hashPw := flag.String("hash-password", "", "hash a plaintext password and print the result, then exit")
+	hashToken := flag.String("hash-token", "", "print the digest of an agent token or API key for config.yaml, then exit")
+	showVersion := flag.Bool("version", false, "print the server's version, then exit")
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Println("health-dashboard", version)
+		return
+	}
+
 	if *hashPw != "" {
 		h, err := auth.HashPassword(*hashPw)
 		if err != nil {
@@ -32,6 +50,11 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *hashToken != "" {
+		fmt.Println(auth.HashToken(*hashToken))
+		os.Exit(0)
+	}
+
 	cfg, err := config.Load(*configPath)
 	if err != nil {
 		log.Fatalf("config: %v", err)
@@ -40,12 +63,45 @@ func main() {
 	if !strings.HasPrefix(cfg.Auth.Password, "$2a$") && !strings.HasPrefix(cfg.Auth.Password, "$2b$") {
 		log.Println("WARNING: password in config.yaml is stored as plaintext — use --hash-password to generate a bcrypt hash")
 	}
+	for i, u := range cfg.Auth.Users {
+		switch {
+		case u.Name == "" || u.Password == "":
+			log.Fatalf("config: auth.users[%d] needs a name and password", i)
+		case slices.ContainsFunc(cfg.Auth.Users[:i], func(o config.UserConfig) bool { return o.Name == u.Name }):
+			log.Fatalf("config: auth.users[%d]: duplicate name %q", i, u.Name)
+		case u.Role != string(auth.RoleAdmin) && u.Role != string(auth.RoleViewer):
+			log.Fatalf("config: auth.users[%d]: role must be admin or viewer, got %q", i, u.Role)
+		case len(u.Workspaces) == 0 || slices.ContainsFunc(u.Workspaces, func(id int64) bool { return id <= 0 }):
+			log.Fatalf("config: auth.users[%d] needs a list of workspace IDs", i)
+		}
+		if !strings.HasPrefix(u.Password, "$2a$") && !strings.HasPrefix(u.Password, "$2b$") {
+			log.Printf("WARNING: password of auth.users %q is stored as plaintext — use --hash-password to generate a bcrypt hash", u.Name)
+		}
+	}
+	if slices.ContainsFunc(cfg.Auth.ViewerWorkspaces, func(id int64) bool { return id <= 0 }) {
+		log.Fatalf("config: auth.viewer_workspaces must list workspace IDs")
+	}
+	// agent.token and events.api_key are often also what this host's agent
+	// sends, so only the rotation lists are expected to hold digests.
+	for _, tokens := range [][]string{cfg.Agent.Tokens, cfg.Events.APIKeys} {
+		if slices.ContainsFunc(tokens, func(t string) bool { return !auth.IsHashedToken(t) }) {
+			log.Println("WARNING: agent.tokens or events.api_keys in config.yaml hold plaintext values — use --hash-token to generate their digests")
+			break
+		}
+	}
 
 	if err := os.MkdirAll(cfg.Server.DataDir, 0o755); err != nil {
 		log.Fatalf("data dir: %v", err)
 	}
 
-	database, err := db.Open(cfg.Server.DataDir)
+	if cfg.Replication.ReadOnly && cfg.Cluster.Enabled {
+		log.Fatalf("config: replication.read_only and cluster.enabled are mutually exclusive")
+	}
+	database, err := db.Open(cfg.Server.DataDir, db.Options{
+		RestoreCommand:      cfg.Replication.RestoreCommand,
+		ExternalCheckpoints: cfg.Replication.ExternalCheckpoints,
+		ReadOnly:            cfg.Replication.ReadOnly,
+	})
 	if err != nil {
 		log.Fatalf("database: %v", err)
 	}
@@ -55,44 +111,286 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	secretsKey, err := cfg.Secrets.LoadKey()
+	if err != nil {
+		log.Fatalf("secrets: %v", err)
+	}
+	box, err := secrets.New(secretsKey, cfg.Secrets.OldKeys)
+	if err != nil {
+		log.Fatalf("secrets: %v", err)
+	}
 	monitorStore := monitor.NewStore(database)
+	monitorStore.SetSecrets(box)
+	workspaces := workspace.NewStore(database)
+	workspaces.SetSecrets(box)
+	if !cfg.Replication.ReadOnly {
+		// After a key is set, rotated or removed, bring every stored
+		// credential in line with it.
+		for _, reseal := range []func() (int, error){monitorStore.ResealSecrets, workspaces.ResealSecrets} {
+			n, err := reseal()
+			if err != nil {
+				log.Fatalf("secrets: %v", err)
+			}
+			if n > 0 && box.Enabled() {
+				log.Printf("secrets: encrypted %d rows with key %s", n, box.KeyID())
+			} else if n > 0 {
+				log.Printf("secrets: decrypted %d rows; they are now stored in plaintext", n)
+			}
+		}
+		hashed, err := workspaces.HashKeys()
+		if err != nil {
+			log.Fatalf("hash workspace keys: %v", err)
+		}
+		for _, id := range hashed {
+			log.Printf("workspace %d: keys are now stored hashed; GitHub webhooks must be re-signed with its new github_secret", id)
+		}
+	}
+	if a := cfg.Alerts; a.TemperatureClear != nil && *a.TemperatureClear > a.TemperatureThreshold {
+		log.Fatalf("config: alerts.temperature_clear must not be above temperature_threshold")
+	}
+	if a := cfg.Alerts; a.TemperatureForSeconds < 0 || a.TemperatureForSeconds > 24*3600 {
+		log.Fatalf("config: alerts.temperature_for_seconds must be between 0 and 86400")
+	}
 	alerter := monitor.NewAlerter(cfg.Alerts.WebhookURL)
-	checker := monitor.NewChecker(monitorStore, alerter)
-	if err := checker.Start(ctx); err != nil {
-		log.Fatalf("checker start: %v", err)
+	alerter.SetWorkspaceWebhooks(workspaces.WebhookURL)
+	alerter.SetAlertContext(alertContext(database))
+	runbooks := make([]monitor.RunbookTemplate, len(cfg.Alerts.Runbooks))
+	for i, rb := range cfg.Alerts.Runbooks {
+		if err := monitor.ValidateRunbookURL(rb.URL); err != nil {
+			log.Fatalf("config: alerts.runbooks[%d]: url %v", i, err)
+		}
+		runbooks[i] = monitor.RunbookTemplate{Tag: rb.Tag, URL: rb.URL}
+	}
+	alerter.SetRunbooks(runbooks)
+	if wt := cfg.Alerts.WebhookTemplate; wt.Body != "" {
+		t, err := monitor.ParseWebhookTemplate(wt.Body, wt.ContentType, wt.Headers)
+		if err != nil {
+			log.Fatalf("config: alerts.webhook_template: %v", err)
+		}
+		alerter.SetTemplate(t)
+	}
+	var trackers []monitor.IssueTracker
+	if j := cfg.Alerts.Issues.Jira; j.Project != "" {
+		if j.URL == "" || j.Email == "" || j.APIToken == "" {
+			log.Fatalf("config: alerts.issues.jira needs url, email and api_token")
+		}
+		trackers = append(trackers, &monitor.JiraTracker{
+			URL: j.URL, Email: j.Email, APIToken: j.APIToken, Project: j.Project,
+			IssueType: j.IssueType, DoneTransition: j.DoneTransition, Labels: j.Labels, Tag: j.Tag,
+			Workspaces: j.Workspaces,
+		})
+	}
+	if g := cfg.Alerts.Issues.GitHub; g.Repo != "" {
+		if g.Token == "" || strings.Count(g.Repo, "/") != 1 {
+			log.Fatalf("config: alerts.issues.github needs a token and repo as owner/name")
+		}
+		trackers = append(trackers, &monitor.GitHubTracker{
+			APIURL: g.APIURL, Repo: g.Repo, Token: g.Token, Labels: g.Labels, Tag: g.Tag,
+			Workspaces: g.Workspaces,
+		})
+	}
+	alerter.SetIssueTrackers(monitorStore, trackers)
+	checker := monitor.NewChecker(monitorStore, alerter, cfg.Checker.MaxConcurrent)
+	cache := newReadCache(time.Duration(cfg.Server.CacheSeconds) * time.Second)
+	checker.SetOnStateChange(func(m *monitor.Monitor) {
+		cache.invalidate(m.WorkspaceID, cacheMonitors, cacheStatusPage)
+	})
+	policy := monitor.AddrPolicy{
+		BlockLinkLocal: cfg.Checker.BlockLinkLocal,
+		BlockPrivate:   cfg.Checker.BlockPrivate,
+	}
+	checker.SetAddrPolicy(policy)
+	checker.SetRecheckOnStart(cfg.Checker.RecheckOnStart)
+	checker.SetSampling(monitor.SamplingPolicy{
+		BelowSeconds: cfg.Checker.SampleBelowSeconds,
+		KeepEvery:    cfg.Checker.SampleKeepEvery,
+	})
+	if cfg.Checker.DNSCache {
+		checker.SetDNSCache(monitor.NewDNSCache(
+			time.Duration(cfg.Checker.DNSMinTTL)*time.Second,
+			time.Duration(cfg.Checker.DNSMaxTTL)*time.Second,
+			time.Duration(cfg.Checker.DNSNegativeTTL)*time.Second))
 	}
 
-	sessions := auth.NewStore()
+	var elector *cluster.Elector
+	var members *cluster.Membership
+	var clusterDone chan struct{} // closed once the checker has stopped in cluster mode
+	lease := time.Duration(cfg.Cluster.LeaseSeconds) * time.Second
+	switch {
+	case cfg.Replication.ReadOnly:
+		log.Println("read-only standby: checks and alerts are disabled")
+	case !cfg.Cluster.Enabled:
+		if err := checker.Start(ctx); err != nil {
+			log.Fatalf("checker start: %v", err)
+		}
+	case cfg.Cluster.Mode == "leader":
+		// Only the lease holder probes; every instance serves the API.
+		elector = cluster.NewElector(database, cfg.Cluster.NodeID, lease)
+		clusterDone = make(chan struct{})
+		go func() {
+			defer close(clusterDone)
+			elector.Run(ctx, func(ctx context.Context) { runChecker(ctx, checker) })
+		}()
+	case cfg.Cluster.Mode == "shard":
+		// Every instance probes its share of monitors, rebalanced as members come and go.
+		members = cluster.NewMembership(database, cfg.Cluster.NodeID, lease)
+		checker.SetOwner(members.Owns)
+		clusterDone = make(chan struct{})
+		go func() {
+			defer close(clusterDone)
+			heartbeatDone := make(chan struct{})
+			go func() {
+				defer close(heartbeatDone)
+				members.Run(ctx, func() {
+					if err := checker.Sync(); err != nil {
+						log.Printf("checker sync: %v", err)
+					}
+				})
+			}()
+			runChecker(ctx, checker)
+			<-heartbeatDone
+		}()
+	default:
+		log.Fatalf("config: cluster.mode must be leader or shard, got %q", cfg.Cluster.Mode)
+	}
+
+	static, err := loadAssets()
+	if err != nil {
+		log.Fatalf("static assets: %v", err)
+	}
+
+	// A replica can't write sessions to its database, so it keeps its own.
+	sessionDB := database
+	if cfg.Replication.ReadOnly {
+		if sessionDB, err = db.OpenMemory(); err != nil {
+			log.Fatalf("sessions: %v", err)
+		}
+	}
+	sessions := auth.NewStore(sessionDB)
+	logins, err := audit.Open(database, cfg.Auth.GeoIPDB)
+	if err != nil {
+		log.Fatalf("geoip: %v", err)
+	}
+	defer logins.Close()
 
 	srv := &server{
 		cfg:      cfg,
 		db:       database,
 		sessions: sessions,
+		logins:   logins,
 		monitors: monitorStore,
+		spaces:   workspaces,
+		pages:    statuspage.NewStore(database),
 		checker:  checker,
+		policy:   policy,
+		assets:   static,
+		temps:    newTempWatch(cfg.Alerts, alerter),
+		alerter:  alerter,
+		runbooks: runbooks,
+		cache:    cache,
+		versions: newVersionLog(),
+		hosts:    newHostTracker(database),
+		elector:  elector,
+		members:  members,
+		ingest:   make(chan struct{}, cfg.Ingest.MaxInFlight),
 	}
 
+	if cfg.Ingest.FlushSeconds > 0 && !cfg.Replication.ReadOnly {
+		buf, err := openMetricBuffer(database, cfg.Ingest.Journal, cfg.Ingest.MaxBatch, cfg.Ingest.MaxPending)
+		if err != nil {
+			log.Fatalf("metrics journal: %v", err)
+		}
+		srv.metricBuf = buf
+		go buf.run(ctx, time.Duration(cfg.Ingest.FlushSeconds)*time.Second)
+	}
+	if cfg.Metrics.LongTermDays > 0 && !cfg.Replication.ReadOnly {
+		go runRollups(ctx, database, cfg.Metrics.LongTermDays)
+	}
+	if !cfg.Replication.ReadOnly {
+		go runBackupWatch(ctx, database, alerter)
+	}
+
+	var handler http.Handler = srv.routes()
+	if cfg.Replication.ReadOnly {
+		handler = srv.rejectWrites(handler)
+	}
+	handler = withRequestID(handler)
 	httpSrv := &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
-		Handler: srv.routes(),
+		Handler: handler,
+		// Headers get a tighter bound than the whole request, which is what
+		// a slowloris client drags out.
+		ReadHeaderTimeout: min(10*time.Second, time.Duration(cfg.Server.ReadTimeoutSeconds)*time.Second),
+		ReadTimeout:       time.Duration(cfg.Server.ReadTimeoutSeconds) * time.Second,
+		WriteTimeout:      time.Duration(cfg.Server.WriteTimeoutSeconds) * time.Second,
+		IdleTimeout:       time.Duration(cfg.Server.IdleTimeoutSeconds) * time.Second,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
 	}
 
+	ln, from, err := listen(httpSrv.Addr, cfg.Server.ReusePort)
+	if err != nil {
+		log.Fatalf("listen: %v", err)
+	}
 	// Serve in a goroutine so we can react to the shutdown signal.
 	go func() {
-		log.Printf("health-dashboard listening on %s", httpSrv.Addr)
-		if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("health-dashboard %s listening on %s%s", version, ln.Addr(), from)
+		if err := httpSrv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("server: %v", err)
 		}
 	}()
 
 	// Block until SIGINT/SIGTERM.
 	<-ctx.Done()
+	// A second signal kills the process rather than waiting for the drain.
+	stop()
+
+	// Drain: fail health checks so load balancers move on, and close
+	// keep-alive connections after their current request so clients
+	// reconnect, to the new server if one is already listening.
+	srv.draining.Store(true)
+	httpSrv.SetKeepAlivesEnabled(false)
+	if d := cfg.Server.DrainSeconds; d > 0 {
+		log.Printf("draining for %ds...", d)
+		time.Sleep(time.Duration(d) * time.Second)
+	}
 	log.Println("shutting down...")
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeoutSeconds)*time.Second)
 	defer cancel()
 	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
 		log.Printf("http shutdown: %v", err)
 	}
-	checker.Stop()
+	if srv.metricBuf != nil {
+		if err := srv.metricBuf.Close(); err != nil {
+			log.Printf("metrics flush: %v", err)
+		}
+	}
+	if clusterDone != nil {
+		<-clusterDone
+	} else if !cfg.Replication.ReadOnly {
+		checker.Stop()
+	}
+}
+
+// runChecker runs the checker until ctx (the process, or the leader lease)
+// ends, and periodically syncs it with monitors edited through other instances.
+func runChecker(ctx context.Context, checker *monitor.Checker) {
+	if err := checker.Start(ctx); err != nil {
+		log.Printf("checker start: %v", err)
+		return
+	}
+	defer checker.Stop()
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := checker.Sync(); err != nil {
+				log.Printf("checker sync: %v", err)
+			}
+		}
+	}
 }
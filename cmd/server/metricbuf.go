@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// metricRow is one validated agent payload, ready to insert. RecordedAt is
+// when the agent collected it (or the server received it, for agents that
+// don't say), so buffered and replayed rows keep their own time.
+type metricRow struct {
+	WorkspaceID int64           `json:"workspace_id"`
+	RecordedAt  string          `json:"recorded_at"` // "2006-01-02 15:04:05", UTC
+	CPUPercent  float64         `json:"cpu_percent"`
+	CoresJSON   string          `json:"cpu_cores_json"`
+	Load1       float64         `json:"load_1"`
+	Load5       float64         `json:"load_5"`
+	Load15      float64         `json:"load_15"`
+	MemUsed     int64           `json:"mem_used"`
+	MemTotal    int64           `json:"mem_total"`
+	SwapUsed    int64           `json:"swap_used"`
+	SwapTotal   int64           `json:"swap_total"`
+	DiskJSON    string          `json:"disk_json"`
+	NetJSON     string          `json:"net_json"`
+	IOJSON      string          `json:"disk_io_json"`
+	TempsJSON   string          `json:"temps_json"`
+	PingsJSON   string          `json:"pings_json"`
+	DirsJSON    string          `json:"dir_sizes_json"`
+	VMsJSON     string          `json:"vms_json"`
+	TopCPUJSON  string          `json:"top_cpu_json"`
+	TopMemJSON  string          `json:"top_mem_json"`
+	FailedJSON  string          `json:"failed_units_json"`
+	CustomJSON  string          `json:"custom_json"`
+	Containers  []containerInfo `json:"containers"`
+	Sockets     *sockInfo       `json:"sockets"`
+	NTPOffsetMs *float64        `json:"ntp_offset_ms"`
+}
+
+// writeMetrics inserts rows and their container stats in one transaction.
+func writeMetrics(ctx context.Context, db *sql.DB, rows []*metricRow) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, m := range rows {
+		var fdOpen, fdMax, tcpEst, tcpInUse, tcpTW any // NULL without socket counts
+		if k := m.Sockets; k != nil {
+			fdOpen, fdMax, tcpEst, tcpInUse, tcpTW = k.FDOpen, k.FDMax, k.TCPEstablished, k.TCPInUse, k.TCPTimeWait
+		}
+		pingsJSON, dirsJSON, vmsJSON := m.PingsJSON, m.DirsJSON, m.VMsJSON
+		if pingsJSON == "" {
+			pingsJSON = "[]" // journaled by an older server
+		}
+		if dirsJSON == "" {
+			dirsJSON = "[]"
+		}
+		if vmsJSON == "" {
+			vmsJSON = "[]"
+		}
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO metrics (workspace_id, recorded_at, cpu_percent, cpu_cores_json, load_1, load_5, load_15, mem_used, mem_total,
+			 swap_used, swap_total, disk_json, net_json, disk_io_json, temps_json, top_cpu_json, top_mem_json, failed_units_json,
+			 fd_open, fd_max, tcp_established, tcp_inuse, tcp_time_wait, custom_json, ntp_offset_ms, pings_json,
+			 dir_sizes_json, vms_json)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			m.WorkspaceID, m.RecordedAt, m.CPUPercent, m.CoresJSON, m.Load1, m.Load5, m.Load15, m.MemUsed, m.MemTotal,
+			m.SwapUsed, m.SwapTotal, m.DiskJSON, m.NetJSON, m.IOJSON, m.TempsJSON,
+			m.TopCPUJSON, m.TopMemJSON, m.FailedJSON,
+			fdOpen, fdMax, tcpEst, tcpInUse, tcpTW, m.CustomJSON, m.NTPOffsetMs, pingsJSON, dirsJSON, vmsJSON,
+		)
+		if err != nil {
+			return err
+		}
+		metricID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, c := range m.Containers {
+			_, err := tx.ExecContext(ctx,
+				`INSERT INTO container_stats (metric_id, container_id, name, image, state, cpu_percent, mem_used, mem_limit, restart_count)
+				 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				metricID, c.ID, c.Name, c.Image, c.State, c.CPUPercent, c.MemUsed, c.MemLimit, c.RestartCount)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// metricBuffer holds agent payloads in memory and writes them in batches,
+// so a fleet of agents costs one transaction per flush rather than one per
+// POST. Every payload is appended to a journal file before it is
+// acknowledged. A flush moves the journal aside to <journal>.flushing, so
+// payloads added while the batch is written start a fresh one, and removes
+// it once the batch is stored; both files are replayed on startup, so a
+// crash between flushes loses nothing. The journal isn't fsynced: it
+// survives the process crashing, not the host losing power.
+type metricBuffer struct {
+	db         *sql.DB
+	path       string
+	maxBatch   int
+	maxPending int // add refuses rows beyond this while flushes are failing
+
+	flushMu sync.Mutex // held for a whole flush, so one runs at a time
+
+	mu       sync.Mutex
+	pending  []*metricRow
+	inFlight int // rows being written by flush, counted against maxPending
+	journal  *os.File
+	full     chan struct{} // signalled when pending reaches maxBatch
+}
+
+// openMetricBuffer opens (or creates) the journal at path and writes any
+// rows left in it, or in a flush it interrupted, by a previous run before
+// returning.
+func openMetricBuffer(db *sql.DB, path string, maxBatch, maxPending int) (*metricBuffer, error) {
+	f, err := openJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &metricBuffer{db: db, path: path, maxBatch: maxBatch, maxPending: maxPending, journal: f, full: make(chan struct{}, 1)}
+	if err := b.recover(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("replay %s: %w", path, err)
+	}
+	return b, nil
+}
+
+func openJournal(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+}
+
+// flushing is where flush moves the journal while its rows are written.
+func (b *metricBuffer) flushing() string {
+	return b.path + ".flushing"
+}
+
+// recover writes the rows found in an interrupted flush's file and then in
+// the journal, removes the first and truncates the second. A torn last
+// line, from a crash mid-append, was never acknowledged and is dropped.
+func (b *metricBuffer) recover() error {
+	rows, err := readJournal(b.flushing())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if _, err := b.journal.Seek(0, 0); err != nil {
+		return err
+	}
+	more, err := scanJournal(b.journal)
+	if err != nil {
+		return err
+	}
+	rows = append(rows, more...)
+	if len(rows) > 0 {
+		if err := writeMetrics(context.Background(), b.db, rows); err != nil {
+			return err
+		}
+		log.Printf("metrics journal: recovered %d buffered payloads", len(rows))
+	}
+	if err := os.Remove(b.flushing()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return b.journal.Truncate(0)
+}
+
+func readJournal(path string) ([]*metricRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return scanJournal(f)
+}
+
+func scanJournal(f *os.File) ([]*metricRow, error) {
+	var rows []*metricRow
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 2*maxMetricsBody)
+	for sc.Scan() {
+		var m metricRow
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			log.Printf("metrics journal: skipping unreadable entry: %v", err)
+			continue
+		}
+		rows = append(rows, &m)
+	}
+	return rows, sc.Err()
+}
+
+// errBufferFull is returned by add when maxPending rows are already queued.
+var errBufferFull = errors.New("metrics buffer is full")
+
+// add journals m and queues it for the next flush.
+func (b *metricBuffer) add(m *metricRow) error {
+	line, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending)+b.inFlight >= b.maxPending {
+		return errBufferFull
+	}
+	if _, err := b.journal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	b.pending = append(b.pending, m)
+	if len(b.pending) >= b.maxBatch {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// flush writes everything pending. Only taking the rows and moving their
+// journal aside holds the lock, so adds carry on during the write. On
+// failure the rows are queued again ahead of newer ones, and their journal
+// is kept for the next attempt.
+func (b *metricBuffer) flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	rows := b.pending
+	if len(rows) == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	if err := b.rotate(); err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	b.pending, b.inFlight = nil, len(rows)
+	b.mu.Unlock()
+
+	err := writeMetrics(context.Background(), b.db, rows)
+
+	b.mu.Lock()
+	b.inFlight = 0
+	if err != nil {
+		b.pending = append(rows, b.pending...)
+	}
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.Remove(b.flushing())
+}
+
+// rotate moves the journal's rows to the flushing file and starts an empty
+// journal. If a failed flush left that file behind, the journal is appended
+// to it rather than replacing it, matching the order rows are requeued in.
+// b.mu must be held.
+func (b *metricBuffer) rotate() error {
+	if _, err := os.Stat(b.flushing()); err == nil {
+		return b.appendToFlushing()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(b.path, b.flushing()); err != nil {
+		return err
+	}
+	f, err := openJournal(b.path)
+	if err != nil {
+		return errors.Join(err, os.Rename(b.flushing(), b.path))
+	}
+	b.journal.Close()
+	b.journal = f
+	return nil
+}
+
+func (b *metricBuffer) appendToFlushing() error {
+	dst, err := os.OpenFile(b.flushing(), os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := b.journal.Seek(0, 0); err != nil {
+		dst.Close()
+		return err
+	}
+	if _, err := io.Copy(dst, b.journal); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return b.journal.Truncate(0)
+}
+
+// depth returns the number of payloads waiting for a flush, and the most
+// add accepts.
+func (b *metricBuffer) depth() (pending, max int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending) + b.inFlight, b.maxPending
+}
+
+// run flushes every interval, or sooner when a batch fills up, until ctx
+// is done.
+func (b *metricBuffer) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.full:
+		}
+		if err := b.flush(); err != nil {
+			log.Printf("metrics flush: %v", err)
+		}
+	}
+}
+
+// Close flushes what is pending and closes the journal. If the final flush
+// fails the journal is kept, and the rows are written on the next start.
+func (b *metricBuffer) Close() error {
+	err := b.flush()
+	return errors.Join(err, b.journal.Close())
+}
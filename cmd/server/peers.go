@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// An agent's peer_address is refreshed in the hosts table at most every
+// peerSeenEvery, and it is handed out as a peer, and its checks shown in the
+// reachability matrix, for peerLive after that; longer than the agents'
+// 5-minute peer list refresh, so a live host doesn't drop in and out.
+const (
+	peerSeenEvery = 5 * time.Minute
+	peerLive      = 15 * time.Minute
+)
+
+// peerSeen is the peer_address last written for a host, and when.
+type peerSeen struct {
+	address string
+	at      time.Time
+}
+
+// peerInfo is one of peer_checks in a metrics payload: the sending agent's
+// check of another.
+type peerInfo struct {
+	Peer        string   `json:"peer"` // the other agent's host name
+	Target      string   `json:"target"`
+	Method      string   `json:"method"`
+	RTTMs       *float64 `json:"rtt_ms"`
+	LossPercent float64  `json:"loss_percent"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// recordPeers stores the address the named agent advertises to its peers
+// ("" to stop) and its checks of them, as collected at. A check of a peer
+// with no hosts row is dropped, and one older than the stored check of the
+// same peer, replayed from the agent's queue, doesn't replace it.
+func (t *hostTracker) recordPeers(workspaceID int64, name, address string, at time.Time, checks []peerInfo) error {
+	now := time.Now().UTC()
+	t.mu.Lock()
+	id, err := t.hostID(workspaceID, name)
+	if err == nil {
+		seen, ok := t.peers[id]
+		if address == "" && (!ok || seen.address != "") ||
+			address != "" && (seen.address != address || now.Sub(seen.at) >= peerSeenEvery) {
+			var seenAt any
+			if address != "" {
+				seenAt = now.Format("2006-01-02 15:04:05")
+			}
+			if _, err = t.db.Exec(`UPDATE hosts SET peer_address = ?, peer_seen_at = ? WHERE id = ?`, address, seenAt, id); err == nil {
+				t.peers[id] = peerSeen{address, now}
+			}
+		}
+	}
+	t.mu.Unlock()
+	if err != nil || len(checks) == 0 {
+		return err
+	}
+
+	tx, err := t.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, c := range checks {
+		if _, err := tx.Exec(`
+			INSERT INTO peer_checks (workspace_id, from_host, to_host, target, method, rtt_ms, loss_percent, error, checked_at)
+			SELECT ?, ?, id, ?, ?, ?, ?, ?, ? FROM hosts WHERE workspace_id = ? AND name = ? AND id != ?
+			ON CONFLICT (workspace_id, from_host, to_host) DO UPDATE SET
+				target = excluded.target, method = excluded.method, rtt_ms = excluded.rtt_ms, loss_percent = excluded.loss_percent,
+				error = excluded.error, checked_at = excluded.checked_at
+			WHERE excluded.checked_at >= peer_checks.checked_at`,
+			workspaceID, id, c.Target, c.Method, c.RTTMs, c.LossPercent, c.Error, at.Format("2006-01-02 15:04:05"),
+			workspaceID, c.Peer, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// agentPeer is one entry of GET /api/agent/peers.
+type agentPeer struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// handleAgentPeers handles GET /api/agent/peers, authenticated like
+// POST /api/metrics: the other agents in the workspace that advertised a
+// peer_address within peerLive, for agents with agent.peer_checks to check.
+func (s *server) handleAgentPeers(w http.ResponseWriter, r *http.Request) {
+	wsID, ok := s.agentWorkspace(w, r)
+	if !ok {
+		return
+	}
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT name, peer_address FROM hosts
+		WHERE workspace_id = ? AND name != ? AND peer_address != '' AND peer_seen_at >= ?
+		ORDER BY name LIMIT ?`,
+		wsID, agentHost(r), time.Now().UTC().Add(-peerLive).Format("2006-01-02 15:04:05"), maxPeers)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+	peers := []agentPeer{}
+	for rows.Next() {
+		var p agentPeer
+		if err := rows.Scan(&p.Name, &p.Address); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		peers = append(peers, p)
+	}
+	if err := rows.Err(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(peers)
+}
+
+// peerCheck is one cell of the reachability matrix.
+type peerCheck struct {
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Target      string    `json:"target"`
+	Method      string    `json:"method"`
+	RTTMs       *float64  `json:"rtt_ms"`
+	LossPercent float64   `json:"loss_percent"`
+	Error       string    `json:"error,omitempty"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+// reachability is the response of GET /api/dashboard/reachability.
+type reachability struct {
+	Hosts  []string    `json:"hosts"` // the matrix's rows and columns, by name
+	Checks []peerCheck `json:"checks"`
+}
+
+// handleDashboardReachability returns the workspace's reachability matrix:
+// the latest check of each agent by each other, from the last peerLive.
+// Its hosts are those checked or checking then, and those advertising a
+// peer_address that no one has checked yet.
+func (s *server) handleDashboardReachability(w http.ResponseWriter, r *http.Request) {
+	wsID := workspaceID(r.Context())
+	since := time.Now().UTC().Add(-peerLive).Format("2006-01-02 15:04:05")
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT f.name, t.name, c.target, c.method, c.rtt_ms, c.loss_percent, c.error, c.checked_at
+		FROM peer_checks c
+		JOIN hosts f ON f.id = c.from_host
+		JOIN hosts t ON t.id = c.to_host
+		WHERE c.workspace_id = ? AND c.checked_at >= ?
+		ORDER BY f.name, t.name`, wsID, since)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+	resp := reachability{Hosts: []string{}, Checks: []peerCheck{}}
+	for rows.Next() {
+		var c peerCheck
+		if err := rows.Scan(&c.From, &c.To, &c.Target, &c.Method, &c.RTTMs, &c.LossPercent, &c.Error, &c.CheckedAt); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		resp.Checks = append(resp.Checks, c)
+		resp.Hosts = append(resp.Hosts, c.From, c.To)
+	}
+	if err := rows.Err(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+
+	advertised, err := s.db.QueryContext(r.Context(), `
+		SELECT name FROM hosts WHERE workspace_id = ? AND peer_address != '' AND peer_seen_at >= ?`, wsID, since)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	defer advertised.Close()
+	for advertised.Next() {
+		var name string
+		if err := advertised.Scan(&name); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		resp.Hosts = append(resp.Hosts, name)
+	}
+	if err := advertised.Err(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	slices.Sort(resp.Hosts)
+	resp.Hosts = slices.Compact(resp.Hosts)
+
+	s.writeListJSON(w, r, resp)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Body limits for the ingestion endpoints.
+const (
+	maxMetricsBody = 1 << 20 // room for every list in a payload at its cap, such as maxVMs guests
+	maxEventBody   = 16 << 10
+	maxWebhookBody = 1 << 20 // GitHub and GitLab payloads embed whole repository objects
+)
+
+// bodyTooLarge answers 413 and logs it: a client that treats 413 as final,
+// like the agent, drops the payload, so the operator should hear about it.
+func bodyTooLarge(w http.ResponseWriter, r *http.Request, limit int64) {
+	log.Printf("%s %s from %s: body over %d bytes rejected", r.Method, r.URL.Path, r.RemoteAddr, limit)
+	writeError(w, r, http.StatusRequestEntityTooLarge, codeTooLarge,
+		fmt.Sprintf("request body exceeds %d bytes", limit))
+}
+
+// decodeStrict decodes a single JSON value from r's body into v, rejecting
+// bodies over limit bytes (413), malformed JSON (400), and unknown fields or
+// mistyped values (422). A body sent with Content-Encoding: gzip is
+// decompressed first, and limit applies to both its compressed and
+// decompressed size. It writes the error response and returns false on
+// failure.
+func decodeStrict(w http.ResponseWriter, r *http.Request, v any, limit int64) bool {
+	body := http.MaxBytesReader(w, r.Body, limit)
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				bodyTooLarge(w, r, limit)
+			} else {
+				writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid gzip body")
+			}
+			return false
+		}
+		defer zr.Close()
+		body = http.MaxBytesReader(w, zr, limit)
+	default:
+		writeError(w, r, http.StatusUnsupportedMediaType, codeBadRequest,
+			fmt.Sprintf("unsupported Content-Encoding %q", enc))
+		return false
+	}
+
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.More() {
+		err = errors.New("unexpected data after JSON value")
+	}
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	var corrupt flate.CorruptInputError
+	switch {
+	case errors.As(err, &tooLarge):
+		bodyTooLarge(w, r, limit)
+	case errors.As(err, &typeErr):
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField,
+			fmt.Sprintf("%s must be %s", typeErr.Field, jsonTypeName(typeErr.Type)))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for unknown fields.
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidField,
+			strings.TrimPrefix(err.Error(), "json: "))
+	case errors.Is(err, gzip.ErrChecksum), errors.Is(err, gzip.ErrHeader), errors.As(err, &corrupt):
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid gzip body")
+	case errors.Is(err, io.EOF):
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "request body is empty")
+	default:
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON, "invalid JSON")
+	}
+	return false
+}
+
+// jsonTypeName describes a Go type the way a JSON client would.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	case reflect.Pointer:
+		return jsonTypeName(t.Elem())
+	default:
+		return "a number"
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"health-dashboard/internal/rules"
+)
+
+// Each rollup recomputes the complete hours in the rollupWindow before it,
+// so samples an agent replays from its queue after an outage are counted.
+// Later replays only reach the raw metrics. The first rollup after startup
+// covers the backfillWindow instead, all of the 7-day raw retention but its
+// oldest, partly pruned day, so turning rollups on keeps most of the past week.
+const (
+	rollupWindow   = 48 * time.Hour
+	backfillWindow = 6 * 24 * time.Hour
+	rollupEvery    = time.Hour
+)
+
+// runRollups rolls up metrics at startup and then every rollupEvery until
+// ctx ends, keeping hourly rows for keepDays. Rolling up is idempotent, so
+// every instance of a cluster may run it.
+func runRollups(ctx context.Context, db *sql.DB, keepDays int) {
+	ticker := time.NewTicker(rollupEvery)
+	defer ticker.Stop()
+	window := backfillWindow
+	for {
+		if err := rollupMetrics(ctx, db, time.Now().UTC(), window, keepDays); err != nil {
+			log.Printf("metrics rollup: %v", err)
+		}
+		window = rollupWindow
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rollupMetrics writes the hourly min/avg/max of every rules.Metrics metric
+// for the complete hours of the window before now, then deletes hourly rows
+// older than keepDays.
+func rollupMetrics(ctx context.Context, db *sql.DB, now time.Time, window time.Duration, keepDays int) error {
+	to := now.Truncate(time.Hour)
+	from := to.Add(-window).Format("2006-01-02 15:04:05")
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, name := range rules.Metrics {
+		_, err := tx.ExecContext(ctx, `
+			INSERT OR REPLACE INTO metrics_hourly (workspace_id, hour, metric, samples, min, avg, max)
+			SELECT workspace_id, strftime('%Y-%m-%d %H:00:00', recorded_at) AS hour, ?, COUNT(v), MIN(v), AVG(v), MAX(v)
+			FROM (SELECT workspace_id, recorded_at, `+metricExprs[name]+` AS v FROM metrics
+				WHERE recorded_at >= ? AND recorded_at < ?)
+			WHERE v IS NOT NULL
+			GROUP BY workspace_id, hour`,
+			name, from, to.Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM metrics_hourly WHERE hour < ?`,
+		now.AddDate(0, 0, -keepDays).Format("2006-01-02 15:04:05")); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// hourlyPoint is one hour of GET /api/dashboard/metrics/long-term.
+type hourlyPoint struct {
+	Ts      int64   `json:"ts"` // start of the hour, unix seconds
+	Samples int     `json:"samples"`
+	Min     float64 `json:"min"`
+	Avg     float64 `json:"avg"`
+	Max     float64 `json:"max"`
+}
+
+// handleLongTermMetrics handles GET /api/dashboard/metrics/long-term: one
+// metric's hourly rollups over the last days (default and at most
+// metrics.long_term_days), oldest first.
+func (s *server) handleLongTermMetrics(w http.ResponseWriter, r *http.Request) {
+	keep := s.cfg.Metrics.LongTermDays
+	if keep <= 0 {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "long-term metrics are disabled; set metrics.long_term_days")
+		return
+	}
+	metric := r.URL.Query().Get("metric")
+	if !slices.Contains(rules.Metrics, metric) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidField, fmt.Sprintf("metric must be one of %v", rules.Metrics))
+		return
+	}
+	days := keep
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > keep {
+			writeError(w, r, http.StatusBadRequest, codeInvalidField, fmt.Sprintf("days must be between 1 and %d", keep))
+			return
+		}
+		days = n
+	}
+
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT strftime('%s', hour), samples, min, avg, max FROM metrics_hourly
+		WHERE workspace_id = ? AND metric = ? AND hour >= ?
+		ORDER BY hour`,
+		workspaceID(r.Context()), metric, time.Now().UTC().AddDate(0, 0, -days).Format("2006-01-02 15:04:05"))
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+	points := []hourlyPoint{}
+	for rows.Next() {
+		var p hourlyPoint
+		if err := rows.Scan(&p.Ts, &p.Samples, &p.Min, &p.Avg, &p.Max); err != nil {
+			internalError(w, r, err)
+			return
+		}
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(points)
+}
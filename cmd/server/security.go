@@ -0,0 +1,119 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+
+	"health-dashboard/internal/audit"
+	"health-dashboard/internal/auth"
+)
+
+// loginHistoryLimit is how many recent login attempts are listed by default;
+// the API accepts ?limit= up to maxLoginHistory.
+const (
+	loginHistoryLimit = 100
+	maxLoginHistory   = 1000
+)
+
+var securityTmpl = template.Must(template.New("security").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>Account security — Health Dashboard</title>
+  <style>
+    *, *::before, *::after { box-sizing: border-box; margin: 0; padding: 0; }
+    body {
+      font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+      background: #0f1117;
+      color: #e2e8f0;
+      padding: 2rem 1.5rem;
+    }
+    .card {
+      background: #1a1d27;
+      border: 1px solid #2d3148;
+      border-radius: 8px;
+      padding: 1.5rem;
+      max-width: 1100px;
+      margin: 0 auto 1.5rem;
+      overflow-x: auto;
+    }
+    h1 { font-size: 1.25rem; max-width: 1100px; margin: 0 auto 1.5rem; color: #f8fafc; }
+    h1 a { font-size: 0.85rem; font-weight: 400; color: #94a3b8; margin-left: 1rem; }
+    h2 { font-size: 1rem; margin-bottom: 1rem; color: #f8fafc; }
+    table { width: 100%; border-collapse: collapse; font-size: 0.85rem; }
+    th { text-align: left; color: #94a3b8; font-weight: 500; padding: 0.4rem 0.6rem; border-bottom: 1px solid #2d3148; }
+    td { padding: 0.4rem 0.6rem; border-bottom: 1px solid #1f2333; vertical-align: top; }
+    td.ua { color: #94a3b8; max-width: 360px; overflow-wrap: anywhere; }
+    .ok { color: #4ade80; }
+    .fail { color: #f87171; }
+    .empty { color: #475569; font-size: 0.85rem; }
+  </style>
+</head>
+<body>
+  <h1>Account security <a href="/">← Dashboard</a></h1>
+  <div class="card">
+    <h2>Active sessions</h2>
+    <table>
+      <tr><th>Signed in</th><th>Last used</th><th>Role</th><th>IP</th><th>User agent</th><th></th></tr>
+      {{range .Sessions}}
+      <tr>
+        <td>{{.CreatedAt.Format "2006-01-02 15:04:05"}} UTC</td>
+        <td>{{.LastUsed.Format "2006-01-02 15:04:05"}} UTC</td>
+        <td>{{.Role}}</td>
+        <td>{{.IP}}</td>
+        <td class="ua">{{.UserAgent}}</td>
+        <td>{{if .Current}}this session{{end}}</td>
+      </tr>
+      {{end}}
+    </table>
+  </div>
+  <div class="card">
+    <h2>Recent logins</h2>
+    {{if .Logins}}
+    <table>
+      <tr><th>Time</th><th>Result</th><th>Role</th><th>IP</th><th>Location</th><th>User agent</th></tr>
+      {{range .Logins}}
+      <tr>
+        <td>{{.CreatedAt.Format "2006-01-02 15:04:05"}} UTC</td>
+        <td>{{if .Success}}<span class="ok">success</span>{{else}}<span class="fail">failed</span>{{end}}</td>
+        <td>{{.Role}}</td>
+        <td>{{.IP}}</td>
+        <td>{{.City}}{{if and .City .Country}}, {{end}}{{.Country}}</td>
+        <td class="ua">{{.UserAgent}}</td>
+      </tr>
+      {{end}}
+    </table>
+    {{else}}
+    <p class="empty">No logins recorded yet.</p>
+    {{end}}
+  </div>
+</body>
+</html>`))
+
+// handleSecurityPage serves GET /security: recent login attempts and live
+// sessions, so unexpected access is easy to spot. Admin only.
+func (s *server) handleSecurityPage(w http.ResponseWriter, r *http.Request) {
+	token := auth.GetSessionToken(r)
+	if g, _ := s.sessions.Grant(token); g.Role != auth.RoleAdmin || g.Workspaces != nil {
+		http.Error(w, "admin only", http.StatusForbidden)
+		return
+	}
+	logins, err := s.logins.Recent(loginHistoryLimit)
+	if err != nil {
+		log.Printf("login history: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	sessions, err := s.sessions.List(token)
+	if err != nil {
+		log.Printf("sessions: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	securityTmpl.Execute(w, struct {
+		Sessions []auth.SessionInfo
+		Logins   []audit.Login
+	}{sessions, logins})
+}
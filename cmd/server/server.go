@@ -1,20 +1,44 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 
+	"health-dashboard/internal/audit"
 	"health-dashboard/internal/auth"
+	"health-dashboard/internal/cluster"
 	"health-dashboard/internal/config"
 	"health-dashboard/internal/monitor"
+	"health-dashboard/internal/statuspage"
+	"health-dashboard/internal/workspace"
 )
 
 type server struct {
 	cfg      *config.Config
 	db       *sql.DB
 	sessions *auth.Store
+	logins   *audit.Log
 	monitors *monitor.Store
+	spaces   *workspace.Store
+	pages    *statuspage.Store
 	checker  *monitor.Checker
+	policy   monitor.AddrPolicy
+	assets   *assets
+	temps    *tempWatch
+	alerter  *monitor.Alerter
+	hosts    *hostTracker
+	runbooks []monitor.RunbookTemplate
+	cache    *readCache
+	versions *versionLog
+	elector  *cluster.Elector    // nil unless cluster mode is "leader"
+	members  *cluster.Membership // nil unless cluster mode is "shard"
+
+	metricBuf *metricBuffer // nil unless ingest.flush_seconds is set
+	ingest    chan struct{} // one slot per ingestion request in flight
+	draining  atomic.Bool   // set on SIGTERM; /health answers 503
 }
 
 func (s *server) routes() *http.ServeMux {
@@ -25,26 +49,94 @@ func (s *server) routes() *http.ServeMux {
 	mux.HandleFunc("GET /login", s.handleLoginPage)
 	mux.HandleFunc("POST /login", s.handleLogin)
 	mux.HandleFunc("POST /logout", s.handleLogout)
+	mux.HandleFunc("GET /status", s.handleStatusPage)
+	mux.HandleFunc("GET /api/status", s.handleStatusJSON)
 
 	// Metrics ingestion (agent token auth — no session required)
-	mux.HandleFunc("POST /api/metrics", s.handleMetricsPost)
+	mux.HandleFunc("POST /api/metrics", s.limitIngest(s.handleMetricsPost))
+	mux.HandleFunc("GET /api/agent/peers", s.limitIngest(s.handleAgentPeers))
 
 	// Business event ingestion (X-API-Key header auth)
-	mux.HandleFunc("POST /api/events", s.requireAPIKey(s.handleEventPost))
+	mux.HandleFunc("POST /api/events", s.limitIngest(s.requireAPIKey(s.invalidates(s.handleEventPost, cacheEventSummary))))
 	mux.HandleFunc("GET /api/events/summary", s.requireAPIKey(s.handleEventSummary))
+	mux.HandleFunc("POST /api/backups", s.limitIngest(s.requireAPIKey(s.handleBackupPost)))
+	mux.HandleFunc("POST /api/webhooks/github", s.limitIngest(s.handleGitHubWebhook))
+	mux.HandleFunc("POST /api/webhooks/gitlab", s.limitIngest(s.handleGitLabWebhook))
+
+	// Push monitor pings (the token in the path is the auth)
+	mux.HandleFunc("POST /api/push/{token}/start", s.limitIngest(s.handlePushStart))
+	mux.HandleFunc("POST /api/push/{token}/finish", s.limitIngest(s.handlePushFinish))
 
 	// Dashboard data endpoints (session auth — used by the frontend)
 	mux.HandleFunc("GET /api/dashboard/monitors", s.requireAuthAPI(s.handleDashboardMonitors))
 	mux.HandleFunc("GET /api/dashboard/metrics", s.requireAuthAPI(s.handleDashboardMetrics))
+	mux.HandleFunc("GET /api/dashboard/metrics/long-term", s.requireAuthAPI(s.handleLongTermMetrics))
 	mux.HandleFunc("GET /api/dashboard/events", s.requireAuthAPI(s.handleDashboardEvents))
+	mux.HandleFunc("GET /api/dashboard/containers", s.requireAuthAPI(s.handleDashboardContainers))
+	mux.HandleFunc("GET /api/dashboard/vms", s.requireAuthAPI(s.handleDashboardVMs))
+	mux.HandleFunc("GET /api/dashboard/backups", s.requireAuthAPI(s.handleDashboardBackups))
+	mux.HandleFunc("GET /api/dashboard/annotations", s.requireAuthAPI(s.handleDashboardAnnotations))
+	mux.HandleFunc("GET /api/dashboard/reachability", s.requireAuthAPI(s.handleDashboardReachability))
+	mux.HandleFunc("POST /api/annotations", s.requireAuthAPI(s.handleAnnotationCreate))
+	mux.HandleFunc("DELETE /api/annotations/{id}", s.requireAuthAPI(s.handleAnnotationDelete))
+	mux.HandleFunc("GET /api/hosts", s.requireAuthAPI(s.handleHostList))
+	mux.HandleFunc("GET /api/hosts/{id}/ingestion", s.requireAuthAPI(s.handleHostIngestion))
 
 	// Monitor CRUD API (session auth)
-	mux.HandleFunc("POST /api/monitors", s.requireAuthAPI(s.handleMonitorCreate))
+	mux.HandleFunc("POST /api/monitors", s.requireAuthAPI(s.invalidates(s.handleMonitorCreate, cacheMonitors, cacheStatusPage)))
 	mux.HandleFunc("GET /api/monitors", s.requireAuthAPI(s.handleMonitorList))
+	mux.HandleFunc("POST /api/monitors/check-all", s.requireAuthAPI(s.handleMonitorCheckAll))
+	mux.HandleFunc("GET /api/checker/dns-cache", s.requireAuthAPI(s.handleDNSCacheStats))
 	mux.HandleFunc("GET /api/monitors/{id}", s.requireAuthAPI(s.handleMonitorGet))
-	mux.HandleFunc("PUT /api/monitors/{id}", s.requireAuthAPI(s.handleMonitorUpdate))
-	mux.HandleFunc("DELETE /api/monitors/{id}", s.requireAuthAPI(s.handleMonitorDelete))
+	mux.HandleFunc("PUT /api/monitors/{id}", s.requireAuthAPI(s.invalidates(s.handleMonitorUpdate, cacheMonitors, cacheStatusPage)))
+	mux.HandleFunc("DELETE /api/monitors/{id}", s.requireAuthAPI(s.invalidates(s.handleMonitorDelete, cacheMonitors, cacheStatusPage)))
+	mux.HandleFunc("PUT /api/monitors/{id}/known-issue", s.requireAuthAPI(s.invalidates(s.handleMonitorKnownIssue, cacheMonitors, cacheStatusPage)))
+	mux.HandleFunc("DELETE /api/monitors/{id}/known-issue", s.requireAuthAPI(s.invalidates(s.handleMonitorKnownIssueClear, cacheMonitors, cacheStatusPage)))
 	mux.HandleFunc("GET /api/monitors/{id}/checks", s.requireAuthAPI(s.handleMonitorChecks))
+	mux.HandleFunc("GET /api/monitors/{id}/gaps", s.requireAuthAPI(s.handleMonitorGaps))
+	mux.HandleFunc("GET /api/monitors/{id}/traces", s.requireAuthAPI(s.handleMonitorTraces))
+	mux.HandleFunc("GET /api/monitors/{id}/runs", s.requireAuthAPI(s.handleMonitorRuns))
+	mux.HandleFunc("GET /api/monitors/{id}/heatmap", s.requireAuthAPI(s.handleMonitorHeatmap))
+	mux.HandleFunc("GET /api/monitors/{id}/history", s.requireAuthAPI(s.handleMonitorHistory))
+	mux.HandleFunc("POST /api/monitors/{id}/history/{version}/revert", s.requireAuthAPI(s.invalidates(s.handleMonitorRevert, cacheMonitors, cacheStatusPage)))
+
+	// Reports (session auth)
+	mux.HandleFunc("GET /api/reports/compare", s.requireAuthAPI(s.handleReportCompare))
+	mux.HandleFunc("GET /api/topology", s.requireAuthAPI(s.handleTopology))
+	mux.HandleFunc("POST /api/alert-rules/preview", s.requireAuthAPI(s.handleRulePreview))
+	mux.HandleFunc("GET /api/alert-rules/prometheus", s.requireAuthAPI(s.handlePromRules))
+
+	// Status page curation and incidents (session auth)
+	mux.HandleFunc("GET /api/status-page/components", s.requireAuthAPI(s.handleComponentList))
+	mux.HandleFunc("POST /api/status-page/components", s.requireAuthAPI(s.invalidates(s.handleComponentCreate, cacheStatusPage)))
+	mux.HandleFunc("PUT /api/status-page/components/{id}", s.requireAuthAPI(s.invalidates(s.handleComponentUpdate, cacheStatusPage)))
+	mux.HandleFunc("DELETE /api/status-page/components/{id}", s.requireAuthAPI(s.invalidates(s.handleComponentDelete, cacheStatusPage)))
+	mux.HandleFunc("GET /api/status-page/incidents", s.requireAuthAPI(s.handleIncidentList))
+	mux.HandleFunc("POST /api/status-page/incidents", s.requireAuthAPI(s.invalidates(s.handleIncidentCreate, cacheStatusPage)))
+	mux.HandleFunc("PUT /api/status-page/incidents/{id}", s.requireAuthAPI(s.invalidates(s.handleIncidentUpdate, cacheStatusPage)))
+	mux.HandleFunc("POST /api/status-page/incidents/{id}/updates", s.requireAuthAPI(s.invalidates(s.handleIncidentAddUpdate, cacheStatusPage)))
+	mux.HandleFunc("DELETE /api/status-page/incidents/{id}", s.requireAuthAPI(s.invalidates(s.handleIncidentDelete, cacheStatusPage)))
+
+	// Session management (session auth, admin only)
+	mux.HandleFunc("GET /api/auth/sessions", s.requireAuthAPI(s.handleSessionList))
+	mux.HandleFunc("DELETE /api/auth/sessions", s.requireAuthAPI(s.handleSessionRevokeOthers))
+	mux.HandleFunc("DELETE /api/auth/sessions/{id}", s.requireAuthAPI(s.handleSessionRevoke))
+	mux.HandleFunc("GET /api/auth/logins", s.requireAuthAPI(s.handleLoginList))
+
+	// Workspace admin API (session auth)
+	mux.HandleFunc("GET /api/workspaces", s.requireAuthAPI(s.handleWorkspaceList))
+	mux.HandleFunc("POST /api/workspaces", s.requireAuthAPI(s.handleWorkspaceCreate))
+	mux.HandleFunc("PUT /api/workspaces/{id}", s.requireAuthAPI(s.handleWorkspaceUpdate))
+	mux.HandleFunc("DELETE /api/workspaces/{id}", s.requireAuthAPI(s.handleWorkspaceDelete))
+	mux.HandleFunc("POST /api/workspaces/{id}/rotate-keys", s.requireAuthAPI(s.handleWorkspaceRotateKeys))
+
+	// Unknown API paths get a JSON 404 rather than the dashboard's login redirect.
+	mux.HandleFunc("GET /api/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "not found")
+	})
+
+	// Account security page: login history and sessions (admin only)
+	mux.HandleFunc("GET /security", s.requireAuth(s.handleSecurityPage))
 
 	// Protected dashboard (must be last — it's the catch-all)
 	mux.HandleFunc("GET /", s.requireAuth(s.handleDashboard))
@@ -52,6 +144,41 @@ func (s *server) routes() *http.ServeMux {
 	return mux
 }
 
+// rejectWrites answers 503 to anything but reads and login/logout, for a
+// read-only standby whose database is a replica.
+func (s *server) rejectWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet, r.Method == http.MethodHead,
+			r.URL.Path == "/login", r.URL.Path == "/logout":
+			next.ServeHTTP(w, r)
+		default:
+			writeError(w, r, http.StatusServiceUnavailable, codeReadOnly, "read-only standby")
+		}
+	})
+}
+
+// limitIngest sheds ingestion requests with 429 once ingest.max_in_flight
+// are already being handled, rather than queueing them behind a saturated
+// database.
+func (s *server) limitIngest(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case s.ingest <- struct{}{}:
+			defer func() { <-s.ingest }()
+			next(w, r)
+		default:
+			s.tooBusy(w, r)
+		}
+	}
+}
+
+// tooBusy tells a client to back off for ingest.retry_after_seconds.
+func (s *server) tooBusy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(s.cfg.Ingest.RetryAfterSeconds))
+	writeError(w, r, http.StatusTooManyRequests, codeBusy, "server is busy, retry later")
+}
+
 // requireAuth wraps a handler to redirect unauthenticated requests to /login.
 func (s *server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -64,15 +191,102 @@ func (s *server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-// requireAuthAPI wraps a handler to return 401 JSON for unauthenticated API requests.
+// requireAuthAPI wraps a handler to return 401 JSON for unauthenticated API
+// requests and 403 for writes from viewers. The request is scoped to the
+// workspace named by the ?workspace= query parameter, or the default workspace,
+// which must be one the session's grant allows.
 func (s *server) requireAuthAPI(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := auth.GetSessionToken(r)
-		if !s.sessions.Valid(token) {
-			w.Header().Set("Content-Type", "application/json")
-			http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		grant, ok := s.sessions.Grant(auth.GetSessionToken(r))
+		if !ok {
+			writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
 			return
 		}
-		next(w, r)
+		if grant.Role != auth.RoleAdmin && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, r, http.StatusForbidden, codeForbidden, "viewers have read-only access")
+			return
+		}
+		wsID, ok := s.queryWorkspace(w, r, grant)
+		if !ok {
+			return
+		}
+		ctx := context.WithValue(withWorkspace(r.Context(), wsID), grantKey{}, grant)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// anyWorkspace lets the public status pages name every workspace.
+var anyWorkspace = auth.Grant{}
+
+// queryWorkspace returns the workspace named by the ?workspace= query
+// parameter, writing an error if it is invalid, outside g's workspaces or
+// doesn't exist. Without one it is the default workspace, or g's first if
+// g doesn't allow that.
+func (s *server) queryWorkspace(w http.ResponseWriter, r *http.Request, g auth.Grant) (int64, bool) {
+	v := r.URL.Query().Get("workspace")
+	if v == "" {
+		if !g.Allows(workspace.DefaultID) {
+			return g.Workspaces[0], true
+		}
+		return workspace.DefaultID, true
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid workspace")
+		return 0, false
+	}
+	if !g.Allows(id) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "no access to this workspace")
+		return 0, false
+	}
+	ws, err := s.spaces.Get(id)
+	if err != nil {
+		internalError(w, r, err)
+		return 0, false
+	}
+	if ws == nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "workspace not found")
+		return 0, false
+	}
+	return id, true
+}
+
+type grantKey struct{}
+
+// grant returns the grant of the request's session.
+func grant(ctx context.Context) auth.Grant {
+	g, _ := ctx.Value(grantKey{}).(auth.Grant)
+	return g
+}
+
+// isAdmin reports whether the request's session has the admin role, in the
+// request's workspace.
+func isAdmin(ctx context.Context) bool {
+	return grant(ctx).Role == auth.RoleAdmin
+}
+
+// instanceAdminOnly is the 403 message for what only an admin of every
+// workspace may do.
+const instanceAdminOnly = "only an admin of every workspace can do this"
+
+// isInstanceAdmin reports whether the request's session is an admin of
+// every workspace, who alone manages workspaces and sessions.
+func isInstanceAdmin(ctx context.Context) bool {
+	g := grant(ctx)
+	return g.Role == auth.RoleAdmin && g.Workspaces == nil
+}
+
+type workspaceKey struct{}
+
+func withWorkspace(ctx context.Context, id int64) context.Context {
+	return context.WithValue(ctx, workspaceKey{}, id)
+}
+
+// workspaceID returns the workspace a request was scoped to by its
+// authentication middleware.
+func workspaceID(ctx context.Context) int64 {
+	if id, ok := ctx.Value(workspaceKey{}).(int64); ok {
+		return id
 	}
+	return workspace.DefaultID
 }
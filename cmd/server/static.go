@@ -1,6 +1,18 @@
 package main
 
-import "embed"
+import (
+	"embed"
+	"io/fs"
+)
 
 //go:embed static
 var staticFS embed.FS
+
+// loadAssets prepares the embedded static directory for serving.
+func loadAssets() (*assets, error) {
+	sub, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		return nil, err
+	}
+	return newAssets(sub)
+}
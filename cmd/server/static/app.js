@@ -8,15 +8,25 @@ const html = htm.bind(h);
 
 const REFRESH_MS = 30_000;
 
+// Workspace shown, from ?workspace= in the page URL; the server defaults to 1.
+const WORKSPACE = new URLSearchParams(window.location.search).get('workspace');
+
 // ─── API helpers ─────────────────────────────────────────────────────────────
 
+function scoped(path) {
+  return WORKSPACE ? `${path}?workspace=${encodeURIComponent(WORKSPACE)}` : path;
+}
+
 async function apiFetch(path) {
   const res = await fetch(path, { credentials: 'include' });
   if (res.status === 401) {
     window.location.href = '/login';
     return null;
   }
-  if (!res.ok) throw new Error(`${res.status} ${res.statusText}`);
+  if (!res.ok) {
+    const body = await res.json().catch(() => null);
+    throw new Error(body?.error?.message ?? `${res.status} ${res.statusText}`);
+  }
   return res.json();
 }
 
@@ -29,6 +39,12 @@ function fmtBytes(bytes) {
   return bytes + ' B';
 }
 
+function fmtDuration(secs) {
+  if (secs >= 3600) return `${Math.floor(secs / 3600)}h ${Math.floor((secs % 3600) / 60)}m`;
+  if (secs >= 60)   return `${Math.floor(secs / 60)}m`;
+  return `${secs}s`;
+}
+
 function gaugeStroke(pct) {
   if (pct >= 90) return '#f87171';
   if (pct >= 70) return '#f59e0b';
@@ -41,6 +57,7 @@ const STATUS_STYLES = {
   up:      { label: 'UP',      color: '#22c55e', bg: 'rgba(34,197,94,0.10)',  border: 'rgba(34,197,94,0.25)'  },
   down:    { label: 'DOWN',    color: '#f87171', bg: 'rgba(248,113,113,0.10)', border: 'rgba(248,113,113,0.25)' },
   unknown: { label: 'UNKNOWN', color: '#64748b', bg: 'rgba(100,116,139,0.10)', border: 'rgba(100,116,139,0.25)' },
+  out_of_hours: { label: 'OUT OF HOURS', color: '#94a3b8', bg: 'rgba(148,163,184,0.08)', border: 'rgba(148,163,184,0.20)' },
 };
 
 function StatusPill({ state }) {
@@ -53,17 +70,40 @@ function StatusPill({ state }) {
 function MonitorCard({ m }) {
   const latency = m.last_response_ms != null ? `${m.last_response_ms} ms` : '—';
   const uptime  = m.uptime_24h      != null ? `${m.uptime_24h.toFixed(1)}%` : '—';
+  const weight  = m.last_response_bytes != null ? fmtBytes(m.last_response_bytes) : '—';
   return html`
     <div class="monitor-card">
       <div class="monitor-top">
         <${StatusPill} state=${m.state} />
         <span class="monitor-name">${m.name}</span>
       </div>
-      <div class="monitor-url">${m.url}</div>
+      <div class="monitor-url">${m.type === 'composite' ? 'Composite of other monitors' : m.type === 'push' ? 'Pinged by its job' : m.url}</div>
+      ${m.known_issue
+        ? html`<div class="monitor-known-issue">
+            <strong>Known issue</strong> ${m.known_issue}
+            <span class="monitor-known-issue-until">until ${new Date(m.known_issue_until).toLocaleString()}</span>
+          </div>`
+        : null}
       <div class="monitor-stats">
         <span class="stat"><span class="stat-label">Latency</span>${latency}</span>
         <span class="stat"><span class="stat-label">24 h uptime</span>${uptime}</span>
+        <span class="stat"><span class="stat-label">Size</span>${weight}</span>
+        ${m.security_score != null
+          ? html`<span class="stat"><span class="stat-label">Security</span>${m.security_score}/100</span>`
+          : null}
+        ${m.no_data_seconds_24h > 0
+          ? html`<span class="stat" title="No checks ran, e.g. while the server was down"><span class="stat-label">No data</span>${fmtDuration(m.no_data_seconds_24h)}</span>`
+          : null}
       </div>
+      ${m.owner || m.runbook_url
+        ? html`<div class="monitor-contact">
+            ${m.owner ? html`<span>Owner: ${m.owner}</span>` : null}
+            ${m.runbook_url ? html`<a href=${m.runbook_url} target="_blank" rel="noopener noreferrer">Runbook</a>` : null}
+          </div>`
+        : null}
+      ${m.description
+        ? html`<details class="monitor-description"><summary>Description</summary><div>${m.description}</div></details>`
+        : null}
     </div>`;
 }
 
@@ -88,7 +128,7 @@ function MonitorsSection({ monitors, loading }) {
 
 const ARC_LEN = Math.PI * 40; // 125.66
 
-function Gauge({ label, pct, subtitle }) {
+function Gauge({ label, pct, subtitle, detail }) {
   const safeP = Math.min(Math.max(pct ?? 0, 0), 100);
   const fill  = (safeP / 100) * ARC_LEN;
   const color = gaugeStroke(safeP);
@@ -105,12 +145,48 @@ function Gauge({ label, pct, subtitle }) {
       </svg>
       <div class="gauge-label">${label}</div>
       ${subtitle ? html`<div class="gauge-sub">${subtitle}</div>` : null}
+      ${detail ?? null}
+    </div>`;
+}
+
+// ─── CoreBars ────────────────────────────────────────────────────────────────
+//
+// One thin bar per CPU core, coloured like the gauges.
+
+function CoreBars({ cores }) {
+  return html`
+    <div class="core-bars" aria-label="Per-core CPU">
+      ${cores.map((pct, i) => html`
+        <div key=${i} class="core-bar" title="cpu${i}: ${Math.round(pct)}%">
+          <div class="core-bar-fill" style="height:${Math.min(Math.max(pct, 0), 100)}%;background:${gaugeStroke(pct)}"></div>
+        </div>`)}
     </div>`;
 }
 
-// ─── MetricsChart (uPlot) ────────────────────────────────────────────────────
+// ─── ProcessTable ────────────────────────────────────────────────────────────
+
+function ProcessTable({ title, procs, value }) {
+  return html`
+    <div class="proc-table">
+      <div class="proc-title">${title}</div>
+      ${procs.length === 0
+        ? html`<div class="muted">—</div>`
+        : procs.map(p => html`
+          <div key=${p.pid} class="proc-row">
+            <span class="proc-name" title="pid ${p.pid}">${p.name}</span>
+            <span class="proc-value">${value(p)}</span>
+          </div>`)}
+    </div>`;
+}
 
-function MetricsChart({ series }) {
+// ─── TimeChart (uPlot) ───────────────────────────────────────────────────────
+//
+// lines: [{ label, stroke, fill, value: point => number|null }]
+// range: fixed y range (e.g. [0, 100]) or null to auto-scale from zero.
+// marks: annotations drawn as vertical lines (see annotationMarks).
+// Points marked { gap: true } are blanks that break the lines (see withGaps).
+
+function TimeChart({ series, lines, fmtY, range = null, marks = [] }) {
   const containerRef = useRef(null);
   const chartRef     = useRef(null);
 
@@ -121,8 +197,7 @@ function MetricsChart({ series }) {
     if (chartRef.current) { chartRef.current.destroy(); chartRef.current = null; }
 
     const timestamps = series.map(d => d.ts);
-    const cpu        = series.map(d => d.cpu_percent);
-    const mem        = series.map(d => d.mem_total > 0 ? (d.mem_used / d.mem_total) * 100 : null);
+    const data       = [timestamps, ...lines.map(l => series.map(d => d.gap ? null : l.value(d)))];
 
     const w = containerRef.current.clientWidth || 700;
 
@@ -131,8 +206,7 @@ function MetricsChart({ series }) {
       height: 180,
       series: [
         {},
-        { label: 'CPU %',    stroke: '#6366f1', width: 1.5, fill: 'rgba(99,102,241,0.07)'  },
-        { label: 'Memory %', stroke: '#22c55e', width: 1.5, fill: 'rgba(34,197,94,0.07)'   },
+        ...lines.map(l => ({ label: l.label, stroke: l.stroke, width: 1.5, fill: l.fill })),
       ],
       axes: [
         { stroke: '#475569', grid: { stroke: '#1e293b' }, ticks: { stroke: '#1e293b' } },
@@ -140,18 +214,19 @@ function MetricsChart({ series }) {
           stroke: '#475569',
           grid:   { stroke: '#1e293b' },
           ticks:  { stroke: '#1e293b' },
-          values: (_u, vals) => vals.map(v => v != null ? v.toFixed(0) + '%' : ''),
-          size:   46,
+          values: (_u, vals) => vals.map(v => v != null ? fmtY(v) : ''),
+          size:   range ? 46 : 70,
         },
       ],
-      scales: { y: { auto: false, range: [0, 100] } },
+      scales: { y: range ? { auto: false, range } : { range: (_u, _min, max) => [0, max > 0 ? max : 1] } },
       cursor: { show: true },
+      hooks:  { draw: [u => drawMarks(u, marks)] },
     };
 
-    chartRef.current = new uPlot(opts, [timestamps, cpu, mem], containerRef.current);
+    chartRef.current = new uPlot(opts, data, containerRef.current);
 
     return () => { if (chartRef.current) { chartRef.current.destroy(); chartRef.current = null; } };
-  }, [series]);
+  }, [series, marks]);
 
   // Resize chart when container width changes.
   useEffect(() => {
@@ -171,18 +246,126 @@ function MetricsChart({ series }) {
   return html`<div ref=${containerRef}></div>`;
 }
 
+// Line colours of each annotation kind on charts and in the timeline.
+const MARK_COLORS = { deploy: '#22c55e', deploy_failed: '#f87171', release: '#38bdf8', note: '#f59e0b' };
+
+// annotationMarks turns annotations into chart marks, oldest first.
+const annotationMarks = annotations =>
+  annotations.map(a => ({ ts: new Date(a.at) / 1000, kind: a.kind, title: a.title })).reverse();
+
+// drawMarks draws each mark inside the chart's time range as a dashed line.
+function drawMarks(u, marks) {
+  const { ctx, bbox } = u;
+  const [min, max] = [u.scales.x.min, u.scales.x.max];
+  ctx.save();
+  ctx.lineWidth = devicePixelRatio;
+  ctx.setLineDash([4 * devicePixelRatio, 3 * devicePixelRatio]);
+  for (const m of marks) {
+    if (m.ts < min || m.ts > max) continue;
+    const x = Math.round(u.valToPos(m.ts, 'x', true));
+    ctx.strokeStyle = MARK_COLORS[m.kind] ?? '#94a3b8';
+    ctx.beginPath();
+    ctx.moveTo(x, bbox.top);
+    ctx.lineTo(x, bbox.top + bbox.height);
+    ctx.stroke();
+  }
+  ctx.restore();
+}
+
+const USAGE_LINES = [
+  { label: 'CPU %',    stroke: '#6366f1', fill: 'rgba(99,102,241,0.07)', value: d => d.cpu_percent },
+  { label: 'Memory %', stroke: '#22c55e', fill: 'rgba(34,197,94,0.07)',
+    value: d => d.mem_total > 0 ? (d.mem_used / d.mem_total) * 100 : null },
+  { label: 'Busiest core %', stroke: '#f59e0b', value: d => d.cpu_max_core },
+  { label: 'Swap %',   stroke: '#f87171', fill: 'rgba(248,113,113,0.07)',
+    value: d => d.swap_total > 0 ? (d.swap_used / d.swap_total) * 100 : null },
+];
+
+const LOAD_LINES = [
+  { label: '1 min',  stroke: '#6366f1', value: d => d.load_1 },
+  { label: '5 min',  stroke: '#38bdf8', value: d => d.load_5 },
+  { label: '15 min', stroke: '#475569', value: d => d.load_15 },
+];
+
+const NETWORK_LINES = [
+  { label: 'RX', stroke: '#38bdf8', fill: 'rgba(56,189,248,0.07)', value: d => d.rx_bytes_per_sec },
+  { label: 'TX', stroke: '#f59e0b', fill: 'rgba(245,158,11,0.07)', value: d => d.tx_bytes_per_sec },
+];
+
+const DISK_IO_LINES = [
+  { label: 'Read',  stroke: '#a78bfa', fill: 'rgba(167,139,250,0.07)', value: d => d.read_bytes_per_sec },
+  { label: 'Write', stroke: '#f472b6', fill: 'rgba(244,114,182,0.07)', value: d => d.write_bytes_per_sec },
+];
+
+const TEMP_LINES = [
+  { label: 'Hottest sensor', stroke: '#f87171', fill: 'rgba(248,113,113,0.07)', value: d => d.temp_max },
+];
+
+const PING_LINES = [
+  { label: 'Slowest target', stroke: '#38bdf8', fill: 'rgba(56,189,248,0.07)', value: d => d.ping_max_ms },
+];
+
+const SOCKET_LINES = [
+  { label: 'Open files',      stroke: '#a78bfa', value: d => d.fd_open },
+  { label: 'TCP established', stroke: '#22c55e', value: d => d.tcp_established },
+  { label: 'TCP time-wait',   stroke: '#475569', value: d => d.tcp_time_wait },
+];
+
+// withGaps adds a blank point inside each gap, so a chart shows missing data
+// as a break instead of a line drawn across it, and one at the end of an
+// ongoing gap so the time axis runs up to now.
+function withGaps(series, gaps) {
+  if (gaps.length === 0) return series;
+  const out = [];
+  let g = 0;
+  for (const d of series) {
+    out.push(d);
+    while (g < gaps.length && gaps[g].start <= d.ts) {
+      if (gaps[g].start === d.ts) out.push({ ts: d.ts + 1, gap: true });
+      g++;
+    }
+  }
+  const last = gaps[gaps.length - 1];
+  if (last.ongoing) out.push({ ts: last.end, gap: true });
+  return out;
+}
+
+const fmtPct  = v => v.toFixed(0) + '%';
+const fmtTemp = v => v.toFixed(0) + ' °C';
+const fmtLoad = v => v.toFixed(2);
+const fmtRate = v => fmtBytes(Math.round(v)) + '/s';
+const fmtCount = v => Math.round(v).toLocaleString();
+const fmtMs    = v => (v < 10 ? v.toFixed(1) : Math.round(v)) + ' ms';
+
 // ─── MetricsSection ──────────────────────────────────────────────────────────
 
-function MetricsSection({ data, loading }) {
+function MetricsSection({ data, loading, annotations = [] }) {
   if (loading) {
     return html`<section class="section"><h2 class="section-title">System Metrics</h2><p class="muted">Loading…</p></section>`;
   }
 
   const latest = data?.latest;
-  const series = data?.series ?? [];
+  const gaps   = data?.gaps ?? [];
+  const series = withGaps(data?.series ?? [], gaps);
+  const marks  = annotationMarks(annotations);
+  const inRange = series.length > 0 ? marks.filter(m => m.ts >= series[0].ts) : [];
   const disks  = latest?.disks ?? [];
+  const net    = latest?.net ?? [];
+  const diskIO = latest?.disk_io ?? [];
+  const temps  = latest?.temps ?? [];
+  const pings  = latest?.pings ?? [];
+  const dirs   = [...(latest?.dir_sizes ?? [])].sort((a, b) => b.bytes - a.bytes);
+  const topCPU = latest?.top_cpu ?? [];
+  const topMem = latest?.top_mem ?? [];
+  const failed = latest?.failed_units ?? [];
+  const socks  = latest?.sockets;
+  const custom = Object.entries(latest?.custom_metrics ?? {}).sort(([a], [b]) => a.localeCompare(b));
+  const hot    = t => data?.temp_threshold > 0 && t.celsius >= data.temp_threshold;
+  const drift  = latest?.ntp_offset_ms;
+  const drifting = drift != null && data?.clock_drift_threshold_ms > 0 && Math.abs(drift) > data.clock_drift_threshold_ms;
 
   const cpuPct = latest?.cpu_percent ?? 0;
+  const cores  = latest?.cpu_cores ?? [];
   const memPct = latest ? (latest.mem_used / latest.mem_total) * 100 : 0;
 
   return html`
@@ -191,19 +374,330 @@ function MetricsSection({ data, loading }) {
       ${!latest
         ? html`<p class="muted">No metrics yet — ensure the agent is running and pointed at this server.</p>`
         : html`
+          ${failed.length > 0 ? html`
+            <div class="failed-units">
+              <strong>${failed.length} failed systemd unit${failed.length === 1 ? '' : 's'}</strong>
+              ${failed.map(u => html`<code key=${u}>${u}</code>`)}
+            </div>` : null}
+          ${drifting ? html`
+            <div class="clock-drift">
+              <strong>Clock ${Math.abs(drift).toLocaleString(undefined, { maximumFractionDigits: 1 })} ms ${drift > 0 ? 'ahead of' : 'behind'} NTP</strong>
+              <span>metric and event timestamps from this host are off by as much</span>
+            </div>` : null}
           <div class="gauges-row">
-            <${Gauge} label="CPU" pct=${cpuPct} subtitle="utilization" />
+            <${Gauge} label="CPU" pct=${cpuPct}
+              subtitle="load ${fmtLoad(latest.load_1)} / ${fmtLoad(latest.load_5)} / ${fmtLoad(latest.load_15)}" />
+            ${cores.length > 0 ? html`<${CoreBars} cores=${cores} />` : null}
             <${Gauge} label="Memory" pct=${memPct}
               subtitle="${fmtBytes(latest.mem_used)} / ${fmtBytes(latest.mem_total)}" />
+            ${latest.swap_total > 0 ? html`<${Gauge} label="Swap" pct=${(latest.swap_used / latest.swap_total) * 100}
+              subtitle="${fmtBytes(latest.swap_used)} / ${fmtBytes(latest.swap_total)}" />` : null}
             ${disks.map((d, i) => {
               const dp = d.total > 0 ? (d.used / d.total) * 100 : 0;
+              const ip = d.inodes_total > 0 ? (d.inodes_used / d.inodes_total) * 100 : null;
               return html`<${Gauge} key=${i} label=${d.mount} pct=${dp}
-                subtitle="${fmtBytes(d.used)} / ${fmtBytes(d.total)}" />`;
+                subtitle="${fmtBytes(d.used)} / ${fmtBytes(d.total)}"
+                detail=${ip === null ? null : html`
+                  <div class="gauge-sub" title="${d.inodes_used.toLocaleString()} / ${d.inodes_total.toLocaleString()} inodes"
+                    style=${ip >= 70 ? `color:${gaugeStroke(ip)}` : ''}>inodes ${Math.round(ip)}%</div>`} />`;
             })}
           </div>
+          ${dirs.length > 0 ? html`
+            <div class="rate-list">
+              ${dirs.map(d => html`
+                <span key=${d.path} class="rate-item ${d.error ? 'rate-item-hot' : ''}"
+                  title="scanned ${new Date(d.scanned_at).toLocaleString()}${d.skipped > 0 ? `; ${d.skipped} entries unreadable` : ''}">
+                  ${d.path}: ${d.error ? d.error : `${fmtBytes(d.bytes)} · ${fmtCount(d.files)} files`}
+                </span>`)}
+            </div>` : null}
+          ${gaps.length > 0 ? html`
+            <p class="metrics-gaps">
+              ${gaps.length} gap${gaps.length === 1 ? '' : 's'} in the last 24 h:
+              ${gaps.map(g => html`<span key=${g.start} title="about ${g.missed} samples missed">
+                ${new Date(g.start * 1000).toLocaleString()} for ${fmtDuration(g.end - g.start)}${g.ongoing ? ' (ongoing)' : ''}</span>`)}
+            </p>` : null}
+          <div class="chart-wrap">
+            <${TimeChart} series=${series} lines=${USAGE_LINES} fmtY=${fmtPct} range=${[0, 100]} marks=${marks} />
+          </div>
+          ${inRange.length > 0 ? html`
+            <div class="chart-marks">
+              ${inRange.map((m, i) => html`
+                <span key=${i} style=${`border-color:${MARK_COLORS[m.kind] ?? '#94a3b8'}`}>
+                  ${new Date(m.ts * 1000).toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' })} ${m.title}
+                </span>`)}
+            </div>` : null}
+          <h3 class="chart-title">Load average</h3>
           <div class="chart-wrap">
-            <${MetricsChart} series=${series} />
-          </div>`}
+            <${TimeChart} series=${series} lines=${LOAD_LINES} fmtY=${fmtLoad} marks=${marks} />
+          </div>
+          ${topCPU.length + topMem.length > 0 ? html`
+            <h3 class="chart-title">Top processes</h3>
+            <div class="proc-tables">
+              <${ProcessTable} title="By CPU" procs=${topCPU} value=${p => p.cpu_percent.toFixed(1) + '%'} />
+              <${ProcessTable} title="By memory" procs=${topMem} value=${p => fmtBytes(p.rss)} />
+            </div>` : null}
+          ${net.length > 0 ? html`
+            <h3 class="chart-title">Network</h3>
+            <div class="chart-wrap">
+              <${TimeChart} series=${series} lines=${NETWORK_LINES} fmtY=${fmtRate} marks=${marks} />
+            </div>
+            <div class="rate-list">
+              ${net.map(n => html`
+                <span key=${n.iface} class="rate-item">
+                  ${n.iface}: ↓ ${fmtRate(n.rx_bytes_per_sec)} (${Math.round(n.rx_packets_per_sec)} pkt/s)
+                  · ↑ ${fmtRate(n.tx_bytes_per_sec)} (${Math.round(n.tx_packets_per_sec)} pkt/s)
+                </span>`)}
+            </div>` : null}
+          ${diskIO.length > 0 ? html`
+            <h3 class="chart-title">Disk I/O</h3>
+            <div class="chart-wrap">
+              <${TimeChart} series=${series} lines=${DISK_IO_LINES} fmtY=${fmtRate} marks=${marks} />
+            </div>
+            <div class="rate-list">
+              ${diskIO.map(d => html`
+                <span key=${d.device} class="rate-item">
+                  ${d.device}: ${Math.round(d.reads_per_sec + d.writes_per_sec)} IOPS
+                  · read ${fmtRate(d.read_bytes_per_sec)} · write ${fmtRate(d.write_bytes_per_sec)}
+                </span>`)}
+            </div>` : null}
+          ${socks ? html`
+            <h3 class="chart-title">Open files and TCP connections</h3>
+            <div class="chart-wrap">
+              <${TimeChart} series=${series} lines=${SOCKET_LINES} fmtY=${fmtCount} marks=${marks} />
+            </div>
+            <div class="rate-list">
+              <span class="rate-item">open files: ${fmtCount(socks.fd_open)}${socks.fd_max > 0 ? ` of ${fmtCount(socks.fd_max)}` : ''}</span>
+              <span class="rate-item">
+                TCP: ${fmtCount(socks.tcp_established)} established · ${fmtCount(socks.tcp_inuse)} in use
+                · ${fmtCount(socks.tcp_time_wait)} time-wait
+              </span>
+            </div>` : null}
+          ${temps.length > 0 ? html`
+            <h3 class="chart-title">Temperature</h3>
+            <div class="chart-wrap">
+              <${TimeChart} series=${series} lines=${TEMP_LINES} fmtY=${fmtTemp} marks=${marks} />
+            </div>
+            <div class="rate-list">
+              ${temps.map(t => html`
+                <span key=${t.sensor} class="rate-item ${hot(t) ? 'rate-item-hot' : ''}">
+                  ${t.sensor}: ${t.celsius.toFixed(1)} °C
+                </span>`)}
+            </div>` : null}
+          ${pings.length > 0 ? html`
+            <h3 class="chart-title">Network latency</h3>
+            <div class="chart-wrap">
+              <${TimeChart} series=${series} lines=${PING_LINES} fmtY=${fmtMs} marks=${marks} />
+            </div>
+            <div class="rate-list">
+              ${pings.map(p => html`
+                <span key=${p.target} class="rate-item ${p.loss_percent > 0 ? 'rate-item-hot' : ''}" title=${p.error ?? ''}>
+                  ${p.target}: ${p.rtt_ms != null ? fmtMs(p.rtt_ms) : 'no reply'}${p.loss_percent > 0 && p.rtt_ms != null ? ` · ${Math.round(p.loss_percent)}% loss` : ''}
+                </span>`)}
+            </div>` : null}
+          ${custom.length > 0 ? html`
+            <h3 class="chart-title">Custom metrics</h3>
+            <div class="rate-list">
+              ${custom.map(([k, v]) => html`
+                <span key=${k} class="rate-item">${k}: ${Number.isInteger(v) ? fmtCount(v) : v.toFixed(2)}</span>`)}
+            </div>` : null}`}
+    </section>`;
+}
+
+// ─── ContainersSection ───────────────────────────────────────────────────────
+
+function ContainersSection({ containers }) {
+  if (containers.length === 0) return null;
+  return html`
+    <section class="section">
+      <h2 class="section-title">Containers</h2>
+      <div class="events-table">
+        <div class="containers-header">
+          <span>Container</span>
+          <span>State</span>
+          <span>CPU</span>
+          <span>Memory</span>
+          <span>Restarts (24h)</span>
+        </div>
+        ${containers.map(c => html`
+          <div key=${c.id} class="containers-row">
+            <span class="container-name" title=${c.id}>
+              ${c.name}<span class="container-image">${c.image}</span>
+            </span>
+            <span class="container-state container-state-${c.state === 'running' ? 'up' : 'down'}">${c.state}</span>
+            <span class="container-value">${c.cpu_percent.toFixed(1)}%</span>
+            <span class="container-value">
+              ${fmtBytes(c.mem_used)}${c.mem_limit > 0 ? ` / ${fmtBytes(c.mem_limit)}` : ''}
+            </span>
+            <span class="container-value ${c.restarts_24h > 0 ? 'container-restarted' : ''}">${c.restarts_24h}</span>
+          </div>`)}
+      </div>
+    </section>`;
+}
+
+// ─── VMsSection ──────────────────────────────────────────────────────────────
+
+// A guest links to the host of an agent running inside it and lists the
+// monitors of the same name, so a down monitor can be traced to its VM.
+function VMsSection({ vms }) {
+  if (vms.length === 0) return null;
+  return html`
+    <section class="section">
+      <h2 class="section-title">Virtual machines</h2>
+      <div class="events-table">
+        <div class="vms-header">
+          <span>Guest</span>
+          <span>State</span>
+          <span>CPU</span>
+          <span>Memory</span>
+          <span>Host &amp; monitors</span>
+        </div>
+        ${vms.map(v => html`
+          <div key=${v.id} class="vms-row">
+            <span class="container-name" title=${v.id}>
+              ${v.name || v.id}<span class="container-image">${v.kind}${v.node ? ` on ${v.node}` : ''}</span>
+            </span>
+            <span class="container-state container-state-${v.state === 'running' ? 'up' : 'down'}">${v.state}</span>
+            <span class="container-value">${v.cpu_percent.toFixed(1)}% of ${v.cpus}</span>
+            <span class="container-value">
+              ${fmtBytes(v.mem_used)}${v.mem_total > 0 ? ` / ${fmtBytes(v.mem_total)}` : ''}
+            </span>
+            <span class="vm-links">
+              ${v.host ? html`<span class="vm-host">${v.host}</span>` : null}
+              ${v.monitors.map(m => html`
+                <span key=${m.id} class="vm-monitor container-state-${m.state}" title=${m.state}>${m.name}</span>`)}
+            </span>
+          </div>`)}
+      </div>
+    </section>`;
+}
+
+// ─── AgentsSection ───────────────────────────────────────────────────────────
+
+// Agents older than the server are listed first and flagged, so they can be
+// upgraded with `agent install`.
+function AgentsSection({ hosts }) {
+  const agents = hosts.filter(h => h.agent)
+    .sort((a, b) => (b.outdated - a.outdated) || a.name.localeCompare(b.name));
+  if (agents.length === 0) return null;
+  return html`
+    <section class="section">
+      <h2 class="section-title">Agents</h2>
+      <div class="events-table">
+        <div class="agents-header">
+          <span>Host</span>
+          <span>Version</span>
+          <span>Platform</span>
+          <span>Kernel</span>
+          <span>Arch</span>
+        </div>
+        ${agents.map(h => html`
+          <div key=${h.id} class="agents-row">
+            <span class="container-name">${h.name}</span>
+            <span class="container-value ${h.outdated ? 'container-restarted' : ''}" title=${h.outdated ? 'older than the server' : ''}>
+              ${h.agent.version}${h.outdated ? ' ⚠' : ''}
+            </span>
+            <span class="container-value">${h.agent.platform || h.agent.os}</span>
+            <span class="container-value">${h.agent.kernel || '—'}</span>
+            <span class="container-value">${h.agent.arch}</span>
+          </div>`)}
+      </div>
+    </section>`;
+}
+
+// ─── BackupsSection ──────────────────────────────────────────────────────────
+
+const BACKUP_STATE = { ok: 'up', failed: 'down', stale: 'down' };
+
+function BackupsSection({ backups }) {
+  if (backups.length === 0) return null;
+  const ago = t => t ? `${fmtDuration(Math.max(0, Math.floor((Date.now() - new Date(t)) / 1000)))} ago` : 'never';
+  return html`
+    <section class="section">
+      <h2 class="section-title">Backups</h2>
+      <div class="events-table">
+        <div class="backups-header">
+          <span>Repository</span>
+          <span>Status</span>
+          <span>Last success</span>
+          <span>Last run</span>
+          <span>Size (added)</span>
+        </div>
+        ${backups.map(b => html`
+          <div key=${b.repository} class="backups-row">
+            <span class="container-name" title=${b.last_run.snapshot_id}>
+              ${b.repository}<span class="container-image">${[b.last_run.tool, b.last_run.host].filter(Boolean).join(' on ')}</span>
+            </span>
+            <span class="container-state container-state-${BACKUP_STATE[b.status]}">${b.status}</span>
+            <span class="container-value" title=${`max ${b.max_age_hours}h`}>${ago(b.last_success_at)}</span>
+            <span class="container-value" title=${b.last_run.error ?? ''}>
+              ${ago(b.last_run.finished_at)}${b.last_run.duration_seconds != null ? `, took ${fmtDuration(Math.round(b.last_run.duration_seconds))}` : ''}
+            </span>
+            <span class="container-value">
+              ${b.last_run.size_bytes != null ? fmtBytes(b.last_run.size_bytes) : '—'}${b.last_run.added_bytes != null ? ` (+${fmtBytes(b.last_run.added_bytes)})` : ''}
+            </span>
+          </div>`)}
+      </div>
+    </section>`;
+}
+
+// ─── ReachabilitySection ─────────────────────────────────────────────────────
+
+// Each row is a checking host and each column the host it checked, so a
+// red column is a host nobody can reach and a red row one that reaches no one.
+function ReachabilitySection({ data }) {
+  if (!data || data.checks.length === 0) return null;
+  const cells = new Map(data.checks.map(c => [`${c.from}\n${c.to}`, c]));
+  const cell = (from, to) => {
+    if (from === to) return html`<span class="reach-cell reach-self">—</span>`;
+    const c = cells.get(`${from}\n${to}`);
+    if (!c) return html`<span class="reach-cell"></span>`;
+    const state = c.rtt_ms == null ? 'down' : c.loss_percent > 0 ? 'lossy' : 'up';
+    const title = `${from} → ${to} (${c.method} ${c.target})${c.loss_percent > 0 ? ` · ${Math.round(c.loss_percent)}% loss` : ''}${c.error ? ` · ${c.error}` : ''}`;
+    return html`
+      <span class="reach-cell reach-${state}" title=${title}>
+        ${c.rtt_ms != null ? fmtMs(c.rtt_ms) : '✕'}
+      </span>`;
+  };
+  return html`
+    <section class="section">
+      <h2 class="section-title">Reachability</h2>
+      <div class="reach-grid" style=${{ gridTemplateColumns: `minmax(6rem, auto) repeat(${data.hosts.length}, minmax(4.5rem, 1fr))` }}>
+        <span class="reach-corner">from ↓ to →</span>
+        ${data.hosts.map(to => html`<span key=${to} class="reach-head" title=${to}>${to}</span>`)}
+        ${data.hosts.map(from => [
+          html`<span key=${from} class="reach-head reach-row" title=${from}>${from}</span>`,
+          ...data.hosts.map(to => cell(from, to)),
+        ])}
+      </div>
+    </section>`;
+}
+
+// ─── TimelineSection ─────────────────────────────────────────────────────────
+
+const ANNOTATION_STATE = { deploy: 'up', deploy_failed: 'down', release: 'up', note: 'up' };
+
+function TimelineSection({ annotations }) {
+  if (annotations.length === 0) return null;
+  return html`
+    <section class="section">
+      <h2 class="section-title">Timeline</h2>
+      <div class="events-table">
+        <div class="timeline-header">
+          <span>When</span>
+          <span>Kind</span>
+          <span>What</span>
+          <span>By</span>
+        </div>
+        ${annotations.map(a => html`
+          <div key=${a.id} class="timeline-row">
+            <span class="container-value" title=${a.source}>${new Date(a.at).toLocaleString()}</span>
+            <span class="container-state container-state-${ANNOTATION_STATE[a.kind] ?? 'up'}">${a.kind.replace('_', ' ')}</span>
+            <span class="container-name">
+              ${a.url ? html`<a class="timeline-link" href=${a.url} target="_blank" rel="noopener">${a.title}</a>` : a.title}
+            </span>
+            <span class="container-value">${a.author ?? ''}</span>
+          </div>`)}
+      </div>
     </section>`;
 }
 
@@ -234,28 +728,64 @@ function EventsSection({ events, loading }) {
     </section>`;
 }
 
+// ─── Workspace picker ────────────────────────────────────────────────────────
+
+function WorkspacePicker() {
+  const [spaces, setSpaces] = useState([]);
+
+  useEffect(() => {
+    apiFetch('/api/workspaces').then(ws => setSpaces(ws ?? [])).catch(() => {});
+  }, []);
+
+  if (spaces.length < 2) return null;
+  const current = WORKSPACE ?? '1';
+  return html`
+    <select class="workspace-select" value=${current}
+      onChange=${e => { window.location.search = `?workspace=${e.target.value}`; }}>
+      ${spaces.map(ws => html`<option key=${ws.id} value=${String(ws.id)}>${ws.name}</option>`)}
+    </select>`;
+}
+
 // ─── App ─────────────────────────────────────────────────────────────────────
 
 function App() {
   const [monitors, setMonitors] = useState([]);
   const [metrics,  setMetrics]  = useState(null);
   const [events,   setEvents]   = useState([]);
+  const [containers, setContainers] = useState([]);
+  const [vms,      setVMs]      = useState([]);
+  const [backups,  setBackups]  = useState([]);
+  const [annotations, setAnnotations] = useState([]);
+  const [hosts,    setHosts]    = useState([]);
+  const [reach,    setReach]    = useState(null);
   const [loading,  setLoading]  = useState(true);
   const [updated,  setUpdated]  = useState(null);
   const [error,    setError]    = useState(null);
 
   const fetchAll = useCallback(async () => {
     try {
-      const [mon, met, evt] = await Promise.all([
-        apiFetch('/api/dashboard/monitors'),
-        apiFetch('/api/dashboard/metrics'),
-        apiFetch('/api/dashboard/events'),
+      const [mon, met, evt, ctr, vm, bak, ann, hst, rch] = await Promise.all([
+        apiFetch(scoped('/api/dashboard/monitors')),
+        apiFetch(scoped('/api/dashboard/metrics')),
+        apiFetch(scoped('/api/dashboard/events')),
+        apiFetch(scoped('/api/dashboard/containers')),
+        apiFetch(scoped('/api/dashboard/vms')),
+        apiFetch(scoped('/api/dashboard/backups')),
+        apiFetch(scoped('/api/dashboard/annotations')),
+        apiFetch(scoped('/api/hosts')),
+        apiFetch(scoped('/api/dashboard/reachability')),
       ]);
       // null means a 401 redirect is in progress — bail out silently.
-      if (mon === null || met === null || evt === null) return;
+      if (mon === null || met === null || evt === null || ctr === null || vm === null || bak === null || ann === null || hst === null || rch === null) return;
       setMonitors(mon ?? []);
       setMetrics(met);
       setEvents(evt ?? []);
+      setContainers(ctr ?? []);
+      setVMs(vm ?? []);
+      setBackups(bak ?? []);
+      setAnnotations(ann ?? []);
+      setHosts(hst ?? []);
+      setReach(rch);
       setUpdated(new Date());
       setError(null);
     } catch (err) {
@@ -276,8 +806,10 @@ function App() {
       <header class="topbar">
         <span class="topbar-title">Health Dashboard</span>
         <div class="topbar-right">
+          <${WorkspacePicker} />
           ${error ? html`<span class="topbar-error">⚠ ${error}</span>` : null}
           ${updated ? html`<span class="topbar-updated">Updated ${updated.toLocaleTimeString()}</span>` : null}
+          <a class="topbar-link" href="/security">Security</a>
           <form method="POST" action="/logout" style="margin:0">
             <button type="submit" class="logout-btn">Sign out</button>
           </form>
@@ -285,7 +817,13 @@ function App() {
       </header>
       <main class="main">
         <${MonitorsSection} monitors=${monitors} loading=${loading} />
-        <${MetricsSection}  data=${metrics}      loading=${loading} />
+        <${MetricsSection}  data=${metrics}      loading=${loading} annotations=${annotations} />
+        <${ContainersSection} containers=${containers} />
+        <${VMsSection} vms=${vms} />
+        <${BackupsSection} backups=${backups} />
+        <${TimelineSection} annotations=${annotations} />
+        <${AgentsSection} hosts=${hosts} />
+        <${ReachabilitySection} data=${reach} />
         <${EventsSection}   events=${events}     loading=${loading} />
       </main>
     </div>`;
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"health-dashboard/internal/statuspage"
+)
+
+var statusLabels = map[string]string{
+	statuspage.StatusOperational:   "Operational",
+	statuspage.StatusUnknown:       "No data",
+	statuspage.StatusPartialOutage: "Partial outage",
+	statuspage.StatusMajorOutage:   "Major outage",
+}
+
+var impactLabels = map[string]string{
+	statuspage.ImpactNone:     "No impact",
+	statuspage.ImpactMinor:    "Minor",
+	statuspage.ImpactMajor:    "Major",
+	statuspage.ImpactCritical: "Critical",
+}
+
+var statusTmpl = template.Must(template.New("status").Funcs(template.FuncMap{
+	"label":  func(s string) string { return statusLabels[s] },
+	"impact": func(s string) string { return impactLabels[s] },
+	"title":  func(s string) string { return strings.ToUpper(s[:1]) + s[1:] },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <meta http-equiv="refresh" content="60">
+  <title>{{.Name}} status</title>
+  <style>
+    *, *::before, *::after { box-sizing: border-box; margin: 0; padding: 0; }
+    body {
+      font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+      background: #0f1117;
+      color: #e2e8f0;
+      padding: 3rem 1.5rem;
+    }
+    main { max-width: 720px; margin: 0 auto; }
+    h1 { font-size: 1.5rem; margin-bottom: 1.5rem; color: #f8fafc; }
+    .banner { padding: 1rem 1.25rem; border-radius: 8px; font-weight: 600; margin-bottom: 2rem; }
+    .card {
+      background: #1a1d27;
+      border: 1px solid #2d3148;
+      border-radius: 8px;
+      margin-bottom: 1rem;
+    }
+    .component { display: flex; justify-content: space-between; padding: 1rem 1.25rem; font-weight: 600; }
+    .monitor { display: flex; justify-content: space-between; padding: 0.6rem 1.25rem 0.6rem 2rem; border-top: 1px solid #1f2333; font-size: 0.9rem; color: #cbd5e1; }
+    .known-issue { padding: 0 1.25rem 0.6rem 2rem; font-size: 0.85rem; color: #fcd34d; white-space: pre-wrap; }
+    .known-issue time { display: block; font-size: 0.75rem; color: #64748b; }
+    .operational    { color: #4ade80; }
+    .unknown        { color: #94a3b8; }
+    .partial_outage { color: #f59e0b; }
+    .major_outage   { color: #f87171; }
+    .banner.operational    { background: rgba(74,222,128,0.12); }
+    .banner.unknown        { background: rgba(148,163,184,0.12); }
+    .banner.partial_outage { background: rgba(245,158,11,0.12); }
+    .banner.major_outage   { background: rgba(248,113,113,0.12); }
+    h2 { font-size: 1rem; margin: 2rem 0 1rem; color: #f8fafc; }
+    .incident { padding: 1rem 1.25rem; }
+    .incident-title { display: flex; justify-content: space-between; font-weight: 600; }
+    .incident-meta { font-size: 0.8rem; color: #64748b; margin-top: 0.25rem; }
+    .update { padding: 0.6rem 0 0; font-size: 0.9rem; color: #cbd5e1; white-space: pre-wrap; }
+    .update b { color: #f8fafc; }
+    .update time, .postmortem h3 { display: block; font-size: 0.75rem; color: #64748b; font-weight: 400; }
+    .postmortem { margin-top: 0.8rem; padding-top: 0.6rem; border-top: 1px solid #1f2333; font-size: 0.9rem; color: #cbd5e1; white-space: pre-wrap; }
+    .impact-minor    { color: #f59e0b; }
+    .impact-major    { color: #fb923c; }
+    .impact-critical { color: #f87171; }
+    .impact-none     { color: #94a3b8; }
+    footer { color: #475569; font-size: 0.8rem; margin-top: 2rem; }
+  </style>
+</head>
+<body>
+  <main>
+    <h1>{{.Name}}</h1>
+    <div class="banner {{.Page.Status}}">
+      {{if eq .Page.Status "operational"}}All systems operational{{else}}{{label .Page.Status}}{{end}}
+    </div>
+    {{range .Page.Incidents}}{{if not .ResolvedAt}}
+    <div class="card incident">
+      <div class="incident-title"><span>{{.Title}}</span><span class="impact-{{.Impact}}">{{impact .Impact}}</span></div>
+      {{range .Updates}}
+      <div class="update"><b>{{title .Status}}</b> — {{.Message}}<time>{{.CreatedAt.Format "2006-01-02 15:04"}} UTC</time></div>
+      {{end}}
+    </div>
+    {{end}}{{end}}
+    {{range .Page.Components}}
+    <div class="card">
+      <div class="component"><span>{{.Name}}</span><span class="{{.Status}}">{{label .Status}}</span></div>
+      {{range .Monitors}}
+      <div class="monitor"><span>{{.Name}}</span><span class="{{.Status}}">{{label .Status}}</span></div>
+      {{if .KnownIssue}}<div class="known-issue">Known issue: {{.KnownIssue}}<time>Until {{.KnownIssueUntil.Format "2006-01-02 15:04"}} UTC</time></div>{{end}}
+      {{end}}
+    </div>
+    {{end}}
+    {{if .Resolved}}
+    <h2>Past incidents</h2>
+    {{range .Resolved}}
+    <div class="card incident">
+      <div class="incident-title"><span>{{.Title}}</span><span class="impact-{{.Impact}}">{{impact .Impact}}</span></div>
+      <div class="incident-meta">Resolved {{.ResolvedAt.Format "2006-01-02 15:04"}} UTC</div>
+      {{range .Updates}}
+      <div class="update"><b>{{title .Status}}</b> — {{.Message}}<time>{{.CreatedAt.Format "2006-01-02 15:04"}} UTC</time></div>
+      {{end}}
+      {{if .Postmortem}}<div class="postmortem"><h3>Postmortem</h3>{{.Postmortem}}</div>{{end}}
+    </div>
+    {{end}}
+    {{end}}
+    <footer>Updated {{.Page.UpdatedAt.Format "2006-01-02 15:04"}} UTC</footer>
+  </main>
+</body>
+</html>`))
+
+// handleStatusPage serves GET /status, the public status page for the
+// workspace named by ?workspace= (default workspace otherwise). It shows only
+// the curated components and display names, never internal monitor data.
+func (s *server) handleStatusPage(w http.ResponseWriter, r *http.Request) {
+	wsID, ok := s.queryWorkspace(w, r, anyWorkspace)
+	if !ok {
+		return
+	}
+	ws, err := s.spaces.Get(wsID)
+	if err != nil {
+		log.Printf("status page: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	page, err := s.statusPage(wsID)
+	if err != nil {
+		log.Printf("status page: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	var resolved []statuspage.PublicIncident
+	for _, inc := range page.Incidents {
+		if inc.ResolvedAt != nil {
+			resolved = append(resolved, inc)
+		}
+	}
+	statusTmpl.Execute(w, struct {
+		Name     string
+		Page     *statuspage.Page
+		Resolved []statuspage.PublicIncident
+	}{ws.Name, page, resolved})
+}
+
+// handleStatusJSON serves GET /api/status, the status page as JSON for
+// embedding elsewhere. Public, like /status.
+func (s *server) handleStatusJSON(w http.ResponseWriter, r *http.Request) {
+	wsID, ok := s.queryWorkspace(w, r, anyWorkspace)
+	if !ok {
+		return
+	}
+	page, err := s.statusPage(wsID)
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	s.writeListJSON(w, r, page)
+}
+
+// statusPage returns the workspace's public status page, cached
+// (server.cache_seconds) since it is the page most likely to draw a crowd.
+func (s *server) statusPage(wsID int64) (*statuspage.Page, error) {
+	page, err := s.cache.get(cacheStatusPage, wsID, func() (any, error) {
+		return s.pages.Page(wsID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page.(*statuspage.Page), nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"health-dashboard/internal/config"
+	"health-dashboard/internal/monitor"
+	"health-dashboard/internal/rules"
+)
+
+// tempWatch fires temperature alerts from agent payloads. It tracks each
+// sensor with an alert rule, so a webhook goes out once per crossing, not on
+// every payload, and alerts.temperature_for_seconds and temperature_clear
+// apply as for_seconds and clear_threshold do in rules.
+type tempWatch struct {
+	rule    rules.Rule
+	alerter *monitor.Alerter
+
+	mu      sync.Mutex
+	sensors map[tempKey]*rules.Watch
+}
+
+// tempKey identifies a sensor. Hosts in a workspace often share sensor
+// names, such as coretemp/Package id 0, so the host is part of it.
+type tempKey struct {
+	workspace int64
+	host      string
+	sensor    string
+}
+
+// newTempWatch returns a tempWatch for a's temperature settings; a threshold
+// of 0 disables alerts.
+func newTempWatch(a config.AlertsConfig, alerter *monitor.Alerter) *tempWatch {
+	return &tempWatch{
+		rule: rules.Rule{Kind: rules.KindMetric, Metric: "temp_max", Op: ">=", Threshold: a.TemperatureThreshold,
+			ClearThreshold: a.TemperatureClear, ForSeconds: a.TemperatureForSeconds},
+		alerter: alerter,
+		sensors: make(map[tempKey]*rules.Watch),
+	}
+}
+
+// observe feeds a payload's sensors from host, collected at, to their rules
+// and alerts on changes: "temperature_high" when a sensor starts firing, and
+// "temperature_ok" when it clears.
+func (t *tempWatch) observe(workspaceID int64, host string, temps []tempInfo, at time.Time) {
+	if t.rule.Threshold <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range temps {
+		key := tempKey{workspaceID, host, s.Sensor}
+		w := t.sensors[key]
+		if w == nil {
+			w = &rules.Watch{Rule: t.rule}
+			t.sensors[key] = w
+		}
+		firing, changed := w.Observe(rules.Point{At: at, Value: s.Celsius})
+		if !changed {
+			continue
+		}
+		status := "temperature_ok"
+		if firing {
+			status = "temperature_high"
+		}
+		detail := fmt.Sprintf("%s on %s at %.1f °C (threshold %.1f °C)", s.Sensor, host, s.Celsius, t.rule.Threshold)
+		go t.alerter.NotifyMetric(workspaceID, "temperature: "+host+" "+s.Sensor, status, detail)
+	}
+}
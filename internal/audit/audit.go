@@ -0,0 +1,106 @@
+// Package audit records dashboard login attempts so suspicious access can be
+// spotted after the fact.
+package audit
+
+import (
+	"database/sql"
+	"net"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Login is one recorded login attempt.
+type Login struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Success   bool      `json:"success"`
+	Role      string    `json:"role"` // granted role; empty for failures
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Country   string    `json:"country"` // ISO code from the GeoIP database, if any
+	City      string    `json:"city"`
+}
+
+// Log stores login attempts, enriching them with a location when a GeoIP
+// database is configured.
+type Log struct {
+	db  *sql.DB
+	geo *maxminddb.Reader // nil when GeoIP is disabled
+}
+
+// Open creates a Log backed by db. geoipPath is an optional MaxMind DB
+// (GeoLite2-City or -Country format); empty disables location lookups.
+func Open(db *sql.DB, geoipPath string) (*Log, error) {
+	l := &Log{db: db}
+	if geoipPath != "" {
+		r, err := maxminddb.Open(geoipPath)
+		if err != nil {
+			return nil, err
+		}
+		l.geo = r
+	}
+	return l, nil
+}
+
+// Close releases the GeoIP database.
+func (l *Log) Close() error {
+	if l.geo == nil {
+		return nil
+	}
+	return l.geo.Close()
+}
+
+// Record stores a login attempt from ip.
+func (l *Log) Record(success bool, role, ip, userAgent string) error {
+	country, city := l.locate(ip)
+	_, err := l.db.Exec(`
+		INSERT INTO login_events (success, role, ip, user_agent, country, city)
+		VALUES (?, ?, ?, ?, ?, ?)`, success, role, ip, userAgent, country, city)
+	return err
+}
+
+// Recent returns up to limit login attempts, newest first.
+func (l *Log) Recent(limit int) ([]Login, error) {
+	rows, err := l.db.Query(`
+		SELECT id, created_at, success, role, ip, user_agent, country, city
+		FROM login_events ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Login{}
+	for rows.Next() {
+		var e Login
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.Success, &e.Role, &e.IP, &e.UserAgent, &e.Country, &e.City); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// geoRecord is the subset of the GeoLite2 record we use.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// locate returns the country code and English city name for ip, or empty
+// strings when unknown (no database, private address, lookup failure).
+func (l *Log) locate(ip string) (country, city string) {
+	addr := net.ParseIP(ip)
+	if l.geo == nil || addr == nil {
+		return "", ""
+	}
+	var rec geoRecord
+	if err := l.geo.Lookup(addr, &rec); err != nil {
+		return "", ""
+	}
+	return rec.Country.ISOCode, rec.City.Names["en"]
+}
@@ -2,10 +2,18 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -16,62 +24,224 @@ const (
 	sessionDuration   = 24 * time.Hour
 )
 
-type session struct {
-	createdAt time.Time
+// Role is what a session may do.
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"  // full access
+	RoleViewer Role = "viewer" // read-only; credentials are redacted
+)
+
+// A Grant is what a session may do: its role, within its workspaces.
+type Grant struct {
+	User       string  `json:"user"` // "" for a login with auth.password or auth.viewer_password
+	Role       Role    `json:"role"`
+	Workspaces []int64 `json:"workspaces"` // nil allows every workspace
+}
+
+// Allows reports whether g may use workspace id.
+func (g Grant) Allows(id int64) bool {
+	return g.Workspaces == nil || slices.Contains(g.Workspaces, id)
+}
+
+// SessionInfo describes a live session for listing and revocation.
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used"`
+	Grant
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Current   bool   `json:"current"`
+}
+
+// encodeWorkspaces and decodeWorkspaces convert a Grant's workspaces to and
+// from the sessions table's comma-separated list, "" for every workspace.
+func encodeWorkspaces(ids []int64) string {
+	if ids == nil {
+		return ""
+	}
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(s, ",")
+}
+
+func decodeWorkspaces(s string) ([]int64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var ids []int64
+	for _, v := range strings.Split(s, ",") {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("session workspaces %q: %v", s, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
 }
 
-// Store is a thread-safe in-memory session store.
+// Store keeps sessions in the sessions table, so every instance sharing the
+// database honours them and they survive restarts. Only HashToken digests of
+// the cookie values are stored.
 type Store struct {
-	mu       sync.RWMutex
-	sessions map[string]session
+	db *sql.DB
 }
 
-func NewStore() *Store {
-	s := &Store{sessions: make(map[string]session)}
+// NewStore returns a Store backed by db and starts removing expired
+// sessions from it.
+func NewStore(db *sql.DB) *Store {
+	s := &Store{db: db}
 	go s.cleanup()
 	return s
 }
 
+// sessionLive is the SQL condition for an unexpired session.
+var sessionLive = fmt.Sprintf("created_at > datetime('now', '-%d seconds')", int(sessionDuration.Seconds()))
+
 func (s *Store) cleanup() {
 	t := time.NewTicker(15 * time.Minute)
 	defer t.Stop()
 	for range t.C {
-		s.mu.Lock()
-		for token, sess := range s.sessions {
-			if time.Since(sess.createdAt) > sessionDuration {
-				delete(s.sessions, token)
-			}
+		if _, err := s.db.Exec(`DELETE FROM sessions WHERE NOT (` + sessionLive + `)`); err != nil {
+			log.Printf("sessions: cleanup: %v", err)
 		}
-		s.mu.Unlock()
 	}
 }
 
-func (s *Store) Create() (string, error) {
-	b := make([]byte, 32)
+// Create starts a session with grant g for the client making r.
+func (s *Store) Create(g Grant, r *http.Request) (string, error) {
+	if g.Workspaces != nil && len(g.Workspaces) == 0 {
+		return "", errors.New("grant allows no workspace")
+	}
+	b := make([]byte, 40)
 	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
-	token := hex.EncodeToString(b)
-	s.mu.Lock()
-	s.sessions[token] = session{createdAt: time.Now()}
-	s.mu.Unlock()
+	token := hex.EncodeToString(b[:32])
+	_, err := s.db.Exec(`
+		INSERT INTO sessions (token_hash, id, username, role, workspaces, ip, user_agent, created_at, last_used)
+		VALUES (?, ?, ?, ?, ?, ?, ?, datetime('now'), datetime('now'))`,
+		HashToken(token), hex.EncodeToString(b[32:]), g.User, g.Role, encodeWorkspaces(g.Workspaces), ClientIP(r), r.UserAgent())
+	if err != nil {
+		return "", err
+	}
 	return token, nil
 }
 
 func (s *Store) Valid(token string) bool {
+	_, ok := s.Grant(token)
+	return ok
+}
+
+// Grant returns the grant of a live session and marks it as used.
+// last_used is only rewritten once a minute, so reads don't each cost a
+// write. A database error counts as no session.
+func (s *Store) Grant(token string) (Grant, bool) {
 	if token == "" {
-		return false
+		return Grant{}, false
+	}
+	var g Grant
+	var workspaces string
+	var stale bool
+	err := s.db.QueryRow(`
+		SELECT username, role, workspaces, last_used < datetime('now', '-1 minute') FROM sessions
+		WHERE token_hash = ? AND `+sessionLive, HashToken(token)).Scan(&g.User, &g.Role, &workspaces, &stale)
+	if err == nil {
+		g.Workspaces, err = decodeWorkspaces(workspaces)
+	}
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("sessions: %v", err)
+		}
+		return Grant{}, false
+	}
+	if stale {
+		if _, err := s.db.Exec(`UPDATE sessions SET last_used = datetime('now') WHERE token_hash = ?`, HashToken(token)); err != nil {
+			log.Printf("sessions: mark used: %v", err)
+		}
+	}
+	return g, true
+}
+
+const sessionCols = `token_hash, id, created_at, last_used, username, role, workspaces, ip, user_agent`
+
+func scanSession(sc interface{ Scan(...any) error }, current string) (SessionInfo, error) {
+	var info SessionInfo
+	var hash, workspaces string
+	if err := sc.Scan(&hash, &info.ID, &info.CreatedAt, &info.LastUsed, &info.User, &info.Role, &workspaces, &info.IP, &info.UserAgent); err != nil {
+		return SessionInfo{}, err
+	}
+	var err error
+	if info.Workspaces, err = decodeWorkspaces(workspaces); err != nil {
+		return SessionInfo{}, err
+	}
+	info.CreatedAt, info.LastUsed = info.CreatedAt.UTC(), info.LastUsed.UTC()
+	info.Current = current != "" && hash == HashToken(current)
+	return info, nil
+}
+
+// Info describes the live session with the given token.
+func (s *Store) Info(token string) (SessionInfo, bool) {
+	if token == "" {
+		return SessionInfo{}, false
+	}
+	info, err := scanSession(s.db.QueryRow(`SELECT `+sessionCols+` FROM sessions WHERE token_hash = ? AND `+sessionLive, HashToken(token)), token)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("sessions: %v", err)
+		}
+		return SessionInfo{}, false
+	}
+	return info, true
+}
+
+// List returns the live sessions, newest first. current is the caller's
+// token, flagged in the result.
+func (s *Store) List(current string) ([]SessionInfo, error) {
+	rows, err := s.db.Query(`SELECT ` + sessionCols + ` FROM sessions WHERE ` + sessionLive + ` ORDER BY created_at DESC, rowid DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []SessionInfo{}
+	for rows.Next() {
+		info, err := scanSession(rows, current)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, info)
+	}
+	return list, rows.Err()
+}
+
+// Revoke deletes the session with the given public ID and reports whether
+// it existed.
+func (s *Store) Revoke(id string) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	if err != nil {
+		return false, err
 	}
-	s.mu.RLock()
-	sess, ok := s.sessions[token]
-	s.mu.RUnlock()
-	return ok && time.Since(sess.createdAt) < sessionDuration
+	n, err := res.RowsAffected()
+	return n > 0, err
 }
 
-func (s *Store) Delete(token string) {
-	s.mu.Lock()
-	delete(s.sessions, token)
-	s.mu.Unlock()
+// RevokeOthers deletes every session except keep and returns how many
+// were removed.
+func (s *Store) RevokeOthers(keep string) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE token_hash != ?`, HashToken(keep))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *Store) Delete(token string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE token_hash = ?`, HashToken(token))
+	return err
 }
 
 // CheckPassword verifies a submitted password against the stored credential.
@@ -82,7 +252,58 @@ func CheckPassword(stored, submitted string) bool {
 		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(submitted)) == nil
 	}
 	// Plaintext fallback — acceptable only in dev environments.
-	return stored == submitted
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(submitted)) == 1
+}
+
+// TokenMatches reports whether got equals one of the accepted tokens, in
+// time independent of where (or whether) it matches. An accepted token may be
+// given as its HashToken digest. Empty tokens never match.
+func TokenMatches(got string, accepted []string) bool {
+	if got == "" {
+		return false
+	}
+	hashed := HashToken(got)
+	match := 0
+	for _, want := range accepted {
+		switch {
+		case want == "":
+		case IsHashedToken(want):
+			match |= subtle.ConstantTimeCompare([]byte(hashed), []byte(want))
+		default:
+			match |= subtle.ConstantTimeCompare([]byte(got), []byte(want))
+		}
+	}
+	return match == 1
+}
+
+// hashedTokenPrefix marks a token stored as its SHA-256 digest.
+const hashedTokenPrefix = "sha256:"
+
+// HashToken returns the form in which token is stored at rest:
+// "sha256:<hex digest>". Agent tokens and API keys are long random strings,
+// so unlike passwords they need no slow, salted hash.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hashedTokenPrefix + hex.EncodeToString(sum[:])
+}
+
+// IsHashedToken reports whether s is a HashToken digest rather than a
+// plaintext token.
+func IsHashedToken(s string) bool {
+	return strings.HasPrefix(s, hashedTokenPrefix) && len(s) == len(hashedTokenPrefix)+2*sha256.Size
+}
+
+// TokenLookupLen is how many leading characters of a generated token are
+// kept in plaintext, to find its stored digest without trying every one.
+const TokenLookupLen = 8
+
+// TokenLookup returns the lookup prefix of token, or "" if it is too short
+// to be a generated one.
+func TokenLookup(token string) string {
+	if len(token) < 2*TokenLookupLen {
+		return ""
+	}
+	return token[:TokenLookupLen]
 }
 
 // HashPassword returns a bcrypt hash of password.
@@ -94,6 +315,15 @@ func HashPassword(password string) (string, error) {
 	return string(h), nil
 }
 
+// ClientIP returns the address of the client that sent r, without the port.
+func ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
 func SetSessionCookie(w http.ResponseWriter, token string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     sessionCookieName,
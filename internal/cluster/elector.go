@@ -0,0 +1,170 @@
+// Package cluster coordinates multiple server instances that share one
+// database, so that background work runs on exactly one of them.
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// leaseName is the lease row guarding the checker and alerter.
+const leaseName = "checker"
+
+// Elector holds a lease row in the shared database, bumping its term on
+// every renewal. The node holding the lease is the leader; another takes
+// over once it has watched the term stay unchanged for the ttl. Both sides
+// measure time on their own monotonic clock, so nodes' wall clocks need
+// not agree.
+type Elector struct {
+	db     *sql.DB
+	nodeID string
+	ttl    time.Duration
+	leader atomic.Bool
+
+	// The lease as this node last saw it held by another, and when it
+	// changed; only Run's goroutine uses them.
+	seenHolder string
+	seenTerm   int64
+	seenAt     time.Time
+}
+
+// NewElector creates an Elector that identifies itself as nodeID and holds
+// the lease for ttl between renewals.
+func NewElector(db *sql.DB, nodeID string, ttl time.Duration) *Elector {
+	return &Elector{db: db, nodeID: nodeID, ttl: ttl}
+}
+
+// IsLeader reports whether this instance currently holds the lease.
+func (e *Elector) IsLeader() bool { return e.leader.Load() }
+
+// Run blocks until ctx is cancelled. Each time this node acquires the lease
+// it calls lead in a goroutine; lead's context is cancelled when the lease
+// is lost or ctx ends, and Run waits for lead to return before competing
+// again. On exit the lease is released so a standby can take over at once.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context)) {
+	renew := e.ttl / 3
+	for {
+		ok, err := e.acquire()
+		if err != nil {
+			log.Printf("cluster: acquire lease: %v", err)
+		}
+		if ok {
+			log.Printf("cluster: %s is now leader", e.nodeID)
+			e.hold(ctx, lead, renew)
+			log.Printf("cluster: %s stepped down", e.nodeID)
+		}
+		select {
+		case <-ctx.Done():
+			if err := e.release(); err != nil {
+				log.Printf("cluster: release lease: %v", err)
+			}
+			return
+		case <-time.After(renew):
+		}
+	}
+}
+
+// hold runs lead while renewing the lease. A renewal that fails with a
+// database error is retried until the lease would have expired.
+func (e *Elector) hold(ctx context.Context, lead func(ctx context.Context), renew time.Duration) {
+	e.leader.Store(true)
+	defer e.leader.Store(false)
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(leadCtx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	lastRenewed := time.Now()
+	ticker := time.NewTicker(renew)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+			ok, err := e.acquire()
+			switch {
+			case err != nil:
+				log.Printf("cluster: renew lease: %v", err)
+				// Step down before the lease can lapse and another node takes over.
+				if time.Since(lastRenewed) > e.ttl-renew {
+					return
+				}
+			case !ok:
+				log.Printf("cluster: lease taken over by another node")
+				return
+			default:
+				lastRenewed = time.Now()
+			}
+		}
+	}
+}
+
+// acquire takes or renews the lease. It succeeds if the lease is free,
+// already held by this node, or another node's term has not moved for the
+// ttl, judged by this node's clock from when it saw the term change. Taking
+// over compares and swaps the term, so only one standby wins.
+func (e *Elector) acquire() (bool, error) {
+	expires := fmt.Sprintf("+%d seconds", int(e.ttl.Seconds()))
+	var holder string
+	var term int64
+	err := e.db.QueryRow(`SELECT holder, term FROM leader_lease WHERE name = ?`, leaseName).Scan(&holder, &term)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		res, err := e.db.Exec(`
+			INSERT INTO leader_lease (name, holder, term, expires_at) VALUES (?, ?, 1, datetime('now', ?))
+			ON CONFLICT(name) DO NOTHING`, leaseName, e.nodeID, expires)
+		return rowsChanged(res, err)
+	case err != nil:
+		return false, err
+	case holder == e.nodeID:
+		res, err := e.db.Exec(`
+			UPDATE leader_lease SET term = term + 1, expires_at = datetime('now', ?)
+			WHERE name = ? AND holder = ?`, expires, leaseName, e.nodeID)
+		return rowsChanged(res, err)
+	}
+	if holder != e.seenHolder || term != e.seenTerm {
+		e.seenHolder, e.seenTerm, e.seenAt = holder, term, time.Now()
+		return false, nil
+	}
+	if time.Since(e.seenAt) < e.ttl {
+		return false, nil
+	}
+	res, err := e.db.Exec(`
+		UPDATE leader_lease SET holder = ?, term = term + 1, expires_at = datetime('now', ?)
+		WHERE name = ? AND term = ?`, e.nodeID, expires, leaseName, term)
+	if ok, err := rowsChanged(res, err); err != nil || ok {
+		return ok, err
+	}
+	// Another standby won the race; time its term from now.
+	e.seenAt = time.Now()
+	return false, nil
+}
+
+func rowsChanged(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// release gives up the lease if this node holds it.
+func (e *Elector) release() error {
+	_, err := e.db.Exec(`DELETE FROM leader_lease WHERE name = ? AND holder = ?`, leaseName, e.nodeID)
+	return err
+}
@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Membership tracks live instances through heartbeat rows in the shared
+// database and assigns work among them by rendezvous hashing, so a member
+// joining or leaving only moves the keys it gains or loses.
+type Membership struct {
+	db      *sql.DB
+	nodeID  string
+	ttl     time.Duration
+	mu      sync.RWMutex
+	members []string // sorted live node IDs, including this one
+}
+
+// NewMembership creates a Membership for nodeID. A node whose heartbeat is
+// older than ttl is considered gone.
+func NewMembership(db *sql.DB, nodeID string, ttl time.Duration) *Membership {
+	return &Membership{db: db, nodeID: nodeID, ttl: ttl}
+}
+
+// Members returns the live node IDs as of the last heartbeat.
+func (m *Membership) Members() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.members)
+}
+
+// Owns reports whether this node is responsible for key. Before the first
+// heartbeat completes the node owns nothing.
+func (m *Membership) Owns(key int64) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var best string
+	var bestScore uint64
+	for _, node := range m.members {
+		h := fnv.New64a()
+		h.Write([]byte(node))
+		h.Write([]byte{0})
+		h.Write([]byte(strconv.FormatInt(key, 10)))
+		if s := h.Sum64(); best == "" || s > bestScore {
+			best, bestScore = node, s
+		}
+	}
+	return best == m.nodeID
+}
+
+// Run heartbeats until ctx is cancelled, calling onChange whenever the set
+// of live members changes. On exit the node's row is removed so the others
+// rebalance without waiting for it to expire.
+func (m *Membership) Run(ctx context.Context, onChange func()) {
+	ticker := time.NewTicker(m.ttl / 3)
+	defer ticker.Stop()
+	for {
+		if err := m.heartbeat(onChange); err != nil {
+			log.Printf("cluster: heartbeat: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			if _, err := m.db.Exec(`DELETE FROM cluster_nodes WHERE node_id = ?`, m.nodeID); err != nil {
+				log.Printf("cluster: leave: %v", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Membership) heartbeat(onChange func()) error {
+	if _, err := m.db.Exec(`
+		INSERT INTO cluster_nodes (node_id, last_seen) VALUES (?, datetime('now'))
+		ON CONFLICT(node_id) DO UPDATE SET last_seen = excluded.last_seen`, m.nodeID); err != nil {
+		return err
+	}
+	rows, err := m.db.Query(`
+		SELECT node_id FROM cluster_nodes
+		WHERE last_seen >= datetime('now', ?)
+		ORDER BY node_id`, fmt.Sprintf("-%d seconds", int(m.ttl.Seconds())))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var live []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		live = append(live, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	changed := !slices.Equal(live, m.members)
+	m.members = live
+	m.mu.Unlock()
+	if changed {
+		log.Printf("cluster: %d live members %v", len(live), live)
+		onChange()
+	}
+	return nil
+}
@@ -1,42 +1,392 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Server  ServerConfig  `yaml:"server"`
-	Auth    AuthConfig    `yaml:"auth"`
-	Agent   AgentConfig   `yaml:"agent"`
-	Alerts  AlertsConfig  `yaml:"alerts"`
-	Events  EventsConfig  `yaml:"events"`
+	Server      ServerConfig      `yaml:"server"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Agent       AgentConfig       `yaml:"agent"`
+	Alerts      AlertsConfig      `yaml:"alerts"`
+	Events      EventsConfig      `yaml:"events"`
+	Checker     CheckerConfig     `yaml:"checker"`
+	Cluster     ClusterConfig     `yaml:"cluster"`
+	Replication ReplicationConfig `yaml:"replication"`
+	Ingest      IngestConfig      `yaml:"ingest"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	Secrets     SecretsConfig     `yaml:"secrets"`
+}
+
+// SecretsKeyEnv, when set, overrides secrets.key and secrets.key_file.
+const SecretsKeyEnv = "HEALTH_DASHBOARD_SECRETS_KEY"
+
+type SecretsConfig struct {
+	// Key encrypts the credentials stored in the database: monitors'
+	// auth secrets and http_headers, and workspaces' GitHub secrets and
+	// webhook URLs. It is 32 bytes, base64-encoded. KeyFile reads it from a
+	// file instead, such as one a KMS or secrets manager mounts. Without
+	// either they are stored in plaintext.
+	Key     string `yaml:"key"`
+	KeyFile string `yaml:"key_file"`
+	// OldKeys still decrypt, while rotating; the server re-encrypts what
+	// they sealed with Key at startup.
+	OldKeys []string `yaml:"old_keys"`
+}
+
+// LoadKey returns the secrets key from the environment, KeyFile or Key, in
+// that order, or "" if none is set.
+func (s SecretsConfig) LoadKey() (string, error) {
+	if k := os.Getenv(SecretsKeyEnv); k != "" {
+		return k, nil
+	}
+	if s.KeyFile != "" {
+		b, err := os.ReadFile(s.KeyFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return s.Key, nil
+}
+
+type MetricsConfig struct {
+	// LongTermDays, when above 0, rolls the raw agent metrics (kept 7 days)
+	// up into hourly min/avg/max of each alert rule metric, kept for
+	// LongTermDays.
+	LongTermDays int `yaml:"long_term_days"`
+}
+
+type IngestConfig struct {
+	// FlushSeconds, when above 0, buffers agent metrics in memory and writes
+	// them every FlushSeconds in one transaction, or sooner once MaxBatch are
+	// queued. Buffered payloads are journaled to Journal (default
+	// data_dir/metrics.journal) and replayed after a crash.
+	FlushSeconds int    `yaml:"flush_seconds"`
+	MaxBatch     int    `yaml:"max_batch"`
+	Journal      string `yaml:"journal"`
+	// Once MaxInFlight ingestion requests are being handled, or MaxPending
+	// payloads are buffered (flushes failing), further agent and event POSTs
+	// get 429 with a Retry-After of RetryAfterSeconds.
+	MaxInFlight       int `yaml:"max_in_flight"`
+	MaxPending        int `yaml:"max_pending"`
+	RetryAfterSeconds int `yaml:"retry_after_seconds"`
+}
+
+type ReplicationConfig struct {
+	// RestoreCommand recovers the database from a replica when data_dir
+	// has none (e.g. a fresh volume after disaster).
+	RestoreCommand string `yaml:"restore_command"`
+	// ExternalCheckpoints leaves WAL checkpoints to the replication tool.
+	ExternalCheckpoints bool `yaml:"external_checkpoints"`
+	// ReadOnly runs a standby that serves the dashboard from a replica but
+	// never writes: no checks, alerts, or ingestion.
+	ReadOnly bool `yaml:"read_only"`
+}
+
+type ClusterConfig struct {
+	// Enabled lets several instances on one host share one database. SQLite's
+	// WAL isn't safe across hosts, e.g. over NFS, so this isn't multi-host HA.
+	Enabled bool `yaml:"enabled"`
+	// Mode is "leader" (default: only the elected leader runs checks and
+	// alerts) or "shard" (monitors are split across all instances).
+	Mode string `yaml:"mode"`
+	// NodeID identifies this instance in the lease; defaults to hostname-pid.
+	NodeID       string `yaml:"node_id"`
+	LeaseSeconds int    `yaml:"lease_seconds"`
+}
+
+type CheckerConfig struct {
+	// MaxConcurrent caps how many monitor probes run at the same time.
+	MaxConcurrent int `yaml:"max_concurrent"`
+	// BlockLinkLocal refuses to probe link-local and cloud metadata
+	// addresses; BlockPrivate also refuses loopback and private ranges.
+	BlockLinkLocal bool `yaml:"block_link_local"`
+	BlockPrivate   bool `yaml:"block_private"`
+	// RecheckOnStart probes every monitor right after startup, including
+	// cron monitors that would otherwise wait for their schedule.
+	RecheckOnStart bool `yaml:"recheck_on_start"`
+	// SampleKeepEvery, when above 1, stores one row per that many
+	// consecutive successes for monitors checked every SampleBelowSeconds
+	// or more often; failures and recoveries are always stored.
+	SampleKeepEvery    int `yaml:"sample_keep_every"`
+	SampleBelowSeconds int `yaml:"sample_below_seconds"`
+	// DNSCache shares host lookups between probes, keeping each answer for
+	// its TTL clamped to DNSMinTTL..DNSMaxTTL seconds (default 5..300), and
+	// names that don't exist for DNSNegativeTTL seconds (default 30).
+	DNSCache       bool `yaml:"dns_cache"`
+	DNSMinTTL      int  `yaml:"dns_min_ttl"`
+	DNSMaxTTL      int  `yaml:"dns_max_ttl"`
+	DNSNegativeTTL int  `yaml:"dns_negative_ttl"`
 }
 
 type EventsConfig struct {
 	APIKey string `yaml:"api_key"`
+	// APIKeys are also accepted, so keys can be rotated without downtime.
+	APIKeys []string `yaml:"api_keys"`
+}
+
+// AcceptedKeys returns every key the events API accepts.
+func (e EventsConfig) AcceptedKeys() []string {
+	return append([]string{e.APIKey}, e.APIKeys...)
 }
 
 type ServerConfig struct {
 	Host    string `yaml:"host"`
 	Port    int    `yaml:"port"`
 	DataDir string `yaml:"data_dir"`
+	// CacheSeconds is how long the monitor list, event summary and status
+	// page are served from memory before being queried again. Edits clear
+	// them at once. Default 5; negative disables.
+	CacheSeconds int `yaml:"cache_seconds"`
+	// ReadTimeoutSeconds bounds reading a request, headers and body;
+	// WriteTimeoutSeconds, from the end of its headers to the end of the
+	// response; IdleTimeoutSeconds, how long a keep-alive connection may
+	// wait for its next request. MaxHeaderBytes caps the request line and
+	// headers. They keep slow or idle clients from holding connections
+	// open indefinitely.
+	ReadTimeoutSeconds  int `yaml:"read_timeout_seconds"`
+	WriteTimeoutSeconds int `yaml:"write_timeout_seconds"`
+	IdleTimeoutSeconds  int `yaml:"idle_timeout_seconds"`
+	MaxHeaderBytes      int `yaml:"max_header_bytes"`
+	// ReusePort binds with SO_REUSEPORT, so an upgraded server can start
+	// on the same port before the old one stops. Ignored under systemd
+	// socket activation, which hands over the socket itself.
+	ReusePort bool `yaml:"reuse_port"`
+	// On SIGTERM the server reports itself draining on /health (503) and
+	// keeps serving for DrainSeconds, so a load balancer can take it out
+	// of rotation; then it stops accepting connections and gives requests
+	// in flight up to ShutdownTimeoutSeconds (default 30) to finish.
+	DrainSeconds           int `yaml:"drain_seconds"`
+	ShutdownTimeoutSeconds int `yaml:"shutdown_timeout_seconds"`
 }
 
 type AuthConfig struct {
 	// Password is either a bcrypt hash (starts with $2a$) or plaintext (dev only)
-	Password      string `yaml:"password"`
-	SessionSecret string `yaml:"session_secret"`
+	Password string `yaml:"password"`
+	// ViewerPassword, if set, logs in with the read-only viewer role,
+	// limited to ViewerWorkspaces (default the default workspace). Same
+	// format as Password.
+	ViewerPassword   string  `yaml:"viewer_password"`
+	ViewerWorkspaces []int64 `yaml:"viewer_workspaces"`
+	// Users log in by name, each limited to its own workspaces. Only a
+	// Password login reaches every workspace and manages workspaces and
+	// sessions.
+	Users         []UserConfig `yaml:"users"`
+	SessionSecret string       `yaml:"session_secret"`
+	// GeoIPDB is an optional MaxMind DB (e.g. GeoLite2-City.mmdb) used to
+	// add a location to recorded logins.
+	GeoIPDB string `yaml:"geoip_db"`
+}
+
+// UserConfig is a named login, such as a customer's, in auth.users.
+type UserConfig struct {
+	Name       string  `yaml:"name"`
+	Password   string  `yaml:"password"` // same format as AuthConfig.Password
+	Role       string  `yaml:"role"`     // "admin" or "viewer" (default)
+	Workspaces []int64 `yaml:"workspaces"`
 }
 
 type AgentConfig struct {
-	Token     string `yaml:"token"`
-	ServerURL string `yaml:"server_url"`
+	// Token is what the agent sends; the server accepts it and any of Tokens.
+	Token     string   `yaml:"token"`
+	Tokens    []string `yaml:"tokens"`
+	ServerURL string   `yaml:"server_url"`
+	// Hostname names the agent in the server's per-host ingestion stats
+	// (default the machine's hostname).
+	Hostname string `yaml:"hostname"`
+	// Splay offsets the host's sends within each 30s interval by a hash of
+	// Hostname, and Jitter delays each send by up to that many seconds
+	// more, so agents provisioned together don't report in lockstep.
+	Splay  bool `yaml:"splay"`
+	Jitter int  `yaml:"jitter"`
+	// NTPServer is checked every 5 minutes for the clock's offset, which is
+	// reported with the metrics; "chronyc" asks the local chronyd instead.
+	NTPServer string `yaml:"ntp_server"`
+	// Pings are measured every cycle: a host by ICMP echo, "host:port" by
+	// TCP connect, or "gateway" for the default gateway.
+	Pings []string `yaml:"pings"`
+	// PeerAddress is where other agents check this host's reachability:
+	// a host for ICMP echo or "host:port" for TCP connects. PeerChecks
+	// checks every other agent in the workspace that sets one, from the
+	// list the server hands out.
+	PeerAddress string `yaml:"peer_address"`
+	PeerChecks  bool   `yaml:"peer_checks"`
+	// DirSizes are directories whose disk usage is measured in the
+	// background every DirScanMinutes (default 60), reading at most
+	// DirScanRate entries a second (default 2000).
+	DirSizes       []string `yaml:"dir_sizes"`
+	DirScanMinutes int      `yaml:"dir_scan_minutes"`
+	DirScanRate    int      `yaml:"dir_scan_rate"`
+	// PerCoreCPU also reports each core's utilization, so one pegged core
+	// is visible even when the average is low.
+	PerCoreCPU bool `yaml:"per_core_cpu"`
+	// Docker enables per-container stats from the Docker Engine API at
+	// DockerSocket (default /var/run/docker.sock).
+	Docker       bool   `yaml:"docker"`
+	DockerSocket string `yaml:"docker_socket"`
+	// Hypervisor reports the VMs and containers of the Proxmox VE cluster
+	// or libvirt host the agent runs on.
+	Hypervisor HypervisorConfig `yaml:"hypervisor"`
+	// Payloads the server doesn't accept are kept in QueueFile (default
+	// health-dashboard/agent-queue.jsonl in the user cache directory) and
+	// replayed once it is back, up to QueueMax payloads (default 2880).
+	QueueFile string `yaml:"queue_file"`
+	QueueMax  int    `yaml:"queue_max"`
+	// ServerCA is a PEM file of the CAs the agent trusts for an https
+	// ServerURL, instead of the system store. ServerFingerprint pins the
+	// server certificate's SHA-256 fingerprint; ServerFingerprints are also
+	// accepted, for rotating certificates.
+	ServerCA           string   `yaml:"server_ca"`
+	ServerFingerprint  string   `yaml:"server_fingerprint"`
+	ServerFingerprints []string `yaml:"server_fingerprints"`
+	// ProxyURL is the http://, https:// or socks5:// proxy the agent
+	// reaches the server through. Unset, HTTPS_PROXY, HTTP_PROXY and
+	// NO_PROXY apply.
+	ProxyURL string `yaml:"proxy_url"`
+	// Plugins are executables run every cycle, each printing a JSON object
+	// of numbers that is sent as custom metrics; each gets PluginTimeout
+	// seconds (default 10).
+	Plugins       []string `yaml:"plugins"`
+	PluginTimeout int      `yaml:"plugin_timeout"`
+	// Gzip compresses payloads (Content-Encoding: gzip), typically to a
+	// third of their size or less, for agents on metered links.
+	Gzip bool `yaml:"gzip"`
+	// Thresholds are checked by the agent on every cycle; crossing one
+	// posts an event to POST /api/events with APIKey (default the
+	// events.api_key in the same file).
+	Thresholds []AgentThreshold `yaml:"thresholds"`
+	APIKey     string           `yaml:"api_key"`
+}
+
+// HypervisorConfig says where the agent lists guests from.
+type HypervisorConfig struct {
+	// Type is "proxmox" or "libvirt"; empty turns the integration off.
+	Type string `yaml:"type"`
+	// URL is the Proxmox API (default https://127.0.0.1:8006) or the
+	// libvirt connection URI (default qemu:///system).
+	URL string `yaml:"url"`
+	// TokenID ("user@realm!name") and TokenSecret are a Proxmox API token
+	// with the PVEAuditor role.
+	TokenID     string `yaml:"token_id"`
+	TokenSecret string `yaml:"token_secret"`
+	// CA and Fingerprint verify the Proxmox certificate the way server_ca
+	// and server_fingerprint verify the server's.
+	CA          string `yaml:"ca"`
+	Fingerprint string `yaml:"fingerprint"`
+}
+
+// AgentThreshold posts Event once Metric has compared to Value by Op for
+// ForSeconds, and ClearEvent (if set) once it no longer does, or with a
+// ClearValue, once it is past that on the other side, as alert rules'
+// clear_threshold.
+type AgentThreshold struct {
+	// Metric is an alert rule metric (cpu_percent, disk_percent, ...) or a
+	// plugin's custom metric ("ups.battery_percent").
+	Metric string `yaml:"metric"`
+	// Mount narrows disk_percent to one mount point instead of the fullest.
+	Mount      string   `yaml:"mount"`
+	Op         string   `yaml:"op"` // ">", ">=", "<" or "<="
+	Value      float64  `yaml:"value"`
+	Event      string   `yaml:"event"`
+	ClearEvent string   `yaml:"clear_event"`
+	ClearValue *float64 `yaml:"clear_value"`
+	ForSeconds int      `yaml:"for_seconds"`
+}
+
+// AcceptedTokens returns every token the metrics endpoint accepts.
+func (a AgentConfig) AcceptedTokens() []string {
+	return append([]string{a.Token}, a.Tokens...)
+}
+
+// PinnedFingerprints returns every server certificate fingerprint the agent
+// accepts; empty means the certificate isn't pinned.
+func (a AgentConfig) PinnedFingerprints() []string {
+	if a.ServerFingerprint == "" {
+		return a.ServerFingerprints
+	}
+	return append([]string{a.ServerFingerprint}, a.ServerFingerprints...)
 }
 
 type AlertsConfig struct {
 	WebhookURL string `yaml:"webhook_url"`
+	// TemperatureThreshold (°C) fires a webhook when any agent sensor has
+	// been at or above it for TemperatureForSeconds, and again once it drops
+	// back below, or below TemperatureClear if set. 0 disables.
+	TemperatureThreshold  float64  `yaml:"temperature_threshold"`
+	TemperatureClear      *float64 `yaml:"temperature_clear"`
+	TemperatureForSeconds int      `yaml:"temperature_for_seconds"`
+	// ClockDriftThresholdMs flags a host on the dashboard once its clock is
+	// off from NTP (agent.ntp_server) by more than this. 0 disables.
+	ClockDriftThresholdMs float64 `yaml:"clock_drift_threshold_ms"`
+	// Runbooks are runbook URL templates for monitors without a runbook_url
+	// of their own; the first whose tag the monitor has applies.
+	Runbooks []RunbookConfig `yaml:"runbooks"`
+	// WebhookTemplate, when Body is set, replaces the JSON alert payload
+	// with a body rendered from a Go text/template.
+	WebhookTemplate WebhookTemplateConfig `yaml:"webhook_template"`
+	// Issues open an issue in Jira or GitHub when a monitor goes down, and
+	// comment on and close it when the monitor recovers.
+	Issues IssuesConfig `yaml:"issues"`
+}
+
+// IssuesConfig holds the issue trackers; each is off until its project or
+// repo is set.
+type IssuesConfig struct {
+	Jira   JiraIssuesConfig   `yaml:"jira"`
+	GitHub GitHubIssuesConfig `yaml:"github"`
+}
+
+// JiraIssuesConfig files issues in a Jira Cloud project. APIToken is an
+// Atlassian API token for Email's account. IssueType defaults to Bug and
+// DoneTransition, the workflow transition that closes an issue, to Done.
+// A non-empty Tag limits issues to monitors with that tag, and Workspaces
+// to those workspaces' monitors (default the default workspace).
+type JiraIssuesConfig struct {
+	URL            string   `yaml:"url"`
+	Email          string   `yaml:"email"`
+	APIToken       string   `yaml:"api_token"`
+	Project        string   `yaml:"project"`
+	IssueType      string   `yaml:"issue_type"`
+	DoneTransition string   `yaml:"done_transition"`
+	Labels         []string `yaml:"labels"`
+	Tag            string   `yaml:"tag"`
+	Workspaces     []int64  `yaml:"workspaces"`
+}
+
+// GitHubIssuesConfig files issues in a GitHub repository ("owner/name").
+// Token needs write access to its issues. APIURL defaults to
+// https://api.github.com. A non-empty Tag limits issues to monitors with
+// that tag, and Workspaces to those workspaces' monitors (default the
+// default workspace).
+type GitHubIssuesConfig struct {
+	Repo       string   `yaml:"repo"`
+	Token      string   `yaml:"token"`
+	APIURL     string   `yaml:"api_url"`
+	Labels     []string `yaml:"labels"`
+	Tag        string   `yaml:"tag"`
+	Workspaces []int64  `yaml:"workspaces"`
+}
+
+// WebhookTemplateConfig is the template for alert webhook bodies. Body sees
+// the alert's JSON fields; ContentType defaults to application/json, and
+// Headers, such as Authorization, are added to every webhook request.
+type WebhookTemplateConfig struct {
+	Body        string            `yaml:"body"`
+	ContentType string            `yaml:"content_type"`
+	Headers     map[string]string `yaml:"headers"`
+}
+
+// RunbookConfig is one runbook URL template. An empty Tag matches every
+// monitor.
+type RunbookConfig struct {
+	Tag string `yaml:"tag"`
+	URL string `yaml:"url"`
 }
 
 func Load(path string) (*Config, error) {
@@ -62,4 +412,79 @@ func (c *Config) applyDefaults() {
 	if c.Server.DataDir == "" {
 		c.Server.DataDir = "/data"
 	}
+	if len(c.Auth.ViewerWorkspaces) == 0 {
+		c.Auth.ViewerWorkspaces = []int64{1} // the default workspace
+	}
+	// Trackers are the operator's projects, so other workspaces' monitors
+	// only file issues there when listed.
+	if len(c.Alerts.Issues.Jira.Workspaces) == 0 {
+		c.Alerts.Issues.Jira.Workspaces = []int64{1}
+	}
+	if len(c.Alerts.Issues.GitHub.Workspaces) == 0 {
+		c.Alerts.Issues.GitHub.Workspaces = []int64{1}
+	}
+	for i := range c.Auth.Users {
+		if c.Auth.Users[i].Role == "" {
+			c.Auth.Users[i].Role = "viewer"
+		}
+	}
+	if c.Server.CacheSeconds == 0 {
+		c.Server.CacheSeconds = 5
+	}
+	if c.Server.ReadTimeoutSeconds <= 0 {
+		c.Server.ReadTimeoutSeconds = 30
+	}
+	if c.Server.WriteTimeoutSeconds <= 0 {
+		c.Server.WriteTimeoutSeconds = 60
+	}
+	if c.Server.IdleTimeoutSeconds <= 0 {
+		c.Server.IdleTimeoutSeconds = 120
+	}
+	if c.Server.MaxHeaderBytes <= 0 {
+		c.Server.MaxHeaderBytes = 64 << 10
+	}
+	if c.Server.ShutdownTimeoutSeconds <= 0 {
+		c.Server.ShutdownTimeoutSeconds = 30
+	}
+	if c.Checker.MaxConcurrent <= 0 {
+		c.Checker.MaxConcurrent = 16
+	}
+	if c.Checker.SampleBelowSeconds <= 0 {
+		c.Checker.SampleBelowSeconds = 15
+	}
+	if c.Checker.DNSMinTTL <= 0 {
+		c.Checker.DNSMinTTL = 5
+	}
+	if c.Checker.DNSMaxTTL <= 0 {
+		c.Checker.DNSMaxTTL = 300
+	}
+	c.Checker.DNSMaxTTL = max(c.Checker.DNSMaxTTL, c.Checker.DNSMinTTL)
+	if c.Checker.DNSNegativeTTL <= 0 {
+		c.Checker.DNSNegativeTTL = 30
+	}
+	if c.Ingest.MaxBatch <= 0 {
+		c.Ingest.MaxBatch = 500
+	}
+	if c.Ingest.MaxPending <= 0 {
+		c.Ingest.MaxPending = 10 * c.Ingest.MaxBatch
+	}
+	if c.Ingest.MaxInFlight <= 0 {
+		c.Ingest.MaxInFlight = 32
+	}
+	if c.Ingest.RetryAfterSeconds <= 0 {
+		c.Ingest.RetryAfterSeconds = 30
+	}
+	if c.Ingest.Journal == "" {
+		c.Ingest.Journal = filepath.Join(c.Server.DataDir, "metrics.journal")
+	}
+	if c.Cluster.Mode == "" {
+		c.Cluster.Mode = "leader"
+	}
+	if c.Cluster.NodeID == "" {
+		host, _ := os.Hostname()
+		c.Cluster.NodeID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if c.Cluster.LeaseSeconds <= 0 {
+		c.Cluster.LeaseSeconds = 15
+	}
 }
@@ -2,24 +2,111 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
 
 	_ "modernc.org/sqlite"
 )
 
+// Options tune Open for running under a replication tool such as Litestream
+// or LiteFS. The zero value is a standalone read-write database.
+type Options struct {
+	// RestoreCommand is run through sh -c when the database file does not
+	// exist yet, e.g. "litestream restore -if-replica-exists -o $DB_PATH s3://bucket/db".
+	// DB_PATH is set to the database path in its environment.
+	RestoreCommand string
+	// ExternalCheckpoints disables SQLite's automatic WAL checkpoints so the
+	// replication tool decides when the WAL is folded into the database.
+	ExternalCheckpoints bool
+	// ReadOnly opens a replica without migrating it; writes fail.
+	ReadOnly bool
+}
+
+// Path returns the database file path inside dataDir.
+func Path(dataDir string) string {
+	return fmt.Sprintf("%s/health.db", dataDir)
+}
+
 // Open opens (or creates) the SQLite database with WAL mode enabled.
 // MaxOpenConns is set to 1 to serialize writes and avoid SQLITE_BUSY.
-func Open(dataDir string) (*sql.DB, error) {
-	path := fmt.Sprintf("%s/health.db", dataDir)
-	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on", path)
+func Open(dataDir string, opts Options) (*sql.DB, error) {
+	path := Path(dataDir)
+	if opts.RestoreCommand != "" {
+		if err := restore(path, opts.RestoreCommand); err != nil {
+			return nil, fmt.Errorf("restore: %w", err)
+		}
+	}
+
+	// modernc.org/sqlite takes pragmas as _pragma=name(value); the
+	// mattn-style _journal_mode/_busy_timeout parameters are silently ignored.
+	// Transactions take the write lock up front (BEGIN IMMEDIATE) so the busy
+	// timeout applies when another instance holds it.
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_txlock=immediate", path)
+	if opts.ReadOnly {
+		// The primary owns the journal mode and schema.
+		dsn += "&_pragma=query_only(1)"
+	} else {
+		dsn += "&_pragma=journal_mode(WAL)"
+	}
+	if opts.ExternalCheckpoints {
+		dsn += "&_pragma=wal_autocheckpoint(0)"
+	}
 	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, err
 	}
 	db.SetMaxOpenConns(1)
+	if opts.ReadOnly {
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return db, nil
+	}
 	if err := migrate(db); err != nil {
 		db.Close()
 		return nil, err
 	}
 	return db, nil
 }
+
+// OpenMemory opens a private in-memory database with the full schema, for
+// state a read-only replica must keep to itself, such as its sessions.
+func OpenMemory() (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file::memory:?_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, err
+	}
+	// Each connection would get its own empty database.
+	db.SetMaxOpenConns(1)
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// restore runs cmd if the database at path does not exist. A command that
+// succeeds without creating the file (no replica yet) yields a fresh database.
+func restore(path, cmd string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	log.Printf("db: %s not found, running restore command", path)
+	c := exec.Command("sh", "-c", cmd)
+	c.Env = append(os.Environ(), "DB_PATH="+path)
+	c.Stdout, c.Stderr = os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		log.Printf("db: restored %s", path)
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package db
 
-import "database/sql"
+import (
+	"database/sql"
+	"fmt"
+)
 
 const schema = `
 CREATE TABLE IF NOT EXISTS monitors (
@@ -25,14 +28,10 @@ CREATE TABLE IF NOT EXISTS checks (
 );
 CREATE INDEX IF NOT EXISTS idx_checks_monitor_checked ON checks(monitor_id, checked_at);
 
--- Prune checks older than 7 days via a scheduled ticker in the server.
--- Trigger kept as a belt-and-suspenders safety net.
-CREATE TRIGGER IF NOT EXISTS prune_old_checks
-    AFTER INSERT ON checks
-BEGIN
-    DELETE FROM checks
-    WHERE checked_at < datetime('now', '-7 days');
-END;
+-- Checks are pruned by a scheduled ticker in the server, honouring each
+-- monitor's retention_days. The old fixed 7-day trigger would defeat longer
+-- per-monitor retention, so drop it from existing databases.
+DROP TRIGGER IF EXISTS prune_old_checks;
 
 CREATE TABLE IF NOT EXISTS metrics (
     id          INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -65,9 +64,520 @@ BEGIN
     DELETE FROM events
     WHERE created_at < datetime('now', '-7 days');
 END;
+
+-- Periods with no check data for a monitor (e.g. the server was down),
+-- recorded at startup so the history shows them explicitly.
+CREATE TABLE IF NOT EXISTS data_gaps (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    monitor_id INTEGER  NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    started_at DATETIME NOT NULL,
+    ended_at   DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_data_gaps_monitor_ended ON data_gaps(monitor_id, ended_at);
+
+-- Diagnostic traces of failed or slow checks for monitors with debug_trace
+-- enabled: redacted request and response headers, a body snippet and phase
+-- timings, as JSON. Kept for monitor.TraceRetention.
+CREATE TABLE IF NOT EXISTS check_traces (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    monitor_id INTEGER  NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    check_id   INTEGER  REFERENCES checks(id) ON DELETE CASCADE,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    trace_json TEXT     NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS idx_check_traces_monitor_created ON check_traces(monitor_id, created_at);
+
+-- Issues opened in a tracker (alerts.issues) for a monitor that went down,
+-- kept until the monitor recovers and the issue is closed. tracker is
+-- "jira" or "github"; ref is the issue key or number.
+CREATE TABLE IF NOT EXISTS monitor_issues (
+    monitor_id INTEGER  NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    tracker    TEXT     NOT NULL,
+    ref        TEXT     NOT NULL,
+    url        TEXT     NOT NULL DEFAULT '',
+    opened_at  DATETIME NOT NULL DEFAULT (datetime('now')),
+    PRIMARY KEY (monitor_id, tracker)
+);
+
+-- Leader lease for cluster mode: the holder of a row that is
+-- still being renewed runs the checker and alerter.
+CREATE TABLE IF NOT EXISTS leader_lease (
+    name       TEXT PRIMARY KEY,
+    holder     TEXT     NOT NULL,
+    expires_at DATETIME NOT NULL
+);
+
+-- Tenants. Monitors, metrics and events carry a workspace_id; workspace 1
+-- always exists and owns data ingested with the keys from config.yaml.
+CREATE TABLE IF NOT EXISTS workspaces (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    name        TEXT     NOT NULL,
+    api_key     TEXT     NOT NULL DEFAULT '',
+    agent_token TEXT     NOT NULL DEFAULT '',
+    webhook_url TEXT     NOT NULL DEFAULT '',
+    created_at  DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+INSERT OR IGNORE INTO workspaces (id, name) VALUES (1, 'Default');
+
+-- Agents that have posted metrics, by the name they send in X-Agent-Host
+-- (or their address). Their ingestion counters are kept in memory.
+CREATE TABLE IF NOT EXISTS hosts (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace_id INTEGER  NOT NULL,
+    name         TEXT     NOT NULL,
+    created_at   DATETIME NOT NULL DEFAULT (datetime('now')),
+    UNIQUE (workspace_id, name)
+);
+
+-- The latest reachability check of one agent by another, from the
+-- peer_checks in the checking agent's payloads; one row per direction.
+CREATE TABLE IF NOT EXISTS peer_checks (
+    workspace_id INTEGER  NOT NULL,
+    from_host    INTEGER  NOT NULL,
+    to_host      INTEGER  NOT NULL,
+    target       TEXT     NOT NULL, -- the address checked
+    method       TEXT     NOT NULL,
+    rtt_ms       REAL,
+    loss_percent REAL     NOT NULL,
+    error        TEXT     NOT NULL DEFAULT '',
+    checked_at   DATETIME NOT NULL,
+    PRIMARY KEY (workspace_id, from_host, to_host)
+);
+
+-- Backup job reports from POST /api/backups, one row per run; kept 90 days.
+CREATE TABLE IF NOT EXISTS backup_runs (
+    id               INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace_id     INTEGER  NOT NULL,
+    repository       TEXT     NOT NULL,
+    tool             TEXT     NOT NULL DEFAULT '',
+    host             TEXT     NOT NULL DEFAULT '',
+    snapshot_id      TEXT     NOT NULL DEFAULT '',
+    success          INTEGER  NOT NULL,
+    size_bytes       INTEGER,
+    added_bytes      INTEGER,
+    duration_seconds REAL,
+    error            TEXT     NOT NULL DEFAULT '',
+    finished_at      DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_backup_runs_repository ON backup_runs(workspace_id, repository, finished_at);
+
+CREATE TRIGGER IF NOT EXISTS prune_old_backup_runs
+    AFTER INSERT ON backup_runs
+BEGIN
+    DELETE FROM backup_runs
+    WHERE finished_at < datetime('now', '-90 days');
+END;
+
+-- One row per backup repository reported on: how old its last successful
+-- backup may get, whether its latest run failed, and whether a stale alert
+-- is out, so that alert goes out once.
+CREATE TABLE IF NOT EXISTS backup_repos (
+    workspace_id    INTEGER  NOT NULL,
+    repository      TEXT     NOT NULL,
+    max_age_hours   REAL     NOT NULL,
+    first_seen_at   DATETIME NOT NULL,
+    last_run_at     DATETIME NOT NULL,
+    last_success_at DATETIME,
+    failing         INTEGER  NOT NULL DEFAULT 0,
+    stale           INTEGER  NOT NULL DEFAULT 0,
+    PRIMARY KEY (workspace_id, repository)
+);
+
+-- Points on the dashboard timeline, such as deploys and releases from the
+-- GitHub and GitLab webhooks; kept a year. delivery_id is the sender's ID
+-- for the webhook delivery, so a redelivery isn't stored twice.
+CREATE TABLE IF NOT EXISTS annotations (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace_id INTEGER  NOT NULL,
+    at           DATETIME NOT NULL,
+    kind         TEXT     NOT NULL,
+    source       TEXT     NOT NULL,
+    title        TEXT     NOT NULL,
+    url          TEXT     NOT NULL DEFAULT '',
+    author       TEXT     NOT NULL DEFAULT '',
+    delivery_id  TEXT     NOT NULL DEFAULT '',
+    created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_annotations_at ON annotations(workspace_id, at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_annotations_delivery ON annotations(source, delivery_id) WHERE delivery_id != '';
+
+CREATE TRIGGER IF NOT EXISTS prune_old_annotations
+    AFTER INSERT ON annotations
+BEGIN
+    DELETE FROM annotations
+    WHERE at < datetime('now', '-365 days');
+END;
+
+-- Hourly min/avg/max of each alert rule metric, rolled up from the raw
+-- metrics when metrics.long_term_days is set and kept that long, so trends
+-- outlive the 7-day raw retention.
+CREATE TABLE IF NOT EXISTS metrics_hourly (
+    workspace_id INTEGER  NOT NULL,
+    hour         DATETIME NOT NULL,
+    metric       TEXT     NOT NULL,
+    samples      INTEGER  NOT NULL,
+    min          REAL     NOT NULL,
+    avg          REAL     NOT NULL,
+    max          REAL     NOT NULL,
+    PRIMARY KEY (workspace_id, metric, hour)
+);
+
+-- Dashboard login attempts, successful or not, for the account security page.
+CREATE TABLE IF NOT EXISTS login_events (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    success    INTEGER  NOT NULL DEFAULT 0,
+    role       TEXT     NOT NULL DEFAULT '',
+    ip         TEXT     NOT NULL DEFAULT '',
+    user_agent TEXT     NOT NULL DEFAULT '',
+    country    TEXT     NOT NULL DEFAULT '',
+    city       TEXT     NOT NULL DEFAULT ''
+);
+
+CREATE TRIGGER IF NOT EXISTS prune_old_login_events
+    AFTER INSERT ON login_events
+BEGIN
+    DELETE FROM login_events
+    WHERE created_at < datetime('now', '-90 days');
+END;
+
+-- Runs of push monitors' jobs: a start ping opens one and a finish ping
+-- closes it, or records one without a start. Times are to the millisecond.
+CREATE TABLE IF NOT EXISTS push_runs (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    monitor_id  INTEGER NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    started_at  DATETIME,
+    finished_at DATETIME,
+    duration_ms INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_push_runs_monitor ON push_runs(monitor_id, id);
+
+-- Dashboard login sessions, shared by every instance using the database.
+-- token_hash is the auth.HashToken digest of the cookie value; id is the
+-- public identifier sessions are listed and revoked by.
+CREATE TABLE IF NOT EXISTS sessions (
+    token_hash TEXT PRIMARY KEY,
+    id         TEXT     NOT NULL UNIQUE,
+    role       TEXT     NOT NULL,
+    ip         TEXT     NOT NULL DEFAULT '',
+    user_agent TEXT     NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    last_used  DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+
+-- Public status page: named components in display order, each listing
+-- monitors under customer-facing names.
+CREATE TABLE IF NOT EXISTS status_components (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace_id INTEGER  NOT NULL DEFAULT 1,
+    name         TEXT     NOT NULL,
+    position     INTEGER  NOT NULL DEFAULT 0,
+    created_at   DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+CREATE INDEX IF NOT EXISTS idx_status_components_workspace ON status_components(workspace_id, position);
+
+CREATE TABLE IF NOT EXISTS status_component_monitors (
+    component_id INTEGER NOT NULL REFERENCES status_components(id) ON DELETE CASCADE,
+    monitor_id   INTEGER NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    display_name TEXT    NOT NULL DEFAULT '',
+    position     INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (component_id, monitor_id)
+);
+
+-- Incidents published by hand on the status page, and their status updates.
+CREATE TABLE IF NOT EXISTS status_incidents (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace_id INTEGER  NOT NULL DEFAULT 1,
+    title        TEXT     NOT NULL,
+    impact       TEXT     NOT NULL DEFAULT 'minor',
+    status       TEXT     NOT NULL DEFAULT 'investigating',
+    postmortem   TEXT     NOT NULL DEFAULT '',
+    created_at   DATETIME NOT NULL DEFAULT (datetime('now')),
+    resolved_at  DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_status_incidents_workspace ON status_incidents(workspace_id, created_at);
+
+CREATE TABLE IF NOT EXISTS status_incident_updates (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    incident_id INTEGER  NOT NULL REFERENCES status_incidents(id) ON DELETE CASCADE,
+    status      TEXT     NOT NULL,
+    message     TEXT     NOT NULL,
+    created_at  DATETIME NOT NULL DEFAULT (datetime('now'))
+);
+CREATE INDEX IF NOT EXISTS idx_status_incident_updates_incident ON status_incident_updates(incident_id);
+
+-- Per-container stats from agents with docker enabled, one row per container
+-- per metrics payload; pruned along with their metrics row.
+CREATE TABLE IF NOT EXISTS container_stats (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    metric_id     INTEGER NOT NULL REFERENCES metrics(id) ON DELETE CASCADE,
+    container_id  TEXT    NOT NULL,
+    name          TEXT    NOT NULL DEFAULT '',
+    image         TEXT    NOT NULL DEFAULT '',
+    state         TEXT    NOT NULL DEFAULT '',
+    cpu_percent   REAL    NOT NULL DEFAULT 0,
+    mem_used      INTEGER NOT NULL DEFAULT 0,
+    mem_limit     INTEGER NOT NULL DEFAULT 0,
+    restart_count INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_container_stats_metric ON container_stats(metric_id);
+CREATE INDEX IF NOT EXISTS idx_container_stats_container ON container_stats(container_id, metric_id);
+
+-- Every saved configuration of a monitor, numbered from 1 per monitor.
+-- config_json holds the user-editable fields; changed_by is the session's
+-- role, empty for a baseline recorded from a monitor's pre-history state.
+CREATE TABLE IF NOT EXISTS monitor_versions (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    monitor_id  INTEGER  NOT NULL REFERENCES monitors(id) ON DELETE CASCADE,
+    version     INTEGER  NOT NULL,
+    config_json TEXT     NOT NULL,
+    note        TEXT     NOT NULL DEFAULT '',
+    changed_by  TEXT     NOT NULL DEFAULT '',
+    session_id  TEXT     NOT NULL DEFAULT '',
+    client_ip   TEXT     NOT NULL DEFAULT '',
+    created_at  DATETIME NOT NULL DEFAULT (datetime('now')),
+    UNIQUE (monitor_id, version)
+);
+
+-- Live instances in sharded mode; monitors are split among fresh rows.
+CREATE TABLE IF NOT EXISTS cluster_nodes (
+    node_id   TEXT PRIMARY KEY,
+    last_seen DATETIME NOT NULL
+);
+
+-- One row per instance, rewritten by GET /health?deep=1 to prove the
+-- database still takes writes.
+CREATE TABLE IF NOT EXISTS health_writes (
+    node_id    TEXT PRIMARY KEY,
+    written_at DATETIME NOT NULL
+);
 `
 
+// column is a column added after the initial schema. Existing databases get
+// it via ALTER TABLE; fresh databases go through the same path.
+type column struct {
+	table string
+	name  string
+	def   string
+}
+
+var columns = []column{
+	// Business-hours schedule: checks only run inside the active window.
+	{"monitors", "active_days", "TEXT NOT NULL DEFAULT ''"},
+	{"monitors", "active_hours", "TEXT NOT NULL DEFAULT ''"},
+	{"monitors", "timezone", "TEXT NOT NULL DEFAULT ''"},
+	// Cron expression; overrides interval_seconds when set.
+	{"monitors", "schedule", "TEXT NOT NULL DEFAULT ''"},
+	// Days of raw checks to keep; 0 uses the global default.
+	{"monitors", "retention_days", "INTEGER NOT NULL DEFAULT 0"},
+	// Content-change (defacement) detection and the window in which it is expected.
+	{"monitors", "detect_content_change", "INTEGER NOT NULL DEFAULT 0"},
+	{"monitors", "content_hash", "TEXT NOT NULL DEFAULT ''"},
+	{"monitors", "maintenance_start", "DATETIME"},
+	{"monitors", "maintenance_end", "DATETIME"},
+	// Performance budgets; 0 disables. budget_breaches counts consecutive overruns.
+	{"monitors", "budget_bytes", "INTEGER NOT NULL DEFAULT 0"},
+	{"monitors", "budget_ms", "INTEGER NOT NULL DEFAULT 0"},
+	{"monitors", "budget_breaches", "INTEGER NOT NULL DEFAULT 0"},
+	// Page weight and total load time (headers + body) per check.
+	{"checks", "response_bytes", "INTEGER"},
+	{"checks", "load_time_ms", "INTEGER"},
+	// HTTP security header audit: 0-100 score and comma-separated failed items.
+	{"monitors", "security_audit", "INTEGER NOT NULL DEFAULT 0"},
+	{"checks", "security_score", "INTEGER"},
+	{"checks", "security_issues", "TEXT NOT NULL DEFAULT ''"},
+	// Why a check failed (transport error or assertion), empty when up.
+	{"checks", "error", "TEXT NOT NULL DEFAULT ''"},
+	// Assert HTTP→HTTPS and www/apex variants redirect to the monitored URL.
+	{"monitors", "assert_canonical", "INTEGER NOT NULL DEFAULT 0"},
+	// Monitor type ("http", "dnsbl", "dns"); for dnsbl and dns the url column holds the host.
+	{"monitors", "type", "TEXT NOT NULL DEFAULT 'http'"},
+	{"monitors", "dnsbl_zones", "TEXT NOT NULL DEFAULT ''"},
+	// Negotiated HTTP version ("h1", "h2") and whether Alt-Svc offered h3.
+	{"checks", "protocol", "TEXT NOT NULL DEFAULT ''"},
+	{"checks", "h3_advertised", "INTEGER NOT NULL DEFAULT 0"},
+	// Successful checks a sampled row stands for, and when the latest ran.
+	{"checks", "sample_weight", "INTEGER NOT NULL DEFAULT 1"},
+	{"checks", "sampled_until", "DATETIME"},
+	{"monitors", "assert_http2", "INTEGER NOT NULL DEFAULT 0"},
+	// DNS server (ip[:port]) the monitor resolves through; empty uses the system resolver.
+	{"monitors", "dns_server", "TEXT NOT NULL DEFAULT ''"},
+	// Comma-separated IPs/CIDRs the target must resolve to; empty disables the assertion.
+	{"monitors", "expected_ips", "TEXT NOT NULL DEFAULT ''"},
+	// dns monitors: the record type queried for the url's name, and the
+	// host names or TXT value it must have; empty doesn't check the answer.
+	{"monitors", "dns_record_type", "TEXT NOT NULL DEFAULT ''"},
+	{"monitors", "dns_expected", "TEXT NOT NULL DEFAULT ''"},
+	// http monitors: conditions on the JSON response body ("$.status ==
+	// \"ok\""); empty disables the assertion.
+	{"monitors", "json_assert", "TEXT NOT NULL DEFAULT ''"},
+	// http monitors: the probe's method, extra headers (a JSON object, or
+	// '' for none) and request body.
+	{"monitors", "http_method", "TEXT NOT NULL DEFAULT 'GET'"},
+	{"monitors", "http_headers", "TEXT NOT NULL DEFAULT ''"},
+	{"monitors", "http_body", "TEXT NOT NULL DEFAULT ''"},
+	// http monitors: codes and ranges counted as up, e.g. "200-204,401";
+	// '' accepts 200-399.
+	{"monitors", "accepted_status_codes", "TEXT NOT NULL DEFAULT ''"},
+	// http monitors: credentials sent with each probe. auth_secret, the
+	// password or token, is never returned by the API.
+	{"monitors", "auth_type", "TEXT NOT NULL DEFAULT ''"},
+	{"monitors", "auth_username", "TEXT NOT NULL DEFAULT ''"},
+	{"monitors", "auth_secret", "TEXT NOT NULL DEFAULT ''"},
+	// Consecutive failed checks before a monitor goes down.
+	{"monitors", "failure_threshold", "INTEGER NOT NULL DEFAULT 3"},
+	// Extra attempts within a check before it is recorded as failed, and
+	// the pause between them.
+	{"monitors", "retries", "INTEGER NOT NULL DEFAULT 0"},
+	{"monitors", "retry_delay_seconds", "INTEGER NOT NULL DEFAULT 2"},
+	// Known-issue banner, shown on the dashboard and status page until
+	// known_issue_until.
+	{"monitors", "known_issue", "TEXT NOT NULL DEFAULT ''"},
+	{"monitors", "known_issue_until", "DATETIME"},
+	// Owning workspace; existing rows belong to the default workspace.
+	{"monitors", "workspace_id", "INTEGER NOT NULL DEFAULT 1"},
+	// Comma-separated lowercase labels ("prod,eu") for grouping in reports.
+	{"monitors", "tags", "TEXT NOT NULL DEFAULT ''"},
+	// Composite monitors: child monitor IDs with optional weights ("12,15:2"),
+	// how they combine ("all", "quorum", "weighted") and the quorum or percentage.
+	{"monitors", "children", "TEXT NOT NULL DEFAULT ''"},
+	{"monitors", "composite_mode", "TEXT NOT NULL DEFAULT ''"},
+	{"monitors", "composite_threshold", "INTEGER NOT NULL DEFAULT 0"},
+	// Whether failed and slow checks record a diagnostic trace.
+	{"monitors", "debug_trace", "INTEGER NOT NULL DEFAULT 0"},
+	// Who to contact about a monitor, its runbook and a markdown description,
+	// for whoever is paged.
+	{"monitors", "owner", "TEXT NOT NULL DEFAULT ''"},
+	{"monitors", "runbook_url", "TEXT NOT NULL DEFAULT ''"},
+	{"monitors", "description", "TEXT NOT NULL DEFAULT ''"},
+	{"metrics", "workspace_id", "INTEGER NOT NULL DEFAULT 1"},
+	{"events", "workspace_id", "INTEGER NOT NULL DEFAULT 1"},
+	// Per-interface network throughput from the agent, as a JSON array.
+	{"metrics", "net_json", "TEXT NOT NULL DEFAULT '[]'"},
+	// Per-device disk I/O rates from the agent, as a JSON array.
+	{"metrics", "disk_io_json", "TEXT NOT NULL DEFAULT '[]'"},
+	// 1, 5 and 15-minute load averages.
+	{"metrics", "load_1", "REAL NOT NULL DEFAULT 0"},
+	{"metrics", "load_5", "REAL NOT NULL DEFAULT 0"},
+	{"metrics", "load_15", "REAL NOT NULL DEFAULT 0"},
+	// Swap usage in bytes; swap_total is 0 on hosts without swap.
+	{"metrics", "swap_used", "INTEGER NOT NULL DEFAULT 0"},
+	{"metrics", "swap_total", "INTEGER NOT NULL DEFAULT 0"},
+	// Per-core CPU utilization, as a JSON array of percentages; empty unless
+	// the agent has per_core_cpu enabled.
+	{"metrics", "cpu_cores_json", "TEXT NOT NULL DEFAULT '[]'"},
+	// Hardware temperature sensors from the agent, as a JSON array.
+	{"metrics", "temps_json", "TEXT NOT NULL DEFAULT '[]'"},
+	// Top processes by CPU and by resident memory, as JSON arrays.
+	{"metrics", "top_cpu_json", "TEXT NOT NULL DEFAULT '[]'"},
+	{"metrics", "top_mem_json", "TEXT NOT NULL DEFAULT '[]'"},
+	// Names of failed systemd units, as a JSON array.
+	{"metrics", "failed_units_json", "TEXT NOT NULL DEFAULT '[]'"},
+	// Values from agent plugins, as a JSON object of name to number.
+	{"metrics", "custom_json", "TEXT NOT NULL DEFAULT '{}'"},
+	// Open file handles and TCP socket counts; NULL when the agent doesn't
+	// report them.
+	{"metrics", "fd_open", "INTEGER"},
+	{"metrics", "fd_max", "INTEGER"},
+	{"metrics", "tcp_established", "INTEGER"},
+	{"metrics", "tcp_inuse", "INTEGER"},
+	{"metrics", "tcp_time_wait", "INTEGER"},
+	// The host clock's offset from NTP in milliseconds, positive when ahead;
+	// NULL when the agent doesn't check it.
+	{"metrics", "ntp_offset_ms", "REAL"},
+	// Latency to the agent's ping targets, as a JSON array.
+	{"metrics", "pings_json", "TEXT NOT NULL DEFAULT '[]'"},
+	// Disk usage of the agent's dir_sizes directories, as a JSON array.
+	{"metrics", "dir_sizes_json", "TEXT NOT NULL DEFAULT '[]'"},
+	// Guests of the agent's hypervisor (Proxmox or libvirt), as a JSON array.
+	{"metrics", "vms_json", "TEXT NOT NULL DEFAULT '[]'"},
+	// Where other agents check the host's reachability (its
+	// agent.peer_address), and when it last advertised it.
+	{"hosts", "peer_address", "TEXT NOT NULL DEFAULT ''"},
+	{"hosts", "peer_seen_at", "DATETIME"},
+	// The agent release and platform the host last reported.
+	{"hosts", "agent_version", "TEXT NOT NULL DEFAULT ''"},
+	{"hosts", "agent_os", "TEXT NOT NULL DEFAULT ''"},
+	{"hosts", "agent_platform", "TEXT NOT NULL DEFAULT ''"},
+	{"hosts", "agent_kernel", "TEXT NOT NULL DEFAULT ''"},
+	{"hosts", "agent_arch", "TEXT NOT NULL DEFAULT ''"},
+	// Workspace keys are stored as auth.HashToken digests, found by their
+	// first characters; api_key and agent_token held them in plaintext
+	// before, and are emptied on startup. github_secret stays plaintext,
+	// since GitHub signs webhooks with it rather than sending it.
+	{"workspaces", "api_key_hash", "TEXT NOT NULL DEFAULT ''"},
+	{"workspaces", "api_key_prefix", "TEXT NOT NULL DEFAULT ''"},
+	{"workspaces", "agent_token_hash", "TEXT NOT NULL DEFAULT ''"},
+	{"workspaces", "agent_token_prefix", "TEXT NOT NULL DEFAULT ''"},
+	{"workspaces", "github_secret", "TEXT NOT NULL DEFAULT ''"},
+	// Bumped by every renewal of the leader lease. Standbys time how long it
+	// stays put on their own clock, so nodes need not agree on the time;
+	// expires_at is the holder's view, kept for inspection only.
+	{"leader_lease", "term", "INTEGER NOT NULL DEFAULT 0"},
+	// The auth.users entry a session logged in as, and the comma-separated
+	// workspaces it may use; '' allows every one, for auth.password alone.
+	// Sessions from before are limited to the default workspace.
+	{"sessions", "username", "TEXT NOT NULL DEFAULT ''"},
+	{"sessions", "workspaces", "TEXT NOT NULL DEFAULT '1'"},
+	// Push monitors: the token in their ping URLs, and the limits on a run's
+	// duration (0 for none).
+	{"monitors", "push_token", "TEXT NOT NULL DEFAULT ''"},
+	{"monitors", "max_run_seconds", "INTEGER NOT NULL DEFAULT 0"},
+	{"monitors", "run_median_factor", "REAL NOT NULL DEFAULT 0"},
+}
+
+// indexes run after columns, since they may cover added columns.
+const indexes = `
+CREATE INDEX IF NOT EXISTS idx_monitors_workspace ON monitors(workspace_id);
+CREATE INDEX IF NOT EXISTS idx_metrics_workspace_recorded ON metrics(workspace_id, recorded_at);
+CREATE INDEX IF NOT EXISTS idx_events_workspace_created ON events(workspace_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_workspaces_api_key_prefix ON workspaces(api_key_prefix);
+CREATE INDEX IF NOT EXISTS idx_workspaces_agent_token_prefix ON workspaces(agent_token_prefix);
+CREATE INDEX IF NOT EXISTS idx_monitors_push_token ON monitors(push_token) WHERE push_token != '';
+`
+
+// migrate runs in one immediate transaction so that instances sharing the
+// database can start at the same time without racing on ALTER TABLE.
 func migrate(db *sql.DB) error {
-	_, err := db.Exec(schema)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(schema); err != nil {
+		return err
+	}
+	for _, c := range columns {
+		if err := addColumn(tx, c); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", c.table, c.name, err)
+		}
+	}
+	if _, err := tx.Exec(indexes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addColumn adds c to its table unless it already exists.
+func addColumn(tx *sql.Tx, c column) error {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, c.table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == c.name {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, c.table, c.name, c.def))
 	return err
 }
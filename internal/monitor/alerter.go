@@ -3,8 +3,10 @@ package monitor
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -13,12 +15,50 @@ type AlertPayload struct {
 	MonitorName string `json:"monitor_name"`
 	URL         string `json:"url"`
 	Status      string `json:"status"`
+	Detail      string `json:"detail,omitempty"`
 	Timestamp   string `json:"timestamp"`
+	// Owner, RunbookURL and Description are the monitor's contact details,
+	// when set. RunbookURL may come from a tag's template (see RunbookURL).
+	Owner       string `json:"owner,omitempty"`
+	RunbookURL  string `json:"runbook_url,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Context is what else happened in the monitor's workspace just before
+	// a down alert, for the responder; nil for other alerts.
+	Context *AlertContext `json:"context,omitempty"`
 }
 
-// Alerter sends webhook notifications when a monitor transitions down.
+// AlertContext lists recent events and unusual host metrics around an alert.
+type AlertContext struct {
+	Events    []ContextEvent  `json:"recent_events"`    // newest first
+	Anomalies []MetricAnomaly `json:"metric_anomalies"` // in rules.Metrics order
+}
+
+// ContextEvent is one business event.
+type ContextEvent struct {
+	Name  string    `json:"event_name"`
+	Value float64   `json:"value"`
+	At    time.Time `json:"created_at"`
+}
+
+// MetricAnomaly is a host metric whose recent value strays from its usual
+// level: Value, seen At, against the Baseline mean.
+type MetricAnomaly struct {
+	Metric   string    `json:"metric"`
+	Value    float64   `json:"value"`
+	Baseline float64   `json:"baseline"`
+	At       time.Time `json:"at"`
+}
+
+// Alerter sends webhook notifications when a monitor transitions down, or
+// when a host metric crosses an alert threshold.
 type Alerter struct {
 	webhookURL string
+	webhookFor func(workspaceID int64) string
+	contextFor func(m *Monitor) *AlertContext
+	runbooks   []RunbookTemplate
+	template   *WebhookTemplate
+	issueStore *Store
+	trackers   []IssueTracker
 	client     *http.Client
 }
 
@@ -31,40 +71,137 @@ func NewAlerter(webhookURL string) *Alerter {
 	}
 }
 
-// Notify fires the webhook for a monitor that has just transitioned to down.
-// It retries once after 5 s on failure.
-func (a *Alerter) Notify(m *Monitor) {
-	if a.webhookURL == "" {
-		return
-	}
+// SetWorkspaceWebhooks makes alerts for a monitor go to the webhook that f
+// returns for its workspace, falling back to the global URL when f returns "".
+func (a *Alerter) SetWorkspaceWebhooks(f func(workspaceID int64) string) {
+	a.webhookFor = f
+}
 
+// SetAlertContext makes down alerts carry the context f returns for the
+// monitor; f returns nil when there is none.
+func (a *Alerter) SetAlertContext(f func(m *Monitor) *AlertContext) {
+	a.contextFor = f
+}
+
+// SetRunbooks sets the runbook URL templates for monitors without a
+// runbook_url of their own; see RunbookURL.
+func (a *Alerter) SetRunbooks(templates []RunbookTemplate) {
+	a.runbooks = templates
+}
+
+// SetTemplate makes webhooks carry the body t renders instead of the
+// AlertPayload JSON; nil restores the JSON.
+func (a *Alerter) SetTemplate(t *WebhookTemplate) {
+	a.template = t
+}
+
+// Notify fires the webhook for a monitor that has just transitioned to down,
+// and opens an issue in each issue tracker set. The webhook is retried once
+// after 5 s on failure.
+func (a *Alerter) Notify(m *Monitor) {
 	payload := AlertPayload{
 		MonitorName: m.Name,
 		URL:         m.URL,
 		Status:      "down",
 		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Owner:       m.Owner,
+		RunbookURL:  RunbookURL(m, a.runbooks, "down"),
+		Description: m.Description,
 	}
+	if a.contextFor != nil {
+		payload.Context = a.contextFor(m)
+	}
+	a.deliver(m.WorkspaceID, payload)
+	a.openIssues(m, payload)
+}
 
-	log.Printf("alert: monitor %q (%s) is DOWN — sending webhook to %s", m.Name, m.URL, a.webhookURL)
+// NotifyStatus fires the webhook with an arbitrary status (e.g.
+// "content_changed") and an optional human-readable detail.
+// It retries once after 5 s on failure.
+func (a *Alerter) NotifyStatus(m *Monitor, status, detail string) {
+	a.deliver(m.WorkspaceID, AlertPayload{
+		MonitorName: m.Name,
+		URL:         m.URL,
+		Status:      status,
+		Detail:      detail,
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Owner:       m.Owner,
+		RunbookURL:  RunbookURL(m, a.runbooks, status),
+		Description: m.Description,
+	})
+}
+
+// NotifyMetric fires the webhook for a host metric in workspaceID, e.g. a
+// temperature sensor crossing its threshold. name goes in monitor_name so
+// existing webhook consumers display it; url is empty.
+// It retries once after 5 s on failure.
+func (a *Alerter) NotifyMetric(workspaceID int64, name, status, detail string) {
+	a.deliver(workspaceID, AlertPayload{
+		MonitorName: name,
+		Status:      status,
+		Detail:      detail,
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// deliver posts payload to the workspace's webhook (or the global one),
+// retrying once after 5 s.
+func (a *Alerter) deliver(workspaceID int64, payload AlertPayload) {
+	url := a.webhookURL
+	if a.webhookFor != nil {
+		if u := a.webhookFor(workspaceID); u != "" {
+			url = u
+		}
+	}
+	if url == "" {
+		return
+	}
 
-	if err := a.post(payload); err != nil {
+	subject := fmt.Sprintf("%q", payload.MonitorName)
+	if payload.URL != "" {
+		subject += " (" + payload.URL + ")"
+	}
+	log.Printf("alert: %s is %s — sending webhook to %s", subject, strings.ToUpper(payload.Status), url)
+
+	body, contentType, err := a.body(payload)
+	if err != nil {
+		log.Printf("alert: webhook template failed for %q: %v", payload.MonitorName, err)
+		return
+	}
+	if err := a.post(url, body, contentType); err != nil {
 		log.Printf("alert: webhook failed (%v) — retrying in 5s", err)
 		time.Sleep(5 * time.Second)
-		if err := a.post(payload); err != nil {
+		if err := a.post(url, body, contentType); err != nil {
 			log.Printf("alert: webhook retry failed: %v", err)
 		} else {
-			log.Printf("alert: webhook retry succeeded for monitor %q", m.Name)
+			log.Printf("alert: webhook retry succeeded for %q", payload.MonitorName)
 		}
 	}
 }
 
-func (a *Alerter) post(payload AlertPayload) error {
+// body returns the webhook body for payload and its content type: the
+// template's rendering if one is set, else the payload as JSON.
+func (a *Alerter) body(payload AlertPayload) ([]byte, string, error) {
+	if a.template != nil {
+		body, err := a.template.Render(payload)
+		return body, a.template.contentType, err
+	}
 	body, err := json.Marshal(payload)
+	return body, "application/json", err
+}
+
+func (a *Alerter) post(url string, body []byte, contentType string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
-
-	resp, err := a.client.Post(a.webhookURL, "application/json", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if a.template != nil {
+		for k, v := range a.template.headers {
+			req.Header.Set(k, v)
+		}
+	}
+	resp, err := a.client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -2,57 +2,198 @@ package monitor
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/netip"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// failureThreshold is the number of consecutive failures before a monitor flips to "down".
-const failureThreshold = 3
+// DefaultFailureThreshold is the number of consecutive failures before a
+// monitor flips to "down", unless it sets its own failure_threshold. Budget
+// alerts always wait for this many breaches.
+const DefaultFailureThreshold = 3
+
+// MaxFailureThreshold bounds a monitor's failure_threshold.
+const MaxFailureThreshold = 100
+
+// Limits on a monitor's retries: how many times a failed probe is repeated
+// within the same check, holding its probe slot, before the failure is
+// recorded, and retry_delay_seconds between attempts.
+const (
+	MaxRetries               = 5
+	DefaultRetryDelaySeconds = 2
+	MaxRetryDelaySeconds     = 30
+)
+
+// drainTimeout bounds how long Stop waits for in-flight probes to finish
+// recording their results before it aborts them.
+const drainTimeout = 10 * time.Second
 
 // Checker manages a pool of goroutines that periodically probe monitors.
 type Checker struct {
 	store   *Store
 	alerter *Alerter
-	mu      sync.Mutex
-	cancel  map[int64]context.CancelFunc
-	wg      sync.WaitGroup
+	sem     chan struct{} // limits concurrent probes
+	ctx     context.Context
+	cancel  context.CancelFunc
+	// probeCtx outlives ctx so that probes already running when the
+	// Checker stops can finish; abort cancels it once the drain times out.
+	probeCtx       context.Context
+	abort          context.CancelFunc
+	mu             sync.Mutex
+	running        bool
+	owns           func(monitorID int64) bool // nil means every monitor
+	policy         AddrPolicy
+	transports     map[string]*http.Transport // by DNS server; "" is the system resolver, "direct" the same without proxy
+	dns            *DNSCache                  // nil resolves on every connection
+	recheckOnStart bool
+	onStateChange  func(m *Monitor)
+	sampling       SamplingPolicy
+	samples        map[int64]sampleState // by monitor, for sampled monitors
+	workers        map[int64]*worker
+	wg             sync.WaitGroup
+	waiting        atomic.Int64 // probes waiting for a sem slot
+	lastProbe      time.Time    // when a probe last finished
+	lastPrune      time.Time    // when PruneOldChecks last succeeded
+}
+
+// pruneInterval is how often a running Checker prunes expired checks.
+const pruneInterval = 6 * time.Hour
+
+// CheckerHealth is a snapshot of a Checker's liveness, for /health.
+type CheckerHealth struct {
+	Running       bool      `json:"running"`
+	Workers       int       `json:"workers"`
+	ProbesRunning int       `json:"probes_running"`
+	ProbesWaiting int       `json:"probes_waiting"`
+	MaxConcurrent int       `json:"max_concurrent"`
+	LastProbeAt   time.Time `json:"last_probe_at"`
+	LastPruneAt   time.Time `json:"last_prune_at"`
+
+	maxTimeout time.Duration // longest a probe may take among workers, retries included
 }
 
-// NewChecker creates a Checker backed by store.
-func NewChecker(store *Store, alerter *Alerter) *Checker {
+// Health reports whether the Checker is running and how busy it is.
+func (c *Checker) Health() CheckerHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var maxTimeout time.Duration
+	for _, w := range c.workers {
+		maxTimeout = max(maxTimeout, w.mon.MaxProbeTime())
+	}
+	return CheckerHealth{
+		maxTimeout:    maxTimeout,
+		Running:       c.running,
+		Workers:       len(c.workers),
+		ProbesRunning: len(c.sem),
+		ProbesWaiting: int(c.waiting.Load()),
+		MaxConcurrent: cap(c.sem),
+		LastProbeAt:   c.lastProbe,
+		LastPruneAt:   c.lastPrune,
+	}
+}
+
+// Stalled returns why h looks wedged, or "" if it doesn't: every probe slot
+// has been busy with no probe finishing for twice the longest probe time
+// (and at least a minute), or expired checks haven't been pruned for two
+// prune intervals.
+func (h CheckerHealth) Stalled(now time.Time) string {
+	after := max(2*h.maxTimeout, time.Minute)
+	switch {
+	case !h.Running:
+		return ""
+	case h.ProbesRunning >= h.MaxConcurrent && now.Sub(h.LastProbeAt) > after:
+		return fmt.Sprintf("all %d probe slots busy and no probe finished since %s",
+			h.MaxConcurrent, h.LastProbeAt.UTC().Format(time.RFC3339))
+	case now.Sub(h.LastPruneAt) > 2*pruneInterval:
+		return "expired checks not pruned since " + h.LastPruneAt.UTC().Format(time.RFC3339)
+	}
+	return ""
+}
+
+// worker is the handle for one monitor's probe goroutine.
+type worker struct {
+	cancel  context.CancelFunc
+	trigger chan struct{} // buffered(1): requests an immediate probe
+	mon     Monitor       // config the worker was started with, for Sync
+}
+
+// NewChecker creates a Checker backed by store that runs at most
+// maxConcurrent probes at once.
+func NewChecker(store *Store, alerter *Alerter, maxConcurrent int) *Checker {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
 	return &Checker{
-		store:   store,
-		alerter: alerter,
-		cancel:  make(map[int64]context.CancelFunc),
+		store:      store,
+		alerter:    alerter,
+		sem:        make(chan struct{}, maxConcurrent),
+		workers:    make(map[int64]*worker),
+		samples:    make(map[int64]sampleState),
+		transports: make(map[string]*http.Transport),
 	}
 }
 
 // Start loads all existing monitors from the DB and begins background probing.
-// It also starts a 6-hour ticker to prune checks older than 7 days.
+// Monitors not checked since before startup get a no-data gap recorded
+// and their state reset to "unknown".
+// Workers added later share ctx, so cancelling it stops every worker.
+// It also prunes expired checks now and on a 6-hour ticker.
+// A stopped Checker may be started again.
 func (c *Checker) Start(ctx context.Context) error {
 	monitors, err := c.store.List()
 	if err != nil {
 		return err
 	}
+	c.mu.Lock()
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.probeCtx, c.abort = context.WithCancel(context.WithoutCancel(ctx))
+	ctx = c.ctx
+	c.running = true
+	c.mu.Unlock()
+	var owned []*Monitor
 	for _, m := range monitors {
-		c.startWorker(ctx, m)
+		if c.owned(m.ID) {
+			owned = append(owned, m)
+		}
+	}
+	c.reconcile(owned, time.Now())
+	for _, m := range owned {
+		c.startWorker(m)
+	}
+	if c.recheckOnStart {
+		// Cron monitors otherwise wait for their next scheduled minute.
+		c.CheckAll()
 	}
 
+	// Counted from startup, so a first prune that fails isn't mistaken for
+	// one that never ran.
+	c.mu.Lock()
+	c.lastProbe, c.lastPrune = time.Now(), time.Now()
+	c.mu.Unlock()
+	c.prune()
+
 	c.wg.Add(1)
 	go func() {
 		defer c.wg.Done()
-		ticker := time.NewTicker(6 * time.Hour)
+		ticker := time.NewTicker(pruneInterval)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				if err := c.store.PruneOldChecks(); err != nil {
-					log.Printf("checker: prune old checks: %v", err)
-				}
+				c.prune()
 			}
 		}
 	}()
@@ -60,15 +201,225 @@ func (c *Checker) Start(ctx context.Context) error {
 	return nil
 }
 
+func (c *Checker) prune() {
+	if err := c.store.PruneOldChecks(); err != nil {
+		log.Printf("checker: prune old checks: %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.lastPrune = time.Now()
+	c.mu.Unlock()
+}
+
 // Add starts a background worker for a newly-created monitor.
-func (c *Checker) Add(ctx context.Context, m *Monitor) {
-	c.startWorker(ctx, m)
+// It does nothing while the Checker is stopped or if m belongs to another shard.
+func (c *Checker) Add(m *Monitor) {
+	if c.Running() && c.owned(m.ID) {
+		c.startWorker(m)
+	}
+}
+
+// SetOwner restricts the Checker to monitors for which owns returns true,
+// for splitting monitors across replicas. Call Sync after ownership changes.
+func (c *Checker) SetOwner(owns func(monitorID int64) bool) {
+	c.mu.Lock()
+	c.owns = owns
+	c.mu.Unlock()
+}
+
+// SetOnStateChange makes the Checker call f, in the probe's goroutine, each
+// time a monitor's state changes. Call it before Start.
+func (c *Checker) SetOnStateChange(f func(m *Monitor)) {
+	c.onStateChange = f
+}
+
+// SetRecheckOnStart makes Start probe every monitor immediately, including
+// cron monitors that would otherwise wait for their next scheduled run.
+func (c *Checker) SetRecheckOnStart(on bool) {
+	c.recheckOnStart = on
+}
+
+// SetAddrPolicy restricts the addresses probes may connect to. Call it
+// before Start.
+func (c *Checker) SetAddrPolicy(p AddrPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = p
+	clear(c.transports)
+	if c.dns != nil {
+		c.dns.setPolicy(p)
+	}
+}
+
+// SetDNSCache makes probes resolve hosts through cache. Call it before Start.
+func (c *Checker) SetDNSCache(cache *DNSCache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dns = cache
+	clear(c.transports)
+	cache.setPolicy(c.policy)
+}
+
+// DNSStats returns the DNS cache's counters, or false if there is no cache.
+func (c *Checker) DNSStats() (DNSStats, bool) {
+	c.mu.Lock()
+	cache := c.dns
+	c.mu.Unlock()
+	if cache == nil {
+		return DNSStats{}, false
+	}
+	return cache.Stats(), true
+}
+
+// transportFor returns the shared transport for m's DNS server, so monitors
+// using the same resolver share a connection pool. Monitors asserting their
+// expected IPs connect directly, since through a proxy the peer address
+// would be the proxy's.
+func (c *Checker) transportFor(m *Monitor) *http.Transport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	server, _ := ParseDNSServer(m.DNSServer) // validated on save
+	key := server
+	var resolver *net.Resolver
+	switch {
+	case server != "":
+		resolver = c.policy.dnsResolver(server)
+	case m.ExpectedIPs != "":
+		key, resolver = "direct", net.DefaultResolver
+	}
+	if t, ok := c.transports[key]; ok {
+		return t
+	}
+	t := c.policy.Transport(resolver)
+	if c.dns != nil {
+		t.DialContext = c.dns.dialContext(c.policy.dialer(nil), server)
+	}
+	c.transports[key] = t
+	return t
+}
+
+// resolverFor returns the resolver m's DNS lookups use.
+func (c *Checker) resolverFor(m *Monitor) *net.Resolver {
+	c.mu.Lock()
+	p := c.policy
+	c.mu.Unlock()
+	return p.Resolver(m)
+}
+
+func (c *Checker) owned(id int64) bool {
+	c.mu.Lock()
+	owns := c.owns
+	c.mu.Unlock()
+	return owns == nil || owns(id)
 }
 
 // Restart stops and re-starts the worker for a monitor (e.g. after an update).
-func (c *Checker) Restart(ctx context.Context, m *Monitor) {
+func (c *Checker) Restart(m *Monitor) {
 	c.stopWorker(m.ID)
-	c.startWorker(ctx, m)
+	c.Add(m)
+}
+
+// Running reports whether the Checker has been started and not stopped.
+func (c *Checker) Running() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running
+}
+
+// Sync reconciles workers with the DB: it starts workers for new monitors,
+// stops those for deleted or no longer owned ones, and restarts any whose
+// configuration changed. It picks up edits made through another server
+// instance and shard rebalancing.
+func (c *Checker) Sync() error {
+	if !c.Running() {
+		return nil
+	}
+	monitors, err := c.store.List()
+	if err != nil {
+		return err
+	}
+	seen := make(map[int64]bool, len(monitors))
+	for _, m := range monitors {
+		if !c.owned(m.ID) {
+			continue
+		}
+		seen[m.ID] = true
+		c.mu.Lock()
+		w, ok := c.workers[m.ID]
+		c.mu.Unlock()
+		switch {
+		case !ok:
+			c.Add(m)
+		case !sameConfig(&w.mon, m):
+			c.Restart(m)
+		}
+	}
+
+	c.mu.Lock()
+	var gone []int64
+	for id := range c.workers {
+		if !seen[id] {
+			gone = append(gone, id)
+		}
+	}
+	c.mu.Unlock()
+	for _, id := range gone {
+		c.stopWorker(id)
+	}
+	return nil
+}
+
+// sameConfig reports whether a and b differ only in fields the checker
+// itself writes (state, counters, hashes, timestamps).
+func sameConfig(a, b *Monitor) bool {
+	strip := func(m *Monitor) Monitor {
+		s := *m
+		s.State, s.ConsecutiveFailures, s.BudgetBreaches = "", 0, 0
+		s.ContentHash = ""
+		s.MaintenanceStart, s.MaintenanceEnd = nil, nil // read fresh on every probe
+		s.CreatedAt, s.UpdatedAt = time.Time{}, time.Time{}
+		s.KnownIssue, s.KnownIssueUntil = "", nil
+		if len(s.HTTPHeaders) == 0 {
+			s.HTTPHeaders = nil
+		}
+		return s
+	}
+	return reflect.DeepEqual(strip(a), strip(b))
+}
+
+// CheckAll asks every worker to probe immediately, still subject to the
+// concurrency limit and active schedules. It returns the number of monitors
+// queued; a worker that already has a probe pending is not counted twice.
+// A stopped Checker has no workers and queues nothing.
+func (c *Checker) CheckAll() int {
+	return c.trigger(func(*Monitor) bool { return true })
+}
+
+// CheckWorkspace is CheckAll restricted to one workspace's monitors.
+func (c *Checker) CheckWorkspace(workspaceID int64) int {
+	return c.trigger(func(m *Monitor) bool { return m.WorkspaceID == workspaceID })
+}
+
+// CheckMonitor is CheckAll restricted to monitor id, as after a push ping.
+func (c *Checker) CheckMonitor(id int64) int {
+	return c.trigger(func(m *Monitor) bool { return m.ID == id })
+}
+
+func (c *Checker) trigger(match func(*Monitor) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	queued := 0
+	for _, w := range c.workers {
+		if !match(&w.mon) {
+			continue
+		}
+		select {
+		case w.trigger <- struct{}{}:
+			queued++
+		default:
+		}
+	}
+	return queued
 }
 
 // Remove stops the background worker for a deleted monitor.
@@ -76,43 +427,90 @@ func (c *Checker) Remove(id int64) {
 	c.stopWorker(id)
 }
 
-// Stop cancels all workers and waits for them to exit.
+// Stop cancels all workers so no new probes start, then waits up to
+// drainTimeout for in-flight probes to record their results and alerts.
+// Probes still running after that are aborted and not recorded.
 func (c *Checker) Stop() {
 	c.mu.Lock()
-	ids := make([]int64, 0, len(c.cancel))
-	for id := range c.cancel {
+	c.running = false
+	if c.cancel != nil {
+		c.cancel()
+	}
+	ids := make([]int64, 0, len(c.workers))
+	for id := range c.workers {
 		ids = append(ids, id)
 	}
 	c.mu.Unlock()
 	for _, id := range ids {
 		c.stopWorker(id)
 	}
-	c.wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(drainTimeout):
+		log.Printf("checker: probes still running after %s, aborting", drainTimeout)
+		c.abortProbes()
+		<-done
+	}
+	c.abortProbes()
+}
+
+func (c *Checker) abortProbes() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.abort != nil {
+		c.abort()
+	}
 }
 
-func (c *Checker) startWorker(ctx context.Context, m *Monitor) {
-	workerCtx, cancel := context.WithCancel(ctx)
+func (c *Checker) startWorker(m *Monitor) {
+	// Work from a snapshot so later edits to m don't race with the worker.
+	mon := *m
 	c.mu.Lock()
-	c.cancel[m.ID] = cancel
+	if old, ok := c.workers[m.ID]; ok {
+		// Start and a concurrent Sync can both start the same monitor.
+		old.cancel()
+	}
+	workerCtx, cancel := context.WithCancel(c.ctx)
+	wk := &worker{cancel: cancel, trigger: make(chan struct{}, 1), mon: mon}
+	c.workers[m.ID] = wk
 	c.mu.Unlock()
 
-	id := m.ID
-	interval := time.Duration(m.IntervalSeconds) * time.Second
-	timeout := time.Duration(m.TimeoutSeconds) * time.Second
-	url := m.URL
+	interval := mon.checkEvery()
+	window, err := mon.ActiveWindow()
+	if err != nil {
+		// Validated on create/update; fall back to always-active.
+		log.Printf("monitor %d: active schedule: %v", mon.ID, err)
+	}
+	cron, err := mon.CronSchedule()
+	if err != nil {
+		// Validated on create/update; fall back to the fixed interval.
+		log.Printf("monitor %d: cron schedule: %v", mon.ID, err)
+	}
 
 	c.wg.Add(1)
 	go func() {
 		defer c.wg.Done()
+		if cron != nil {
+			c.runCron(workerCtx, &mon, window, cron, wk.trigger)
+			return
+		}
+
 		// Small stagger on first check to avoid thundering herd at startup.
 		select {
 		case <-workerCtx.Done():
 			return
+		case <-wk.trigger:
 		case <-time.After(time.Second):
 		}
 
 		// Probe immediately, then on each tick.
-		c.probe(workerCtx, id, url, timeout)
+		c.tick(workerCtx, &mon, window)
 
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
@@ -121,28 +519,211 @@ func (c *Checker) startWorker(ctx context.Context, m *Monitor) {
 			case <-workerCtx.Done():
 				return
 			case <-ticker.C:
-				c.probe(workerCtx, id, url, timeout)
+				c.tick(workerCtx, &mon, window)
+			case <-wk.trigger:
+				c.tick(workerCtx, &mon, window)
 			}
 		}
 	}()
 }
 
+// runCron probes m at each minute matched by its cron schedule. Unlike the
+// fixed-interval loop there is no probe at startup: checks only run on the
+// schedule's own boundaries.
+func (c *Checker) runCron(ctx context.Context, m *Monitor, window *ActiveWindow, cron *CronSchedule, trigger <-chan struct{}) {
+	for {
+		next := cron.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("monitor %d: cron schedule %q never fires", m.ID, m.Schedule)
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			c.tick(ctx, m, window)
+		case <-trigger:
+			timer.Stop()
+			c.tick(ctx, m, window)
+		}
+	}
+}
+
+// tick probes m if it is inside its active window, or marks it out of hours.
+func (c *Checker) tick(ctx context.Context, m *Monitor, window *ActiveWindow) {
+	// m is the worker's private snapshot, so its State only tracks whether
+	// the out-of-hours transition has already been written.
+	if !window.Contains(time.Now()) {
+		if m.State == "out_of_hours" {
+			return
+		}
+		if err := c.store.UpdateState(m.ID, "out_of_hours", 0); err != nil {
+			log.Printf("monitor %d: update state: %v", m.ID, err)
+			return
+		}
+		m.State = "out_of_hours"
+		c.triggerParents(m.ID)
+		return
+	}
+	m.State = ""
+	c.probe(ctx, m)
+}
+
+// acquire takes a slot in the concurrency limit, waiting until one is free,
+// and reports false if ctx is done first.
+func (c *Checker) acquire(ctx context.Context) bool {
+	c.waiting.Add(1)
+	defer c.waiting.Add(-1)
+	select {
+	case c.sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a slot taken by acquire.
+func (c *Checker) release() {
+	c.mu.Lock()
+	c.lastProbe = time.Now()
+	c.mu.Unlock()
+	<-c.sem
+}
+
 func (c *Checker) stopWorker(id int64) {
 	c.mu.Lock()
-	cancel, ok := c.cancel[id]
+	wk, ok := c.workers[id]
 	if ok {
-		delete(c.cancel, id)
+		delete(c.workers, id)
 	}
+	delete(c.samples, id)
 	c.mu.Unlock()
 	if ok {
-		cancel()
+		wk.cancel()
 	}
 }
 
-func (c *Checker) probe(ctx context.Context, monitorID int64, url string, timeout time.Duration) {
+// probe runs one check for m, records it, and updates the monitor's state.
+// ctx only gates starting the probe: once running, it completes under the
+// Checker's probe context so that stopping the worker doesn't record a
+// spurious "context canceled" failure.
+func (c *Checker) probe(ctx context.Context, m *Monitor) {
+	if !c.acquire(ctx) {
+		return
+	}
+	held := true
+	defer func() {
+		if held {
+			c.release()
+		}
+	}()
+	c.mu.Lock()
+	pctx := c.probeCtx
+	c.mu.Unlock()
+
+	var check Check
+	var contentHash string
+	var trace *Trace
+	for attempt := 0; ; attempt++ {
+		var ok bool
+		if check, contentHash, trace, ok = c.attempt(pctx, m); !ok {
+			return
+		}
+		if check.IsUp || attempt >= m.Retries || m.Type == TypeComposite {
+			break
+		}
+		// Wait out the delay without a slot, so that in a wide outage the
+		// retries of failing monitors don't hold up every other probe.
+		c.release()
+		held = false
+		if !sleepCtx(pctx, m.retryDelay()) || !c.acquire(pctx) {
+			break // shutting down; not recorded below
+		}
+		held = true
+	}
+	if !check.IsUp && m.Retries > 0 && m.Type != TypeComposite {
+		check.Error += fmt.Sprintf(" (%d attempts)", m.Retries+1)
+	}
+	check.MonitorID = m.ID
+	if pctx.Err() != nil {
+		log.Printf("monitor %d: probe aborted at shutdown, not recorded", m.ID)
+		return
+	}
+
+	if err := c.record(m, &check); err != nil {
+		log.Printf("monitor %d: record check: %v", m.ID, err)
+		return
+	}
+	if trace != nil {
+		trace.MonitorID = m.ID
+		if check.ID != 0 {
+			trace.CheckID = &check.ID
+		}
+		if err := c.store.RecordTrace(trace); err != nil {
+			log.Printf("monitor %d: record trace: %v", m.ID, err)
+		}
+	}
+
+	// Re-read the monitor: state, hashes and counters may have changed since
+	// the worker took its snapshot.
+	cur, err := c.store.Get(m.ID)
+	if err != nil || cur == nil {
+		return
+	}
+	c.updateState(cur, check.IsUp)
+	if contentHash != "" {
+		c.checkContent(cur, contentHash)
+	}
+	if check.IsUp {
+		c.checkBudget(cur, &check)
+	}
+}
+
+// attempt probes m once. It reports false for a composite with nothing to
+// evaluate, which records no check.
+func (c *Checker) attempt(ctx context.Context, m *Monitor) (Check, string, *Trace, bool) {
+	switch m.Type {
+	case TypeDNSBL:
+		return probeDNSBL(ctx, m, c.resolverFor(m)), "", nil, true
+	case TypeDNS:
+		return probeDNS(ctx, m, c.resolverFor(m)), "", nil, true
+	case TypeComposite:
+		check, ok := c.probeComposite(m)
+		return check, "", nil, ok
+	case TypePush:
+		return c.probePush(m), "", nil, true
+	default:
+		check, contentHash, trace := probeHTTP(ctx, m, c.transportFor(m))
+		return check, contentHash, trace, true
+	}
+}
+
+// sleepCtx waits for d and reports whether ctx was still live at the end.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// probeHTTP requests m.URL (GET unless http_method says otherwise) and returns the result along with the body hash when
+// content-change detection is enabled, and a trace when debug_trace is
+// enabled and the check failed or was slow.
+func probeHTTP(ctx context.Context, m *Monitor, transport http.RoundTripper) (Check, string, *Trace) {
+	var tr *tracer
 	client := &http.Client{
-		Timeout: timeout,
+		Transport: transport,
+		Timeout:   time.Duration(m.TimeoutSeconds) * time.Second,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if tr != nil {
+				tr.redirect(req.Response)
+			}
 			if len(via) >= 10 {
 				return http.ErrUseLastResponse
 			}
@@ -150,44 +731,167 @@ func (c *Checker) probe(ctx context.Context, monitorID int64, url string, timeou
 		},
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	var check Check
+	req, err := m.newProbeRequest(ctx)
 	if err != nil {
-		log.Printf("monitor %d: build request for %q: %v", monitorID, url, err)
-		return
+		check.Error = "build request: " + err.Error()
+		return check, "", nil
 	}
-	req.Header.Set("User-Agent", "health-dashboard/1.0")
+
+	// Note the address of the first connection (to m.URL's host, before any
+	// redirect) for the expected-IP assertion.
+	var remote netip.Addr
+	gotConn := func(info httptrace.GotConnInfo) {
+		if !remote.IsValid() {
+			if ap, err := netip.ParseAddrPort(info.Conn.RemoteAddr().String()); err == nil {
+				remote = ap.Addr()
+			}
+		}
+	}
+	hooks := &httptrace.ClientTrace{GotConn: gotConn}
+	if m.DebugTrace {
+		tr = newTracer(req)
+		hooks = tr.clientTrace(gotConn)
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, hooks))
 
 	start := time.Now()
 	resp, httpErr := client.Do(req)
 	ms := int(time.Since(start).Milliseconds())
+	check.ResponseTimeMs = &ms
 
-	check := Check{
-		MonitorID:      monitorID,
-		ResponseTimeMs: &ms,
-	}
+	var contentHash string
 	if httpErr == nil {
-		resp.Body.Close()
 		code := resp.StatusCode
 		check.StatusCode = &code
-		check.IsUp = code >= 200 && code < 400
+		check.IsUp = m.acceptsStatus(code)
+		check.Protocol, check.H3Advertised = negotiatedProtocol(resp)
+		if tr != nil {
+			tr.response(resp)
+		}
+		if m.SecurityAudit {
+			score, issues := AuditSecurityHeaders(resp)
+			check.SecurityScore = &score
+			check.SecurityIssues = issues
+		}
+
+		// Read the whole body (capped) so size and total load time are known.
+		// Only hash successful responses so error pages don't look like content changes.
+		h := sha256.New()
+		sinks := []io.Writer{h}
+		if tr != nil {
+			sinks = append(sinks, tr)
+		}
+		var jsonBody *bodyCapture
+		if m.JSONAssert != "" {
+			jsonBody = &bodyCapture{}
+			sinks = append(sinks, jsonBody)
+		}
+		n, err := io.Copy(io.MultiWriter(sinks...), io.LimitReader(resp.Body, maxBodyBytes))
+		resp.Body.Close()
+		if err == nil {
+			loadMs := int(time.Since(start).Milliseconds())
+			check.ResponseBytes = &n
+			check.LoadTimeMs = &loadMs
+			if m.DetectContentChange && check.IsUp {
+				contentHash = hex.EncodeToString(h.Sum(nil))
+			}
+		}
+		switch {
+		case !check.IsUp:
+			check.Error = fmt.Sprintf("unexpected status %d", code)
+		case m.AssertHTTP2 && check.Protocol != "h2":
+			check.IsUp = false
+			check.Error = fmt.Sprintf("expected HTTP/2, got %s", check.Protocol)
+		case remote.IsValid() && m.unexpectedAddr([]netip.Addr{remote}) != "":
+			check.IsUp = false
+			check.Error = fmt.Sprintf("unexpected address %s (expected %s)", remote.Unmap(), m.ExpectedIPs)
+		case jsonBody != nil && jsonBody.over:
+			check.IsUp = false
+			check.Error = fmt.Sprintf("response over %d bytes, too large for json_assert", maxJSONAssertBody)
+		case jsonBody != nil:
+			if msg := m.jsonAssertFailure(jsonBody.buf.Bytes()); msg != "" {
+				check.IsUp = false
+				check.Error = msg
+			}
+		}
+	} else {
+		// IsUp stays false, StatusCode stays nil.
+		check.Error = httpErr.Error()
 	}
-	// httpErr != nil → IsUp stays false, StatusCode stays nil.
 
-	if err := c.store.RecordCheck(&check); err != nil {
-		log.Printf("monitor %d: record check: %v", monitorID, err)
-		return
+	if check.IsUp && m.AssertCanonical {
+		if err := checkCanonical(ctx, client, m.URL); err != nil {
+			check.IsUp = false
+			check.Error = "canonical redirect: " + err.Error()
+		}
 	}
+	if tr != nil {
+		return check, contentHash, tr.finish(m, &check)
+	}
+	return check, contentHash, nil
+}
 
-	c.updateState(monitorID, check.IsUp)
+// maxBodyBytes caps how much of a response body is read (and hashed for
+// content-change detection).
+const maxBodyBytes = 10 << 20
+
+// checkContent compares hash with the monitor's last-seen body hash and
+// alerts on a change, unless the monitor is in its maintenance window.
+// The new hash is always stored so each change alerts only once.
+func (c *Checker) checkContent(m *Monitor, hash string) {
+	if m.ContentHash == hash {
+		return
+	}
+	if err := c.store.SetContentHash(m.ID, hash); err != nil {
+		log.Printf("monitor %d: store content hash: %v", m.ID, err)
+		return
+	}
+	// The first hash is a baseline, not a change.
+	if m.ContentHash != "" && !m.InMaintenance(time.Now()) {
+		go c.alerter.NotifyStatus(m, "content_changed",
+			fmt.Sprintf("response body hash changed from %.12s to %.12s", m.ContentHash, hash))
+	}
 }
 
-func (c *Checker) updateState(monitorID int64, isUp bool) {
-	m, err := c.store.Get(monitorID)
-	if err != nil || m == nil {
+// checkBudget tracks consecutive checks that exceed the monitor's page-weight
+// or load-time budget and alerts once the regression is sustained for
+// DefaultFailureThreshold checks.
+func (c *Checker) checkBudget(m *Monitor, check *Check) {
+	if m.BudgetBytes <= 0 && m.BudgetMs <= 0 {
+		return
+	}
+	var over []string
+	if m.BudgetBytes > 0 && check.ResponseBytes != nil && *check.ResponseBytes > m.BudgetBytes {
+		over = append(over, fmt.Sprintf("size %d B > budget %d B", *check.ResponseBytes, m.BudgetBytes))
+	}
+	if m.BudgetMs > 0 && check.LoadTimeMs != nil && *check.LoadTimeMs > m.BudgetMs {
+		over = append(over, fmt.Sprintf("load time %d ms > budget %d ms", *check.LoadTimeMs, m.BudgetMs))
+	}
+
+	breaches := 0
+	if len(over) > 0 {
+		breaches = m.BudgetBreaches + 1
+	}
+	if breaches == m.BudgetBreaches {
 		return
 	}
+	if err := c.store.SetBudgetBreaches(m.ID, breaches); err != nil {
+		log.Printf("monitor %d: update budget breaches: %v", m.ID, err)
+		return
+	}
+	if breaches == DefaultFailureThreshold {
+		go c.alerter.NotifyStatus(m, "over_budget", strings.Join(over, "; "))
+	}
+}
 
+func (c *Checker) updateState(m *Monitor, isUp bool) {
+	monitorID := m.ID
 	prevState := m.State
+	if prevState == "out_of_hours" {
+		// Back inside the window: start from a clean slate.
+		m.State, m.ConsecutiveFailures = "unknown", 0
+	}
 
 	var newState string
 	var failures int
@@ -197,7 +901,9 @@ func (c *Checker) updateState(monitorID int64, isUp bool) {
 		failures = 0
 	} else {
 		failures = m.ConsecutiveFailures + 1
-		if failures >= failureThreshold {
+		// A composite's children have already waited out their own
+		// failure threshold.
+		if failures >= m.DownAfter() || m.Type == TypeComposite {
 			newState = "down"
 		} else {
 			// Not enough consecutive failures yet — hold current state.
@@ -210,8 +916,34 @@ func (c *Checker) updateState(monitorID int64, isUp bool) {
 		return
 	}
 
+	if newState != prevState && m.Type != TypeComposite {
+		c.triggerParents(monitorID)
+	}
+	if newState != prevState && c.onStateChange != nil {
+		c.onStateChange(m)
+	}
+
 	// Fire webhook alert on the first transition into "down".
 	if newState == "down" && prevState != "down" {
 		go c.alerter.Notify(m)
 	}
+	if newState == "up" && prevState == "down" {
+		go c.alerter.Recovered(m)
+	}
+}
+
+// negotiatedProtocol reports the HTTP version a response came over ("h1" or
+// "h2") and whether the server advertises HTTP/3 via Alt-Svc. The client
+// does not speak HTTP/3, so h3 is only ever advertised, never negotiated.
+func negotiatedProtocol(resp *http.Response) (proto string, h3 bool) {
+	proto = "h1"
+	if resp.ProtoMajor == 2 {
+		proto = "h2"
+	}
+	for _, alt := range strings.Split(resp.Header.Get("Alt-Svc"), ",") {
+		if id, _, _ := strings.Cut(strings.TrimSpace(alt), "="); id == "h3" {
+			h3 = true
+		}
+	}
+	return proto, h3
 }
@@ -0,0 +1,154 @@
+package monitor
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Composite modes: how a composite monitor's children combine into its state.
+const (
+	CompositeAll      = "all"      // up while every child is up
+	CompositeQuorum   = "quorum"   // up while at least composite_threshold children are up
+	CompositeWeighted = "weighted" // up while children holding composite_threshold% of the weight are up
+)
+
+// Child is one monitor a composite is derived from.
+type Child struct {
+	ID     int64
+	Weight int // 1 unless given; only used in weighted mode
+}
+
+// ParseChildren normalises a composite monitor's children setting: a
+// comma-separated list of monitor IDs, each optionally followed by
+// ":weight" ("12,15:2,18"). Duplicates are rejected.
+func ParseChildren(s string) ([]Child, error) {
+	var out []Child
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		idStr, wStr, hasWeight := strings.Cut(f, ":")
+		id, err := strconv.ParseInt(strings.TrimSpace(idStr), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("children: %q is not a monitor ID", f)
+		}
+		c := Child{ID: id, Weight: 1}
+		if hasWeight {
+			c.Weight, err = strconv.Atoi(strings.TrimSpace(wStr))
+			if err != nil || c.Weight <= 0 {
+				return nil, fmt.Errorf("children: weight in %q must be a positive integer", f)
+			}
+		}
+		if slices.ContainsFunc(out, func(o Child) bool { return o.ID == id }) {
+			return nil, fmt.Errorf("children: monitor %d is listed twice", id)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// FormatChildren is the stored form of children, as accepted by ParseChildren.
+func FormatChildren(children []Child) string {
+	parts := make([]string, len(children))
+	for i, c := range children {
+		parts[i] = strconv.FormatInt(c.ID, 10)
+		if c.Weight != 1 {
+			parts[i] += ":" + strconv.Itoa(c.Weight)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// ValidateComposite checks a composite monitor's mode and threshold against
+// its children, normalising both. children is the parsed Children field.
+func (m *Monitor) ValidateComposite(children []Child) error {
+	if len(children) == 0 {
+		return fmt.Errorf("children is required for composite monitors")
+	}
+	if m.CompositeMode == "" {
+		m.CompositeMode = CompositeAll
+	}
+	switch m.CompositeMode {
+	case CompositeAll:
+		m.CompositeThreshold = 0
+	case CompositeQuorum:
+		if m.CompositeThreshold < 1 || m.CompositeThreshold > len(children) {
+			return fmt.Errorf("composite_threshold must be between 1 and %d (the number of children)", len(children))
+		}
+	case CompositeWeighted:
+		if m.CompositeThreshold < 1 || m.CompositeThreshold > 100 {
+			return fmt.Errorf("composite_threshold must be a percentage between 1 and 100")
+		}
+	default:
+		return fmt.Errorf("composite_mode must be all, quorum or weighted")
+	}
+	return nil
+}
+
+// HasChild reports whether composite m is derived from monitor id.
+func (m *Monitor) HasChild(id int64) bool {
+	children, _ := ParseChildren(m.Children) // validated on save
+	return slices.ContainsFunc(children, func(c Child) bool { return c.ID == id })
+}
+
+// probeComposite derives a check for composite m from its children's current
+// states. Children without a current result (unknown, out of hours, in
+// maintenance, or deleted) are left out: "all" needs every remaining child
+// up, "quorum" needs composite_threshold of them (or all, if fewer remain),
+// and "weighted" needs composite_threshold percent of their weight. ok is
+// false when no child has a result, so nothing is recorded.
+func (c *Checker) probeComposite(m *Monitor) (check Check, ok bool) {
+	children, err := ParseChildren(m.Children)
+	if err != nil {
+		log.Printf("monitor %d: %v", m.ID, err)
+		return check, false
+	}
+	now := time.Now()
+	var counted, up, weight, upWeight int
+	var down []string
+	for _, ch := range children {
+		child, err := c.store.Get(ch.ID)
+		if err != nil {
+			log.Printf("monitor %d: load child %d: %v", m.ID, ch.ID, err)
+			continue
+		}
+		if child == nil || child.InMaintenance(now) || (child.State != "up" && child.State != "down") {
+			continue
+		}
+		counted++
+		weight += ch.Weight
+		if child.State == "up" {
+			up++
+			upWeight += ch.Weight
+		} else {
+			down = append(down, child.Name)
+		}
+	}
+	if counted == 0 {
+		return check, false
+	}
+
+	switch m.CompositeMode {
+	case CompositeQuorum:
+		check.IsUp = up >= min(m.CompositeThreshold, counted)
+	case CompositeWeighted:
+		check.IsUp = upWeight*100 >= m.CompositeThreshold*weight
+	default:
+		check.IsUp = up == counted
+	}
+	if !check.IsUp {
+		check.Error = fmt.Sprintf("%d of %d children up; down: %s", up, counted, strings.Join(down, ", "))
+	}
+	return check, true
+}
+
+// triggerParents asks the workers of composites derived from monitor id to
+// re-evaluate now, after its state changed.
+func (c *Checker) triggerParents(id int64) {
+	c.trigger(func(m *Monitor) bool { return m.Type == TypeComposite && m.HasChild(id) })
+}
@@ -0,0 +1,65 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// Kinds of credentials an http monitor can probe with.
+const (
+	AuthBasic  = "basic"  // auth_username and auth_secret as the password
+	AuthBearer = "bearer" // auth_secret as the token
+)
+
+// maxAuthSecret bounds an http monitor's auth_secret.
+const maxAuthSecret = 4096
+
+// ParseAuth checks an http monitor's auth_type, auth_username and
+// auth_secret. Without an auth_type the other two must be empty.
+func ParseAuth(authType, username, secret string) (string, error) {
+	authType = strings.ToLower(strings.TrimSpace(authType))
+	switch authType {
+	case "":
+		if username != "" || secret != "" {
+			return "", errors.New("auth_username and auth_secret need an auth_type")
+		}
+		return "", nil
+	case AuthBasic:
+		if username == "" {
+			return "", errors.New("auth_type basic needs an auth_username")
+		}
+		if strings.Contains(username, ":") {
+			return "", errors.New("auth_username must not contain a colon")
+		}
+	case AuthBearer:
+		if username != "" {
+			return "", errors.New("auth_username only applies to auth_type basic")
+		}
+		if secret == "" {
+			return "", errors.New("auth_type bearer needs an auth_secret")
+		}
+	default:
+		return "", fmt.Errorf("auth_type must be %s or %s", AuthBasic, AuthBearer)
+	}
+	if len(username) > 256 || !httpguts.ValidHeaderFieldValue(username) {
+		return "", errors.New("auth_username is invalid")
+	}
+	if len(secret) > maxAuthSecret || !httpguts.ValidHeaderFieldValue(secret) {
+		return "", fmt.Errorf("auth_secret must be at most %d bytes, without line breaks", maxAuthSecret)
+	}
+	return authType, nil
+}
+
+// setAuth adds m's credentials, if any, to a probe request.
+func (m *Monitor) setAuth(req *http.Request) {
+	switch m.AuthType {
+	case AuthBasic:
+		req.SetBasicAuth(m.AuthUsername, m.AuthSecret)
+	case AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+m.AuthSecret)
+	}
+}
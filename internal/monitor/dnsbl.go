@@ -0,0 +1,135 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Monitor types.
+const (
+	TypeHTTP      = "http"
+	TypeDNSBL     = "dnsbl"
+	TypeDNS       = "dns"
+	TypeComposite = "composite"
+	TypePush      = "push"
+)
+
+// DefaultDNSBLZones are queried when a dnsbl monitor lists no zones.
+var DefaultDNSBLZones = []string{"zen.spamhaus.org", "bl.spamcop.net", "b.barracudacentral.org"}
+
+// Zones returns the DNS blacklist zones a dnsbl monitor queries.
+func (m *Monitor) Zones() []string {
+	var zones []string
+	for _, z := range strings.Split(m.DNSBLZones, ",") {
+		if z = strings.Trim(strings.TrimSpace(z), "."); z != "" {
+			zones = append(zones, z)
+		}
+	}
+	if len(zones) == 0 {
+		return DefaultDNSBLZones
+	}
+	return zones
+}
+
+// probeDNSBL checks whether any IPv4 address of m.URL (a hostname or IP) is
+// listed on the monitor's blacklist zones. A listing marks the check down.
+func probeDNSBL(ctx context.Context, m *Monitor, resolver *net.Resolver) (check Check) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(m.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	defer func() {
+		ms := int(time.Since(start).Milliseconds())
+		check.ResponseTimeMs = &ms
+	}()
+
+	ips, err := resolveIPv4(ctx, resolver, strings.TrimSpace(m.URL))
+	if err != nil {
+		check.Error = "resolve: " + err.Error()
+		return check
+	}
+
+	addrs := make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		if a, ok := netip.AddrFromSlice(ip); ok {
+			addrs = append(addrs, a)
+		}
+	}
+	if a := m.unexpectedAddr(addrs); a != "" {
+		check.Error = fmt.Sprintf("unexpected address %s (expected %s)", a, m.ExpectedIPs)
+		return check
+	}
+
+	var listings, failures []string
+	for _, ip := range ips {
+		rev := reverseIPv4(ip)
+		for _, zone := range m.Zones() {
+			listed, err := dnsblListed(ctx, resolver, rev+"."+zone)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", zone, err))
+				continue
+			}
+			if listed {
+				listings = append(listings, fmt.Sprintf("%s on %s", ip, zone))
+			}
+		}
+	}
+
+	switch {
+	case len(listings) > 0:
+		check.Error = "listed: " + strings.Join(listings, ", ")
+	case len(failures) == len(ips)*len(m.Zones()):
+		// No zone answered, so listing status is unknown.
+		check.Error = "all lookups failed: " + strings.Join(failures, "; ")
+	default:
+		check.IsUp = true
+	}
+	return check
+}
+
+func resolveIPv4(ctx context.Context, resolver *net.Resolver, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return []net.IP{ip4}, nil
+		}
+		return nil, fmt.Errorf("%s is not an IPv4 address", host)
+	}
+	addrs, err := resolver.LookupIP(ctx, "ip4", host)
+	if err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
+
+func reverseIPv4(ip net.IP) string {
+	ip4 := ip.To4()
+	return fmt.Sprintf("%d.%d.%d.%d", ip4[3], ip4[2], ip4[1], ip4[0])
+}
+
+// dnsblListed reports whether name resolves to a listing code (127.0.0.0/8).
+// NXDOMAIN means not listed. Spamhaus answers 127.255.255.x when it refuses
+// the query (e.g. via an open public resolver), which is an error, not a listing.
+func dnsblListed(ctx context.Context, resolver *net.Resolver, name string) (bool, error) {
+	addrs, err := resolver.LookupHost(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, a := range addrs {
+		if strings.HasPrefix(a, "127.255.255.") {
+			return false, fmt.Errorf("query refused (%s)", a)
+		}
+		if strings.HasPrefix(a, "127.") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,332 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsLookupTimeout bounds a cached lookup. It runs apart from the probe that
+// started it, so one monitor's short timeout doesn't fail the others
+// waiting on the same name.
+const dnsLookupTimeout = 10 * time.Second
+
+// maxDNSEntries is how many names the cache holds before expired entries
+// are swept on the next miss.
+const maxDNSEntries = 4096
+
+// DNSCache is a resolver cache shared by probes, so dozens of monitors on the
+// same few hostnames cost one lookup per TTL. Answers are kept for their
+// record TTL clamped to [MinTTL, MaxTTL]; a name that doesn't exist is kept
+// for NegativeTTL. Other failures (timeouts, SERVFAIL) are not cached.
+type DNSCache struct {
+	MinTTL      time.Duration
+	MaxTTL      time.Duration
+	NegativeTTL time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*dnsEntry // by DNS server and name
+	resolvers map[string]*net.Resolver
+	policy    AddrPolicy // for monitors' own DNS servers
+	stats     DNSStats
+}
+
+// dnsEntry is one cached lookup. ready is closed once it has completed.
+type dnsEntry struct {
+	addrs   []netip.Addr
+	err     error
+	expires time.Time
+	ready   chan struct{}
+}
+
+// DNSStats are counters for GET /api/checker/dns-cache.
+type DNSStats struct {
+	Entries      int   `json:"entries"`       // live cached names
+	Hits         int64 `json:"hits"`          // lookups answered from the cache
+	NegativeHits int64 `json:"negative_hits"` // of which for names that don't exist
+	Misses       int64 `json:"misses"`        // lookups sent to a DNS server
+	Errors       int64 `json:"errors"`        // misses that failed
+}
+
+// NewDNSCache returns an empty cache with the given TTL bounds.
+func NewDNSCache(minTTL, maxTTL, negativeTTL time.Duration) *DNSCache {
+	return &DNSCache{
+		MinTTL:      minTTL,
+		MaxTTL:      maxTTL,
+		NegativeTTL: negativeTTL,
+		entries:     make(map[string]*dnsEntry),
+		resolvers:   make(map[string]*net.Resolver),
+	}
+}
+
+// Stats returns the cache's counters so far.
+func (c *DNSCache) Stats() DNSStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.stats
+	now := time.Now()
+	for _, e := range c.entries {
+		select {
+		case <-e.ready:
+			if now.Before(e.expires) {
+				st.Entries++
+			}
+		default:
+		}
+	}
+	return st
+}
+
+// Lookup returns host's addresses as resolved by server (host:port, or ""
+// for the system resolver), from the cache while they are fresh. Concurrent
+// lookups of the same name share one query.
+func (c *DNSCache) Lookup(ctx context.Context, server, host string) ([]netip.Addr, error) {
+	key := server + " " + strings.ToLower(strings.TrimSuffix(host, "."))
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		select {
+		case <-e.ready:
+			if time.Now().Before(e.expires) {
+				c.hit(e)
+				c.mu.Unlock()
+				return e.addrs, e.err
+			}
+		default:
+			// In flight: wait for it rather than asking again.
+			c.stats.Hits++
+			c.mu.Unlock()
+			select {
+			case <-e.ready:
+				return e.addrs, e.err
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	if len(c.entries) >= maxDNSEntries {
+		c.sweep()
+	}
+	e := &dnsEntry{ready: make(chan struct{})}
+	c.entries[key] = e
+	c.stats.Misses++
+	r := c.resolver(server)
+	c.mu.Unlock()
+
+	rec := &ttlRecorder{}
+	lctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dnsLookupTimeout)
+	e.addrs, e.err = r.LookupNetIP(context.WithValue(lctx, ttlKey{}, rec), "ip", host)
+	cancel()
+
+	now := time.Now()
+	var dnsErr *net.DNSError
+	switch {
+	case e.err == nil:
+		ttl, ok := rec.get()
+		if !ok {
+			ttl = c.MinTTL // e.g. from /etc/hosts, which has no TTL
+		}
+		e.expires = now.Add(min(max(ttl, c.MinTTL), c.MaxTTL))
+	case errors.As(e.err, &dnsErr):
+		if server != "" {
+			dnsErr.Server = server // not the resolv.conf server the resolver thinks it asked
+		}
+		if dnsErr.IsNotFound {
+			e.expires = now.Add(c.NegativeTTL)
+		}
+	}
+	c.mu.Lock()
+	if e.err != nil {
+		c.stats.Errors++
+	}
+	if e.expires.IsZero() && c.entries[key] == e {
+		delete(c.entries, key)
+	}
+	c.mu.Unlock()
+	close(e.ready)
+	return e.addrs, e.err
+}
+
+// hit counts a fresh cached answer. c.mu must be held.
+func (c *DNSCache) hit(e *dnsEntry) {
+	c.stats.Hits++
+	if e.err != nil {
+		c.stats.NegativeHits++
+	}
+}
+
+// sweep drops expired entries. c.mu must be held.
+func (c *DNSCache) sweep() {
+	now := time.Now()
+	for k, e := range c.entries {
+		select {
+		case <-e.ready:
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		default:
+		}
+	}
+}
+
+// resolver returns the resolver for server, which reports the TTLs of the
+// answers it receives to the ttlRecorder in its lookup's context. c.mu must
+// be held.
+func (c *DNSCache) resolver(server string) *net.Resolver {
+	if r, ok := c.resolvers[server]; ok {
+		return r
+	}
+	policy := c.policy
+	r := &net.Resolver{
+		PreferGo: true, // the cgo resolver doesn't dial through Dial
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			d := &net.Dialer{Timeout: 5 * time.Second}
+			if server != "" {
+				address, d = server, policy.dnsDialer()
+			}
+			conn, err := d.DialContext(ctx, network, address)
+			rec, ok := ctx.Value(ttlKey{}).(*ttlRecorder)
+			if !ok || err != nil {
+				return conn, err
+			}
+			tc := &ttlConn{Conn: conn, rec: rec}
+			if pc, ok := conn.(net.PacketConn); ok {
+				// The resolver tells UDP from TCP by this interface.
+				return &ttlPacketConn{ttlConn: tc, pc: pc}, nil
+			}
+			return tc, nil
+		},
+	}
+	c.resolvers[server] = r
+	return r
+}
+
+// setPolicy makes lookups through monitors' DNS servers enforce p.
+func (c *DNSCache) setPolicy(p AddrPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = p
+	clear(c.resolvers)
+}
+
+// dialContext returns a DialContext for http.Transport that resolves hosts
+// through the cache and dials their addresses in turn with d.
+func (c *DNSCache) dialContext(d *net.Dialer, server string) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := netip.ParseAddr(host); err == nil {
+			return d.DialContext(ctx, network, address)
+		}
+		addrs, err := c.Lookup(ctx, server, host)
+		if err != nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+		}
+		var firstErr error
+		for _, a := range addrs {
+			if (network == "tcp4" && !a.Is4()) || (network == "tcp6" && !a.Is6()) {
+				continue
+			}
+			conn, err := d.DialContext(ctx, network, net.JoinHostPort(a.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		if firstErr == nil {
+			firstErr = &net.OpError{Op: "dial", Net: network,
+				Err: &net.DNSError{Err: "no suitable address found", Name: host, IsNotFound: true}}
+		}
+		return nil, firstErr
+	}
+}
+
+// ttlKey is the context key for a lookup's ttlRecorder.
+type ttlKey struct{}
+
+// ttlRecorder collects the lowest TTL among the DNS answers of one lookup
+// (its A and AAAA queries, and any CNAMEs leading to them).
+type ttlRecorder struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen bool
+}
+
+func (r *ttlRecorder) get() (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ttl, r.seen
+}
+
+// observe notes the answer TTLs in msg, if it parses as a DNS response.
+func (r *ttlRecorder) observe(msg []byte) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || !h.Response || p.SkipAllQuestions() != nil {
+		return
+	}
+	for {
+		ah, err := p.AnswerHeader()
+		if err != nil {
+			return
+		}
+		switch ah.Type {
+		case dnsmessage.TypeA, dnsmessage.TypeAAAA, dnsmessage.TypeCNAME:
+			ttl := time.Duration(ah.TTL) * time.Second
+			r.mu.Lock()
+			if !r.seen || ttl < r.ttl {
+				r.ttl, r.seen = ttl, true
+			}
+			r.mu.Unlock()
+		}
+		if p.SkipAnswer() != nil {
+			return
+		}
+	}
+}
+
+// ttlConn passes the DNS responses read from a resolver connection to rec.
+// Over UDP each read is one message; over TCP the message follows a
+// separately read length prefix, which doesn't parse and is ignored.
+type ttlConn struct {
+	net.Conn
+	rec *ttlRecorder
+}
+
+func (c *ttlConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.rec.observe(b[:n])
+	}
+	return n, err
+}
+
+// ttlPacketConn is a ttlConn over UDP.
+type ttlPacketConn struct {
+	*ttlConn
+	pc net.PacketConn
+}
+
+func (c *ttlPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.pc.ReadFrom(b)
+	if n > 0 {
+		c.rec.observe(b[:n])
+	}
+	return n, addr, err
+}
+
+func (c *ttlPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.pc.WriteTo(b, addr)
+}
@@ -0,0 +1,176 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DNSRecordTypes are the record types a dns monitor can query.
+var DNSRecordTypes = []string{"A", "AAAA", "CNAME", "MX", "TXT"}
+
+// maxDNSExpected caps a dns monitor's dns_expected setting.
+const maxDNSExpected = 2048
+
+// ParseDNSRecord normalises a dns monitor's url (the name to query), record
+// type and dns_expected setting. The type defaults to A. For A and AAAA the
+// expected addresses are expected_ips instead, so dns_expected must be
+// empty; for CNAME and MX it is a comma-separated list of host names, and
+// for TXT a single record value.
+func ParseDNSRecord(name, recordType, expected string) (string, string, string, error) {
+	name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+	if name == "" || strings.ContainsAny(name, "/: ") {
+		return "", "", "", fmt.Errorf("url must be the DNS name to query, not %q", name)
+	}
+	recordType = strings.ToUpper(strings.TrimSpace(recordType))
+	if recordType == "" {
+		recordType = "A"
+	}
+	if !slices.Contains(DNSRecordTypes, recordType) {
+		return "", "", "", fmt.Errorf("dns_record_type must be one of %s", strings.Join(DNSRecordTypes, ", "))
+	}
+	if len(expected) > maxDNSExpected {
+		return "", "", "", fmt.Errorf("dns_expected must be at most %d bytes", maxDNSExpected)
+	}
+	switch recordType {
+	case "A", "AAAA":
+		if strings.TrimSpace(expected) != "" {
+			return "", "", "", errors.New("dns_expected doesn't apply to A and AAAA records; use expected_ips")
+		}
+		expected = ""
+	case "CNAME", "MX":
+		var hosts []string
+		for _, h := range strings.Split(expected, ",") {
+			if h = normalizeHost(h); h != "" && !slices.Contains(hosts, h) {
+				hosts = append(hosts, h)
+			}
+		}
+		expected = strings.Join(hosts, ",")
+	case "TXT":
+		expected = strings.TrimSpace(expected)
+	}
+	return name, recordType, expected, nil
+}
+
+func normalizeHost(h string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(h), "."))
+}
+
+// probeDNS queries m.URL's DNSRecordType records through the monitor's DNS
+// server and checks them against what is expected. A missing record is down.
+// With an expectation set, A and AAAA answers must all fall in expected_ips;
+// CNAME and MX answers must be exactly the dns_expected hosts, so both an
+// extra (hijacked) and a missing (not yet propagated) one are down; and one
+// TXT record must equal dns_expected.
+func probeDNS(ctx context.Context, m *Monitor, resolver *net.Resolver) (check Check) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(m.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	defer func() {
+		ms := int(time.Since(start).Milliseconds())
+		check.ResponseTimeMs = &ms
+	}()
+
+	answers, err := lookupRecords(ctx, resolver, m.DNSRecordType, m.URL)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			check.Error = fmt.Sprintf("no %s record for %s", m.DNSRecordType, m.URL)
+		} else {
+			check.Error = "lookup: " + err.Error()
+		}
+		return check
+	}
+	if len(answers) == 0 {
+		check.Error = fmt.Sprintf("no %s record for %s", m.DNSRecordType, m.URL)
+		return check
+	}
+
+	switch m.DNSRecordType {
+	case "A", "AAAA":
+		addrs := make([]netip.Addr, 0, len(answers))
+		for _, a := range answers {
+			if ip, err := netip.ParseAddr(a); err == nil {
+				addrs = append(addrs, ip)
+			}
+		}
+		if a := m.unexpectedAddr(addrs); a != "" {
+			check.Error = fmt.Sprintf("unexpected address %s (expected %s)", a, m.ExpectedIPs)
+			return check
+		}
+	case "CNAME", "MX":
+		if m.DNSExpected == "" {
+			break
+		}
+		expected := strings.Split(m.DNSExpected, ",")
+		for _, a := range answers {
+			if !slices.Contains(expected, a) {
+				check.Error = fmt.Sprintf("unexpected %s %s (expected %s)", m.DNSRecordType, a, m.DNSExpected)
+				return check
+			}
+		}
+		for _, e := range expected {
+			if !slices.Contains(answers, e) {
+				check.Error = fmt.Sprintf("%s %s missing (got %s)", m.DNSRecordType, e, strings.Join(answers, ","))
+				return check
+			}
+		}
+	case "TXT":
+		if m.DNSExpected != "" && !slices.Contains(answers, m.DNSExpected) {
+			check.Error = fmt.Sprintf("no TXT record %q among %d", m.DNSExpected, len(answers))
+			return check
+		}
+	}
+	check.IsUp = true
+	return check
+}
+
+// lookupRecords returns name's records of recordType: addresses, host names
+// (lowercase, without the trailing dot) or TXT values.
+func lookupRecords(ctx context.Context, resolver *net.Resolver, recordType, name string) ([]string, error) {
+	switch recordType {
+	case "A", "AAAA":
+		network := "ip4"
+		if recordType == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := resolver.LookupNetIP(ctx, network, name)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]string, len(ips))
+		for i, ip := range ips {
+			out[i] = ip.Unmap().String()
+		}
+		return out, nil
+	case "CNAME":
+		// The lookup answers with the name itself when it has no CNAME.
+		cname, err := resolver.LookupCNAME(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if c := normalizeHost(cname); c != name {
+			return []string{c}, nil
+		}
+		return nil, nil
+	case "MX":
+		mxs, err := resolver.LookupMX(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]string, len(mxs))
+		for i, mx := range mxs {
+			out[i] = normalizeHost(mx.Host)
+		}
+		return out, nil
+	case "TXT":
+		return resolver.LookupTXT(ctx, name)
+	}
+	return nil, fmt.Errorf("unsupported record type %q", recordType)
+}
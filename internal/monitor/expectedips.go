@@ -0,0 +1,67 @@
+package monitor
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ParseExpectedIPs normalises a monitor's expected_ips setting: a
+// comma-separated list of addresses or CIDR prefixes. Empty disables the
+// assertion.
+func ParseExpectedIPs(s string) (string, error) {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		p, err := parseIPOrPrefix(f)
+		if err != nil {
+			return "", fmt.Errorf("expected_ips: %q is not an IP address or CIDR prefix", f)
+		}
+		if p.IsSingleIP() {
+			out = append(out, p.Addr().String())
+		} else {
+			out = append(out, p.String())
+		}
+	}
+	return strings.Join(out, ","), nil
+}
+
+func parseIPOrPrefix(s string) (netip.Prefix, error) {
+	if a, err := netip.ParseAddr(s); err == nil {
+		a = a.Unmap()
+		return netip.PrefixFrom(a, a.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	return p.Masked(), err
+}
+
+// unexpectedAddr returns the first of addrs outside m's expected set, or ""
+// when all match or no set is configured.
+func (m *Monitor) unexpectedAddr(addrs []netip.Addr) string {
+	if m.ExpectedIPs == "" {
+		return ""
+	}
+	var expected []netip.Prefix
+	for _, f := range strings.Split(m.ExpectedIPs, ",") {
+		if p, err := parseIPOrPrefix(strings.TrimSpace(f)); err == nil {
+			expected = append(expected, p)
+		}
+	}
+	for _, a := range addrs {
+		a = a.Unmap()
+		ok := false
+		for _, p := range expected {
+			if p.Contains(a) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return a.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,112 @@
+package monitor
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Gap is a period with no check data for a monitor, e.g. while the server
+// was down. Uptime is computed from checks only, so a gap is neither up nor
+// down time.
+type Gap struct {
+	MonitorID int64     `json:"monitor_id"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+}
+
+// sqliteTime formats t like datetime('now') so it compares correctly with
+// timestamps SQLite generates.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// RecordGap stores a no-data period for monitorID.
+func (s *Store) RecordGap(monitorID int64, start, end time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO data_gaps (monitor_id, started_at, ended_at) VALUES (?, ?, ?)`,
+		monitorID, sqliteTime(start), sqliteTime(end))
+	return err
+}
+
+// Gaps returns monitorID's no-data periods that ended after since, oldest first.
+func (s *Store) Gaps(monitorID int64, since time.Time) ([]Gap, error) {
+	rows, err := s.db.Query(`
+		SELECT monitor_id, started_at, ended_at FROM data_gaps
+		WHERE monitor_id = ? AND ended_at >= ?
+		ORDER BY started_at`, monitorID, sqliteTime(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var gaps []Gap
+	for rows.Next() {
+		var g Gap
+		if err := rows.Scan(&g.MonitorID, &g.StartedAt, &g.EndedAt); err != nil {
+			return nil, err
+		}
+		gaps = append(gaps, g)
+	}
+	return gaps, rows.Err()
+}
+
+// LastCheckAt returns when monitorID was last checked, or the zero time if never.
+func (s *Store) LastCheckAt(monitorID int64) (time.Time, error) {
+	var t time.Time
+	var until sql.NullTime // set when later successes were folded into the row
+	err := s.db.QueryRow(`SELECT checked_at, sampled_until FROM checks WHERE monitor_id = ? ORDER BY checked_at DESC LIMIT 1`,
+		monitorID).Scan(&t, &until)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if until.Valid {
+		t = until.Time
+	}
+	return t, err
+}
+
+// staleAfter is how long past its last check a monitor may go before the
+// missing stretch counts as a gap.
+func staleAfter(m *Monitor) time.Duration {
+	interval := m.checkEvery()
+	return max(2*interval, interval+time.Minute)
+}
+
+// reconcile runs at Start. For each monitor whose last check is older than
+// its schedule allows, it records the stretch as a gap and resets the state
+// to "unknown" so stale up/down states aren't shown as current. It returns
+// the IDs of the stale monitors.
+func (c *Checker) reconcile(monitors []*Monitor, now time.Time) []int64 {
+	var stale []int64
+	for _, m := range monitors {
+		last, err := c.store.LastCheckAt(m.ID)
+		if err != nil {
+			log.Printf("monitor %d: last check: %v", m.ID, err)
+			continue
+		}
+		if last.IsZero() {
+			continue
+		}
+		expected := last.Add(staleAfter(m))
+		if cron, err := m.CronSchedule(); err == nil && cron != nil {
+			// Allow the next scheduled run plus the same grace as an interval monitor.
+			expected = cron.Next(last).Add(time.Minute)
+		}
+		if !now.After(expected) {
+			continue
+		}
+		stale = append(stale, m.ID)
+		if err := c.store.RecordGap(m.ID, last, now); err != nil {
+			log.Printf("monitor %d: record gap: %v", m.ID, err)
+		}
+		if m.State == "up" || m.State == "down" {
+			if err := c.store.UpdateState(m.ID, "unknown", 0); err != nil {
+				log.Printf("monitor %d: update state: %v", m.ID, err)
+			}
+			m.State, m.ConsecutiveFailures = "unknown", 0
+		}
+	}
+	if len(stale) > 0 {
+		log.Printf("checker: %d monitors had no data since before startup; recorded gaps", len(stale))
+	}
+	return stale
+}
@@ -0,0 +1,54 @@
+package monitor
+
+import "time"
+
+// Heatmap buckets a monitor's checks by weekday and hour of day, so failures
+// that recur at the same time (a nightly backup, a weekly cron job) show up
+// as a hot cell rather than scattered blips. Arrays are indexed
+// [weekday][hour], with Sunday as weekday 0 as in time.Weekday.
+type Heatmap struct {
+	Since       time.Time       `json:"since"`
+	Timezone    string          `json:"timezone"`
+	Checks      [7][24]int      `json:"checks"`
+	Failures    [7][24]int      `json:"failures"`
+	FailureRate [7][24]*float64 `json:"failure_rate"` // failures/checks; null for cells without checks
+}
+
+// FailureHeatmap builds monitorID's heatmap from checks since the given time,
+// bucketed in loc.
+func (s *Store) FailureHeatmap(monitorID int64, since time.Time, loc *time.Location) (*Heatmap, error) {
+	rows, err := s.db.Query(`
+		SELECT checked_at, is_up, sample_weight FROM checks
+		WHERE monitor_id = ? AND checked_at >= ?`, monitorID, sqliteTime(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	h := &Heatmap{Since: since.UTC().Truncate(time.Second), Timezone: loc.String()}
+	for rows.Next() {
+		var at time.Time
+		var isUp, weight int
+		if err := rows.Scan(&at, &isUp, &weight); err != nil {
+			return nil, err
+		}
+		at = at.In(loc)
+		day, hour := at.Weekday(), at.Hour()
+		h.Checks[day][hour] += weight // a sampled row counts in the hour it started
+		if isUp == 0 {
+			h.Failures[day][hour]++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for d := range h.Checks {
+		for hr, n := range h.Checks[d] {
+			if n > 0 {
+				rate := float64(h.Failures[d][hr]) / float64(n)
+				h.FailureRate[d][hr] = &rate
+			}
+		}
+	}
+	return h, nil
+}
@@ -0,0 +1,281 @@
+package monitor
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Actor identifies who saved a monitor configuration.
+type Actor struct {
+	Role      string `json:"role"` // empty for a baseline recorded before history was kept
+	SessionID string `json:"session_id,omitempty"`
+	IP        string `json:"ip,omitempty"`
+}
+
+// Change is one field that differs from the previous version.
+type Change struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// Version is one saved configuration of a monitor.
+type Version struct {
+	Version   int            `json:"version"`
+	Config    map[string]any `json:"config"`
+	Changes   []Change       `json:"changes"` // against the previous version; empty for the first
+	Note      string         `json:"note,omitempty"`
+	ChangedBy Actor          `json:"changed_by"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// runtimeFields are Monitor fields that aren't configuration: identity,
+// timestamps, state the checker maintains and the known-issue banner.
+// Versions leave them out.
+var runtimeFields = []string{
+	"id", "workspace_id", "state", "consecutive_failures", "content_hash",
+	"budget_breaches", "known_issue", "known_issue_until", "created_at", "updated_at",
+	"push_token",
+}
+
+// ConfigOf returns m's configuration as its JSON fields, runtime state
+// excluded.
+func ConfigOf(m *Monitor) (map[string]any, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var cfg map[string]any
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	for _, f := range runtimeFields {
+		delete(cfg, f)
+	}
+	return cfg, nil
+}
+
+// ApplyConfig returns a copy of m with the configuration fields in cfg, as
+// returned by ConfigOf. Runtime state is kept from m, and so is its
+// auth_secret, which versions don't hold, unless cfg has no auth_type.
+func ApplyConfig(m *Monitor, cfg map[string]any) (*Monitor, error) {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := *m
+	// Unmarshal decodes into an existing map or pointee rather than
+	// replacing it, which would merge the version's headers into m's and
+	// write its maintenance window through to m.
+	c.HTTPHeaders, c.MaintenanceStart, c.MaintenanceEnd = nil, nil, nil
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	c.ID, c.WorkspaceID = m.ID, m.WorkspaceID
+	if c.AuthType == "" {
+		c.AuthUsername, c.AuthSecret = "", ""
+	}
+	return &c, nil
+}
+
+// diffConfig lists the fields that differ between two configurations,
+// sorted by name.
+func diffConfig(from, to map[string]any) []Change {
+	changes := []Change{}
+	for f, v := range to {
+		if old, ok := from[f]; !ok || !reflect.DeepEqual(old, v) {
+			changes = append(changes, Change{Field: f, From: from[f], To: v})
+		}
+	}
+	for f, old := range from {
+		if _, ok := to[f]; !ok {
+			changes = append(changes, Change{Field: f, From: old})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// Redacted returns a copy of v safe to show read-only viewers, with
+// credentials in URLs and http_headers masked as in Monitor.Redacted.
+func (v *Version) Redacted() *Version {
+	c := *v
+	c.Config = make(map[string]any, len(v.Config))
+	for k, val := range v.Config {
+		c.Config[k] = val
+	}
+	if u, ok := c.Config["url"].(string); ok {
+		c.Config["url"] = RedactURL(u)
+	}
+	if h, ok := c.Config["http_headers"]; ok {
+		c.Config["http_headers"] = redactConfigHeaders(h)
+	}
+	c.Changes = make([]Change, len(v.Changes))
+	for i, ch := range v.Changes {
+		if ch.Field == "url" {
+			if u, ok := ch.From.(string); ok {
+				ch.From = RedactURL(u)
+			}
+			if u, ok := ch.To.(string); ok {
+				ch.To = RedactURL(u)
+			}
+		}
+		if ch.Field == "http_headers" {
+			ch.From, ch.To = redactConfigHeaders(ch.From), redactConfigHeaders(ch.To)
+		}
+		c.Changes[i] = ch
+	}
+	return &c
+}
+
+// redactConfigHeaders masks credentials in http_headers as decoded into a
+// Version: a JSON object, or nil.
+func redactConfigHeaders(v any) any {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return v
+	}
+	headers := make(map[string]string, len(obj))
+	for name, val := range obj {
+		s, _ := val.(string)
+		headers[name] = s
+	}
+	return RedactHTTPHeaders(headers)
+}
+
+// RecordVersion saves m's configuration as its next version, unless it is
+// the same as the latest one. If m has no history yet and before is given
+// (its configuration prior to this change), before is saved first as a
+// baseline, so the first recorded change still shows what it replaced.
+func (s *Store) RecordVersion(before, m *Monitor, by Actor, note string) error {
+	cfg, err := ConfigOf(m)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var latest int
+	var latestJSON string
+	err = tx.QueryRow(`
+		SELECT version, config_json FROM monitor_versions
+		WHERE monitor_id = ? ORDER BY version DESC LIMIT 1`, m.ID).Scan(&latest, &latestJSON)
+	switch {
+	case err == sql.ErrNoRows && before != nil:
+		base, err := ConfigOf(before)
+		if err != nil {
+			return err
+		}
+		if err := s.insertVersion(tx, m.ID, 1, base, Actor{}, ""); err != nil {
+			return err
+		}
+		latest = 1
+		if reflect.DeepEqual(base, cfg) {
+			return tx.Commit()
+		}
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	default:
+		if prev, err := s.decodeConfig(latestJSON); err == nil && reflect.DeepEqual(prev, cfg) {
+			return nil
+		}
+	}
+	if err := s.insertVersion(tx, m.ID, latest+1, cfg, by, note); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertVersion stores cfg as a monitor's version, encrypted as a whole if s
+// has a key, since it holds http_headers.
+func (s *Store) insertVersion(tx *sql.Tx, monitorID int64, version int, cfg map[string]any, by Actor, note string) error {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	stored, err := s.box.Seal(string(b))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO monitor_versions (monitor_id, version, config_json, note, changed_by, session_id, client_ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		monitorID, version, stored, note, by.Role, by.SessionID, by.IP)
+	return err
+}
+
+// decodeConfig reads a version's config_json as insertVersion stored it.
+func (s *Store) decodeConfig(stored string) (map[string]any, error) {
+	plain, err := s.box.Open(stored)
+	if err != nil {
+		return nil, err
+	}
+	var cfg map[string]any
+	err = json.Unmarshal([]byte(plain), &cfg)
+	return cfg, err
+}
+
+// History returns every saved version of a monitor, newest first, each with
+// its changes against the one before.
+func (s *Store) History(monitorID int64) ([]*Version, error) {
+	rows, err := s.db.Query(`
+		SELECT version, config_json, note, changed_by, session_id, client_ip, created_at
+		FROM monitor_versions WHERE monitor_id = ? ORDER BY version`, monitorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*Version
+	var prev map[string]any
+	for rows.Next() {
+		v := &Version{}
+		var cfgJSON string
+		if err := rows.Scan(&v.Version, &cfgJSON, &v.Note, &v.ChangedBy.Role, &v.ChangedBy.SessionID,
+			&v.ChangedBy.IP, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		if v.Config, err = s.decodeConfig(cfgJSON); err != nil {
+			return nil, fmt.Errorf("monitor %d version %d: %v", monitorID, v.Version, err)
+		}
+		v.Changes = []Change{}
+		if prev != nil {
+			v.Changes = diffConfig(prev, v.Config)
+		}
+		prev = v.Config
+		list = append(list, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list, nil
+}
+
+// GetVersion returns one saved configuration of a monitor, or nil if there
+// is no such version. Changes are not filled in.
+func (s *Store) GetVersion(monitorID int64, version int) (*Version, error) {
+	v := &Version{}
+	var cfgJSON string
+	err := s.db.QueryRow(`
+		SELECT version, config_json, note, changed_by, session_id, client_ip, created_at
+		FROM monitor_versions WHERE monitor_id = ? AND version = ?`, monitorID, version).Scan(
+		&v.Version, &cfgJSON, &v.Note, &v.ChangedBy.Role, &v.ChangedBy.SessionID, &v.ChangedBy.IP, &v.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	v.Config, err = s.decodeConfig(cfgJSON)
+	return v, err
+}
@@ -0,0 +1,330 @@
+package monitor
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IssueTracker opens an issue in a ticket system when a monitor goes down,
+// and comments on and closes it when the monitor recovers.
+type IssueTracker interface {
+	// Name identifies the tracker in log lines and the monitor_issues table.
+	Name() string
+	// Wants reports whether m's outages should be filed here.
+	Wants(m *Monitor) bool
+	// Open files a new issue, returning its key or number and its web URL.
+	Open(title, body string) (ref, link string, err error)
+	// Comment adds a comment to the issue ref.
+	Comment(ref, body string) error
+	// Close comments on the issue ref and closes it.
+	Close(ref, comment string) error
+}
+
+// SetIssueTrackers makes down alerts open an issue in each tracker that
+// wants the monitor, and recoveries close it. Open issues are kept in store
+// so they are closed after a restart too.
+func (a *Alerter) SetIssueTrackers(store *Store, trackers []IssueTracker) {
+	a.issueStore, a.trackers = store, trackers
+}
+
+// openIssues files payload's outage for m in each tracker. A tracker that
+// still has an issue open for m gets a comment on it instead.
+func (a *Alerter) openIssues(m *Monitor, payload AlertPayload) {
+	for _, t := range a.trackers {
+		if !t.Wants(m) {
+			continue
+		}
+		ref, _, err := a.issueStore.openIssue(m.ID, t.Name())
+		if err != nil {
+			log.Printf("alert: %s issue for %q: %v", t.Name(), m.Name, err)
+			continue
+		}
+		if ref != "" {
+			if err := t.Comment(ref, fmt.Sprintf("%s is down again at %s.", m.Name, payload.Timestamp)); err != nil {
+				log.Printf("alert: %s issue %s for %q: comment: %v", t.Name(), ref, m.Name, err)
+			}
+			continue
+		}
+		ref, link, err := t.Open(m.Name+" is down", issueBody(payload))
+		if err != nil {
+			log.Printf("alert: %s issue for %q: open: %v", t.Name(), m.Name, err)
+			continue
+		}
+		if err := a.issueStore.recordIssue(m.ID, t.Name(), ref, link); err != nil {
+			log.Printf("alert: %s issue %s for %q: %v", t.Name(), ref, m.Name, err)
+			continue
+		}
+		log.Printf("alert: opened %s issue %s for %q", t.Name(), ref, m.Name)
+	}
+}
+
+// Recovered closes the issues open for m, which has just come back up.
+// Webhooks aren't sent for recoveries.
+func (a *Alerter) Recovered(m *Monitor) {
+	for _, t := range a.trackers {
+		ref, openedAt, err := a.issueStore.openIssue(m.ID, t.Name())
+		if err != nil {
+			log.Printf("alert: %s issue for %q: %v", t.Name(), m.Name, err)
+			continue
+		}
+		if ref == "" {
+			continue
+		}
+		now := time.Now().UTC()
+		comment := fmt.Sprintf("%s is back up at %s, after %s down.",
+			m.Name, now.Format(time.RFC3339), now.Sub(openedAt).Round(time.Second))
+		if err := t.Close(ref, comment); err != nil {
+			// The row stays, so the next recovery tries again.
+			log.Printf("alert: %s issue %s for %q: close: %v", t.Name(), ref, m.Name, err)
+			continue
+		}
+		if err := a.issueStore.deleteIssue(m.ID, t.Name()); err != nil {
+			log.Printf("alert: %s issue %s for %q: %v", t.Name(), ref, m.Name, err)
+			continue
+		}
+		log.Printf("alert: closed %s issue %s for %q", t.Name(), ref, m.Name)
+	}
+}
+
+// wants reports whether a tracker limited to tag ("" for any) and
+// workspaces files m's outages.
+func wants(m *Monitor, tag string, workspaces []int64) bool {
+	return (tag == "" || m.HasTag(tag)) && slices.Contains(workspaces, m.WorkspaceID)
+}
+
+// issueBody describes a down alert as plain text, which reads well both as
+// Jira's wiki markup and as GitHub's Markdown. The URL's password is masked,
+// since an issue, in a public repository say, may have a wider audience
+// than the dashboard.
+func issueBody(p AlertPayload) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s went down at %s.\n\n", p.MonitorName, p.Timestamp)
+	for _, f := range [][2]string{{"URL", RedactURL(p.URL)}, {"Owner", p.Owner}, {"Runbook", p.RunbookURL}} {
+		if f[1] != "" {
+			fmt.Fprintf(&b, "%s: %s\n", f[0], f[1])
+		}
+	}
+	if p.Description != "" {
+		fmt.Fprintf(&b, "\n%s\n", p.Description)
+	}
+	if c := p.Context; c != nil && (len(c.Events) > 0 || len(c.Anomalies) > 0) {
+		b.WriteString("\nJust before:\n")
+		for _, e := range c.Events {
+			fmt.Fprintf(&b, "- event %s (%g) at %s\n", e.Name, e.Value, e.At.UTC().Format(time.RFC3339))
+		}
+		for _, an := range c.Anomalies {
+			fmt.Fprintf(&b, "- %s at %g, usually %g\n", an.Metric, an.Value, an.Baseline)
+		}
+	}
+	b.WriteString("\nOpened by the health dashboard; it closes this issue when the monitor recovers.")
+	return b.String()
+}
+
+// openIssue returns the issue open for a monitor in tracker, or "".
+func (s *Store) openIssue(monitorID int64, tracker string) (ref string, openedAt time.Time, err error) {
+	err = s.db.QueryRow(`SELECT ref, opened_at FROM monitor_issues WHERE monitor_id = ? AND tracker = ?`,
+		monitorID, tracker).Scan(&ref, &openedAt)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, nil
+	}
+	return ref, openedAt, err
+}
+
+func (s *Store) recordIssue(monitorID int64, tracker, ref, link string) error {
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO monitor_issues (monitor_id, tracker, ref, url) VALUES (?, ?, ?, ?)`,
+		monitorID, tracker, ref, link)
+	return err
+}
+
+func (s *Store) deleteIssue(monitorID int64, tracker string) error {
+	_, err := s.db.Exec(`DELETE FROM monitor_issues WHERE monitor_id = ? AND tracker = ?`, monitorID, tracker)
+	return err
+}
+
+// issueClient is shared by the trackers.
+var issueClient = &http.Client{Timeout: 15 * time.Second}
+
+// callJSON sends body as JSON to rawURL with the given auth header, and
+// decodes a JSON answer into out unless it is nil.
+func callJSON(method, rawURL string, header http.Header, body, out any) error {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, rawURL, rd)
+	if err != nil {
+		return err
+	}
+	req.Header = header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := issueClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: HTTP %d: %s", method, rawURL, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// JiraTracker files issues in a Jira Cloud project through the REST API,
+// authenticating with an account's email and API token.
+type JiraTracker struct {
+	URL            string // e.g. https://example.atlassian.net
+	Email          string
+	APIToken       string
+	Project        string // project key
+	IssueType      string // default "Bug"
+	DoneTransition string // the transition that closes an issue; default "Done"
+	Labels         []string
+	Tag            string  // only monitors with this tag; "" for all
+	Workspaces     []int64 // only these workspaces' monitors
+}
+
+func (j *JiraTracker) Name() string { return "jira" }
+
+func (j *JiraTracker) Wants(m *Monitor) bool { return wants(m, j.Tag, j.Workspaces) }
+
+func (j *JiraTracker) header() http.Header {
+	h := http.Header{}
+	req := http.Request{Header: h}
+	req.SetBasicAuth(j.Email, j.APIToken)
+	return h
+}
+
+func (j *JiraTracker) api(path string) string {
+	return strings.TrimSuffix(j.URL, "/") + "/rest/api/2/" + path
+}
+
+func (j *JiraTracker) Open(title, body string) (string, string, error) {
+	issueType := j.IssueType
+	if issueType == "" {
+		issueType = "Bug"
+	}
+	fields := map[string]any{
+		"project":     map[string]string{"key": j.Project},
+		"issuetype":   map[string]string{"name": issueType},
+		"summary":     title,
+		"description": body,
+	}
+	if len(j.Labels) > 0 {
+		fields["labels"] = j.Labels
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err := callJSON(http.MethodPost, j.api("issue"), j.header(), map[string]any{"fields": fields}, &created); err != nil {
+		return "", "", err
+	}
+	return created.Key, strings.TrimSuffix(j.URL, "/") + "/browse/" + created.Key, nil
+}
+
+func (j *JiraTracker) Comment(ref, body string) error {
+	return callJSON(http.MethodPost, j.api("issue/"+url.PathEscape(ref)+"/comment"), j.header(),
+		map[string]string{"body": body}, nil)
+}
+
+func (j *JiraTracker) Close(ref, comment string) error {
+	if err := j.Comment(ref, comment); err != nil {
+		return err
+	}
+	done := j.DoneTransition
+	if done == "" {
+		done = "Done"
+	}
+	var list struct {
+		Transitions []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"transitions"`
+	}
+	path := j.api("issue/" + url.PathEscape(ref) + "/transitions")
+	if err := callJSON(http.MethodGet, path, j.header(), nil, &list); err != nil {
+		return err
+	}
+	var names []string
+	for _, t := range list.Transitions {
+		if strings.EqualFold(t.Name, done) {
+			return callJSON(http.MethodPost, path, j.header(), map[string]any{"transition": map[string]string{"id": t.ID}}, nil)
+		}
+		names = append(names, t.Name)
+	}
+	return fmt.Errorf("no %q transition; the issue offers %s", done, strings.Join(names, ", "))
+}
+
+// GitHubTracker files issues in a GitHub repository, authenticating with a
+// token that may write its issues.
+type GitHubTracker struct {
+	APIURL     string // default https://api.github.com; GitHub Enterprise uses https://host/api/v3
+	Repo       string // owner/name
+	Token      string
+	Labels     []string
+	Tag        string  // only monitors with this tag; "" for all
+	Workspaces []int64 // only these workspaces' monitors
+}
+
+func (g *GitHubTracker) Name() string { return "github" }
+
+func (g *GitHubTracker) Wants(m *Monitor) bool { return wants(m, g.Tag, g.Workspaces) }
+
+func (g *GitHubTracker) header() http.Header {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer "+g.Token)
+	h.Set("X-GitHub-Api-Version", "2022-11-28")
+	return h
+}
+
+func (g *GitHubTracker) api(path string) string {
+	base := g.APIURL
+	if base == "" {
+		base = "https://api.github.com"
+	}
+	return strings.TrimSuffix(base, "/") + "/repos/" + g.Repo + "/issues" + path
+}
+
+func (g *GitHubTracker) Open(title, body string) (string, string, error) {
+	req := map[string]any{"title": title, "body": body}
+	if len(g.Labels) > 0 {
+		req["labels"] = g.Labels
+	}
+	var created struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := callJSON(http.MethodPost, g.api(""), g.header(), req, &created); err != nil {
+		return "", "", err
+	}
+	return strconv.Itoa(created.Number), created.HTMLURL, nil
+}
+
+func (g *GitHubTracker) Comment(ref, body string) error {
+	return callJSON(http.MethodPost, g.api("/"+ref+"/comments"), g.header(), map[string]string{"body": body}, nil)
+}
+
+func (g *GitHubTracker) Close(ref, comment string) error {
+	if err := g.Comment(ref, comment); err != nil {
+		return err
+	}
+	return callJSON(http.MethodPatch, g.api("/"+ref), g.header(),
+		map[string]string{"state": "closed", "state_reason": "completed"}, nil)
+}
@@ -0,0 +1,270 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Limits on a monitor's json_assert setting and the body it is checked
+// against.
+const (
+	maxJSONAssert     = 2048
+	maxJSONAssertBody = 1 << 20
+)
+
+// A jsonAssertion is one condition of json_assert: the value at path
+// compared with want, or, without an operator, path being present.
+type jsonAssertion struct {
+	path []any // string keys and int indexes
+	expr string
+	op   string // "" for presence
+	want any
+}
+
+// ParseJSONAssert normalises a monitor's json_assert setting: conditions on
+// the JSON response body joined by &&, each a path, optionally followed by
+// an operator and a JSON value.
+//
+//	$.status == "ok"
+//	$.checks.db.healthy == true && $.queue.depth < 100
+//	$.items[0].id
+//
+// A path starts at $ and goes through .name or ["name"] keys and [n]
+// array indexes. == and != compare any JSON values; <, <=, > and >= compare
+// numbers. A bare path only requires the value to be there. Empty disables
+// the assertion.
+func ParseJSONAssert(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	if len(s) > maxJSONAssert {
+		return "", fmt.Errorf("json_assert must be at most %d bytes", maxJSONAssert)
+	}
+	conds, err := parseJSONAssert(s)
+	if err != nil {
+		return "", fmt.Errorf("json_assert: %v", err)
+	}
+	exprs := make([]string, len(conds))
+	for i, c := range conds {
+		exprs[i] = c.expr
+	}
+	return strings.Join(exprs, " && "), nil
+}
+
+var jsonAssertOps = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+func parseJSONAssert(s string) ([]jsonAssertion, error) {
+	var conds []jsonAssertion
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		c, rest, err := parseJSONCondition(s)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, c)
+		rest = strings.TrimLeft(rest, " \t\r\n")
+		if rest == "" {
+			return conds, nil
+		}
+		if !strings.HasPrefix(rest, "&&") {
+			return nil, fmt.Errorf("expected && before %q", rest)
+		}
+		s = rest[2:]
+	}
+}
+
+// parseJSONCondition parses one condition from the start of s and returns
+// what follows it.
+func parseJSONCondition(s string) (jsonAssertion, string, error) {
+	var c jsonAssertion
+	path, rest, err := parseJSONPath(s)
+	if err != nil {
+		return c, "", err
+	}
+	c.path = path
+	c.expr = strings.TrimSpace(s[:len(s)-len(rest)])
+	rest = strings.TrimLeft(rest, " \t")
+	for _, op := range jsonAssertOps {
+		if strings.HasPrefix(rest, op) {
+			c.op = op
+			break
+		}
+	}
+	if c.op == "" {
+		return c, rest, nil
+	}
+	// The value is the one JSON value after the operator.
+	dec := json.NewDecoder(strings.NewReader(rest[len(c.op):]))
+	if err := dec.Decode(&c.want); err != nil {
+		return c, "", fmt.Errorf("%s %s: expected a JSON value, such as \"ok\", 200 or true", c.expr, c.op)
+	}
+	if c.op != "==" && c.op != "!=" {
+		if _, ok := c.want.(float64); !ok {
+			return c, "", fmt.Errorf("%s %s needs a number", c.expr, c.op)
+		}
+	}
+	valueLen := int(dec.InputOffset())
+	value := strings.TrimSpace(rest[len(c.op) : len(c.op)+valueLen])
+	c.expr += " " + c.op + " " + value
+	return c, rest[len(c.op)+valueLen:], nil
+}
+
+// parseJSONPath parses a path from the start of s and returns what follows
+// it.
+func parseJSONPath(s string) ([]any, string, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, "", errors.New("a path must start with $")
+	}
+	s = s[1:]
+	var path []any
+	for {
+		switch {
+		case strings.HasPrefix(s, "."):
+			n := 1
+			for n < len(s) && isPathNameByte(s[n]) {
+				n++
+			}
+			if n == 1 {
+				return nil, "", errors.New("expected a name after .")
+			}
+			path = append(path, s[1:n])
+			s = s[n:]
+		case strings.HasPrefix(s, `["`):
+			dec := json.NewDecoder(strings.NewReader(s[1:]))
+			var key string
+			if err := dec.Decode(&key); err != nil {
+				return nil, "", errors.New(`expected ["name"]`)
+			}
+			n := 1 + int(dec.InputOffset())
+			if !strings.HasPrefix(s[n:], "]") {
+				return nil, "", errors.New(`expected ] after ["name"`)
+			}
+			path = append(path, key)
+			s = s[n+1:]
+		case strings.HasPrefix(s, "["):
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, "", errors.New("expected ] after [")
+			}
+			i, err := strconv.Atoi(s[1:end])
+			if err != nil || i < 0 {
+				return nil, "", fmt.Errorf("%q is not an array index", s[1:end])
+			}
+			path = append(path, i)
+			s = s[end+1:]
+		default:
+			return path, s, nil
+		}
+	}
+}
+
+func isPathNameByte(b byte) bool {
+	return b == '_' || b == '-' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// jsonAssertFailure checks body against m's json_assert and returns why it
+// fails, or "" if every condition holds.
+func (m *Monitor) jsonAssertFailure(body []byte) string {
+	conds, err := parseJSONAssert(m.JSONAssert)
+	if err != nil {
+		return "json_assert: " + err.Error()
+	}
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if err := dec.Decode(&doc); err != nil {
+		return "response is not JSON"
+	}
+	for _, c := range conds {
+		got, ok := lookupJSON(doc, c.path)
+		if !ok {
+			return fmt.Sprintf("%s: not found in response", pathString(c))
+		}
+		if c.op != "" && !compareJSON(got, c.op, c.want) {
+			shown, _ := json.Marshal(got)
+			return fmt.Sprintf("%s is %s, expected %s", pathString(c), truncBytes(shown, 100), strings.TrimPrefix(c.expr, pathString(c)+" "))
+		}
+	}
+	return ""
+}
+
+func pathString(c jsonAssertion) string {
+	p, _, _ := strings.Cut(c.expr, " "+c.op+" ")
+	return p
+}
+
+func lookupJSON(v any, path []any) (any, bool) {
+	for _, seg := range path {
+		switch seg := seg.(type) {
+		case string:
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if v, ok = obj[seg]; !ok {
+				return nil, false
+			}
+		case int:
+			arr, ok := v.([]any)
+			if !ok || seg >= len(arr) {
+				return nil, false
+			}
+			v = arr[seg]
+		}
+	}
+	return v, true
+}
+
+func compareJSON(got any, op string, want any) bool {
+	switch op {
+	case "==":
+		return reflect.DeepEqual(got, want)
+	case "!=":
+		return !reflect.DeepEqual(got, want)
+	}
+	x, ok := got.(float64)
+	if !ok {
+		return false
+	}
+	y := want.(float64)
+	switch op {
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	case ">=":
+		return x >= y
+	}
+	return false
+}
+
+func truncBytes(b []byte, n int) string {
+	if len(b) > n {
+		return string(b[:n]) + "…"
+	}
+	return string(b)
+}
+
+// bodyCapture keeps the first maxJSONAssertBody bytes written to it, for
+// json_assert, and notes whether there were more.
+type bodyCapture struct {
+	buf  bytes.Buffer
+	over bool
+}
+
+func (c *bodyCapture) Write(p []byte) (int, error) {
+	if room := maxJSONAssertBody - c.buf.Len(); len(p) > room {
+		c.over = true
+		c.buf.Write(p[:max(room, 0)])
+	} else {
+		c.buf.Write(p)
+	}
+	return len(p), nil
+}
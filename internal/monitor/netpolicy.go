@@ -0,0 +1,136 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// AddrPolicy restricts which addresses probes may connect to, so that anyone
+// with dashboard access can't use the checker to reach internal services.
+// The zero value allows everything.
+type AddrPolicy struct {
+	// BlockLinkLocal blocks 169.254.0.0/16, fe80::/10, and known cloud
+	// metadata endpoints.
+	BlockLinkLocal bool
+	// BlockPrivate additionally blocks loopback, RFC 1918, shared (CGNAT),
+	// unique-local IPv6, and unspecified addresses.
+	BlockPrivate bool
+}
+
+// metadataAddrs are cloud metadata endpoints outside the link-local ranges.
+var metadataAddrs = []netip.Addr{
+	netip.MustParseAddr("fd00:ec2::254"),   // AWS IMDS over IPv6
+	netip.MustParseAddr("100.100.100.200"), // Alibaba Cloud
+}
+
+var sharedRange = netip.MustParsePrefix("100.64.0.0/10")
+
+// ErrBlockedAddr is returned when a probe target resolves to an address the
+// policy forbids.
+var ErrBlockedAddr = errors.New("address blocked by checker policy")
+
+// Enabled reports whether the policy blocks anything.
+func (p AddrPolicy) Enabled() bool { return p.BlockLinkLocal || p.BlockPrivate }
+
+// Allow returns ErrBlockedAddr (wrapped) if ip may not be probed.
+func (p AddrPolicy) Allow(ip netip.Addr) error {
+	ip = ip.Unmap()
+	blocked := false
+	if p.BlockLinkLocal || p.BlockPrivate {
+		blocked = ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()
+		for _, a := range metadataAddrs {
+			blocked = blocked || ip == a
+		}
+	}
+	if p.BlockPrivate {
+		blocked = blocked || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || sharedRange.Contains(ip)
+	}
+	if blocked {
+		return fmt.Errorf("%s: %w", ip, ErrBlockedAddr)
+	}
+	return nil
+}
+
+// control is a net.Dialer Control hook. It sees the address actually being
+// dialled, so a hostname that re-resolves to a blocked address after
+// validation (DNS rebinding) is still refused.
+func (p AddrPolicy) control(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	return p.Allow(ap.Addr())
+}
+
+// Transport returns an HTTP transport for probes that enforces p at dial
+// time and resolves hosts with resolver (nil means the system resolver).
+// With a policy enabled, proxy settings are ignored: the guard must see the
+// target's address, not the proxy's. So are they with a custom resolver,
+// since a proxy would resolve the host itself.
+func (p AddrPolicy) Transport(resolver *net.Resolver) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if p.Enabled() || resolver != nil {
+		t.DialContext = p.dialer(resolver).DialContext
+		t.Proxy = nil
+	}
+	return t
+}
+
+// dialer returns a probe dialer that enforces p and resolves hosts with
+// resolver (nil means the system resolver).
+func (p AddrPolicy) dialer(resolver *net.Resolver) *net.Dialer {
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Resolver: resolver}
+	if p.Enabled() {
+		d.Control = p.control
+	}
+	return d
+}
+
+// ValidateTarget checks a monitor's target before it is saved: its DNS
+// server, if set, must pass p, http monitors need an http(s) URL with a
+// host, and every target must resolve (through that DNS server). For http
+// monitors the resolved addresses must also pass p. Composite and push
+// monitors have no target, and a dns monitor's name may lack addresses, or
+// not exist yet, by design.
+func (p AddrPolicy) ValidateTarget(ctx context.Context, m *Monitor) error {
+	if server, err := ParseDNSServer(m.DNSServer); err == nil && server != "" {
+		if err := p.Allow(netip.MustParseAddrPort(server).Addr()); err != nil {
+			return fmt.Errorf("dns_server is %w", err)
+		}
+	}
+	if m.Type == TypeComposite || m.Type == TypePush || m.Type == TypeDNS {
+		return nil
+	}
+	host := strings.TrimSpace(m.URL)
+	if m.Type == TypeHTTP {
+		u, err := url.Parse(host)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+			return errors.New("url must be an absolute http:// or https:// URL")
+		}
+		host = u.Hostname()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := p.Resolver(m).LookupNetIP(ctx, "ip", host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("host %s does not resolve", host)
+	}
+	if m.Type != TypeHTTP {
+		return nil
+	}
+	for _, a := range addrs {
+		if err := p.Allow(a); err != nil {
+			return fmt.Errorf("url resolves to %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package monitor
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Push monitors are pinged by the job they watch rather than probed: a
+// start ping when a run begins and a finish ping when it succeeds. A push
+// monitor fails when no finish ping arrives within interval_seconds, or when
+// a run takes longer than its limit (see RunLimit).
+const (
+	// MaxRunMedianFactor bounds run_median_factor.
+	MaxRunMedianFactor = 100
+	// pushCheckEvery is how often a push monitor's runs are evaluated, so a
+	// late or overlong run is caught within a minute, not an interval.
+	pushCheckEvery = time.Minute
+	// medianRuns is how many previous finished runs the median is taken
+	// over, and minMedianRuns how many it needs before it applies.
+	medianRuns    = 20
+	minMedianRuns = 3
+	// maxPushRuns is how many runs are kept per monitor.
+	maxPushRuns = 100
+)
+
+// NewPushToken returns a random token for a push monitor's ping URLs.
+func NewPushToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// setPushToken gives a push monitor a token if it has none, and clears it
+// from other monitors, before m is saved.
+func (m *Monitor) setPushToken() error {
+	if m.Type != TypePush {
+		m.PushToken = ""
+		return nil
+	}
+	if m.PushToken != "" {
+		return nil
+	}
+	var err error
+	m.PushToken, err = NewPushToken()
+	return err
+}
+
+// checkEvery is how often m is checked: every interval_seconds, or at least
+// every pushCheckEvery for a push monitor.
+func (m *Monitor) checkEvery() time.Duration {
+	interval := time.Duration(m.IntervalSeconds) * time.Second
+	if m.Type == TypePush {
+		return min(interval, pushCheckEvery)
+	}
+	return interval
+}
+
+// A PushRun is one run of a push monitor's job. A finish ping without a
+// start records a run with no StartedAt or duration.
+type PushRun struct {
+	ID         int64      `json:"id"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"` // nil while the run is going
+	DurationMs *int64     `json:"duration_ms"`
+}
+
+func (r *PushRun) duration() (time.Duration, bool) {
+	if r.DurationMs == nil {
+		return 0, false
+	}
+	return time.Duration(*r.DurationMs) * time.Millisecond, true
+}
+
+// RunLimit returns the longest a run of m may take given the durations of
+// its previous finished runs: max_run_seconds, or run_median_factor times
+// their median, whichever is shorter. It is 0 when neither applies.
+func (m *Monitor) RunLimit(previous []time.Duration) time.Duration {
+	var limit time.Duration
+	if m.MaxRunSeconds > 0 {
+		limit = time.Duration(m.MaxRunSeconds) * time.Second
+	}
+	if m.RunMedianFactor > 0 && len(previous) >= minMedianRuns {
+		sorted := slices.Clone(previous)
+		slices.Sort(sorted)
+		median := sorted[len(sorted)/2]
+		if len(sorted)%2 == 0 {
+			median = (sorted[len(sorted)/2-1] + median) / 2
+		}
+		if l := time.Duration(float64(median) * m.RunMedianFactor); limit == 0 || l < limit {
+			limit = l
+		}
+	}
+	return limit
+}
+
+// ValidatePush checks a push monitor's run limits, and that settings only
+// push monitors take aren't set on others.
+func (m *Monitor) ValidatePush() error {
+	if m.Type != TypePush {
+		if m.MaxRunSeconds != 0 || m.RunMedianFactor != 0 {
+			return errors.New("max_run_seconds and run_median_factor only apply to push monitors")
+		}
+		return nil
+	}
+	if m.URL != "" {
+		return errors.New("push monitors have no url; jobs ping them instead")
+	}
+	if m.Schedule != "" || m.Retries > 0 {
+		return errors.New("schedule and retries don't apply to push monitors")
+	}
+	if m.MaxRunSeconds < 0 {
+		return errors.New("max_run_seconds must not be negative")
+	}
+	if m.RunMedianFactor != 0 && (m.RunMedianFactor < 1 || m.RunMedianFactor > MaxRunMedianFactor) {
+		return fmt.Errorf("run_median_factor must be 0, or between 1 and %d", MaxRunMedianFactor)
+	}
+	return nil
+}
+
+// GetByPushToken returns the push monitor pinged with token, or nil.
+func (s *Store) GetByPushToken(token string) (*Monitor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	row := s.db.QueryRow(`SELECT `+monitorCols+` FROM monitors WHERE type = ? AND push_token = ?`, TypePush, token)
+	m, err := s.scanMonitor(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return m, err
+}
+
+// pushNow is the current time in push_runs, to the millisecond.
+const pushNow = `strftime('%Y-%m-%d %H:%M:%f', 'now')`
+
+// StartRun records a start ping for push monitor id. A run left open by an
+// earlier start that never finished is superseded.
+func (s *Store) StartRun(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO push_runs (monitor_id, started_at) VALUES (?, `+pushNow+`)`, id); err != nil {
+		return err
+	}
+	if err := prunePushRuns(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FinishRun records a finish ping for push monitor id: it closes the latest
+// run if that is still open, or records a run without a start.
+func (s *Store) FinishRun(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+		UPDATE push_runs
+		SET finished_at = `+pushNow+`,
+		    duration_ms = CAST(ROUND((julianday(`+pushNow+`) - julianday(started_at)) * 86400000) AS INTEGER)
+		WHERE id = (SELECT MAX(id) FROM push_runs WHERE monitor_id = ?) AND finished_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := tx.Exec(`INSERT INTO push_runs (monitor_id, finished_at) VALUES (?, `+pushNow+`)`, id); err != nil {
+			return err
+		}
+		if err := prunePushRuns(tx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// prunePushRuns drops monitor id's runs beyond the latest maxPushRuns.
+func prunePushRuns(tx *sql.Tx, id int64) error {
+	_, err := tx.Exec(`
+		DELETE FROM push_runs WHERE monitor_id = ? AND id <= (
+			SELECT id FROM push_runs WHERE monitor_id = ? ORDER BY id DESC LIMIT 1 OFFSET ?)`,
+		id, id, maxPushRuns)
+	return err
+}
+
+// PushRuns returns up to limit of push monitor id's latest runs, newest
+// first.
+func (s *Store) PushRuns(id int64, limit int) ([]*PushRun, error) {
+	rows, err := s.db.Query(`
+		SELECT id, started_at, finished_at, duration_ms FROM push_runs
+		WHERE monitor_id = ? ORDER BY id DESC LIMIT ?`, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []*PushRun
+	for rows.Next() {
+		r := &PushRun{}
+		if err := rows.Scan(&r.ID, &r.StartedAt, &r.FinishedAt, &r.DurationMs); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// probePush evaluates push monitor m from its latest runs.
+func (c *Checker) probePush(m *Monitor) Check {
+	check := Check{CheckedAt: time.Now().UTC()}
+	runs, err := c.store.PushRuns(m.ID, medianRuns+2)
+	if err != nil {
+		check.Error = fmt.Sprintf("read runs: %v", err)
+		return check
+	}
+	check.Error = pushProblem(m, runs, time.Now())
+	check.IsUp = check.Error == ""
+	if len(runs) > 0 && runs[0].DurationMs != nil {
+		ms := int(*runs[0].DurationMs)
+		check.ResponseTimeMs = &ms
+	}
+	return check
+}
+
+// pushProblem describes what is wrong with push monitor m at now, given its
+// latest runs, newest first, or returns "" if nothing is.
+func pushProblem(m *Monitor, runs []*PushRun, now time.Time) string {
+	// limit returns the limit for runs[i], from the finished runs before it.
+	limit := func(i int) time.Duration {
+		var previous []time.Duration
+		for _, r := range runs[i+1:] {
+			if d, ok := r.duration(); ok && len(previous) < medianRuns {
+				previous = append(previous, d)
+			}
+		}
+		return m.RunLimit(previous)
+	}
+
+	lastFinish := m.CreatedAt
+	for _, r := range runs {
+		if r.FinishedAt != nil {
+			lastFinish = *r.FinishedAt
+			break
+		}
+	}
+	interval := time.Duration(m.IntervalSeconds) * time.Second
+	if late := now.Sub(lastFinish); late > interval {
+		if lastFinish.Equal(m.CreatedAt) {
+			return fmt.Sprintf("no finish ping received in %s", roundDuration(late))
+		}
+		return fmt.Sprintf("no finish ping for %s, over interval_seconds (%s)", roundDuration(late), roundDuration(interval))
+	}
+	if len(runs) == 0 {
+		return ""
+	}
+	latest := runs[0]
+	if latest.FinishedAt == nil && latest.StartedAt != nil {
+		if l, running := limit(0), now.Sub(*latest.StartedAt); l > 0 && running > l {
+			return fmt.Sprintf("run has been going for %s, over its limit of %s", roundDuration(running), roundDuration(l))
+		}
+		if len(runs) < 2 {
+			return ""
+		}
+		// Judge the run before it until this one finishes.
+		runs = runs[1:]
+		latest = runs[0]
+	}
+	if d, ok := latest.duration(); ok {
+		if l := limit(0); l > 0 && d > l {
+			return fmt.Sprintf("last run took %s, over its limit of %s", roundDuration(d), roundDuration(l))
+		}
+	}
+	return ""
+}
+
+// roundDuration rounds d for messages: to the second, or the millisecond
+// below one.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
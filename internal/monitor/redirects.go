@@ -0,0 +1,87 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// checkCanonical verifies that the plain-HTTP and alternate-host ("www." added
+// or removed) variants of canonical all redirect to canonical itself.
+// An alternate host that does not resolve is skipped: not every site
+// publishes both names.
+func checkCanonical(ctx context.Context, client *http.Client, canonical string) error {
+	want, err := url.Parse(canonical)
+	if err != nil {
+		return err
+	}
+	if want.Scheme != "https" {
+		return fmt.Errorf("canonical URL %s is not https", canonical)
+	}
+
+	host := want.Hostname()
+	alt := "www." + host
+	if strings.HasPrefix(host, "www.") {
+		alt = strings.TrimPrefix(host, "www.")
+	}
+	if port := want.Port(); port != "" {
+		alt = net.JoinHostPort(alt, port)
+	}
+
+	variants := []struct {
+		scheme, host string
+		optional     bool
+	}{
+		{"http", want.Host, false},
+		{"http", alt, true},
+		{"https", alt, true},
+	}
+	for _, v := range variants {
+		u := *want
+		u.Scheme, u.Host = v.scheme, v.host
+		final, err := finalURL(ctx, client, u.String())
+		if err != nil {
+			var dnsErr *net.DNSError
+			if v.optional && errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+				continue
+			}
+			return fmt.Errorf("%s: %w", u.String(), err)
+		}
+		if !sameURL(final, want) {
+			return fmt.Errorf("%s redirects to %s, want %s", u.String(), final, canonical)
+		}
+	}
+	return nil
+}
+
+// finalURL follows redirects from rawURL and returns where they end.
+func finalURL(ctx context.Context, client *http.Client, rawURL string) (*url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "health-dashboard/1.0")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp.Request.URL, nil
+}
+
+// sameURL compares scheme, host, and path, treating "" and "/" as equal.
+func sameURL(a, b *url.URL) bool {
+	pathOf := func(u *url.URL) string {
+		if u.Path == "" {
+			return "/"
+		}
+		return u.Path
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) &&
+		strings.EqualFold(a.Host, b.Host) &&
+		pathOf(a) == pathOf(b)
+}
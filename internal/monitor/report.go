@@ -0,0 +1,74 @@
+package monitor
+
+import "time"
+
+// Reliability summarises one monitor's checks over a period.
+type Reliability struct {
+	MonitorID int64    `json:"monitor_id"`
+	Name      string   `json:"name"`
+	Tags      string   `json:"tags"`
+	Checks    int      `json:"checks"`
+	Uptime    *float64 `json:"uptime"` // percentage of up checks; null without checks
+	// Incidents counts outages: runs of at least the monitor's
+	// failure_threshold consecutive failed checks, the same rule that marks
+	// it down.
+	Incidents int `json:"incidents"`
+	// MTTRSeconds is the mean time from an outage's first failed check to
+	// the next successful one, over outages that have recovered; null when
+	// none have.
+	MTTRSeconds *float64 `json:"mttr_seconds"`
+}
+
+// Reliability computes m's uptime, outage count and mean time to recovery
+// from its checks since the given time.
+func (s *Store) Reliability(m *Monitor, since time.Time) (*Reliability, error) {
+	rows, err := s.db.Query(`
+		SELECT checked_at, is_up, sample_weight FROM checks
+		WHERE monitor_id = ? AND checked_at >= ?
+		ORDER BY checked_at, id`, m.ID, sqliteTime(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rel := &Reliability{MonitorID: m.ID, Name: m.Name, Tags: m.Tags}
+	var up, failures, recovered int
+	var downSince time.Time
+	var repair time.Duration
+	for rows.Next() {
+		var at time.Time
+		var isUp, weight int
+		if err := rows.Scan(&at, &isUp, &weight); err != nil {
+			return nil, err
+		}
+		rel.Checks += weight
+		if isUp == 0 {
+			if failures == 0 {
+				downSince = at
+			}
+			failures++
+			if failures == m.DownAfter() {
+				rel.Incidents++
+			}
+			continue
+		}
+		up += weight
+		if failures >= m.DownAfter() {
+			recovered++
+			repair += at.Sub(downSince)
+		}
+		failures = 0
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if rel.Checks > 0 {
+		pct := float64(up) / float64(rel.Checks) * 100
+		rel.Uptime = &pct
+	}
+	if recovered > 0 {
+		mttr := repair.Seconds() / float64(recovered)
+		rel.MTTRSeconds = &mttr
+	}
+	return rel, nil
+}
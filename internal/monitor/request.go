@@ -0,0 +1,131 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// HTTPMethods are the methods an http monitor can probe with.
+var HTTPMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// Limits on an http monitor's custom request.
+const (
+	maxHTTPHeaders = 32
+	maxHTTPBody    = 64 << 10
+)
+
+// userAgent is sent unless a monitor sets its own User-Agent header.
+const userAgent = "health-dashboard/1.0"
+
+// ParseHTTPRequest normalises an http monitor's http_method (default GET),
+// http_headers and http_body. Header names are canonicalised (e.g.
+// "x-api-key" to "X-Api-Key"); a Host header sets the request's host.
+func ParseHTTPRequest(method string, headers map[string]string, body string) (string, map[string]string, error) {
+	method = strings.ToUpper(strings.TrimSpace(method))
+	if method == "" {
+		method = http.MethodGet
+	}
+	if !slices.Contains(HTTPMethods, method) {
+		return "", nil, fmt.Errorf("http_method must be one of %s", strings.Join(HTTPMethods, ", "))
+	}
+	if len(headers) > maxHTTPHeaders {
+		return "", nil, fmt.Errorf("http_headers can have at most %d headers", maxHTTPHeaders)
+	}
+	out := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return "", nil, fmt.Errorf("http_headers: %q is not a valid header name", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return "", nil, fmt.Errorf("http_headers: %s has an invalid value", name)
+		}
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		if _, dup := out[name]; dup {
+			return "", nil, fmt.Errorf("http_headers: %s is set twice", name)
+		}
+		switch name {
+		case "Content-Length", "Transfer-Encoding", "Connection":
+			return "", nil, fmt.Errorf("http_headers: %s is set by the checker", name)
+		}
+		out[name] = value
+	}
+	if len(body) > maxHTTPBody {
+		return "", nil, fmt.Errorf("http_body must be at most %d bytes", maxHTTPBody)
+	}
+	if body != "" && method == http.MethodHead {
+		return "", nil, errors.New("http_body doesn't apply to HEAD requests")
+	}
+	return method, out, nil
+}
+
+// newProbeRequest builds the request an http monitor's check sends. A body
+// that is valid JSON goes as application/json unless the monitor sets a
+// Content-Type.
+func (m *Monitor) newProbeRequest(ctx context.Context) (*http.Request, error) {
+	method := m.HTTPMethod
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if m.HTTPBody != "" {
+		body = strings.NewReader(m.HTTPBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, m.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if m.HTTPBody != "" && json.Valid([]byte(m.HTTPBody)) {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range m.HTTPHeaders {
+		if name == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	m.setAuth(req)
+	return req, nil
+}
+
+// RedactHTTPHeaders returns a copy of an http monitor's http_headers with
+// the values of credential headers masked, as traces mask them.
+func RedactHTTPHeaders(headers map[string]string) map[string]string {
+	out := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if secretName(name) {
+			value = redacted
+		}
+		out[name] = value
+	}
+	return out
+}
+
+// encodeHeaders and decodeHeaders convert http_headers to and from the
+// JSON object stored in the monitors table, "" when there are none.
+func encodeHeaders(headers map[string]string) string {
+	if len(headers) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(headers)
+	return string(b)
+}
+
+func decodeHeaders(s string) (map[string]string, error) {
+	headers := map[string]string{}
+	if s == "" {
+		return headers, nil
+	}
+	err := json.Unmarshal([]byte(s), &headers)
+	return headers, err
+}
@@ -0,0 +1,57 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// ParseDNSServer normalises a monitor's dns_server setting (an IP address,
+// optionally with a port) to host:port. Empty means the system resolver.
+func ParseDNSServer(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.String(), nil
+	}
+	if a, err := netip.ParseAddr(strings.Trim(s, "[]")); err == nil {
+		return netip.AddrPortFrom(a, 53).String(), nil
+	}
+	return "", fmt.Errorf("dns_server %q must be an IP address with optional port", s)
+}
+
+// Resolver returns the resolver m's probes use under p: its DNS server if
+// set, otherwise the system resolver.
+func (p AddrPolicy) Resolver(m *Monitor) *net.Resolver {
+	addr, err := ParseDNSServer(m.DNSServer)
+	if err != nil || addr == "" {
+		return net.DefaultResolver
+	}
+	return p.dnsResolver(addr)
+}
+
+// dnsResolver sends every query to addr, ignoring /etc/resolv.conf.
+func (p AddrPolicy) dnsResolver(addr string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return p.dnsDialer().DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// dnsDialer dials a monitor's DNS server. Unlike the system's resolvers,
+// which the operator configured, it is a user setting, so p applies to it as
+// to probe targets.
+func (p AddrPolicy) dnsDialer() *net.Dialer {
+	d := &net.Dialer{Timeout: 5 * time.Second}
+	if p.Enabled() {
+		d.Control = p.control
+	}
+	return d
+}
@@ -0,0 +1,64 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RunbookTemplate links the monitors tagged Tag, or every monitor when Tag
+// is empty, to a runbook URL template.
+type RunbookTemplate struct {
+	Tag string
+	URL string
+}
+
+// runbookPlaceholders are the fields a runbook URL template may refer to.
+var runbookPlaceholders = []string{"{id}", "{name}", "{status}", "{tags}"}
+
+var placeholderRe = regexp.MustCompile(`\{[^{}/]*\}`)
+
+// ValidateRunbookURL checks a runbook URL or template: an http or https URL
+// whose placeholders are all known.
+func ValidateRunbookURL(tmpl string) error {
+	for _, p := range placeholderRe.FindAllString(tmpl, -1) {
+		if !slices.Contains(runbookPlaceholders, p) {
+			return fmt.Errorf("has unknown placeholder %s; use %s", p, strings.Join(runbookPlaceholders, ", "))
+		}
+	}
+	u, err := url.Parse(placeholderRe.ReplaceAllString(tmpl, "x"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an http or https URL")
+	}
+	return nil
+}
+
+// RunbookURL returns m's runbook link for a notification with status: its
+// own runbook_url if set, else that of the first template matching its tags,
+// with placeholders filled in. It returns "" when none applies.
+func RunbookURL(m *Monitor, templates []RunbookTemplate, status string) string {
+	tmpl := m.RunbookURL
+	for _, t := range templates {
+		if tmpl != "" {
+			break
+		}
+		if t.Tag == "" || m.HasTag(t.Tag) {
+			tmpl = t.URL
+		}
+	}
+	if tmpl == "" {
+		return ""
+	}
+	// Escaped for either a path segment or a query value.
+	esc := func(s string) string { return strings.ReplaceAll(url.QueryEscape(s), "+", "%20") }
+	return strings.NewReplacer(
+		"{id}", strconv.FormatInt(m.ID, 10),
+		"{name}", esc(m.Name),
+		"{status}", esc(status),
+		"{tags}", esc(m.Tags),
+	).Replace(tmpl)
+}
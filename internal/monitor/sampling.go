@@ -0,0 +1,66 @@
+package monitor
+
+// SamplingPolicy thins out stored checks for very frequent monitors. Every
+// failure and every recovery is stored as its own row, but consecutive
+// successes are folded into the last stored success row (its sample_weight
+// counts them) until KeepEvery have accumulated, so a 10-second monitor
+// that stays up writes one row per KeepEvery checks instead of one per check.
+type SamplingPolicy struct {
+	// BelowSeconds applies the policy to interval monitors probing at
+	// least this often. Cron monitors run at most once a minute and are
+	// never sampled.
+	BelowSeconds int
+	// KeepEvery is how many successful checks one stored row may stand
+	// for; 0 or 1 disables sampling.
+	KeepEvery int
+}
+
+func (p SamplingPolicy) applies(m *Monitor) bool {
+	return p.KeepEvery > 1 && m.Schedule == "" && m.IntervalSeconds <= p.BelowSeconds
+}
+
+// sampleState tracks the stored success row a sampled monitor is folding
+// checks into; lastID is 0 after a failure, so the next success gets a row.
+type sampleState struct {
+	lastID int64
+	weight int
+}
+
+// SetSampling sets the policy for storing checks of frequent monitors.
+// Call it before Start.
+func (c *Checker) SetSampling(p SamplingPolicy) {
+	c.mu.Lock()
+	c.sampling = p
+	c.mu.Unlock()
+}
+
+// record stores check, or folds it into the monitor's last stored success
+// when the sampling policy allows.
+func (c *Checker) record(m *Monitor, check *Check) error {
+	c.mu.Lock()
+	policy := c.sampling
+	st := c.samples[m.ID]
+	c.mu.Unlock()
+	if !policy.applies(m) {
+		return c.store.RecordCheck(check)
+	}
+
+	if check.IsUp && st.lastID != 0 && st.weight < policy.KeepEvery {
+		if err := c.store.FoldCheck(st.lastID); err != nil {
+			return err
+		}
+		st.weight++
+	} else {
+		if err := c.store.RecordCheck(check); err != nil {
+			return err
+		}
+		st = sampleState{}
+		if check.IsUp {
+			st = sampleState{lastID: check.ID, weight: 1}
+		}
+	}
+	c.mu.Lock()
+	c.samples[m.ID] = st
+	c.mu.Unlock()
+	return nil
+}
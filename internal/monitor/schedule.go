@@ -0,0 +1,121 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ActiveWindow restricts checks to certain weekdays and hours of the day.
+// Outside the window a monitor is "out_of_hours": no checks are recorded,
+// so the time is excluded from uptime.
+type ActiveWindow struct {
+	days     [7]bool // indexed by time.Weekday
+	startMin int     // minutes after midnight, inclusive
+	endMin   int     // minutes after midnight, exclusive; < startMin wraps past midnight
+	loc      *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseActiveWindow parses a monitor's schedule fields.
+// days is a comma-separated list of weekdays or ranges ("mon-fri", "sat,sun");
+// hours is "HH:MM-HH:MM" ("09:00-18:00", or "22:00-06:00" across midnight);
+// tz is an IANA zone name (empty means the server's local time).
+// It returns nil, nil when days and hours are both empty (always active).
+func ParseActiveWindow(days, hours, tz string) (*ActiveWindow, error) {
+	days, hours = strings.TrimSpace(days), strings.TrimSpace(hours)
+	if days == "" && hours == "" {
+		return nil, nil
+	}
+
+	w := &ActiveWindow{loc: time.Local}
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q", tz)
+		}
+		w.loc = loc
+	}
+
+	if days == "" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	} else {
+		for _, part := range strings.Split(strings.ToLower(days), ",") {
+			part = strings.TrimSpace(part)
+			from, to, isRange := strings.Cut(part, "-")
+			start, ok := weekdays[strings.TrimSpace(from)]
+			if !ok {
+				return nil, fmt.Errorf("invalid weekday %q", from)
+			}
+			end := start
+			if isRange {
+				if end, ok = weekdays[strings.TrimSpace(to)]; !ok {
+					return nil, fmt.Errorf("invalid weekday %q", to)
+				}
+			}
+			for d := start; ; d = (d + 1) % 7 {
+				w.days[d] = true
+				if d == end {
+					break
+				}
+			}
+		}
+	}
+
+	if hours == "" {
+		w.startMin, w.endMin = 0, 24*60
+	} else {
+		from, to, ok := strings.Cut(hours, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid hours %q (want HH:MM-HH:MM)", hours)
+		}
+		var err error
+		if w.startMin, err = parseClock(from); err != nil {
+			return nil, err
+		}
+		if w.endMin, err = parseClock(to); err != nil {
+			return nil, err
+		}
+		if w.startMin == w.endMin {
+			return nil, fmt.Errorf("invalid hours %q: start equals end", hours)
+		}
+	}
+	return w, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		if strings.TrimSpace(s) == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains reports whether t falls inside the window. A nil window is always active.
+func (w *ActiveWindow) Contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	t = t.In(w.loc)
+	minute := t.Hour()*60 + t.Minute()
+	if w.startMin < w.endMin {
+		return w.days[t.Weekday()] && minute >= w.startMin && minute < w.endMin
+	}
+	// Overnight window: the part after midnight belongs to the previous day.
+	if minute >= w.startMin {
+		return w.days[t.Weekday()]
+	}
+	if minute < w.endMin {
+		return w.days[(t.Weekday()+6)%7]
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package monitor
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// securityCheck is one scored item of the HTTP security header audit.
+type securityCheck struct {
+	name   string
+	weight int
+	pass   func(resp *http.Response) bool
+}
+
+var securityChecks = []securityCheck{
+	{"hsts", 25, hasHSTS},
+	{"x_content_type_options", 15, func(resp *http.Response) bool {
+		return strings.EqualFold(strings.TrimSpace(resp.Header.Get("X-Content-Type-Options")), "nosniff")
+	}},
+	{"csp", 30, func(resp *http.Response) bool {
+		return strings.TrimSpace(resp.Header.Get("Content-Security-Policy")) != ""
+	}},
+	{"cookie_secure", 10, func(resp *http.Response) bool {
+		return allCookies(resp, func(c *http.Cookie) bool { return c.Secure })
+	}},
+	{"cookie_httponly", 10, func(resp *http.Response) bool {
+		return allCookies(resp, func(c *http.Cookie) bool { return c.HttpOnly })
+	}},
+	{"cookie_samesite", 10, func(resp *http.Response) bool {
+		return allCookies(resp, func(c *http.Cookie) bool { return c.SameSite != http.SameSiteDefaultMode })
+	}},
+}
+
+// AuditSecurityHeaders scores resp's security headers from 0 to 100 and
+// returns the names of the failed items. Responses without cookies pass the
+// cookie items.
+func AuditSecurityHeaders(resp *http.Response) (score int, issues []string) {
+	for _, sc := range securityChecks {
+		if sc.pass(resp) {
+			score += sc.weight
+		} else {
+			issues = append(issues, sc.name)
+		}
+	}
+	return score, issues
+}
+
+// hasHSTS requires a Strict-Transport-Security header with a positive max-age.
+func hasHSTS(resp *http.Response) bool {
+	for _, directive := range strings.Split(resp.Header.Get("Strict-Transport-Security"), ";") {
+		k, v, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if strings.EqualFold(k, "max-age") {
+			n, err := strconv.Atoi(strings.Trim(v, `"`))
+			return err == nil && n > 0
+		}
+	}
+	return false
+}
+
+func allCookies(resp *http.Response, ok func(*http.Cookie) bool) bool {
+	for _, c := range resp.Cookies() {
+		if !ok(c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultStatusCodes are the codes an http monitor accepts when its
+// accepted_status_codes is empty.
+const defaultStatusCodes = "200-399"
+
+// maxStatusCodeRanges caps the entries in accepted_status_codes.
+const maxStatusCodeRanges = 32
+
+// ParseStatusCodes normalises an http monitor's accepted_status_codes: a
+// comma-separated list of codes and inclusive ranges, such as
+// "200-204,401". Empty accepts 200-399.
+func ParseStatusCodes(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	ranges, err := parseStatusCodes(s)
+	if err != nil {
+		return "", fmt.Errorf("accepted_status_codes: %v", err)
+	}
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = strconv.Itoa(r[0])
+		if r[1] != r[0] {
+			parts[i] += "-" + strconv.Itoa(r[1])
+		}
+	}
+	return strings.Join(parts, ","), nil
+}
+
+func parseStatusCodes(s string) ([][2]int, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) > maxStatusCodeRanges {
+		return nil, fmt.Errorf("at most %d entries", maxStatusCodeRanges)
+	}
+	var ranges [][2]int
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			return nil, errors.New("empty entry")
+		}
+		lo, hi, isRange := strings.Cut(f, "-")
+		from, err := parseStatusCode(lo)
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if isRange {
+			if to, err = parseStatusCode(hi); err != nil {
+				return nil, err
+			}
+			if to < from {
+				return nil, fmt.Errorf("%s: range ends before it starts", f)
+			}
+		}
+		ranges = append(ranges, [2]int{from, to})
+	}
+	return ranges, nil
+}
+
+func parseStatusCode(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	code, err := strconv.Atoi(s)
+	if err != nil || code < 100 || code > 599 {
+		return 0, fmt.Errorf("%q is not a status code between 100 and 599", s)
+	}
+	return code, nil
+}
+
+// acceptsStatus reports whether code counts as up for m.
+func (m *Monitor) acceptsStatus(code int) bool {
+	codes := m.AcceptedStatusCodes
+	if codes == "" {
+		codes = defaultStatusCodes
+	}
+	ranges, err := parseStatusCodes(codes)
+	if err != nil {
+		// Validated on save; fall back to the default.
+		return code >= 200 && code < 400
+	}
+	for _, r := range ranges {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
@@ -2,35 +2,167 @@ package monitor
 
 import (
 	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
 	"time"
+
+	"health-dashboard/internal/secrets"
 )
 
 // Monitor represents a configured uptime check target.
 type Monitor struct {
-	ID                  int64     `json:"id"`
-	Name                string    `json:"name"`
-	URL                 string    `json:"url"`
-	IntervalSeconds     int       `json:"interval_seconds"`
-	TimeoutSeconds      int       `json:"timeout_seconds"`
-	State               string    `json:"state"`
-	ConsecutiveFailures int       `json:"consecutive_failures"`
-	CreatedAt           time.Time `json:"created_at"`
-	UpdatedAt           time.Time `json:"updated_at"`
-}
-
-// Check is a single HTTP probe result.
+	ID                  int64             `json:"id"`
+	WorkspaceID         int64             `json:"workspace_id"`
+	Name                string            `json:"name"`
+	Type                string            `json:"type"`
+	URL                 string            `json:"url"`
+	IntervalSeconds     int               `json:"interval_seconds"`
+	TimeoutSeconds      int               `json:"timeout_seconds"`
+	Schedule            string            `json:"schedule"`
+	RetentionDays       int               `json:"retention_days"`
+	State               string            `json:"state"`
+	ConsecutiveFailures int               `json:"consecutive_failures"`
+	FailureThreshold    int               `json:"failure_threshold"`
+	Retries             int               `json:"retries"`
+	RetryDelaySeconds   int               `json:"retry_delay_seconds"`
+	ActiveDays          string            `json:"active_days"`
+	ActiveHours         string            `json:"active_hours"`
+	Timezone            string            `json:"timezone"`
+	DetectContentChange bool              `json:"detect_content_change"`
+	ContentHash         string            `json:"content_hash"`
+	MaintenanceStart    *time.Time        `json:"maintenance_start"`
+	MaintenanceEnd      *time.Time        `json:"maintenance_end"`
+	BudgetBytes         int64             `json:"budget_bytes"`
+	BudgetMs            int               `json:"budget_ms"`
+	BudgetBreaches      int               `json:"budget_breaches"`
+	SecurityAudit       bool              `json:"security_audit"`
+	AssertCanonical     bool              `json:"assert_canonical"`
+	DNSBLZones          string            `json:"dnsbl_zones"`
+	AssertHTTP2         bool              `json:"assert_http2"`
+	DNSServer           string            `json:"dns_server"`
+	ExpectedIPs         string            `json:"expected_ips"`
+	DNSRecordType       string            `json:"dns_record_type"`
+	DNSExpected         string            `json:"dns_expected"`
+	JSONAssert          string            `json:"json_assert"`
+	HTTPMethod          string            `json:"http_method"`
+	HTTPHeaders         map[string]string `json:"http_headers"`
+	HTTPBody            string            `json:"http_body"`
+	AcceptedStatusCodes string            `json:"accepted_status_codes"`
+	AuthType            string            `json:"auth_type"` // "", AuthBasic or AuthBearer
+	AuthUsername        string            `json:"auth_username"`
+	AuthSecret          string            `json:"-"` // password or token; write-only, so never in responses or history
+	AuthSecretSet       bool              `json:"auth_secret_set"`
+	Tags                string            `json:"tags"`
+	Children            string            `json:"children"`
+	CompositeMode       string            `json:"composite_mode"`
+	CompositeThreshold  int               `json:"composite_threshold"`
+	DebugTrace          bool              `json:"debug_trace"`
+	Owner               string            `json:"owner"`
+	RunbookURL          string            `json:"runbook_url"`
+	Description         string            `json:"description"`
+	PushToken           string            `json:"push_token,omitempty"` // push monitors' ping URL token
+	MaxRunSeconds       int               `json:"max_run_seconds"`
+	RunMedianFactor     float64           `json:"run_median_factor"`
+	KnownIssue          string            `json:"known_issue"` // banner text; "" when none or expired
+	KnownIssueUntil     *time.Time        `json:"known_issue_until"`
+	CreatedAt           time.Time         `json:"created_at"`
+	UpdatedAt           time.Time         `json:"updated_at"`
+}
+
+// Redacted returns a copy of m safe to show read-only viewers: a password
+// in the URL's userinfo (basic auth) and credential headers are masked, and
+// a push monitor's token is left out.
+func (m *Monitor) Redacted() *Monitor {
+	c := *m
+	c.URL = RedactURL(m.URL)
+	c.HTTPHeaders = RedactHTTPHeaders(m.HTTPHeaders)
+	c.PushToken = ""
+	return &c
+}
+
+// RedactURL masks the password in rawURL's userinfo, if any.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+// DownAfter returns how many consecutive failed checks mark m down.
+func (m *Monitor) DownAfter() int {
+	if m.FailureThreshold <= 0 {
+		return DefaultFailureThreshold
+	}
+	return m.FailureThreshold
+}
+
+// retryDelay returns the pause between m's attempts within a check.
+func (m *Monitor) retryDelay() time.Duration {
+	if m.RetryDelaySeconds <= 0 {
+		return DefaultRetryDelaySeconds * time.Second
+	}
+	return time.Duration(m.RetryDelaySeconds) * time.Second
+}
+
+// MaxProbeTime returns the longest one of m's checks can take: each attempt
+// up to its timeout, with the delays between them.
+func (m *Monitor) MaxProbeTime() time.Duration {
+	timeout := time.Duration(m.TimeoutSeconds) * time.Second
+	return time.Duration(m.Retries+1)*timeout + time.Duration(m.Retries)*m.retryDelay()
+}
+
+// ActiveWindow parses the monitor's schedule fields. A nil window means the
+// monitor is always active.
+func (m *Monitor) ActiveWindow() (*ActiveWindow, error) {
+	return ParseActiveWindow(m.ActiveDays, m.ActiveHours, m.Timezone)
+}
+
+// CronSchedule parses the monitor's cron expression, evaluated in its
+// timezone. A nil schedule means checks run every IntervalSeconds.
+func (m *Monitor) CronSchedule() (*CronSchedule, error) {
+	if strings.TrimSpace(m.Schedule) == "" {
+		return nil, nil
+	}
+	return ParseCron(m.Schedule, m.Timezone)
+}
+
+// InMaintenance reports whether t falls inside the monitor's maintenance
+// window. An open-ended window (no end) lasts until it is cleared.
+func (m *Monitor) InMaintenance(t time.Time) bool {
+	if m.MaintenanceStart == nil || t.Before(*m.MaintenanceStart) {
+		return false
+	}
+	return m.MaintenanceEnd == nil || t.Before(*m.MaintenanceEnd)
+}
+
+// Check is a single probe result.
 type Check struct {
 	ID             int64     `json:"id"`
 	MonitorID      int64     `json:"monitor_id"`
 	CheckedAt      time.Time `json:"checked_at"`
 	StatusCode     *int      `json:"status_code"`
 	ResponseTimeMs *int      `json:"response_time_ms"`
+	ResponseBytes  *int64    `json:"response_bytes"`
+	LoadTimeMs     *int      `json:"load_time_ms"`
+	SecurityScore  *int      `json:"security_score,omitempty"`
+	SecurityIssues []string  `json:"security_issues,omitempty"`
+	Protocol       string    `json:"protocol,omitempty"`
+	H3Advertised   bool      `json:"h3_advertised,omitempty"`
 	IsUp           bool      `json:"is_up"`
+	Error          string    `json:"error,omitempty"`
+	// SampleWeight is how many consecutive successful checks this row
+	// stands for; above 1 only for monitors under a sampling policy, with
+	// SampledUntil the time of the latest of them.
+	SampleWeight int        `json:"sample_weight"`
+	SampledUntil *time.Time `json:"sampled_until,omitempty"`
 }
 
 // Store provides monitor and check DB operations.
 type Store struct {
-	db *sql.DB
+	db  *sql.DB
+	box *secrets.Box // nil stores credentials in plaintext
 }
 
 // NewStore creates a Store backed by db.
@@ -38,23 +170,98 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
-const monitorCols = `id, name, url, interval_seconds, timeout_seconds, state, consecutive_failures, created_at, updated_at`
+// SetSecrets makes s encrypt monitors' auth_secret and http_headers, and
+// their saved versions, with box.
+func (s *Store) SetSecrets(box *secrets.Box) {
+	s.box = box
+}
+
+// ResealSecrets re-encrypts stored credentials that aren't sealed with the
+// current key, e.g. after one is set or rotated, and returns how many rows
+// it rewrote.
+func (s *Store) ResealSecrets() (int, error) {
+	n, err := s.box.Reseal(s.db, "monitors", "id", "auth_secret", "http_headers")
+	if err != nil {
+		return n, err
+	}
+	v, err := s.box.Reseal(s.db, "monitor_versions", "id", "config_json")
+	return n + v, err
+}
+
+// sealCredentials returns m's http_headers, as stored, and auth_secret,
+// encrypted if s has a key.
+func (s *Store) sealCredentials(m *Monitor) (headers, secret string, err error) {
+	if headers, err = s.box.Seal(encodeHeaders(m.HTTPHeaders)); err != nil {
+		return "", "", err
+	}
+	secret, err = s.box.Seal(m.AuthSecret)
+	return headers, secret, err
+}
+
+const monitorCols = `id, workspace_id, name, type, url, interval_seconds, timeout_seconds, schedule, retention_days, state, consecutive_failures, failure_threshold, retries, retry_delay_seconds,
+	active_days, active_hours, timezone, detect_content_change, content_hash,
+	maintenance_start, maintenance_end, budget_bytes, budget_ms, budget_breaches, security_audit,
+	assert_canonical, dnsbl_zones, assert_http2, dns_server, expected_ips, dns_record_type, dns_expected, json_assert,
+	http_method, http_headers, http_body, accepted_status_codes, auth_type, auth_username, auth_secret, tags,
+	children, composite_mode, composite_threshold, debug_trace, owner, runbook_url, description,
+	push_token, max_run_seconds, run_median_factor, known_issue, known_issue_until, created_at, updated_at`
 
-func scanMonitor(row interface{ Scan(...any) error }) (*Monitor, error) {
+func (s *Store) scanMonitor(row interface{ Scan(...any) error }) (*Monitor, error) {
 	m := &Monitor{}
-	err := row.Scan(&m.ID, &m.Name, &m.URL, &m.IntervalSeconds, &m.TimeoutSeconds,
-		&m.State, &m.ConsecutiveFailures, &m.CreatedAt, &m.UpdatedAt)
+	var headers string
+	err := row.Scan(&m.ID, &m.WorkspaceID, &m.Name, &m.Type, &m.URL, &m.IntervalSeconds, &m.TimeoutSeconds, &m.Schedule,
+		&m.RetentionDays, &m.State, &m.ConsecutiveFailures, &m.FailureThreshold, &m.Retries, &m.RetryDelaySeconds, &m.ActiveDays, &m.ActiveHours, &m.Timezone,
+		&m.DetectContentChange, &m.ContentHash, &m.MaintenanceStart, &m.MaintenanceEnd,
+		&m.BudgetBytes, &m.BudgetMs, &m.BudgetBreaches, &m.SecurityAudit,
+		&m.AssertCanonical, &m.DNSBLZones, &m.AssertHTTP2, &m.DNSServer, &m.ExpectedIPs, &m.DNSRecordType, &m.DNSExpected, &m.JSONAssert,
+		&m.HTTPMethod, &headers, &m.HTTPBody, &m.AcceptedStatusCodes, &m.AuthType, &m.AuthUsername, &m.AuthSecret, &m.Tags,
+		&m.Children, &m.CompositeMode, &m.CompositeThreshold, &m.DebugTrace,
+		&m.Owner, &m.RunbookURL, &m.Description, &m.PushToken, &m.MaxRunSeconds, &m.RunMedianFactor,
+		&m.KnownIssue, &m.KnownIssueUntil, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return m, err
+	}
+	if m.KnownIssueUntil != nil && !m.KnownIssueUntil.After(time.Now()) {
+		m.KnownIssue, m.KnownIssueUntil = "", nil
+	}
+	if m.AuthSecret, err = s.box.Open(m.AuthSecret); err != nil {
+		return m, fmt.Errorf("monitor %d: auth_secret: %v", m.ID, err)
+	}
+	m.AuthSecretSet = m.AuthSecret != ""
+	if headers, err = s.box.Open(headers); err != nil {
+		return m, fmt.Errorf("monitor %d: http_headers: %v", m.ID, err)
+	}
+	m.HTTPHeaders, err = decodeHeaders(headers)
 	return m, err
 }
 
 // Create inserts m into the DB and populates its ID, State, and timestamps.
 func (s *Store) Create(m *Monitor) error {
 	const q = `
-		INSERT INTO monitors (name, url, interval_seconds, timeout_seconds)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO monitors (workspace_id, name, type, url, interval_seconds, timeout_seconds, schedule, retention_days, failure_threshold, retries, retry_delay_seconds,
+		                      active_days, active_hours, timezone, detect_content_change,
+		                      maintenance_start, maintenance_end, budget_bytes, budget_ms, security_audit,
+		                      assert_canonical, dnsbl_zones, assert_http2, dns_server, expected_ips, dns_record_type, dns_expected, json_assert,
+		                      http_method, http_headers, http_body, accepted_status_codes, auth_type, auth_username, auth_secret, tags,
+		                      children, composite_mode, composite_threshold, debug_trace, owner, runbook_url, description,
+		                      push_token, max_run_seconds, run_median_factor)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		RETURNING ` + monitorCols
-	row := s.db.QueryRow(q, m.Name, m.URL, m.IntervalSeconds, m.TimeoutSeconds)
-	result, err := scanMonitor(row)
+	headers, secret, err := s.sealCredentials(m)
+	if err != nil {
+		return err
+	}
+	if err := m.setPushToken(); err != nil {
+		return err
+	}
+	row := s.db.QueryRow(q, m.WorkspaceID, m.Name, m.Type, m.URL, m.IntervalSeconds, m.TimeoutSeconds, m.Schedule, m.RetentionDays, m.FailureThreshold,
+		m.Retries, m.RetryDelaySeconds, m.ActiveDays, m.ActiveHours, m.Timezone, boolToInt(m.DetectContentChange),
+		m.MaintenanceStart, m.MaintenanceEnd, m.BudgetBytes, m.BudgetMs, boolToInt(m.SecurityAudit),
+		boolToInt(m.AssertCanonical), m.DNSBLZones, boolToInt(m.AssertHTTP2), m.DNSServer, m.ExpectedIPs, m.DNSRecordType, m.DNSExpected, m.JSONAssert,
+		m.HTTPMethod, headers, m.HTTPBody, m.AcceptedStatusCodes, m.AuthType, m.AuthUsername, secret, m.Tags,
+		m.Children, m.CompositeMode, m.CompositeThreshold, boolToInt(m.DebugTrace),
+		m.Owner, m.RunbookURL, m.Description, m.PushToken, m.MaxRunSeconds, m.RunMedianFactor)
+	result, err := s.scanMonitor(row)
 	if err != nil {
 		return err
 	}
@@ -68,11 +275,24 @@ func (s *Store) List() ([]*Monitor, error) {
 	if err != nil {
 		return nil, err
 	}
+	return s.scanMonitors(rows)
+}
+
+// ListWorkspace returns the monitors in one workspace ordered by ID.
+func (s *Store) ListWorkspace(workspaceID int64) ([]*Monitor, error) {
+	rows, err := s.db.Query(`SELECT `+monitorCols+` FROM monitors WHERE workspace_id = ? ORDER BY id`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanMonitors(rows)
+}
+
+func (s *Store) scanMonitors(rows *sql.Rows) ([]*Monitor, error) {
 	defer rows.Close()
 
 	var monitors []*Monitor
 	for rows.Next() {
-		m, err := scanMonitor(rows)
+		m, err := s.scanMonitor(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -84,7 +304,7 @@ func (s *Store) List() ([]*Monitor, error) {
 // Get returns the monitor with the given ID, or nil if not found.
 func (s *Store) Get(id int64) (*Monitor, error) {
 	row := s.db.QueryRow(`SELECT `+monitorCols+` FROM monitors WHERE id = ?`, id)
-	m, err := scanMonitor(row)
+	m, err := s.scanMonitor(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -94,11 +314,32 @@ func (s *Store) Get(id int64) (*Monitor, error) {
 // Update writes m's mutable fields back to the DB.
 // Returns sql.ErrNoRows if the ID does not exist.
 func (s *Store) Update(m *Monitor) error {
+	headers, secret, err := s.sealCredentials(m)
+	if err != nil {
+		return err
+	}
+	if err := m.setPushToken(); err != nil {
+		return err
+	}
 	res, err := s.db.Exec(`
 		UPDATE monitors
-		SET name = ?, url = ?, interval_seconds = ?, timeout_seconds = ?, updated_at = datetime('now')
+		SET name = ?, type = ?, url = ?, interval_seconds = ?, timeout_seconds = ?, schedule = ?, retention_days = ?, failure_threshold = ?,
+		    retries = ?, retry_delay_seconds = ?,
+		    active_days = ?, active_hours = ?, timezone = ?, detect_content_change = ?,
+		    maintenance_start = ?, maintenance_end = ?, budget_bytes = ?, budget_ms = ?,
+		    security_audit = ?, assert_canonical = ?, dnsbl_zones = ?, assert_http2 = ?, dns_server = ?, expected_ips = ?,
+		    dns_record_type = ?, dns_expected = ?, json_assert = ?, http_method = ?, http_headers = ?, http_body = ?, accepted_status_codes = ?,
+		    auth_type = ?, auth_username = ?, auth_secret = ?, tags = ?, children = ?, composite_mode = ?, composite_threshold = ?, debug_trace = ?,
+		    owner = ?, runbook_url = ?, description = ?, push_token = ?, max_run_seconds = ?, run_median_factor = ?,
+		    updated_at = datetime('now')
 		WHERE id = ?`,
-		m.Name, m.URL, m.IntervalSeconds, m.TimeoutSeconds, m.ID)
+		m.Name, m.Type, m.URL, m.IntervalSeconds, m.TimeoutSeconds, m.Schedule, m.RetentionDays, m.FailureThreshold,
+		m.Retries, m.RetryDelaySeconds, m.ActiveDays, m.ActiveHours, m.Timezone, boolToInt(m.DetectContentChange),
+		m.MaintenanceStart, m.MaintenanceEnd, m.BudgetBytes, m.BudgetMs, boolToInt(m.SecurityAudit),
+		boolToInt(m.AssertCanonical), m.DNSBLZones, boolToInt(m.AssertHTTP2), m.DNSServer, m.ExpectedIPs, m.DNSRecordType, m.DNSExpected, m.JSONAssert,
+		m.HTTPMethod, headers, m.HTTPBody, m.AcceptedStatusCodes, m.AuthType, m.AuthUsername, secret, m.Tags,
+		m.Children, m.CompositeMode, m.CompositeThreshold, boolToInt(m.DebugTrace),
+		m.Owner, m.RunbookURL, m.Description, m.PushToken, m.MaxRunSeconds, m.RunMedianFactor, m.ID)
 	if err != nil {
 		return err
 	}
@@ -109,6 +350,25 @@ func (s *Store) Update(m *Monitor) error {
 	return nil
 }
 
+// Parents returns the composite monitors derived from monitor id.
+func (s *Store) Parents(id int64) ([]*Monitor, error) {
+	rows, err := s.db.Query(`SELECT `+monitorCols+` FROM monitors WHERE type = ? ORDER BY id`, TypeComposite)
+	if err != nil {
+		return nil, err
+	}
+	all, err := s.scanMonitors(rows)
+	if err != nil {
+		return nil, err
+	}
+	var parents []*Monitor
+	for _, m := range all {
+		if m.HasChild(id) {
+			parents = append(parents, m)
+		}
+	}
+	return parents, nil
+}
+
 // Delete removes the monitor and its checks (cascade) from the DB.
 func (s *Store) Delete(id int64) error {
 	_, err := s.db.Exec(`DELETE FROM monitors WHERE id = ?`, id)
@@ -125,19 +385,61 @@ func (s *Store) UpdateState(monitorID int64, state string, consecutiveFailures i
 	return err
 }
 
-// RecordCheck inserts a probe result into the checks table.
+// SetKnownIssue shows text as the monitor's known issue until until, on the
+// dashboard and the public status page; "" clears it. Returns sql.ErrNoRows
+// if the ID does not exist.
+func (s *Store) SetKnownIssue(monitorID int64, text string, until *time.Time) error {
+	res, err := s.db.Exec(`UPDATE monitors SET known_issue = ?, known_issue_until = ? WHERE id = ?`,
+		text, until, monitorID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetContentHash stores the latest response-body hash for content-change detection.
+func (s *Store) SetContentHash(monitorID int64, hash string) error {
+	_, err := s.db.Exec(`UPDATE monitors SET content_hash = ? WHERE id = ?`, hash, monitorID)
+	return err
+}
+
+// SetBudgetBreaches stores the count of consecutive over-budget checks.
+func (s *Store) SetBudgetBreaches(monitorID int64, n int) error {
+	_, err := s.db.Exec(`UPDATE monitors SET budget_breaches = ? WHERE id = ?`, n, monitorID)
+	return err
+}
+
+// RecordCheck inserts a probe result into the checks table and sets c.ID.
 func (s *Store) RecordCheck(c *Check) error {
-	_, err := s.db.Exec(`
-		INSERT INTO checks (monitor_id, status_code, response_time_ms, is_up)
-		VALUES (?, ?, ?, ?)`,
-		c.MonitorID, c.StatusCode, c.ResponseTimeMs, boolToInt(c.IsUp))
+	res, err := s.db.Exec(`
+		INSERT INTO checks (monitor_id, status_code, response_time_ms, response_bytes, load_time_ms,
+		                    security_score, security_issues, protocol, h3_advertised, is_up, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.MonitorID, c.StatusCode, c.ResponseTimeMs, c.ResponseBytes, c.LoadTimeMs,
+		c.SecurityScore, strings.Join(c.SecurityIssues, ","), c.Protocol, boolToInt(c.H3Advertised),
+		boolToInt(c.IsUp), c.Error)
+	if err != nil {
+		return err
+	}
+	c.ID, err = res.LastInsertId()
+	return err
+}
+
+// FoldCheck counts one more successful check against the stored check id,
+// instead of inserting a row for it.
+func (s *Store) FoldCheck(id int64) error {
+	_, err := s.db.Exec(`UPDATE checks SET sample_weight = sample_weight + 1, sampled_until = datetime('now') WHERE id = ?`, id)
 	return err
 }
 
 // RecentChecks returns the most recent limit checks for monitorID, newest first.
 func (s *Store) RecentChecks(monitorID int64, limit int) ([]*Check, error) {
 	rows, err := s.db.Query(`
-		SELECT id, monitor_id, checked_at, status_code, response_time_ms, is_up
+		SELECT id, monitor_id, checked_at, status_code, response_time_ms, response_bytes, load_time_ms,
+		       security_score, security_issues, protocol, h3_advertised, is_up, error, sample_weight, sampled_until
 		FROM checks
 		WHERE monitor_id = ?
 		ORDER BY checked_at DESC
@@ -151,19 +453,46 @@ func (s *Store) RecentChecks(monitorID int64, limit int) ([]*Check, error) {
 	for rows.Next() {
 		c := &Check{}
 		var isUp int
-		if err := rows.Scan(&c.ID, &c.MonitorID, &c.CheckedAt, &c.StatusCode, &c.ResponseTimeMs, &isUp); err != nil {
+		var issues string
+		if err := rows.Scan(&c.ID, &c.MonitorID, &c.CheckedAt, &c.StatusCode, &c.ResponseTimeMs,
+			&c.ResponseBytes, &c.LoadTimeMs, &c.SecurityScore, &issues, &c.Protocol, &c.H3Advertised,
+			&isUp, &c.Error, &c.SampleWeight, &c.SampledUntil); err != nil {
 			return nil, err
 		}
 		c.IsUp = isUp == 1
+		if issues != "" {
+			c.SecurityIssues = strings.Split(issues, ",")
+		}
 		checks = append(checks, c)
 	}
 	return checks, rows.Err()
 }
 
-// PruneOldChecks deletes checks older than 7 days.
+// DefaultRetentionDays is how long raw checks are kept for monitors without
+// a retention_days override.
+const DefaultRetentionDays = 7
+
+// PruneOldChecks deletes checks and gaps older than their monitor's retention
+// period (retention_days, or DefaultRetentionDays when unset), and traces
+// older than TraceRetention.
 func (s *Store) PruneOldChecks() error {
-	_, err := s.db.Exec(`DELETE FROM checks WHERE checked_at < datetime('now', '-7 days')`)
-	return err
+	_, err := s.db.Exec(`
+		DELETE FROM checks
+		WHERE checked_at < datetime('now', '-' || COALESCE(
+			(SELECT NULLIF(retention_days, 0) FROM monitors WHERE id = checks.monitor_id), ?
+		) || ' days')`, DefaultRetentionDays)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		DELETE FROM data_gaps
+		WHERE ended_at < datetime('now', '-' || COALESCE(
+			(SELECT NULLIF(retention_days, 0) FROM monitors WHERE id = data_gaps.monitor_id), ?
+		) || ' days')`, DefaultRetentionDays)
+	if err != nil {
+		return err
+	}
+	return s.PruneTraces()
 }
 
 func boolToInt(b bool) int {
@@ -0,0 +1,35 @@
+package monitor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ParseTags normalises a monitor's tags setting: a comma-separated list of
+// labels, lowercased, deduplicated and sorted. Tags may contain letters,
+// digits, '-', '_', '.' and ':'.
+func ParseTags(s string) (string, error) {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" {
+			continue
+		}
+		if len(f) > 64 || strings.IndexFunc(f, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:", r))
+		}) >= 0 {
+			return "", fmt.Errorf("tags: %q is not a valid tag", f)
+		}
+		if !slices.Contains(out, f) {
+			out = append(out, f)
+		}
+	}
+	slices.Sort(out)
+	return strings.Join(out, ","), nil
+}
+
+// HasTag reports whether m is tagged tag (compared case-insensitively).
+func (m *Monitor) HasTag(tag string) bool {
+	return tag != "" && slices.Contains(strings.Split(m.Tags, ","), strings.ToLower(tag))
+}
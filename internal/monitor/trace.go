@@ -0,0 +1,345 @@
+package monitor
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceRetention is how long check traces are kept.
+const TraceRetention = 24 * time.Hour
+
+// maxTraceBody caps the response body snippet kept in a trace.
+const maxTraceBody = 4 << 10
+
+// maxTraces is how many traces one monitor keeps; older ones are dropped
+// as new ones are recorded.
+const maxTraces = 100
+
+// Trace is a diagnostic record of one failed or slow HTTP check, kept for
+// monitors with debug_trace enabled. Credentials are redacted before it is
+// stored: see redactHeaders and redactBody.
+type Trace struct {
+	ID        int64     `json:"id"`
+	MonitorID int64     `json:"monitor_id"`
+	CheckID   *int64    `json:"check_id"` // nil if the check was folded by sampling
+	CreatedAt time.Time `json:"created_at"`
+
+	Reason string `json:"reason"` // "failed" or "slow"
+	Error  string `json:"error,omitempty"`
+
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestHeaders http.Header `json:"request_headers"`
+	Redirects      []TraceHop  `json:"redirects,omitempty"`
+	RemoteAddr     string      `json:"remote_addr,omitempty"`
+	TLSVersion     string      `json:"tls_version,omitempty"`
+
+	Status          int         `json:"status,omitempty"`
+	Proto           string      `json:"proto,omitempty"`
+	ResponseHeaders http.Header `json:"response_headers,omitempty"`
+	BodySnippet     string      `json:"body_snippet,omitempty"`
+	BodyTruncated   bool        `json:"body_truncated,omitempty"`
+
+	Timings TraceTimings `json:"timings"`
+}
+
+// TraceHop is one redirect the check followed.
+type TraceHop struct {
+	Status   int    `json:"status"`
+	Location string `json:"location"`
+}
+
+// TraceTimings are how long the first connection's DNS, connect and TLS
+// phases took, and when its first response byte and the whole check
+// finished, from the start of the check, in milliseconds. A phase is nil
+// when it didn't happen: there is no DNS phase for IP targets or names
+// resolved through the DNS cache (whose lookup then counts towards
+// connect), and no TLS phase over plain HTTP.
+type TraceTimings struct {
+	DNSMs       *int `json:"dns_ms"`
+	ConnectMs   *int `json:"connect_ms"`
+	TLSMs       *int `json:"tls_ms"`
+	FirstByteMs *int `json:"first_byte_ms"`
+	TotalMs     int  `json:"total_ms"`
+}
+
+// tracer collects a Trace while probeHTTP runs. Its hooks may run on the
+// transport's goroutines, so fields set by them are guarded by mu.
+type tracer struct {
+	start time.Time
+
+	mu        sync.Mutex
+	t         Trace
+	dnsStart  time.Time
+	connStart time.Time
+	tlsStart  time.Time
+	connected bool // the first connection is done; later phases are not timed
+	body      bytes.Buffer
+}
+
+func newTracer(req *http.Request) *tracer {
+	tr := &tracer{start: time.Now()}
+	tr.t.Method = req.Method
+	tr.t.URL = redactTraceURL(req.URL.String())
+	tr.t.RequestHeaders = req.Header.Clone()
+	tr.t.RequestHeaders.Set("Host", req.URL.Host)
+	return tr
+}
+
+// since returns a pointer to the milliseconds from the start of the check.
+func (tr *tracer) since() *int {
+	ms := int(time.Since(tr.start).Milliseconds())
+	return &ms
+}
+
+// clientTrace returns hooks timing the first connection's phases. gotConn is
+// called as well, for the probe's own use.
+func (tr *tracer) clientTrace(gotConn func(httptrace.GotConnInfo)) *httptrace.ClientTrace {
+	phase := func(set func()) {
+		tr.mu.Lock()
+		if !tr.connected {
+			set()
+		}
+		tr.mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { phase(func() { tr.dnsStart = time.Now() }) },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			phase(func() {
+				ms := int(time.Since(tr.dnsStart).Milliseconds())
+				tr.t.Timings.DNSMs = &ms
+			})
+		},
+		ConnectStart: func(string, string) {
+			phase(func() {
+				if tr.connStart.IsZero() {
+					tr.connStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(string, string, error) {
+			phase(func() {
+				ms := int(time.Since(tr.connStart).Milliseconds())
+				tr.t.Timings.ConnectMs = &ms
+			})
+		},
+		TLSHandshakeStart: func() { phase(func() { tr.tlsStart = time.Now() }) },
+		TLSHandshakeDone: func(cs tls.ConnectionState, _ error) {
+			phase(func() {
+				ms := int(time.Since(tr.tlsStart).Milliseconds())
+				tr.t.Timings.TLSMs = &ms
+				if cs.Version != 0 {
+					tr.t.TLSVersion = tls.VersionName(cs.Version)
+				}
+			})
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			phase(func() { tr.t.RemoteAddr = info.Conn.RemoteAddr().String() })
+			gotConn(info)
+		},
+		GotFirstResponseByte: func() {
+			phase(func() {
+				tr.t.Timings.FirstByteMs = tr.since()
+				tr.connected = true
+			})
+		},
+	}
+}
+
+// redirect notes a redirect response the client is about to follow.
+func (tr *tracer) redirect(resp *http.Response) {
+	tr.mu.Lock()
+	tr.t.Redirects = append(tr.t.Redirects, TraceHop{
+		Status:   resp.StatusCode,
+		Location: redactTraceURL(resp.Header.Get("Location")),
+	})
+	tr.mu.Unlock()
+}
+
+// response notes the final response's status and headers.
+func (tr *tracer) response(resp *http.Response) {
+	tr.mu.Lock()
+	tr.t.Status = resp.StatusCode
+	tr.t.Proto = resp.Proto
+	tr.t.ResponseHeaders = resp.Header.Clone()
+	tr.mu.Unlock()
+}
+
+// Write keeps the first maxTraceBody bytes of the response body.
+func (tr *tracer) Write(p []byte) (int, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if room := maxTraceBody - tr.body.Len(); len(p) > room {
+		tr.body.Write(p[:max(room, 0)])
+		tr.t.BodyTruncated = true
+		return len(p), nil
+	}
+	return tr.body.Write(p)
+}
+
+// finish returns the redacted trace of check, or nil if the check neither
+// failed nor exceeded the monitor's load-time budget.
+func (tr *tracer) finish(m *Monitor, check *Check) *Trace {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	t := tr.t
+	switch {
+	case !check.IsUp:
+		t.Reason = "failed"
+	case m.BudgetMs > 0 && check.LoadTimeMs != nil && *check.LoadTimeMs > m.BudgetMs:
+		t.Reason = "slow"
+	default:
+		return nil
+	}
+	t.Error = check.Error
+	t.Timings.TotalMs = int(time.Since(tr.start).Milliseconds())
+	if check.LoadTimeMs != nil {
+		t.Timings.TotalMs = *check.LoadTimeMs
+	}
+	redactHeaders(t.RequestHeaders)
+	redactHeaders(t.ResponseHeaders)
+	t.BodySnippet = redactBody(strings.ToValidUTF8(tr.body.String(), "\uFFFD"))
+	return &t
+}
+
+// redacted replaces secret values in traces.
+const redacted = "[redacted]"
+
+// secretParts appear in the names of headers and fields holding credentials:
+// Authorization, X-Auth-Key, Private-Token, api_key, Set-Cookie and so on.
+var secretParts = []string{"auth", "key", "token", "secret", "password", "passwd", "credential", "session", "cookie", "signature"}
+
+// harmlessNames contain one of secretParts without holding a credential.
+var harmlessNames = []string{"author", "authority", ":authority", "www-authenticate", "keep-alive", "keywords"}
+
+// secretName reports whether a header or field name looks like it holds a
+// credential.
+func secretName(name string) bool {
+	name = strings.ToLower(name)
+	if slices.Contains(harmlessNames, name) {
+		return false
+	}
+	for _, s := range secretParts {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactTraceURL masks the password in rawURL, as RedactURL does, and the
+// values of query parameters whose name looks like a credential.
+func redactTraceURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if q := u.Query(); len(q) > 0 {
+		changed := false
+		for name, vals := range q {
+			if secretName(name) {
+				for i := range vals {
+					vals[i] = redacted
+				}
+				changed = true
+			}
+		}
+		if changed {
+			u.RawQuery = q.Encode()
+		}
+	}
+	return u.Redacted()
+}
+
+func redactHeaders(h http.Header) {
+	for name, vals := range h {
+		if secretName(name) {
+			for i := range vals {
+				vals[i] = redacted
+			}
+		}
+	}
+}
+
+// secretFields matches "name": "value" and name=value pairs in a body, for
+// redactBody to mask the values of those whose name looks like a credential.
+var secretFields = regexp.MustCompile(`("([^"\\]{1,64})"\s*:\s*")((?:[^"\\]|\\.)*)"|\b([A-Za-z0-9_.-]{1,64})=([^&\s"'<>]+)`)
+
+// redactBody masks credential-like values in a body snippet: JSON string
+// fields and form or query parameters whose name looks like a credential.
+func redactBody(s string) string {
+	return secretFields.ReplaceAllStringFunc(s, func(match string) string {
+		sub := secretFields.FindStringSubmatch(match)
+		switch {
+		case sub[2] != "" && secretName(sub[2]):
+			return sub[1] + redacted + `"`
+		case sub[4] != "" && secretName(sub[4]):
+			return sub[4] + "=" + redacted
+		}
+		return match
+	})
+}
+
+// RecordTrace stores t for its monitor, dropping the monitor's oldest
+// traces beyond maxTraces.
+func (s *Store) RecordTrace(t *Trace) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`INSERT INTO check_traces (monitor_id, check_id, trace_json) VALUES (?, ?, ?)`,
+		t.MonitorID, t.CheckID, string(b)); err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		DELETE FROM check_traces
+		WHERE monitor_id = ? AND id NOT IN (
+			SELECT id FROM check_traces WHERE monitor_id = ? ORDER BY id DESC LIMIT ?
+		)`, t.MonitorID, t.MonitorID, maxTraces)
+	return err
+}
+
+// Traces returns monitorID's traces from the last TraceRetention, newest first.
+func (s *Store) Traces(monitorID int64) ([]*Trace, error) {
+	rows, err := s.db.Query(`
+		SELECT id, monitor_id, check_id, created_at, trace_json FROM check_traces
+		WHERE monitor_id = ? AND created_at >= ?
+		ORDER BY id DESC`, monitorID, time.Now().UTC().Add(-TraceRetention).Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var traces []*Trace
+	for rows.Next() {
+		var t Trace
+		var id, mid int64
+		var checkID *int64
+		var at time.Time
+		var raw string
+		if err := rows.Scan(&id, &mid, &checkID, &at, &raw); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(raw), &t); err != nil {
+			return nil, err
+		}
+		t.ID, t.MonitorID, t.CheckID, t.CreatedAt = id, mid, checkID, at
+		traces = append(traces, &t)
+	}
+	return traces, rows.Err()
+}
+
+// PruneTraces deletes traces older than TraceRetention.
+func (s *Store) PruneTraces() error {
+	_, err := s.db.Exec(`DELETE FROM check_traces WHERE created_at < ?`,
+		time.Now().UTC().Add(-TraceRetention).Format("2006-01-02 15:04:05"))
+	return err
+}
@@ -0,0 +1,69 @@
+package monitor
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSecretName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"Authorization":       true,
+		"Proxy-Authorization": true,
+		"Cookie":              true,
+		"Set-Cookie":          true,
+		"X-Auth":              true,
+		"X-Auth-Key":          true,
+		"X-Auth-Email":        true,
+		"X-Auth-Token":        true,
+		"Private-Token":       true,
+		"X-Api-Key":           true,
+		"Apikey":              true,
+		"api_key":             true,
+		"X-Amz-Signature":     true,
+		"client_secret":       true,
+		"Accept":              false,
+		"Accept-Encoding":     false,
+		"Content-Type":        false,
+		"User-Agent":          false,
+		"Keep-Alive":          false,
+		"Author":              false,
+		"WWW-Authenticate":    false,
+		"page":                false,
+	} {
+		if got := secretName(name); got != want {
+			t.Errorf("secretName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("X-Auth-Key", "k")
+	h.Set("X-Auth-Email", "me@example.com")
+	h.Set("Private-Token", "t")
+	h.Set("Accept", "application/json")
+	redactHeaders(h)
+	for _, name := range []string{"X-Auth-Key", "X-Auth-Email", "Private-Token"} {
+		if got := h.Get(name); got != redacted {
+			t.Errorf("%s = %q, want it redacted", name, got)
+		}
+	}
+	if got := h.Get("Accept"); got != "application/json" {
+		t.Errorf("Accept = %q, want it kept", got)
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	got := redactBody(`{"auth_key": "k", "user": "me"} private_token=t&page=2`)
+	want := `{"auth_key": "[redacted]", "user": "me"} private_token=[redacted]&page=2`
+	if got != want {
+		t.Errorf("redactBody = %s, want %s", got, want)
+	}
+}
+
+func TestRedactHTTPHeaders(t *testing.T) {
+	got := RedactHTTPHeaders(map[string]string{"X-Auth-Key": "k", "private-token": "t", "Accept": "*/*"})
+	if got["X-Auth-Key"] != redacted || got["private-token"] != redacted || got["Accept"] != "*/*" {
+		t.Errorf("RedactHTTPHeaders = %v", got)
+	}
+}
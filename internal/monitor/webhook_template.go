@@ -0,0 +1,325 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// maxTemplateOutput caps a rendered webhook body, so a runaway template
+// can't build an unbounded one.
+const maxTemplateOutput = 256 << 10
+
+// WebhookTemplate renders alert webhook bodies for receivers that want
+// their own format. The template sees the alert as its JSON fields
+// ({{.monitor_name}}, {{.context.recent_events}}, ...) and can call only
+// the functions in templateFuncs, none of which reach the file system,
+// the network or the environment.
+type WebhookTemplate struct {
+	tmpl        *template.Template
+	contentType string
+	headers     map[string]string
+}
+
+// ParseWebhookTemplate parses body and checks it by rendering a sample down
+// alert. contentType defaults to application/json, in which case the sample
+// must also render to valid JSON. headers are sent as given.
+func ParseWebhookTemplate(body, contentType string, headers map[string]string) (*WebhookTemplate, error) {
+	tmpl, err := template.New("webhook").Funcs(templateFuncs).Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	t := &WebhookTemplate{tmpl: tmpl, contentType: contentType, headers: headers}
+	out, err := t.Render(sampleAlert())
+	if err != nil {
+		return nil, err
+	}
+	if isJSON(contentType) && !json.Valid(out) {
+		return nil, fmt.Errorf("renders invalid JSON for a sample alert:\n%s", out)
+	}
+	return t, nil
+}
+
+// Render executes the template for payload.
+func (t *WebhookTemplate) Render(payload AlertPayload) ([]byte, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]any
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	// Fields left out of the JSON when empty are "" here, not missing, so
+	// they print as nothing rather than "<no value>".
+	for _, k := range []string{"detail", "owner", "runbook_url", "description"} {
+		if _, ok := data[k]; !ok {
+			data[k] = ""
+		}
+	}
+	var out limitedBuffer
+	if err := t.tmpl.Execute(&out, data); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func isJSON(contentType string) bool {
+	ct, _, _ := strings.Cut(contentType, ";")
+	ct = strings.TrimSpace(strings.ToLower(ct))
+	return ct == "application/json" || strings.HasSuffix(ct, "+json")
+}
+
+// sampleAlert is a down alert with every field set, for checking a template.
+func sampleAlert() AlertPayload {
+	now := time.Now().UTC()
+	return AlertPayload{
+		MonitorName: "Example", URL: "https://example.com", Status: "down", Detail: "HTTP 503",
+		Timestamp: now.Format(time.RFC3339), Owner: "ops", RunbookURL: "https://wiki.example.com/example",
+		Description: "Example monitor",
+		Context: &AlertContext{
+			Events:    []ContextEvent{{Name: "deploy", Value: 1, At: now.Add(-time.Minute)}},
+			Anomalies: []MetricAnomaly{{Metric: "cpu_percent", Value: 97.5, Baseline: 12.3, At: now}},
+		},
+	}
+}
+
+// limitedBuffer is a bytes.Buffer that refuses to grow past
+// maxTemplateOutput.
+type limitedBuffer struct{ bytes.Buffer }
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > maxTemplateOutput {
+		return 0, fmt.Errorf("output exceeds %d bytes", maxTemplateOutput)
+	}
+	return b.Buffer.Write(p)
+}
+
+// templateFuncs are the functions a webhook template may call, named and
+// ordered as in sprig so existing snippets carry over: the value being
+// worked on comes last, ready for a pipeline.
+var templateFuncs = template.FuncMap{
+	// Strings
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"title":      titleCase,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"trunc":      truncRunes,
+	"quote":      func(v any) string { return strconv.Quote(toString(v)) },
+	"squote":     func(v any) string { return "'" + toString(v) + "'" },
+	"indent":     indent,
+	"nindent":    func(n int, s string) string { return "\n" + indent(n, s) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       join,
+	"toString":   toString,
+	"b64enc":     func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+
+	// Regular expressions (RE2, so linear time)
+	"regexMatch": func(re, s string) (bool, error) {
+		r, err := regexp.Compile(re)
+		if err != nil {
+			return false, err
+		}
+		return r.MatchString(s), nil
+	},
+	"regexReplaceAll": func(re, s, repl string) (string, error) {
+		r, err := regexp.Compile(re)
+		if err != nil {
+			return "", err
+		}
+		return r.ReplaceAllString(s, repl), nil
+	},
+
+	// Defaults and conditionals
+	"default":  func(def, v any) any { return ternary(v, def, !empty(v)) },
+	"empty":    empty,
+	"coalesce": coalesce,
+	"ternary":  ternary,
+
+	// Encoding
+	"toJson": func(v any) (string, error) {
+		out, err := json.Marshal(v)
+		return string(out), err
+	},
+	"toPrettyJson": func(v any) (string, error) {
+		out, err := json.MarshalIndent(v, "", "  ")
+		return string(out), err
+	},
+
+	// Dates: a layout in Go's reference-time form, and a time or an RFC 3339
+	// string such as .timestamp
+	"now":  func() time.Time { return time.Now().UTC() },
+	"date": formatDate,
+	"unix": func(v any) (int64, error) {
+		t, err := toTime(v)
+		return t.Unix(), err
+	},
+
+	// Numbers, which JSON fields are as float64
+	"add": func(a, b any) (float64, error) { return arith(a, b, func(x, y float64) float64 { return x + y }) },
+	"sub": func(a, b any) (float64, error) { return arith(a, b, func(x, y float64) float64 { return x - y }) },
+	"mul": func(a, b any) (float64, error) { return arith(a, b, func(x, y float64) float64 { return x * y }) },
+	"div": func(a, b any) (float64, error) {
+		if y, err := toFloat(b); err == nil && y == 0 {
+			return 0, errors.New("div: division by zero")
+		}
+		return arith(a, b, func(x, y float64) float64 { return x / y })
+	},
+	"round": func(places int, v any) (float64, error) {
+		x, err := toFloat(v)
+		if err != nil {
+			return 0, err
+		}
+		p, _ := strconv.ParseFloat(strconv.FormatFloat(x, 'f', places, 64), 64)
+		return p, nil
+	},
+
+	// Collections
+	"list": func(v ...any) []any { return v },
+	"dict": func(kv ...any) (map[string]any, error) {
+		if len(kv)%2 != 0 {
+			return nil, errors.New("dict: odd number of arguments")
+		}
+		m := make(map[string]any, len(kv)/2)
+		for i := 0; i < len(kv); i += 2 {
+			m[toString(kv[i])] = kv[i+1]
+		}
+		return m, nil
+	},
+}
+
+func titleCase(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		r := []rune(w)
+		words[i] = strings.ToUpper(string(r[0])) + string(r[1:])
+	}
+	return strings.Join(words, " ")
+}
+
+func truncRunes(n int, s string) string {
+	if r := []rune(s); n >= 0 && len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", min(max(n, 0), 1000))
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+func join(sep string, v any) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return toString(v)
+	}
+	parts := make([]string, rv.Len())
+	for i := range parts {
+		parts[i] = toString(rv.Index(i).Interface())
+	}
+	return strings.Join(parts, sep)
+}
+
+func toString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// empty reports whether v is its type's zero value, or an empty slice or
+// map.
+func empty(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return rv.Len() == 0
+	}
+	return rv.IsZero()
+}
+
+func coalesce(v ...any) any {
+	for _, x := range v {
+		if !empty(x) {
+			return x
+		}
+	}
+	return nil
+}
+
+func ternary(a, b any, cond bool) any {
+	if cond {
+		return a
+	}
+	return b
+}
+
+func toFloat(v any) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+func arith(a, b any, op func(x, y float64) float64) (float64, error) {
+	x, err := toFloat(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toFloat(b)
+	if err != nil {
+		return 0, err
+	}
+	return op(x, y), nil
+}
+
+func toTime(v any) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		return time.Parse(time.RFC3339, v)
+	}
+	return time.Time{}, fmt.Errorf("%v is not a time", v)
+}
+
+func formatDate(layout string, v any) (string, error) {
+	t, err := toTime(v)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(layout), nil
+}
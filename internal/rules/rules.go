@@ -0,0 +1,233 @@
+// Package rules defines threshold alert rules over agent metrics and
+// business events, and evaluates them against a series of samples.
+package rules
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Rule kinds.
+const (
+	KindMetric = "metric" // a host metric from the agent
+	KindEvent  = "event"  // the total of a business event over a trailing window
+)
+
+// Metrics are the host metrics a metric rule may watch. Percentages are
+// 0-100; disk_percent is the fullest disk.
+var Metrics = []string{
+	"cpu_percent", "mem_percent", "swap_percent", "load_1", "load_5", "load_15",
+	"disk_percent", "temp_max", "fd_open", "tcp_established",
+}
+
+// MaxGap is the longest silence between samples that a condition is
+// assumed to hold across. A longer gap (an agent offline) ends a firing at
+// the last sample and restarts the For clock.
+const MaxGap = 5 * time.Minute
+
+// Rule fires once its value has compared to Threshold by Op for at least
+// ForSeconds. It stops firing when the value no longer does, or, with a
+// ClearThreshold, only once the value is past that on the other side: rule
+// "> 90" with clear threshold 80 keeps firing through 85 and clears below 80,
+// so a value hovering around 90 doesn't flap.
+type Rule struct {
+	Kind           string   `json:"kind"`
+	Metric         string   `json:"metric,omitempty"` // for metric rules
+	Event          string   `json:"event,omitempty"`  // for event rules
+	Op             string   `json:"op"`               // ">", ">=", "<" or "<="
+	Threshold      float64  `json:"threshold"`
+	ClearThreshold *float64 `json:"clear_threshold,omitempty"`
+	ForSeconds     int      `json:"for_seconds"`
+	// WindowSeconds is the trailing window an event rule totals over;
+	// e.g. "fewer than 1 signup per hour" is op "<", threshold 1, window 3600.
+	WindowSeconds int `json:"window_seconds,omitempty"`
+}
+
+// Validate reports the first problem with r, or nil.
+func (r *Rule) Validate() error {
+	switch r.Kind {
+	case KindMetric:
+		if !slices.Contains(Metrics, r.Metric) {
+			return fmt.Errorf("metric must be one of %v", Metrics)
+		}
+	case KindEvent:
+		if r.Event == "" {
+			return fmt.Errorf("event is required for event rules")
+		}
+		if r.WindowSeconds < 60 || r.WindowSeconds > 7*24*3600 {
+			return fmt.Errorf("window_seconds must be between 60 and 604800")
+		}
+	default:
+		return fmt.Errorf("kind must be metric or event")
+	}
+	switch r.Op {
+	case ">", ">=", "<", "<=":
+	default:
+		return fmt.Errorf("op must be >, >=, < or <=")
+	}
+	if r.ForSeconds < 0 || r.ForSeconds > 24*3600 {
+		return fmt.Errorf("for_seconds must be between 0 and 86400")
+	}
+	if c := r.ClearThreshold; c != nil {
+		if (r.Op == ">" || r.Op == ">=") && *c > r.Threshold {
+			return fmt.Errorf("clear_threshold must not be above threshold for op %s", r.Op)
+		}
+		if (r.Op == "<" || r.Op == "<=") && *c < r.Threshold {
+			return fmt.Errorf("clear_threshold must not be below threshold for op %s", r.Op)
+		}
+	}
+	return nil
+}
+
+// breached reports whether v meets the rule's condition.
+func (r *Rule) breached(v float64) bool {
+	switch r.Op {
+	case ">":
+		return v > r.Threshold
+	case ">=":
+		return v >= r.Threshold
+	case "<":
+		return v < r.Threshold
+	default:
+		return v <= r.Threshold
+	}
+}
+
+// cleared reports whether v ends a firing: it is past ClearThreshold
+// (strictly, so "clear at 80" needs a value below 80 for a ">" rule), or no
+// longer meets the condition when there is none.
+func (r *Rule) cleared(v float64) bool {
+	if r.ClearThreshold == nil {
+		return !r.breached(v)
+	}
+	if r.Op == "<" || r.Op == "<=" {
+		return v > *r.ClearThreshold
+	}
+	return v < *r.ClearThreshold
+}
+
+// Point is one sample of the rule's value.
+type Point struct {
+	At    time.Time
+	Value float64
+}
+
+// Firing is one period the rule would have been firing. End is nil if it
+// still is at the last sample. Worst is the value furthest past the
+// threshold during the firing.
+type Firing struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end"`
+	Worst float64    `json:"worst"`
+}
+
+// Evaluate replays points, oldest first, through r and returns the periods
+// it would have fired: the condition must hold on every sample for
+// ForSeconds before a firing starts, and the firing ends at the first
+// sample that clears it (see Rule).
+func Evaluate(r Rule, points []Point) []Firing {
+	var firings []Firing
+	var cur *Firing
+	worse := func(a, b float64) bool {
+		if r.Op == "<" || r.Op == "<=" {
+			return a < b
+		}
+		return a > b
+	}
+	end := func(at time.Time) {
+		t := at
+		cur.End = &t
+		firings = append(firings, *cur)
+		cur = nil
+	}
+	w := Watch{Rule: r}
+	for _, p := range points {
+		last := w.last
+		gapEnded, started, ended := w.step(p)
+		switch {
+		case gapEnded:
+			end(last)
+		case ended:
+			end(p.At)
+		case cur != nil && worse(p.Value, cur.Worst):
+			cur.Worst = p.Value
+		}
+		if started {
+			cur = &Firing{Start: p.At, Worst: p.Value}
+		}
+	}
+	if cur != nil {
+		firings = append(firings, *cur)
+	}
+	return firings
+}
+
+// A Watch evaluates Rule live, one sample at a time, the way Evaluate
+// replays a series, so alerts that fire as samples arrive honour ForSeconds
+// and ClearThreshold too. The zero Watch with a Rule set is ready to use.
+type Watch struct {
+	Rule Rule
+
+	firing      bool
+	since, last time.Time // since: first sample of the current breach
+}
+
+// Observe feeds w the next sample and reports whether the rule is firing
+// after it, and whether that changed. A firing that a gap over MaxGap ends
+// and the same sample starts again counts as unchanged.
+func (w *Watch) Observe(p Point) (firing, changed bool) {
+	was := w.firing
+	w.step(p)
+	return w.firing, w.firing != was
+}
+
+// step advances w by p. gapEnded reports that a firing ended at the previous
+// sample because of the gap before p; ended that p cleared a firing, and
+// started that p began one.
+func (w *Watch) step(p Point) (gapEnded, started, ended bool) {
+	if !w.last.IsZero() && p.At.Sub(w.last) > MaxGap {
+		gapEnded = w.firing
+		w.firing, w.since = false, time.Time{}
+	}
+	w.last = p.At
+	if w.firing {
+		if w.Rule.cleared(p.Value) {
+			w.firing, w.since = false, time.Time{}
+			ended = true
+		}
+		return
+	}
+	if !w.Rule.breached(p.Value) {
+		w.since = time.Time{}
+		return
+	}
+	if w.since.IsZero() {
+		w.since = p.At
+	}
+	if p.At.Sub(w.since) >= time.Duration(w.Rule.ForSeconds)*time.Second {
+		w.firing, started = true, true
+	}
+	return
+}
+
+// WindowTotals turns event occurrences into a series for an event rule:
+// at every step from since to until, the total of the values in the
+// trailing window. Occurrences must be sorted by time.
+func WindowTotals(occurrences []Point, window, step time.Duration, since, until time.Time) []Point {
+	var out []Point
+	var total float64
+	lo, hi := 0, 0 // occurrences[lo:hi] are inside the window
+	for at := since; !at.After(until); at = at.Add(step) {
+		for hi < len(occurrences) && !occurrences[hi].At.After(at) {
+			total += occurrences[hi].Value
+			hi++
+		}
+		for lo < hi && !occurrences[lo].At.After(at.Add(-window)) {
+			total -= occurrences[lo].Value
+			lo++
+		}
+		out = append(out, Point{At: at, Value: total})
+	}
+	return out
+}
@@ -0,0 +1,212 @@
+// Package secrets encrypts credentials the dashboard stores in its database,
+// such as monitors' auth secrets and workspaces' webhook URLs, with an
+// application key kept outside it.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// prefix marks a sealed value: "enc:v1:<key id>:<base64 nonce and
+// ciphertext>". Anything else is plaintext, as stored before a key was set.
+const prefix = "enc:v1:"
+
+// A Box seals values with its current key and opens values sealed with it
+// or with one of its old keys. A nil Box, or one without a current key,
+// stores values in plaintext.
+type Box struct {
+	current string // key id; "" stores plaintext
+	keys    map[string]cipher.AEAD
+}
+
+// New returns a Box sealing with current and also opening values sealed
+// with any of old. Keys are 32 bytes, base64-encoded, as printed by
+// `openssl rand -base64 32`. current may be empty to store plaintext again,
+// with old still opening what was sealed.
+func New(current string, old []string) (*Box, error) {
+	b := &Box{keys: map[string]cipher.AEAD{}}
+	for i, k := range append([]string{current}, old...) {
+		if k == "" {
+			continue
+		}
+		id, aead, err := parseKey(k)
+		if err != nil {
+			if i == 0 {
+				return nil, fmt.Errorf("key: %v", err)
+			}
+			return nil, fmt.Errorf("old key %d: %v", i, err)
+		}
+		if i == 0 {
+			b.current = id
+		}
+		b.keys[id] = aead
+	}
+	return b, nil
+}
+
+func parseKey(k string) (string, cipher.AEAD, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(k))
+	if err != nil || len(raw) != 32 {
+		return "", nil, errors.New("must be 32 bytes, base64-encoded")
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:4]), aead, nil
+}
+
+// Enabled reports whether b seals new values.
+func (b *Box) Enabled() bool {
+	return b != nil && b.current != ""
+}
+
+// KeyID identifies b's current key in sealed values, or "" without one.
+func (b *Box) KeyID() string {
+	if b == nil {
+		return ""
+	}
+	return b.current
+}
+
+// Seal encrypts s with the current key. Empty values stay empty, so they
+// still read as "not set".
+func (b *Box) Seal(s string) (string, error) {
+	if !b.Enabled() || s == "" {
+		return s, nil
+	}
+	aead := b.keys[b.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(s), []byte(b.current))
+	return prefix + b.current + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value returned by Seal. Plaintext is returned as is.
+func (b *Box) Open(s string) (string, error) {
+	id, data, ok := parse(s)
+	if !ok {
+		return s, nil
+	}
+	var aead cipher.AEAD
+	if b != nil {
+		aead = b.keys[id]
+	}
+	if aead == nil {
+		return "", fmt.Errorf("value is encrypted with key %s, which isn't configured", id)
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(raw) < aead.NonceSize() {
+		return "", fmt.Errorf("value encrypted with key %s is corrupt", id)
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("value encrypted with key %s can't be decrypted: %v", id, err)
+	}
+	return string(plain), nil
+}
+
+func parse(s string) (id, data string, ok bool) {
+	rest, ok := strings.CutPrefix(s, prefix)
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, ":")
+}
+
+// stale reports whether s isn't stored the way Seal would store it now:
+// plaintext while there is a current key, or sealed with another key or
+// none.
+func (b *Box) stale(s string) bool {
+	if s == "" {
+		return false
+	}
+	id, _, sealed := parse(s)
+	if !sealed {
+		return b.Enabled()
+	}
+	return id != b.KeyID()
+}
+
+// Reseal rewrites the values of cols in table that are stale, so each is
+// sealed with the current key (or in plaintext without one), after a key is
+// set or rotated. Rows are identified by the integer column idCol. It
+// returns how many rows were rewritten.
+func (b *Box) Reseal(db *sql.DB, table, idCol string, cols ...string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`SELECT ` + idCol + `, ` + strings.Join(cols, ", ") + ` FROM ` + table)
+	if err != nil {
+		return 0, err
+	}
+	type row struct {
+		id   int64
+		vals []string
+	}
+	var stale []row
+	for rows.Next() {
+		r := row{vals: make([]string, len(cols))}
+		dest := []any{&r.id}
+		for i := range r.vals {
+			dest = append(dest, &r.vals[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		for _, v := range r.vals {
+			if b.stale(v) {
+				stale = append(stale, r)
+				break
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sets := make([]string, len(cols))
+	for i, c := range cols {
+		sets[i] = c + " = ?"
+	}
+	update := `UPDATE ` + table + ` SET ` + strings.Join(sets, ", ") + ` WHERE ` + idCol + ` = ?`
+	for _, r := range stale {
+		args := make([]any, 0, len(cols)+1)
+		for i, v := range r.vals {
+			if b.stale(v) {
+				plain, err := b.Open(v)
+				if err != nil {
+					return 0, fmt.Errorf("%s %d: %s: %v", table, r.id, cols[i], err)
+				}
+				if v, err = b.Seal(plain); err != nil {
+					return 0, err
+				}
+			}
+			args = append(args, v)
+		}
+		if _, err := tx.Exec(update, append(args, r.id)...); err != nil {
+			return 0, err
+		}
+	}
+	return len(stale), tx.Commit()
+}
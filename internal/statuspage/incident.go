@@ -0,0 +1,224 @@
+package statuspage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Incident impact levels, least to most severe.
+const (
+	ImpactNone     = "none"
+	ImpactMinor    = "minor"
+	ImpactMajor    = "major"
+	ImpactCritical = "critical"
+)
+
+// Incident statuses. An incident is open until it is resolved.
+const (
+	IncidentInvestigating = "investigating"
+	IncidentIdentified    = "identified"
+	IncidentMonitoring    = "monitoring"
+	IncidentResolved      = "resolved"
+)
+
+// ValidImpact reports whether s is an impact level.
+func ValidImpact(s string) bool {
+	switch s {
+	case ImpactNone, ImpactMinor, ImpactMajor, ImpactCritical:
+		return true
+	}
+	return false
+}
+
+// ValidIncidentStatus reports whether s is an incident status.
+func ValidIncidentStatus(s string) bool {
+	switch s {
+	case IncidentInvestigating, IncidentIdentified, IncidentMonitoring, IncidentResolved:
+		return true
+	}
+	return false
+}
+
+// Update is one status update posted to an incident.
+type Update struct {
+	ID        int64     `json:"id"`
+	Status    string    `json:"status"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Incident is a manually published incident, independent of monitor state.
+type Incident struct {
+	ID          int64      `json:"id"`
+	WorkspaceID int64      `json:"workspace_id"`
+	Title       string     `json:"title"`
+	Impact      string     `json:"impact"`
+	Status      string     `json:"status"`     // that of the latest update
+	Postmortem  string     `json:"postmortem"` // written after the fact; may be empty
+	Updates     []Update   `json:"updates"`    // newest first
+	CreatedAt   time.Time  `json:"created_at"`
+	ResolvedAt  *time.Time `json:"resolved_at"`
+}
+
+const incidentCols = `id, workspace_id, title, impact, status, postmortem, created_at, resolved_at`
+
+func scanIncident(row interface{ Scan(...any) error }) (*Incident, error) {
+	inc := &Incident{}
+	var resolved sql.NullTime
+	err := row.Scan(&inc.ID, &inc.WorkspaceID, &inc.Title, &inc.Impact, &inc.Status,
+		&inc.Postmortem, &inc.CreatedAt, &resolved)
+	if resolved.Valid {
+		inc.ResolvedAt = &resolved.Time
+	}
+	return inc, err
+}
+
+// Incidents returns a workspace's open incidents and those resolved since
+// the given time, newest first. Pass the zero time for all of them.
+func (s *Store) Incidents(workspaceID int64, since time.Time) ([]*Incident, error) {
+	rows, err := s.db.Query(`
+		SELECT `+incidentCols+` FROM status_incidents
+		WHERE workspace_id = ? AND (resolved_at IS NULL OR resolved_at >= ?)
+		ORDER BY created_at DESC, id DESC`, workspaceID, since.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	var list []*Incident
+	for rows.Next() {
+		inc, err := scanIncident(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list = append(list, inc)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, inc := range list {
+		if inc.Updates, err = s.updates(inc.ID); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// Incident returns the incident with the given ID, or nil if not found.
+func (s *Store) Incident(id int64) (*Incident, error) {
+	inc, err := scanIncident(s.db.QueryRow(`SELECT `+incidentCols+` FROM status_incidents WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	inc.Updates, err = s.updates(id)
+	return inc, err
+}
+
+func (s *Store) updates(incidentID int64) ([]Update, error) {
+	rows, err := s.db.Query(`
+		SELECT id, status, message, created_at FROM status_incident_updates
+		WHERE incident_id = ? ORDER BY created_at DESC, id DESC`, incidentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Update{}
+	for rows.Next() {
+		var u Update
+		if err := rows.Scan(&u.ID, &u.Status, &u.Message, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+// CreateIncident inserts inc with message as its first update, in inc.Status,
+// and reloads inc from the stored rows.
+func (s *Store) CreateIncident(inc *Incident, message string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow(`
+		INSERT INTO status_incidents (workspace_id, title, impact, status, postmortem)
+		VALUES (?, ?, ?, ?, ?) RETURNING id`,
+		inc.WorkspaceID, inc.Title, inc.Impact, inc.Status, inc.Postmortem).Scan(&inc.ID)
+	if err != nil {
+		return err
+	}
+	if err := addUpdate(tx, inc.ID, inc.Status, message); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return s.reload(inc)
+}
+
+// UpdateIncident writes inc's title, impact and postmortem back to the DB.
+// Status changes go through AddUpdate. Returns sql.ErrNoRows if the ID does
+// not exist.
+func (s *Store) UpdateIncident(inc *Incident) error {
+	res, err := s.db.Exec(`UPDATE status_incidents SET title = ?, impact = ?, postmortem = ? WHERE id = ?`,
+		inc.Title, inc.Impact, inc.Postmortem, inc.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return s.reload(inc)
+}
+
+// AddUpdate posts a status update to inc and moves it to that status.
+// Resolving stamps resolved_at; any other status reopens the incident.
+func (s *Store) AddUpdate(inc *Incident, status, message string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := addUpdate(tx, inc.ID, status, message); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return s.reload(inc)
+}
+
+func addUpdate(tx *sql.Tx, incidentID int64, status, message string) error {
+	if _, err := tx.Exec(`
+		INSERT INTO status_incident_updates (incident_id, status, message)
+		VALUES (?, ?, ?)`, incidentID, status, message); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		UPDATE status_incidents SET status = ?,
+			resolved_at = CASE WHEN ? = 'resolved' THEN COALESCE(resolved_at, datetime('now')) END
+		WHERE id = ?`, status, status, incidentID)
+	return err
+}
+
+func (s *Store) reload(inc *Incident) error {
+	got, err := s.Incident(inc.ID)
+	if err != nil {
+		return err
+	}
+	if got == nil {
+		return sql.ErrNoRows
+	}
+	*inc = *got
+	return nil
+}
+
+// DeleteIncident removes an incident and its updates.
+func (s *Store) DeleteIncident(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM status_incidents WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,316 @@
+// Package statuspage curates what a workspace's public status page shows:
+// named components in a chosen order, each backed by monitors listed under
+// customer-facing names rather than their internal ones.
+package statuspage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Entry places a monitor in a component under a public display name.
+type Entry struct {
+	MonitorID   int64  `json:"monitor_id"`
+	DisplayName string `json:"display_name"` // shown instead of the monitor's internal name
+}
+
+// Component is a named group of monitors on the status page.
+type Component struct {
+	ID          int64     `json:"id"`
+	WorkspaceID int64     `json:"workspace_id"`
+	Name        string    `json:"name"`
+	Position    int       `json:"position"` // ascending; ties break by ID
+	Monitors    []Entry   `json:"monitors"` // in display order
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Store provides status page DB operations.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore creates a Store backed by db.
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// List returns a workspace's components in display order.
+func (s *Store) List(workspaceID int64) ([]*Component, error) {
+	rows, err := s.db.Query(`
+		SELECT id, workspace_id, name, position, created_at
+		FROM status_components WHERE workspace_id = ?
+		ORDER BY position, id`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	var list []*Component
+	for rows.Next() {
+		c := &Component{}
+		if err := rows.Scan(&c.ID, &c.WorkspaceID, &c.Name, &c.Position, &c.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, c := range list {
+		if c.Monitors, err = s.entries(c.ID); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// Get returns the component with the given ID, or nil if not found.
+func (s *Store) Get(id int64) (*Component, error) {
+	c := &Component{}
+	err := s.db.QueryRow(`
+		SELECT id, workspace_id, name, position, created_at
+		FROM status_components WHERE id = ?`, id).
+		Scan(&c.ID, &c.WorkspaceID, &c.Name, &c.Position, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Monitors, err = s.entries(id)
+	return c, err
+}
+
+func (s *Store) entries(componentID int64) ([]Entry, error) {
+	rows, err := s.db.Query(`
+		SELECT monitor_id, display_name FROM status_component_monitors
+		WHERE component_id = ? ORDER BY position`, componentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Entry{}
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.MonitorID, &e.DisplayName); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// Create inserts c with its monitors and populates ID and CreatedAt.
+func (s *Store) Create(c *Component) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow(`
+		INSERT INTO status_components (workspace_id, name, position)
+		VALUES (?, ?, ?) RETURNING id, created_at`, c.WorkspaceID, c.Name, c.Position).
+		Scan(&c.ID, &c.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if err := putEntries(tx, c); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update writes c's name, position and monitors back to the DB; the monitor
+// list is replaced. Returns sql.ErrNoRows if the ID does not exist.
+func (s *Store) Update(c *Component) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`UPDATE status_components SET name = ?, position = ? WHERE id = ?`,
+		c.Name, c.Position, c.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(`DELETE FROM status_component_monitors WHERE component_id = ?`, c.ID); err != nil {
+		return err
+	}
+	if err := putEntries(tx, c); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func putEntries(tx *sql.Tx, c *Component) error {
+	for i, e := range c.Monitors {
+		if _, err := tx.Exec(`
+			INSERT INTO status_component_monitors (component_id, monitor_id, display_name, position)
+			VALUES (?, ?, ?, ?)`, c.ID, e.MonitorID, e.DisplayName, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a component; its monitors are left untouched.
+func (s *Store) Delete(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM status_components WHERE id = ?`, id)
+	return err
+}
+
+// Public component and overall statuses.
+const (
+	StatusOperational   = "operational"
+	StatusUnknown       = "unknown"
+	StatusPartialOutage = "partial_outage"
+	StatusMajorOutage   = "major_outage"
+)
+
+// PublicMonitor is a monitor as customers see it. KnownIssue is the
+// monitor's known-issue banner, if one is set and hasn't expired.
+type PublicMonitor struct {
+	Name            string     `json:"name"`
+	Status          string     `json:"status"`
+	KnownIssue      string     `json:"known_issue,omitempty"`
+	KnownIssueUntil *time.Time `json:"known_issue_until,omitempty"`
+}
+
+// PublicComponent is a component as customers see it.
+type PublicComponent struct {
+	Name     string          `json:"name"`
+	Status   string          `json:"status"`
+	Monitors []PublicMonitor `json:"monitors"`
+}
+
+// PublicUpdate is an incident update as customers see it.
+type PublicUpdate struct {
+	Status    string    `json:"status"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PublicIncident is an incident as customers see it.
+type PublicIncident struct {
+	Title      string         `json:"title"`
+	Impact     string         `json:"impact"`
+	Status     string         `json:"status"`
+	Postmortem string         `json:"postmortem,omitempty"`
+	Updates    []PublicUpdate `json:"updates"`
+	CreatedAt  time.Time      `json:"created_at"`
+	ResolvedAt *time.Time     `json:"resolved_at"`
+}
+
+// recentIncidents is how long a resolved incident stays on the page.
+const recentIncidents = 7 * 24 * time.Hour
+
+// Page is the public view of a workspace's status page. It carries no
+// internal monitor names, URLs or IDs.
+type Page struct {
+	Status     string            `json:"status"`
+	Components []PublicComponent `json:"components"`
+	Incidents  []PublicIncident  `json:"incidents"` // open, then resolved in the last week; newest first
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// Page builds the public status page for a workspace from its components,
+// their monitors' current states and its recent incidents.
+func (s *Store) Page(workspaceID int64) (*Page, error) {
+	comps, err := s.List(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	page := &Page{Status: StatusOperational, Components: []PublicComponent{}, UpdatedAt: time.Now().UTC().Truncate(time.Second)}
+	for _, c := range comps {
+		pc := PublicComponent{Name: c.Name, Monitors: []PublicMonitor{}}
+		var up, down int
+		for _, e := range c.Monitors {
+			var state, issue string
+			var until *time.Time
+			err := s.db.QueryRow(`SELECT state, known_issue, known_issue_until FROM monitors WHERE id = ?`, e.MonitorID).
+				Scan(&state, &issue, &until)
+			if err != nil {
+				return nil, err
+			}
+			if until == nil || !until.After(time.Now()) {
+				issue, until = "", nil
+			}
+			status := StatusUnknown
+			switch state {
+			case "up":
+				status = StatusOperational
+				up++
+			case "down":
+				status = StatusMajorOutage
+				down++
+			}
+			pc.Monitors = append(pc.Monitors, PublicMonitor{Name: e.DisplayName, Status: status, KnownIssue: issue, KnownIssueUntil: until})
+		}
+		switch {
+		case down > 0 && down == len(c.Monitors):
+			pc.Status = StatusMajorOutage
+		case down > 0:
+			pc.Status = StatusPartialOutage
+		case up > 0 && up == len(c.Monitors):
+			pc.Status = StatusOperational
+		default:
+			pc.Status = StatusUnknown
+		}
+		page.Components = append(page.Components, pc)
+	}
+	incidents, err := s.Incidents(workspaceID, time.Now().Add(-recentIncidents))
+	if err != nil {
+		return nil, err
+	}
+	page.Incidents = []PublicIncident{}
+	var open []PublicIncident
+	for _, inc := range incidents {
+		pi := PublicIncident{Title: inc.Title, Impact: inc.Impact, Status: inc.Status, Postmortem: inc.Postmortem,
+			Updates: []PublicUpdate{}, CreatedAt: inc.CreatedAt, ResolvedAt: inc.ResolvedAt}
+		for _, u := range inc.Updates {
+			pi.Updates = append(pi.Updates, PublicUpdate{Status: u.Status, Message: u.Message, CreatedAt: u.CreatedAt})
+		}
+		if inc.ResolvedAt == nil {
+			open = append(open, pi)
+		} else {
+			page.Incidents = append(page.Incidents, pi)
+		}
+	}
+	page.Incidents = append(open, page.Incidents...)
+	page.Status = overall(page.Components, open)
+	return page, nil
+}
+
+// overall is the page headline: the worst outage among the components and
+// open incidents, or operational. A critical incident counts as a major
+// outage and a major one as a partial outage; minor ones do not change the
+// headline. It is unknown only when nothing on the page has data at all.
+func overall(comps []PublicComponent, open []PublicIncident) string {
+	status, known := StatusOperational, len(comps) == 0
+	for _, inc := range open {
+		switch inc.Impact {
+		case ImpactCritical:
+			return StatusMajorOutage
+		case ImpactMajor:
+			status, known = StatusPartialOutage, true
+		}
+	}
+	for _, c := range comps {
+		switch c.Status {
+		case StatusMajorOutage:
+			return StatusMajorOutage
+		case StatusPartialOutage:
+			status = StatusPartialOutage
+		}
+		if c.Status != StatusUnknown {
+			known = true
+		}
+	}
+	if !known {
+		return StatusUnknown
+	}
+	return status
+}
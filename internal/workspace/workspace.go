@@ -0,0 +1,376 @@
+// Package workspace scopes monitors, hosts and events to tenants so one
+// instance can serve several clients.
+package workspace
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"health-dashboard/internal/auth"
+	"health-dashboard/internal/secrets"
+)
+
+// DefaultID is the workspace that exists in every database. Data from before
+// workspaces were introduced, and ingestion authenticated with the keys in
+// config.yaml, belongs to it.
+const DefaultID int64 = 1
+
+// Errors returned by Delete.
+var (
+	ErrDefault  = errors.New("the default workspace cannot be deleted")
+	ErrNotEmpty = errors.New("workspace still has monitors")
+)
+
+// Workspace is a tenant with its own ingestion keys and alert webhook.
+// Only the keys' digests are stored, so APIKey and AgentToken are set just
+// by Create and RotateKeys, for the one response that shows them; the
+// prefixes identify them afterwards.
+type Workspace struct {
+	ID               int64     `json:"id"`
+	Name             string    `json:"name"`
+	APIKey           string    `json:"api_key,omitempty"`     // X-API-Key for event ingestion
+	AgentToken       string    `json:"agent_token,omitempty"` // X-Agent-Token for metrics ingestion
+	APIKeyPrefix     string    `json:"api_key_prefix"`
+	AgentTokenPrefix string    `json:"agent_token_prefix"`
+	GitHubSecret     string    `json:"github_secret"` // signs GitHub webhooks, which need it in plaintext
+	WebhookURL       string    `json:"webhook_url"`   // overrides alerts.webhook_url when set
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// Redacted returns a copy of ws for read-only viewers, without its ingestion
+// keys, GitHub secret or webhook URL (chat webhooks carry their secret in the
+// path).
+func (ws *Workspace) Redacted() *Workspace {
+	c := *ws
+	c.APIKey, c.AgentToken, c.GitHubSecret, c.WebhookURL = "", "", "", ""
+	return &c
+}
+
+// Store provides workspace DB operations.
+type Store struct {
+	db  *sql.DB
+	box *secrets.Box // nil stores GitHub secrets and webhook URLs in plaintext
+}
+
+// NewStore creates a Store backed by db.
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// SetSecrets makes s encrypt workspaces' GitHub secrets and webhook URLs
+// with box.
+func (s *Store) SetSecrets(box *secrets.Box) {
+	s.box = box
+}
+
+// ResealSecrets re-encrypts the GitHub secrets and webhook URLs that aren't
+// sealed with the current key and returns how many workspaces it rewrote.
+func (s *Store) ResealSecrets() (int, error) {
+	return s.box.Reseal(s.db, "workspaces", "id", "github_secret", "webhook_url")
+}
+
+const cols = `id, name, api_key_prefix, agent_token_prefix, github_secret, webhook_url, created_at`
+
+func (s *Store) scan(row interface{ Scan(...any) error }) (*Workspace, error) {
+	ws := &Workspace{}
+	err := row.Scan(&ws.ID, &ws.Name, &ws.APIKeyPrefix, &ws.AgentTokenPrefix, &ws.GitHubSecret, &ws.WebhookURL, &ws.CreatedAt)
+	if err != nil {
+		return ws, err
+	}
+	if ws.GitHubSecret, err = s.box.Open(ws.GitHubSecret); err != nil {
+		return ws, fmt.Errorf("workspace %d: github_secret: %v", ws.ID, err)
+	}
+	if ws.WebhookURL, err = s.box.Open(ws.WebhookURL); err != nil {
+		return ws, fmt.Errorf("workspace %d: webhook_url: %v", ws.ID, err)
+	}
+	return ws, nil
+}
+
+// seal encrypts each value if s has a key.
+func (s *Store) seal(vals ...*string) error {
+	for _, v := range vals {
+		sealed, err := s.box.Seal(*v)
+		if err != nil {
+			return err
+		}
+		*v = sealed
+	}
+	return nil
+}
+
+// List returns all workspaces ordered by ID.
+func (s *Store) List() ([]*Workspace, error) {
+	rows, err := s.db.Query(`SELECT ` + cols + ` FROM workspaces ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*Workspace
+	for rows.Next() {
+		ws, err := s.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ws)
+	}
+	return list, rows.Err()
+}
+
+// Get returns the workspace with the given ID, or nil if not found.
+func (s *Store) Get(id int64) (*Workspace, error) {
+	ws, err := s.scan(s.db.QueryRow(`SELECT `+cols+` FROM workspaces WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return ws, err
+}
+
+// Create inserts a workspace with freshly generated ingestion keys and
+// populates ws from the stored row, plus the keys themselves.
+func (s *Store) Create(ws *Workspace) error {
+	k, err := newKeys()
+	if err != nil {
+		return err
+	}
+	secret, webhookURL := k.githubSecret, ws.WebhookURL
+	if err := s.seal(&secret, &webhookURL); err != nil {
+		return err
+	}
+	row := s.db.QueryRow(`
+		INSERT INTO workspaces (name, api_key_hash, api_key_prefix, agent_token_hash, agent_token_prefix, github_secret, webhook_url)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING `+cols, ws.Name, auth.HashToken(k.apiKey), auth.TokenLookup(k.apiKey),
+		auth.HashToken(k.agentToken), auth.TokenLookup(k.agentToken), secret, webhookURL)
+	created, err := s.scan(row)
+	if err != nil {
+		return err
+	}
+	*ws = *created
+	ws.APIKey, ws.AgentToken = k.apiKey, k.agentToken
+	return nil
+}
+
+// RotateKeys replaces a workspace's API key, agent token and GitHub secret
+// with new ones and returns the workspace with them, or nil if not found.
+// The old ones stop working at once.
+func (s *Store) RotateKeys(id int64) (*Workspace, error) {
+	k, err := newKeys()
+	if err != nil {
+		return nil, err
+	}
+	secret := k.githubSecret
+	if err := s.seal(&secret); err != nil {
+		return nil, err
+	}
+	ws, err := s.scan(s.db.QueryRow(`
+		UPDATE workspaces
+		SET api_key_hash = ?, api_key_prefix = ?, agent_token_hash = ?, agent_token_prefix = ?, github_secret = ?
+		WHERE id = ?
+		RETURNING `+cols, auth.HashToken(k.apiKey), auth.TokenLookup(k.apiKey),
+		auth.HashToken(k.agentToken), auth.TokenLookup(k.agentToken), secret, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ws.APIKey, ws.AgentToken = k.apiKey, k.agentToken
+	return ws, nil
+}
+
+// HashKeys replaces the plaintext keys of workspaces created before keys
+// were hashed with their digests, and gives those workspaces a GitHub
+// secret, since their API key can no longer verify GitHub's signatures. It
+// returns the IDs of the workspaces it changed.
+func (s *Store) HashKeys() ([]int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`SELECT id, api_key, agent_token FROM workspaces WHERE api_key != '' OR agent_token != ''`)
+	if err != nil {
+		return nil, err
+	}
+	type plain struct {
+		id                 int64
+		apiKey, agentToken string
+	}
+	var list []plain
+	for rows.Next() {
+		var p plain
+		if err := rows.Scan(&p.id, &p.apiKey, &p.agentToken); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for _, p := range list {
+		secret, err := newKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := s.seal(&secret); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`
+			UPDATE workspaces
+			SET api_key_hash = ?, api_key_prefix = ?, agent_token_hash = ?, agent_token_prefix = ?,
+			    github_secret = CASE github_secret WHEN '' THEN ? ELSE github_secret END,
+			    api_key = '', agent_token = ''
+			WHERE id = ?`,
+			hashOrEmpty(p.apiKey), auth.TokenLookup(p.apiKey),
+			hashOrEmpty(p.agentToken), auth.TokenLookup(p.agentToken), secret, p.id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, p.id)
+	}
+	return ids, tx.Commit()
+}
+
+func hashOrEmpty(token string) string {
+	if token == "" {
+		return ""
+	}
+	return auth.HashToken(token)
+}
+
+// Update writes ws's name and webhook URL back to the DB.
+// Returns sql.ErrNoRows if the ID does not exist.
+func (s *Store) Update(ws *Workspace) error {
+	webhookURL := ws.WebhookURL
+	if err := s.seal(&webhookURL); err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`UPDATE workspaces SET name = ?, webhook_url = ? WHERE id = ?`,
+		ws.Name, webhookURL, ws.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Delete removes an empty workspace along with its metrics, events and
+// status page components and incidents.
+// The default workspace cannot be deleted.
+func (s *Store) Delete(id int64) error {
+	if id == DefaultID {
+		return ErrDefault
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM monitors WHERE workspace_id = ?`, id).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrNotEmpty
+	}
+	for _, q := range []string{
+		`DELETE FROM metrics WHERE workspace_id = ?`,
+		`DELETE FROM metrics_hourly WHERE workspace_id = ?`,
+		`DELETE FROM events WHERE workspace_id = ?`,
+		`DELETE FROM peer_checks WHERE workspace_id = ?`,
+		`DELETE FROM backup_runs WHERE workspace_id = ?`,
+		`DELETE FROM backup_repos WHERE workspace_id = ?`,
+		`DELETE FROM annotations WHERE workspace_id = ?`,
+		`DELETE FROM hosts WHERE workspace_id = ?`,
+		`DELETE FROM status_components WHERE workspace_id = ?`,
+		`DELETE FROM status_incidents WHERE workspace_id = ?`,
+		`DELETE FROM workspaces WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(q, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ForAPIKey returns the workspace whose events API key is key, or 0.
+func (s *Store) ForAPIKey(key string) (int64, error) {
+	return s.match(key, "api_key")
+}
+
+// ForAgentToken returns the workspace whose agent token is token, or 0.
+func (s *Store) ForAgentToken(token string) (int64, error) {
+	return s.match(token, "agent_token")
+}
+
+// match finds the workspaces whose key (the column pair named by kind) has
+// got's lookup prefix and compares got against their digests in constant
+// time.
+func (s *Store) match(got, kind string) (int64, error) {
+	lookup := auth.TokenLookup(got)
+	if lookup == "" {
+		return 0, nil
+	}
+	rows, err := s.db.Query(`SELECT id, `+kind+`_hash FROM workspaces WHERE `+kind+`_prefix = ?`, lookup)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var id int64
+	for rows.Next() {
+		var wsID int64
+		var hash string
+		if err := rows.Scan(&wsID, &hash); err != nil {
+			return 0, err
+		}
+		if auth.TokenMatches(got, []string{hash}) {
+			id = wsID
+		}
+	}
+	return id, rows.Err()
+}
+
+// WebhookURL returns the alert webhook configured for workspace id, or "".
+func (s *Store) WebhookURL(id int64) string {
+	var stored string
+	s.db.QueryRow(`SELECT webhook_url FROM workspaces WHERE id = ?`, id).Scan(&stored)
+	url, err := s.box.Open(stored)
+	if err != nil {
+		log.Printf("workspace %d: webhook_url: %v", id, err)
+	}
+	return url
+}
+
+type keys struct {
+	apiKey, agentToken, githubSecret string
+}
+
+func newKeys() (keys, error) {
+	var k keys
+	for _, p := range []*string{&k.apiKey, &k.agentToken, &k.githubSecret} {
+		key, err := newKey()
+		if err != nil {
+			return k, err
+		}
+		*p = key
+	}
+	return k, nil
+}
+
+func newKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}